@@ -0,0 +1,272 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+)
+
+// Animation is a decoded animation as fully composited frames, each
+// the size of the logical screen.
+type Animation struct {
+	Frames    []*image.RGBA
+	Delays    []int // per frame, in hundredths of a second
+	LoopCount int   // 0 loops forever, -1 plays once, n plays n+1 times
+}
+
+// DecodeAnimation reads an animated GIF or APNG, applying each frame's
+// disposal and blending so that every frame stands alone. Other formats
+// decode to a single frame.
+func DecodeAnimation(r io.Reader) (*Animation, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(pngHeader)); string(magic) == pngHeader {
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return nil, err
+		}
+		if isAPNG(data) {
+			return decodeAPNG(data)
+		}
+		br = bufio.NewReader(bytes.NewReader(data))
+	}
+	if magic, _ := br.Peek(3); string(magic) != "GIF" {
+		img, _, err := Decode(br)
+		if err != nil {
+			return nil, err
+		}
+		b := img.Bounds()
+		frame := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(frame, frame.Rect, img, b.Min, draw.Src)
+		return &Animation{Frames: []*image.RGBA{frame}, Delays: []int{0}, LoopCount: -1}, nil
+	}
+
+	g, err := gif.DecodeAll(br)
+	if err != nil {
+		return nil, err
+	}
+	screen := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if screen.Empty() && len(g.Image) > 0 {
+		screen = g.Image[0].Rect
+	}
+	a := &Animation{LoopCount: g.LoopCount}
+	canvas := image.NewRGBA(screen)
+	for i, p := range g.Image {
+		var saved *image.RGBA
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			saved = cloneRGBA(canvas)
+		}
+		draw.Draw(canvas, p.Rect, p, p.Rect.Min, draw.Over)
+		a.Frames = append(a.Frames, cloneRGBA(canvas))
+		a.Delays = append(a.Delays, g.Delay[i])
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, p.Rect, image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = saved
+		}
+	}
+	return a, nil
+}
+
+func cloneRGBA(m *image.RGBA) *image.RGBA {
+	c := *m
+	c.Pix = append([]byte(nil), m.Pix...)
+	return &c
+}
+
+// ConvertAnimation reads an animation and writes it as an animated
+// GIF, passing each frame through opts.FrameFunc if set and then
+// through the stages Encode runs on still images, such as Redact,
+// Grade, padding and effects. APNG input gets a GIF fallback for
+// clients that do not support APNG.
+func ConvertAnimation(r io.Reader, w io.Writer, opts Options) error {
+	a, err := DecodeAnimation(r)
+	if err != nil {
+		return err
+	}
+	if opts.FrameFunc != nil {
+		for i, f := range a.Frames {
+			if err := opts.FrameFunc(i, f); err != nil {
+				return err
+			}
+		}
+	}
+	return encodeAnimatedGIF(w, a, opts)
+}
+
+// EncodeAnimation writes frames as an animation that loops forever.
+// Delays are in hundredths of a second, one per frame; missing entries
+// are 10. Only GIF supports animation; frames are quantized to their
+// own palettes, or to one shared palette with Options.GlobalPalette.
+// Each frame goes through the stages Encode runs on still images,
+// except Trim, TrimBorders and Deskew, which are reported with
+// WarnFramesUntrimmed instead.
+func EncodeAnimation(w io.Writer, frames []image.Image, delays []int, format Format, opts Options) error {
+	if !format.Capabilities().Animation {
+		return errors.New("format does not support animation")
+	}
+	if len(frames) == 0 {
+		return errors.New("animation has no frames")
+	}
+	a := &Animation{}
+	size := frames[0].Bounds().Size()
+	for i, img := range frames {
+		b := img.Bounds()
+		if b.Size() != size {
+			return errors.New("animation frames differ in size")
+		}
+		f := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+		draw.Draw(f, f.Rect, img, b.Min, draw.Src)
+		a.Frames = append(a.Frames, f)
+		delay := 10
+		if i < len(delays) {
+			delay = delays[i]
+		}
+		a.Delays = append(a.Delays, delay)
+	}
+	return encodeAnimatedGIF(w, a, opts)
+}
+
+// encodeAnimatedGIF writes the frames of a. Unless Options.GlobalPalette
+// is set, each is quantized to its own palette as indexed PNG output
+// is, which keeps a transparent entry when the frame needs one.
+func encodeAnimatedGIF(w io.Writer, a *Animation, opts Options) error {
+	w, opts = opts.teed(w)
+	if len(opts.EncryptionKey) > 0 {
+		return opts.sealed(w, func(w io.Writer, opts Options) error {
+			return encodeAnimatedGIF(w, a, opts)
+		})
+	}
+	if len(a.Frames) == 0 {
+		return errors.New("animation has no frames")
+	}
+	a = opts.retime(a)
+	if err := opts.processFrames(a); err != nil {
+		return err
+	}
+	if opts.OptimizeFrames {
+		return encodeOptimizedGIF(w, a, opts)
+	}
+	g := &gif.GIF{LoopCount: a.LoopCount}
+	global := opts.globalPalette(a, false)
+	if global != nil {
+		g.Config = image.Config{ColorModel: global, Width: a.Frames[0].Rect.Dx(), Height: a.Frames[0].Rect.Dy()}
+	}
+	for i, f := range a.Frames {
+		g.Image = append(g.Image, opts.quantizeFrame(f, global))
+		delay := 0
+		if i < len(a.Delays) {
+			delay = a.Delays[i]
+		}
+		g.Delay = append(g.Delay, delay)
+		// Every frame is complete, so clear it for the next one to
+		// keep transparency from showing earlier frames.
+		g.Disposal = append(g.Disposal, gif.DisposalBackground)
+	}
+	return gif.EncodeAll(w, g)
+}
+
+// processFrames runs the stages Encode runs on still images on every
+// frame of a. Trimming and deskewing would crop each frame to its own
+// content, giving frames of different sizes, so they are skipped with a
+// WarnFramesUntrimmed warning.
+func (opts Options) processFrames(a *Animation) error {
+	if opts.Trim || opts.TrimBorders || opts.Deskew {
+		opts.warn(WarnFramesUntrimmed, GIF)
+		opts.Trim, opts.TrimBorders, opts.Deskew = false, false, false
+	}
+	var size image.Point
+	for i, f := range a.Frames {
+		img, err := opts.process(f)
+		if err != nil {
+			return err
+		}
+		b := img.Bounds()
+		if i == 0 {
+			size = b.Size()
+		} else if b.Size() != size {
+			return errors.New("animation frames differ in size")
+		}
+		if m, ok := img.(*image.RGBA); ok && m.Rect.Min == (image.Point{}) {
+			a.Frames[i] = m
+			continue
+		}
+		m := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+		draw.Draw(m, m.Rect, img, b.Min, draw.Src)
+		a.Frames[i] = m
+	}
+	return nil
+}
+
+// globalPalette returns the palette shared by all frames of a, or nil
+// if each frame gets its own. With keep set, the palette always has a
+// transparent entry, for frames that leave pixels unchanged.
+func (opts Options) globalPalette(a *Animation, keep bool) color.Palette {
+	if !opts.GlobalPalette {
+		return nil
+	}
+	n := opts.Colors
+	if n <= 0 || n > 256 {
+		n = 256
+	}
+	m := opts.Quantizer
+	if m == QuantizePlan9 {
+		m = QuantizeMedianCut
+	}
+	if keep {
+		n--
+	}
+	p := m.Palette(animationSamples(a), n)
+	for _, c := range p {
+		if _, _, _, alpha := c.RGBA(); alpha == 0 {
+			return p
+		}
+	}
+	if keep {
+		p = append(p, color.NRGBA{})
+	}
+	return p
+}
+
+// animationSamples returns an image holding evenly spaced pixels from
+// every frame of a, few enough to quantize in one pass.
+func animationSamples(a *Animation) *image.RGBA {
+	budget := maxQuantizeSamples / len(a.Frames)
+	b := a.Frames[0].Rect
+	step := 1
+	for (b.Dx()/step)*(b.Dy()/step) > budget && step < b.Dx()+b.Dy() {
+		step++
+	}
+	w, h := (b.Dx()+step-1)/step, (b.Dy()+step-1)/step
+	stack := image.NewRGBA(image.Rect(0, 0, w, h*len(a.Frames)))
+	for i, f := range a.Frames {
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				copy(stack.Pix[stack.PixOffset(x, i*h+y):], f.Pix[f.PixOffset(b.Min.X+x*step, b.Min.Y+y*step):][:4])
+			}
+		}
+	}
+	return stack
+}
+
+// quantizeFrame maps a frame to the global palette, or to its own if
+// global is nil.
+func (opts Options) quantizeFrame(img image.Image, global color.Palette) *image.Paletted {
+	if global == nil {
+		return indexed(img, opts).(*image.Paletted)
+	}
+	b := img.Bounds()
+	p := image.NewPaletted(b, global)
+	opts.Dither.Draw(p, b, img, b.Min)
+	return p
+}
@@ -0,0 +1,201 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/png"
+)
+
+// pngChunk is a chunk of a PNG stream, without its length and CRC.
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+// readPNGChunks splits a PNG stream into chunks, stopping at IEND.
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, []byte(pngHeader)) {
+		return nil, errors.New("png: bad signature")
+	}
+	var chunks []pngChunk
+	for i := len(pngHeader); i+8 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		if n < 0 || i+12+n > len(data) {
+			return nil, errors.New("png: truncated chunk")
+		}
+		c := pngChunk{string(data[i+4 : i+8]), data[i+8 : i+8+n]}
+		chunks = append(chunks, c)
+		if c.typ == "IEND" {
+			break
+		}
+		i += 12 + n
+	}
+	return chunks, nil
+}
+
+// isAPNG reports whether the PNG stream has an animation control chunk.
+func isAPNG(data []byte) bool {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return false
+	}
+	for _, c := range chunks {
+		switch c.typ {
+		case "acTL":
+			return true
+		case "IDAT":
+			return false
+		}
+	}
+	return false
+}
+
+// apngFrame is the frame control of an APNG frame with its image data.
+type apngFrame struct {
+	rect     image.Rectangle
+	delay    int // hundredths of a second
+	dispose  byte
+	blend    byte
+	idat     [][]byte
+	hasImage bool
+}
+
+const (
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+	apngBlendOver         = 1
+)
+
+// decodeAPNG decodes an animated PNG into composited frames. Each frame
+// is decoded by image/png as a standalone PNG of the frame's size with
+// the stream's header chunks.
+func decodeAPNG(data []byte) (*Animation, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 || chunks[0].typ != "IHDR" || len(chunks[0].data) != 13 {
+		return nil, errors.New("png: missing IHDR")
+	}
+	ihdr := chunks[0].data
+	screen := image.Rect(0, 0, int(binary.BigEndian.Uint32(ihdr)), int(binary.BigEndian.Uint32(ihdr[4:])))
+
+	a := &Animation{}
+	var header []pngChunk // chunks each frame's PNG needs, such as PLTE and tRNS
+	var frames []*apngFrame
+	var cur *apngFrame
+	for _, c := range chunks[1:] {
+		switch c.typ {
+		case "acTL":
+			if len(c.data) != 8 {
+				return nil, errors.New("png: bad acTL")
+			}
+			// APNG counts plays; GIF counts repeats.
+			if plays := int(binary.BigEndian.Uint32(c.data[4:])); plays > 0 {
+				a.LoopCount = plays - 1
+				if plays == 1 {
+					a.LoopCount = -1
+				}
+			}
+		case "fcTL":
+			if len(c.data) != 26 {
+				return nil, errors.New("png: bad fcTL")
+			}
+			d := c.data
+			w, h := int(binary.BigEndian.Uint32(d[4:])), int(binary.BigEndian.Uint32(d[8:]))
+			x, y := int(binary.BigEndian.Uint32(d[12:])), int(binary.BigEndian.Uint32(d[16:]))
+			num, den := int(binary.BigEndian.Uint16(d[20:])), int(binary.BigEndian.Uint16(d[22:]))
+			if den == 0 {
+				den = 100
+			}
+			cur = &apngFrame{
+				rect:    image.Rect(x, y, x+w, y+h),
+				delay:   (num*100 + den/2) / den,
+				dispose: d[24],
+				blend:   d[25],
+			}
+			if !cur.rect.In(screen) || cur.rect.Empty() {
+				return nil, errors.New("png: frame outside image")
+			}
+			frames = append(frames, cur)
+		case "IDAT":
+			// The default image is the first frame only if a frame
+			// control precedes it.
+			if cur != nil {
+				cur.idat = append(cur.idat, c.data)
+				cur.hasImage = true
+			}
+		case "fdAT":
+			if cur == nil || len(c.data) < 4 {
+				return nil, errors.New("png: unexpected fdAT")
+			}
+			cur.idat = append(cur.idat, c.data[4:])
+			cur.hasImage = true
+		case "IEND":
+		default:
+			if cur == nil {
+				header = append(header, c)
+			}
+		}
+	}
+	if len(frames) == 0 {
+		return nil, errors.New("png: animation has no frames")
+	}
+
+	canvas := image.NewRGBA(screen)
+	for i, f := range frames {
+		if !f.hasImage {
+			return nil, errors.New("png: frame has no image data")
+		}
+		img, err := decodeAPNGFrame(ihdr, header, f)
+		if err != nil {
+			return nil, err
+		}
+		// The first frame's previous state is the cleared canvas.
+		dispose := f.dispose
+		if i == 0 && dispose == apngDisposePrevious {
+			dispose = apngDisposeBackground
+		}
+		var saved *image.RGBA
+		if dispose == apngDisposePrevious {
+			saved = cloneRGBA(canvas)
+		}
+		op := draw.Src
+		if f.blend == apngBlendOver {
+			op = draw.Over
+		}
+		draw.Draw(canvas, f.rect, img, img.Bounds().Min, op)
+		a.Frames = append(a.Frames, cloneRGBA(canvas))
+		a.Delays = append(a.Delays, f.delay)
+
+		switch dispose {
+		case apngDisposeBackground:
+			draw.Draw(canvas, f.rect, image.Transparent, image.Point{}, draw.Src)
+		case apngDisposePrevious:
+			canvas = saved
+		}
+	}
+	return a, nil
+}
+
+// decodeAPNGFrame decodes the image data of f as a standalone PNG.
+func decodeAPNGFrame(ihdr []byte, header []pngChunk, f *apngFrame) (image.Image, error) {
+	var buf bytes.Buffer
+	buf.WriteString(pngHeader)
+	pw := &pngWriter{w: &buf}
+	hdr := append([]byte(nil), ihdr...)
+	binary.BigEndian.PutUint32(hdr, uint32(f.rect.Dx()))
+	binary.BigEndian.PutUint32(hdr[4:], uint32(f.rect.Dy()))
+	pw.chunk("IHDR", hdr)
+	for _, c := range header {
+		pw.chunk(c.typ, c.data)
+	}
+	for _, d := range f.idat {
+		pw.chunk("IDAT", d)
+	}
+	pw.chunk("IEND", nil)
+	return png.Decode(&buf)
+}
@@ -0,0 +1,194 @@
+package convert
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"image"
+	"io"
+	"path"
+	"strings"
+)
+
+// ArchiveOptions configures archive conversion.
+type ArchiveOptions struct {
+	Format    Format // output format for image entries, default PNG
+	Options   Options
+	KeepOther bool // copy non-image entries unchanged instead of dropping them
+}
+
+// ArchiveFunc is called for each image read from an archive.
+type ArchiveFunc func(name string, img image.Image, format Format) error
+
+// ZipImages decodes each image entry of a zip archive and passes it to fn.
+func ZipImages(r io.ReaderAt, size int64, fn ArchiveFunc) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !isImageExtension(f.Name) {
+			continue
+		}
+		img, format, err := decodeZipFile(f)
+		if err != nil {
+			return err
+		}
+		if err := fn(f.Name, img, format); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TarImages decodes each image entry of a tar stream and passes it to fn.
+func TarImages(r io.Reader, fn ArchiveFunc) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg || !isImageExtension(hdr.Name) {
+			continue
+		}
+		img, format, err := Decode(tr)
+		if err != nil {
+			return &ArchiveError{Name: hdr.Name, Err: err}
+		}
+		if err := fn(hdr.Name, img, format); err != nil {
+			return err
+		}
+	}
+}
+
+// ConvertZip converts the image entries of a zip archive (including CBZ
+// comic archives) and writes them to a new zip archive on w.
+func ConvertZip(r io.ReaderAt, size int64, w io.Writer, opts ArchiveOptions) error {
+	if opts.Format == "" {
+		opts.Format = PNG
+	}
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if !isImageExtension(f.Name) {
+			if opts.KeepOther {
+				if err := zw.Copy(f); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		img, _, err := decodeZipFile(f)
+		if err != nil {
+			return err
+		}
+		// A fresh header, so that no extra records of the source
+		// entry, such as its zip64 sizes, are carried over.
+		ew, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     archiveName(f.Name, opts.Format),
+			Method:   zip.Deflate,
+			Modified: f.Modified,
+			Comment:  f.Comment,
+		})
+		if err != nil {
+			return err
+		}
+		if err := Encode(ew, img, opts.Format, opts.Options); err != nil {
+			return &ArchiveError{Name: f.Name, Err: err}
+		}
+	}
+	return zw.Close()
+}
+
+// ConvertTar converts the image entries of a tar stream and writes them
+// to a new tar stream on w.
+func ConvertTar(r io.Reader, w io.Writer, opts ArchiveOptions) error {
+	if opts.Format == "" {
+		opts.Format = PNG
+	}
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if hdr.Typeflag != tar.TypeReg || !isImageExtension(hdr.Name) {
+			if opts.KeepOther {
+				if err := tw.WriteHeader(hdr); err != nil {
+					return err
+				}
+				if _, err := io.Copy(tw, tr); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		img, _, err := Decode(tr)
+		if err != nil {
+			return &ArchiveError{Name: hdr.Name, Err: err}
+		}
+		// Tar headers carry the entry size, so the output is buffered.
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, opts.Format, opts.Options); err != nil {
+			return &ArchiveError{Name: hdr.Name, Err: err}
+		}
+		out := *hdr
+		out.Name = archiveName(hdr.Name, opts.Format)
+		out.Size = int64(buf.Len())
+		if err := tw.WriteHeader(&out); err != nil {
+			return err
+		}
+		if _, err := buf.WriteTo(tw); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// ArchiveError records the archive entry that failed to convert.
+type ArchiveError struct {
+	Name string
+	Err  error
+}
+
+func (e *ArchiveError) Error() string { return e.Name + ": " + e.Err.Error() }
+
+func (e *ArchiveError) Unwrap() error { return e.Err }
+
+func decodeZipFile(f *zip.File) (image.Image, Format, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, "", &ArchiveError{Name: f.Name, Err: err}
+	}
+	defer rc.Close()
+	img, format, err := Decode(rc)
+	if err != nil {
+		return nil, "", &ArchiveError{Name: f.Name, Err: err}
+	}
+	return img, format, nil
+}
+
+// archiveName replaces the extension of an entry name with the one for
+// format.
+func archiveName(name string, format Format) string {
+	return strings.TrimSuffix(name, path.Ext(name)) + "." + format.Extension()
+}
@@ -0,0 +1,126 @@
+package convert
+
+import (
+	"image"
+	"os"
+	"sync"
+)
+
+// An Arena allocates pixel buffers outside the Go heap, from anonymous
+// memory maps, and frees them all at once when Release is called. Large
+// images built in an arena do not grow the heap or lengthen garbage
+// collection, and their memory is returned to the system as soon as
+// they have been encoded rather than at the next collection. On systems
+// without mmap the buffers are ordinary heap memory.
+//
+// Once MemoryBudget bytes have been allocated, further buffers are
+// mapped from temporary files instead, so the system can page them out
+// to disk rather than run out of memory. The files are closed and
+// unlinked as soon as they are mapped and so are cleaned up even if the
+// process dies. Without mmap there is nothing to spill to, and
+// MemoryBudget is ignored.
+//
+// Images allocated from an arena must not be used after Release. The
+// zero value is ready to use, and an Arena is safe for concurrent use.
+type Arena struct {
+	MemoryBudget int64  // bytes to allocate in memory before spilling to disk, 0 for no limit
+	TempDir      string // directory for spill files, default os.TempDir
+
+	mu   sync.Mutex
+	maps [][]byte
+	used int64
+}
+
+func (a *Arena) alloc(n int) ([]byte, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	a.mu.Lock()
+	spill := canMapFiles && a.MemoryBudget > 0 && a.used+int64(n) > a.MemoryBudget
+	if !spill {
+		a.used += int64(n)
+	}
+	a.mu.Unlock()
+
+	var b []byte
+	var err error
+	if spill {
+		b, err = a.mapTemp(n)
+	} else {
+		b, err = mapMemory(n)
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	a.maps = append(a.maps, b)
+	a.mu.Unlock()
+	return b, nil
+}
+
+// mapTemp maps n bytes of a new temporary file, which is removed once
+// closed, as Windows requires, and the mapping outlives.
+func (a *Arena) mapTemp(n int) ([]byte, error) {
+	f, err := os.CreateTemp(a.TempDir, "convert-arena-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := f.Truncate(int64(n)); err != nil {
+		return nil, err
+	}
+	return mapFile(f, n)
+}
+
+// NewRGBA returns a new image.RGBA with the given bounds.
+func (a *Arena) NewRGBA(r image.Rectangle) (*image.RGBA, error) {
+	pix, err := a.alloc(4 * r.Dx() * r.Dy())
+	if err != nil {
+		return nil, err
+	}
+	return &image.RGBA{Pix: pix, Stride: 4 * r.Dx(), Rect: r}, nil
+}
+
+// NewNRGBA returns a new image.NRGBA with the given bounds.
+func (a *Arena) NewNRGBA(r image.Rectangle) (*image.NRGBA, error) {
+	pix, err := a.alloc(4 * r.Dx() * r.Dy())
+	if err != nil {
+		return nil, err
+	}
+	return &image.NRGBA{Pix: pix, Stride: 4 * r.Dx(), Rect: r}, nil
+}
+
+// NewRGBA64 returns a new image.RGBA64 with the given bounds.
+func (a *Arena) NewRGBA64(r image.Rectangle) (*image.RGBA64, error) {
+	pix, err := a.alloc(8 * r.Dx() * r.Dy())
+	if err != nil {
+		return nil, err
+	}
+	return &image.RGBA64{Pix: pix, Stride: 8 * r.Dx(), Rect: r}, nil
+}
+
+// NewGray returns a new image.Gray with the given bounds.
+func (a *Arena) NewGray(r image.Rectangle) (*image.Gray, error) {
+	pix, err := a.alloc(r.Dx() * r.Dy())
+	if err != nil {
+		return nil, err
+	}
+	return &image.Gray{Pix: pix, Stride: r.Dx(), Rect: r}, nil
+}
+
+// Release frees every buffer allocated from the arena, which may then
+// be reused.
+func (a *Arena) Release() error {
+	a.mu.Lock()
+	maps := a.maps
+	a.maps, a.used = nil, 0
+	a.mu.Unlock()
+	var first error
+	for _, b := range maps {
+		if err := unmapMemory(b); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
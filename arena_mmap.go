@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package convert
+
+import (
+	"os"
+	"syscall"
+)
+
+// canMapFiles reports whether mapFile maps files, so that arenas can
+// spill to disk.
+const canMapFiles = true
+
+func mapMemory(n int) ([]byte, error) {
+	return syscall.Mmap(-1, 0, n, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+}
+
+func unmapMemory(b []byte) error {
+	return syscall.Munmap(b)
+}
+
+func mapFile(f *os.File, n int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, n, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+}
@@ -0,0 +1,23 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package convert
+
+import (
+	"errors"
+	"os"
+)
+
+const canMapFiles = false
+
+func mapMemory(n int) ([]byte, error) {
+	return make([]byte, n), nil
+}
+
+func mapFile(f *os.File, n int) ([]byte, error) {
+	return nil, errors.New("mapping files is not supported on this system")
+}
+
+func unmapMemory(b []byte) error {
+	return nil
+}
@@ -0,0 +1,203 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const modulePath = "github.com/imgutils-org/imgutils-convert"
+
+var errBadAuditSignature = errors.New("bad signature")
+
+// AuditEntry is one line of an audit log.
+type AuditEntry struct {
+	Time         time.Time       `json:"time"`
+	Input        string          `json:"input"`
+	InputSHA256  string          `json:"input_sha256"`
+	Output       string          `json:"output"`
+	OutputSHA256 string          `json:"output_sha256"`
+	Format       Format          `json:"format"`
+	Options      json.RawMessage `json:"options"`
+	Software     string          `json:"software"`            // module path and version
+	Prev         string          `json:"prev"`                // SHA-256 of the previous line, empty for the first
+	Signature    string          `json:"signature,omitempty"` // Ed25519 signature of the line without this field
+}
+
+// An AuditLog appends a record of each conversion to a JSON Lines file.
+// Each line holds the hash of the one before, so lines cannot be
+// removed, reordered or edited without breaking the chain, and is
+// optionally signed so that the chain cannot be rewritten either.
+// VerifyAuditLog checks both.
+type AuditLog struct {
+	mu   sync.Mutex
+	f    *os.File
+	key  ed25519.PrivateKey
+	prev string
+}
+
+// OpenAuditLog opens the audit log at path for appending, creating it
+// if needed. If key is not nil, every entry is signed with it.
+func OpenAuditLog(path string, key ed25519.PrivateKey) (*AuditLog, error) {
+	f, err := os.OpenFile(longPath(path), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l := &AuditLog{f: f, key: key}
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<30)
+	for s.Scan() {
+		if line := s.Bytes(); len(line) > 0 {
+			l.prev = lineHash(line)
+		}
+	}
+	if err := s.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Close closes the log file.
+func (l *AuditLog) Close() error {
+	return l.f.Close()
+}
+
+// auditOptions is the options as recorded in an AuditEntry, with the
+// Grade LUT and Mask image replaced by their SHA-256 digests.
+type auditOptions struct {
+	Options
+	GradeSHA256 string `json:",omitempty"`
+	MaskSHA256  string `json:",omitempty"`
+}
+
+func newAuditOptions(opts Options) (auditOptions, error) {
+	a := auditOptions{Options: opts}
+	if opts.Grade != nil {
+		data, err := json.Marshal(opts.Grade)
+		if err != nil {
+			return a, err
+		}
+		sum := sha256.Sum256(data)
+		a.Grade, a.GradeSHA256 = nil, hex.EncodeToString(sum[:])
+	}
+	if opts.Mask != nil {
+		h := sha256.New()
+		fmt.Fprintf(h, "%v\n", opts.Mask.Bounds().Size())
+		imageScanlines(opts.Mask, func(y int, row []byte) error {
+			h.Write(row)
+			return nil
+		})
+		a.MaskSHA256 = hex.EncodeToString(h.Sum(nil))
+	}
+	return a, nil
+}
+
+// ConvertFile runs ConvertFile and, if it succeeds, appends an entry
+// recording the hashes of the input and output files and the options.
+// Bulky options, the Grade LUT and Mask image, are recorded as their
+// SHA-256 digests.
+func (l *AuditLog) ConvertFile(inputPath, outputPath string, opts Options) error {
+	a, err := newAuditOptions(opts)
+	if err != nil {
+		return err
+	}
+	o, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	inSum, err := FileSHA256(inputPath)
+	if err != nil {
+		return err
+	}
+	if err := ConvertFile(inputPath, outputPath, opts); err != nil {
+		return err
+	}
+	outSum, err := FileSHA256(outputPath)
+	if err != nil {
+		return err
+	}
+	return l.append(AuditEntry{
+		Time:         time.Now().UTC(),
+		Input:        inputPath,
+		InputSHA256:  inSum,
+		Output:       outputPath,
+		OutputSHA256: outSum,
+		Format:       FormatFromExtension(outputPath),
+		Options:      o,
+		Software:     modulePath + "@" + BuildInfo().Version,
+	})
+}
+
+func (l *AuditLog) append(e AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e.Prev = l.prev
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if l.key != nil {
+		e.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(l.key, line))
+		if line, err = json.Marshal(e); err != nil {
+			return err
+		}
+	}
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	l.prev = lineHash(line)
+	return nil
+}
+
+// VerifyAuditLog checks that every line of an audit log links to the one
+// before it and, if pub is not nil, is signed by its private key.
+func VerifyAuditLog(r io.Reader, pub ed25519.PublicKey) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<30)
+	prev := ""
+	for n := 1; s.Scan(); n++ {
+		line := s.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var e AuditEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return fmt.Errorf("audit: line %d: %w", n, err)
+		}
+		if e.Prev != prev {
+			return fmt.Errorf("audit: line %d: chain broken", n)
+		}
+		if pub != nil {
+			sig, err := base64.StdEncoding.DecodeString(e.Signature)
+			if err != nil || e.Signature == "" {
+				return fmt.Errorf("audit: line %d: missing signature", n)
+			}
+			e.Signature = ""
+			unsigned, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if !ed25519.Verify(pub, unsigned, sig) {
+				return fmt.Errorf("audit: line %d: %w", n, errBadAuditSignature)
+			}
+		}
+		prev = lineHash(line)
+	}
+	return s.Err()
+}
+
+func lineHash(line []byte) string {
+	sum := sha256.Sum256(bytes.TrimSpace(line))
+	return hex.EncodeToString(sum[:])
+}
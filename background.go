@@ -0,0 +1,60 @@
+package convert
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/imgutils-org/imgutils-convert/pixels"
+)
+
+// A Matter separates the foreground of an image from its background,
+// returning the opacity of each pixel: 0xff for foreground, 0 for
+// background. Segmentation models can implement it to plug into
+// RemoveBackground.
+type Matter interface {
+	Matte(img image.Image) (*image.Alpha, error)
+}
+
+// ChromaKey is a Matter that treats pixels near Color as background,
+// as for photos taken against a green screen or a plain backdrop.
+type ChromaKey struct {
+	Color     color.Color
+	Tolerance int // distance in RGB space, on a 0-255 scale, within which pixels are background
+	Softness  int // width of the ramp beyond Tolerance over which pixels fade in
+}
+
+// Matte implements Matter.
+func (k ChromaKey) Matte(img image.Image) (*image.Alpha, error) {
+	if k.Color == nil {
+		return nil, errors.New("chroma key has no color")
+	}
+	key := color.NRGBAModel.Convert(k.Color).(color.NRGBA)
+	tol, soft := float64(k.Tolerance), float64(k.Softness)
+	b := img.Bounds()
+	m := image.NewAlpha(b)
+	pixels.ForEachPixel(img, func(x, y int, c pixels.Pixel[uint8]) {
+		dr, dg, db := float64(c.R)-float64(key.R), float64(c.G)-float64(key.G), float64(c.B)-float64(key.B)
+		d := math.Sqrt(dr*dr + dg*dg + db*db)
+		a := 0xff
+		switch {
+		case d <= tol:
+			a = 0
+		case d < tol+soft:
+			a = int((d - tol) / soft * 0xff)
+		}
+		m.Pix[m.PixOffset(x, y)] = uint8(a)
+	})
+	return m, nil
+}
+
+// RemoveBackground returns img with the background m finds made
+// transparent. Encode it to a format with alpha, such as PNG.
+func RemoveBackground(img image.Image, m Matter) (image.Image, error) {
+	mask, err := m.Matte(img)
+	if err != nil {
+		return nil, err
+	}
+	return ApplyMask(img, mask), nil
+}
@@ -0,0 +1,65 @@
+// Package barcode generates QR codes and Code 128 barcodes as images,
+// ready to be composed or encoded like decoded ones.
+package barcode
+
+import (
+	"image"
+	"image/color"
+)
+
+// Options configures rendering. A nil *Options uses the defaults.
+type Options struct {
+	// Scale is the size in pixels of one module, default 4 for QR
+	// codes and 2 for Code 128.
+	Scale int
+
+	// QuietZone is the margin in modules, default 4 for QR codes and
+	// 10 for Code 128. Negative values leave no margin.
+	QuietZone int
+
+	// Level is the QR error correction level, default L.
+	Level Level
+
+	// Height is the Code 128 bar height in pixels, default 25 modules.
+	Height int
+}
+
+// palette draws light modules white and dark ones black.
+var palette = color.Palette{color.White, color.Black}
+
+func (o *Options) scale(def int) int {
+	if o == nil || o.Scale <= 0 {
+		return def
+	}
+	return o.Scale
+}
+
+func (o *Options) quietZone(def int) int {
+	switch {
+	case o == nil || o.QuietZone == 0:
+		return def
+	case o.QuietZone < 0:
+		return 0
+	}
+	return o.QuietZone
+}
+
+// render draws a w×h grid of modules, dark where dark reports true,
+// with a quiet zone of q modules and s pixels per module.
+func render(w, h, q, s int, dark func(x, y int) bool) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, (w+2*q)*s, (h+2*q)*s), palette)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if !dark(x, y) {
+				continue
+			}
+			for py := (y + q) * s; py < (y+q+1)*s; py++ {
+				row := img.Pix[py*img.Stride:]
+				for px := (x + q) * s; px < (x+q+1)*s; px++ {
+					row[px] = 1
+				}
+			}
+		}
+	}
+	return img
+}
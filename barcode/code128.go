@@ -0,0 +1,159 @@
+package barcode
+
+import (
+	"errors"
+	"image"
+)
+
+// code128Patterns holds the bar and space widths of each symbol value,
+// starting with a bar. Values 103-105 are the start codes and 106 is
+// the stop pattern with its final bar.
+var code128Patterns = [107]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+// Code 128 code sets, with the value of the start code and of the
+// switch to each set from another one.
+const (
+	setA = iota
+	setB
+	setC
+)
+
+var (
+	code128Start  = [3]int{103, 104, 105}
+	code128Switch = [3]int{101, 100, 99}
+)
+
+const code128Stop = 106
+
+// Code128 returns content, which must be ASCII, encoded as a Code 128
+// barcode. Runs of digits use code set C, which packs two per symbol.
+func Code128(content string, opts *Options) (*image.Paletted, error) {
+	values, err := code128Values(content)
+	if err != nil {
+		return nil, err
+	}
+	var widths []int
+	for _, v := range values {
+		for _, c := range code128Patterns[v] {
+			widths = append(widths, int(c-'0'))
+		}
+	}
+	modules := 0
+	for _, w := range widths {
+		modules += w
+	}
+
+	s, q := opts.scale(2), opts.quietZone(10)
+	h := 25 * s
+	if opts != nil && opts.Height > 0 {
+		h = opts.Height
+	}
+	dark := make([]bool, 0, modules)
+	for i, w := range widths {
+		for k := 0; k < w; k++ {
+			dark = append(dark, i%2 == 0)
+		}
+	}
+	img := render(modules, 1, q, s, func(x, _ int) bool { return dark[x] })
+	// Stretch the single row of modules to the bar height.
+	row := img.Pix[q*s*img.Stride : q*s*img.Stride+img.Stride]
+	tall := image.NewPaletted(image.Rect(0, 0, img.Bounds().Dx(), h), palette)
+	for y := 0; y < h; y++ {
+		copy(tall.Pix[y*tall.Stride:], row)
+	}
+	return tall, nil
+}
+
+// code128Values returns the symbol values encoding content, from the
+// start code to the stop pattern.
+func code128Values(content string) ([]int, error) {
+	if content == "" {
+		return nil, errors.New("barcode: empty code 128 content")
+	}
+	for i := 0; i < len(content); i++ {
+		if content[i] >= 0x80 {
+			return nil, errors.New("barcode: code 128 content must be ascii")
+		}
+	}
+	digits := func(i int) int {
+		n := 0
+		for i+n < len(content) && content[i+n] >= '0' && content[i+n] <= '9' {
+			n++
+		}
+		return n
+	}
+
+	var values []int
+	set := -1
+	use := func(s int) {
+		switch {
+		case set < 0:
+			values = append(values, code128Start[s])
+		case set != s:
+			values = append(values, code128Switch[s])
+		}
+		set = s
+	}
+	for i := 0; i < len(content); {
+		// Set C pays off for four digits at either end of the content
+		// and six in the middle.
+		if n := digits(i); n >= 6 || n >= 4 && (i == 0 || i+n == len(content)) {
+			if n%2 == 1 {
+				use(textSet(content[i], set))
+				values = append(values, textValue(content[i]))
+				i++
+				n--
+			}
+			use(setC)
+			for ; n > 0; n -= 2 {
+				values = append(values, int(content[i]-'0')*10+int(content[i+1]-'0'))
+				i += 2
+			}
+			continue
+		}
+		use(textSet(content[i], set))
+		values = append(values, textValue(content[i]))
+		i++
+	}
+
+	sum := values[0]
+	for i, v := range values[1:] {
+		sum += (i + 1) * v
+	}
+	return append(values, sum%103, code128Stop), nil
+}
+
+// textSet returns the code set to encode c in, keeping the current
+// set when it can.
+func textSet(c byte, current int) int {
+	switch {
+	case c < 0x20:
+		return setA
+	case c >= 0x60:
+		return setB
+	case current == setA:
+		return setA
+	}
+	return setB
+}
+
+// textValue returns the value of c in code set A or B, which share
+// the values of the characters space to underscore.
+func textValue(c byte) int {
+	if c < 0x20 {
+		return int(c) + 64
+	}
+	return int(c) - 32
+}
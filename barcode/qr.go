@@ -0,0 +1,266 @@
+package barcode
+
+import (
+	"errors"
+	"image"
+	"strings"
+)
+
+// Level is a QR code error correction level.
+type Level int
+
+const (
+	L Level = iota // recovers about 7% of codewords
+	M              // about 15%
+	Q              // about 25%
+	H              // about 30%
+)
+
+// ErrTooLong is returned for content that does not fit in a version 40
+// QR code at the requested level.
+var ErrTooLong = errors.New("barcode: content too long for a qr code")
+
+// eccPerBlock and eccBlocks give, for each level and version, the
+// error correction codewords per block and the number of blocks.
+var eccPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var eccBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// formatLevel is the two-bit level field of the format information.
+var formatLevel = [4]int{L: 1, M: 0, Q: 3, H: 2}
+
+const alphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+// segment is content encoded in one of the QR data modes.
+type segment struct {
+	mode      int    // mode indicator
+	count     int    // characters
+	countBits [3]int // count field width for versions 1-9, 10-26 and 27-40
+	bits      bitBuffer
+}
+
+// newSegment encodes s in the densest mode that covers all of it.
+func newSegment(s string) segment {
+	numeric, alnum := true, true
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			numeric = false
+		}
+		if strings.IndexByte(alphanumeric, s[i]) < 0 {
+			alnum = false
+		}
+	}
+	seg := segment{count: len(s)}
+	switch {
+	case numeric:
+		seg.mode, seg.countBits = 1, [3]int{10, 12, 14}
+		for i := 0; i < len(s); i += 3 {
+			n := len(s) - i
+			if n > 3 {
+				n = 3
+			}
+			v := 0
+			for _, c := range s[i : i+n] {
+				v = v*10 + int(c-'0')
+			}
+			seg.bits.append(v, 3*n+1)
+		}
+	case alnum:
+		seg.mode, seg.countBits = 2, [3]int{9, 11, 13}
+		for i := 0; i+1 < len(s); i += 2 {
+			seg.bits.append(strings.IndexByte(alphanumeric, s[i])*45+strings.IndexByte(alphanumeric, s[i+1]), 11)
+		}
+		if len(s)%2 == 1 {
+			seg.bits.append(strings.IndexByte(alphanumeric, s[len(s)-1]), 6)
+		}
+	default:
+		seg.mode, seg.countBits = 4, [3]int{8, 16, 16}
+		for i := 0; i < len(s); i++ {
+			seg.bits.append(int(s[i]), 8)
+		}
+	}
+	return seg
+}
+
+func (s *segment) countWidth(version int) int {
+	switch {
+	case version < 10:
+		return s.countBits[0]
+	case version < 27:
+		return s.countBits[1]
+	}
+	return s.countBits[2]
+}
+
+// QR returns content encoded as a QR code in the smallest version that
+// holds it. Digits and the upper-case alphanumeric set are encoded
+// compactly; other content is stored as bytes, conventionally UTF-8.
+func QR(content string, opts *Options) (*image.Paletted, error) {
+	level := L
+	if opts != nil {
+		level = opts.Level
+	}
+	if level < L || level > H {
+		return nil, errors.New("barcode: unknown error correction level")
+	}
+	seg := newSegment(content)
+	version := 1
+	for ; version <= 40; version++ {
+		if 4+seg.countWidth(version)+len(seg.bits) <= dataCodewords(version, level)*8 &&
+			seg.count < 1<<seg.countWidth(version) {
+			break
+		}
+	}
+	if version > 40 {
+		return nil, ErrTooLong
+	}
+
+	var bits bitBuffer
+	bits.append(seg.mode, 4)
+	bits.append(seg.count, seg.countWidth(version))
+	bits = append(bits, seg.bits...)
+	capacity := dataCodewords(version, level) * 8
+	terminator := capacity - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xec; len(bits) < capacity; pad ^= 0xec ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	data := make([]byte, len(bits)/8)
+	for i, b := range bits {
+		data[i/8] |= b << (7 - i%8)
+	}
+	m := newMatrix(version)
+	m.drawFunctionPatterns(level)
+	m.drawCodewords(interleave(data, version, level))
+	m.applyBestMask(level)
+
+	return render(m.size, m.size, opts.quietZone(4), opts.scale(4), func(x, y int) bool {
+		return m.dark[y*m.size+x]
+	}), nil
+}
+
+// bitBuffer is a sequence of bits, one per element.
+type bitBuffer []byte
+
+func (b *bitBuffer) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, byte(v>>i&1))
+	}
+}
+
+// rawModules returns the number of modules available for codewords in
+// a QR code of the version, including the remainder bits.
+func rawModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+// dataCodewords returns the number of data codewords of the version
+// and level.
+func dataCodewords(version int, level Level) int {
+	return rawModules(version)/8 - eccPerBlock[level][version]*eccBlocks[level][version]
+}
+
+// interleave splits data into blocks, appends their Reed-Solomon error
+// correction codewords and interleaves the result.
+func interleave(data []byte, version int, level Level) []byte {
+	blocks := eccBlocks[level][version]
+	eccLen := eccPerBlock[level][version]
+	raw := rawModules(version) / 8
+	short := blocks - raw%blocks // blocks with one data codeword fewer
+	shortLen := raw/blocks - eccLen
+
+	gen := rsGenerator(eccLen)
+	split := make([][]byte, blocks)
+	ecc := make([][]byte, blocks)
+	for i, k := 0, 0; i < blocks; i++ {
+		n := shortLen
+		if i >= short {
+			n++
+		}
+		split[i] = data[k : k+n]
+		ecc[i] = rsRemainder(split[i], gen)
+		k += n
+	}
+
+	out := make([]byte, 0, raw)
+	for i := 0; i <= shortLen; i++ {
+		for _, b := range split {
+			if i < len(b) {
+				out = append(out, b[i])
+			}
+		}
+	}
+	for i := 0; i < eccLen; i++ {
+		for _, e := range ecc {
+			out = append(out, e[i])
+		}
+	}
+	return out
+}
+
+// gfMul multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMul(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		hi := z >> 7
+		z = z<<1 ^ hi*0x1d
+		z ^= (y >> i & 1) * x
+	}
+	return z
+}
+
+// rsGenerator returns the coefficients, highest power first and
+// without the leading 1, of the Reed-Solomon generator polynomial
+// with roots α^0 to α^(degree-1).
+func rsGenerator(degree int) []byte {
+	g := make([]byte, degree)
+	g[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range g {
+			g[j] = gfMul(g[j], root)
+			if j+1 < len(g) {
+				g[j] ^= g[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return g
+}
+
+// rsRemainder returns the error correction codewords of data.
+func rsRemainder(data, gen []byte) []byte {
+	r := make([]byte, len(gen))
+	for _, b := range data {
+		f := b ^ r[0]
+		copy(r, r[1:])
+		r[len(r)-1] = 0
+		for i, g := range gen {
+			r[i] ^= gfMul(g, f)
+		}
+	}
+	return r
+}
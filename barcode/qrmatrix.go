@@ -0,0 +1,291 @@
+package barcode
+
+// matrix holds the modules of a QR code symbol.
+type matrix struct {
+	version  int
+	size     int
+	dark     []bool
+	function []bool // finder, timing, alignment, format and version modules
+}
+
+func newMatrix(version int) *matrix {
+	size := 4*version + 17
+	return &matrix{
+		version:  version,
+		size:     size,
+		dark:     make([]bool, size*size),
+		function: make([]bool, size*size),
+	}
+}
+
+func (m *matrix) setFunction(x, y int, dark bool) {
+	m.dark[y*m.size+x] = dark
+	m.function[y*m.size+x] = true
+}
+
+// drawFunctionPatterns draws everything but the codewords. The format
+// information is drawn for mask 0 to reserve its modules.
+func (m *matrix) drawFunctionPatterns(level Level) {
+	for i := 0; i < m.size; i++ {
+		m.setFunction(6, i, i%2 == 0)
+		m.setFunction(i, 6, i%2 == 0)
+	}
+	m.drawFinder(3, 3)
+	m.drawFinder(m.size-4, 3)
+	m.drawFinder(3, m.size-4)
+
+	pos := m.alignmentPositions()
+	last := len(pos) - 1
+	for i, x := range pos {
+		for j, y := range pos {
+			// Skip the three corners taken by finder patterns.
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			m.drawAlignment(x, y)
+		}
+	}
+	m.drawFormat(level, 0)
+	m.drawVersion()
+}
+
+// drawFinder draws a finder pattern and its separator around (cx, cy).
+func (m *matrix) drawFinder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || x >= m.size || y < 0 || y >= m.size {
+				continue
+			}
+			d := maxInt(absInt(dx), absInt(dy))
+			m.setFunction(x, y, d != 2 && d != 4)
+		}
+	}
+}
+
+func (m *matrix) drawAlignment(cx, cy int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			m.setFunction(cx+dx, cy+dy, maxInt(absInt(dx), absInt(dy)) != 1)
+		}
+	}
+}
+
+// alignmentPositions returns the row and column centres of the
+// alignment patterns.
+func (m *matrix) alignmentPositions() []int {
+	if m.version == 1 {
+		return nil
+	}
+	n := m.version/7 + 2
+	step := (m.version*8 + n*3 + 5) / (n*4 - 4) * 2
+	pos := make([]int, n)
+	pos[0] = 6
+	for i, p := n-1, m.size-7; i > 0; i, p = i-1, p-step {
+		pos[i] = p
+	}
+	return pos
+}
+
+// drawFormat draws both copies of the format information and the dark
+// module.
+func (m *matrix) drawFormat(level Level, mask int) {
+	data := formatLevel[level]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		m.setFunction(8, i, bit(i))
+	}
+	m.setFunction(8, 7, bit(6))
+	m.setFunction(8, 8, bit(7))
+	m.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		m.setFunction(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		m.setFunction(m.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		m.setFunction(8, m.size-15+i, bit(i))
+	}
+	m.setFunction(8, m.size-8, true)
+}
+
+// drawVersion draws both copies of the version information, present
+// from version 7.
+func (m *matrix) drawVersion() {
+	if m.version < 7 {
+		return
+	}
+	rem := m.version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1f25
+	}
+	bits := m.version<<12 | rem
+	for i := 0; i < 18; i++ {
+		dark := bits>>i&1 != 0
+		a, b := m.size-11+i%3, i/3
+		m.setFunction(a, b, dark)
+		m.setFunction(b, a, dark)
+	}
+}
+
+// drawCodewords places the codewords in the two-module-wide zigzag
+// columns from the bottom right, skipping function modules.
+func (m *matrix) drawCodewords(data []byte) {
+	i := 0
+	for right := m.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		for vert := 0; vert < m.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = m.size - 1 - vert // upward column
+				}
+				if m.function[y*m.size+x] || i >= len(data)*8 {
+					continue
+				}
+				m.dark[y*m.size+x] = data[i/8]>>(7-i%8)&1 != 0
+				i++
+			}
+		}
+	}
+}
+
+// masked reports whether mask pattern k inverts the module at (x, y).
+func masked(k, x, y int) bool {
+	switch k {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	}
+	return ((x+y)%2+x*y%3)%2 == 0
+}
+
+// applyMask inverts the data modules selected by mask pattern k. It is
+// its own inverse.
+func (m *matrix) applyMask(k int) {
+	for y := 0; y < m.size; y++ {
+		for x := 0; x < m.size; x++ {
+			if !m.function[y*m.size+x] && masked(k, x, y) {
+				m.dark[y*m.size+x] = !m.dark[y*m.size+x]
+			}
+		}
+	}
+}
+
+// applyBestMask applies the mask pattern with the lowest penalty score.
+func (m *matrix) applyBestMask(level Level) {
+	best, bestScore := 0, -1
+	for k := 0; k < 8; k++ {
+		m.applyMask(k)
+		m.drawFormat(level, k)
+		if s := m.penalty(); bestScore < 0 || s < bestScore {
+			best, bestScore = k, s
+		}
+		m.applyMask(k)
+	}
+	m.applyMask(best)
+	m.drawFormat(level, best)
+}
+
+// penalty scores the symbol by the four rules of ISO/IEC 18004 section
+// 7.8.3; lower scores are easier to read.
+func (m *matrix) penalty() int {
+	n := m.size
+	at := func(x, y int) bool { return m.dark[y*n+x] }
+	score := 0
+
+	// Runs of five or more modules of one colour, in rows and columns,
+	// and finder-like 1:1:3:1:1 patterns with four light modules on
+	// one side.
+	finder := [11]bool{true, false, true, true, true, false, true, false, false, false, false}
+	for _, row := range []bool{true, false} {
+		get := func(i, j int) bool {
+			if row {
+				return at(j, i)
+			}
+			return at(i, j)
+		}
+		for i := 0; i < n; i++ {
+			run := 1
+			for j := 1; j <= n; j++ {
+				if j < n && get(i, j) == get(i, j-1) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += run - 2
+				}
+				run = 1
+			}
+			for j := 0; j+11 <= n; j++ {
+				fwd, rev := true, true
+				for k := 0; k < 11; k++ {
+					fwd = fwd && get(i, j+k) == finder[k]
+					rev = rev && get(i, j+k) == finder[10-k]
+				}
+				if fwd {
+					score += 40
+				}
+				if rev {
+					score += 40
+				}
+			}
+		}
+	}
+
+	// Two-by-two blocks of one colour.
+	for y := 0; y+1 < n; y++ {
+		for x := 0; x+1 < n; x++ {
+			c := at(x, y)
+			if c == at(x+1, y) && c == at(x, y+1) && c == at(x+1, y+1) {
+				score += 3
+			}
+		}
+	}
+
+	// Deviation of the proportion of dark modules from one half.
+	darkCount := 0
+	for _, d := range m.dark {
+		if d {
+			darkCount++
+		}
+	}
+	total := n * n
+	k := (absInt(darkCount*20-total*10)+total-1)/total - 1
+	return score + k*10
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
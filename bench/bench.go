@@ -0,0 +1,173 @@
+// Package bench measures decode, encode and conversion throughput per
+// format and image size on the current machine, for comparing formats
+// and tuning how many conversions to run at once.
+package bench
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+
+	convert "github.com/imgutils-org/imgutils-convert"
+	"github.com/imgutils-org/imgutils-convert/testpattern"
+)
+
+// Options configures Run.
+type Options struct {
+	Formats     []convert.Format // formats to measure, default JPEG, PNG, GIF, BMP and TIFF
+	Sizes       []image.Point    // image sizes, default 640×480 and 1920×1080
+	Duration    time.Duration    // minimum time to spend on each measurement, default 1s
+	Parallelism int              // conversions run at once, default 1
+	Image       image.Image      // source image, resized to each size, default a text chart over colour bars
+	Options     convert.Options  // encoding options
+}
+
+// Result is one measurement.
+type Result struct {
+	Op            string         `json:"op"` // "decode", "encode" or "convert"
+	Format        convert.Format `json:"format"`
+	Width         int            `json:"width"`
+	Height        int            `json:"height"`
+	Parallelism   int            `json:"parallelism"`
+	Iterations    int            `json:"iterations"`
+	NsPerOp       int64          `json:"ns_per_op"` // wall time over iterations, so it falls with parallelism
+	MPixelsPerSec float64        `json:"mpixels_per_sec"`
+	EncodedBytes  int            `json:"encoded_bytes"` // size of the image in Format
+}
+
+// Report is the outcome of Run, including the machine it ran on.
+type Report struct {
+	GoVersion string   `json:"go_version"`
+	GOOS      string   `json:"goos"`
+	GOARCH    string   `json:"goarch"`
+	NumCPU    int      `json:"num_cpu"`
+	Results   []Result `json:"results"`
+}
+
+// Run measures each format at each size. Encode it as JSON to compare
+// runs across machines or settings.
+func Run(opts Options) (*Report, error) {
+	if len(opts.Formats) == 0 {
+		opts.Formats = []convert.Format{convert.JPEG, convert.PNG, convert.GIF, convert.BMP, convert.TIFF}
+	}
+	if len(opts.Sizes) == 0 {
+		opts.Sizes = []image.Point{{640, 480}, {1920, 1080}}
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = time.Second
+	}
+	if opts.Parallelism <= 0 {
+		opts.Parallelism = 1
+	}
+	if opts.Image == nil {
+		img, err := defaultImage()
+		if err != nil {
+			return nil, err
+		}
+		opts.Image = img
+	}
+
+	rep := &Report{
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		NumCPU:    runtime.NumCPU(),
+	}
+	for _, size := range opts.Sizes {
+		img := convert.Resize(opts.Image, size.X, size.Y)
+		for _, format := range opts.Formats {
+			var buf bytes.Buffer
+			if err := convert.Encode(&buf, img, format, opts.Options); err != nil {
+				return nil, err
+			}
+			data := buf.Bytes()
+			ops := []struct {
+				name string
+				f    func() error
+			}{
+				{"decode", func() error {
+					_, _, err := convert.Decode(bytes.NewReader(data))
+					return err
+				}},
+				{"encode", func() error {
+					return convert.Encode(io.Discard, img, format, opts.Options)
+				}},
+				{"convert", func() error {
+					return convert.Convert(bytes.NewReader(data), io.Discard, format, opts.Options)
+				}},
+			}
+			for _, op := range ops {
+				n, d, err := measure(op.f, opts.Duration, opts.Parallelism)
+				if err != nil {
+					return nil, err
+				}
+				perOp := d / time.Duration(n)
+				rep.Results = append(rep.Results, Result{
+					Op:            op.name,
+					Format:        format,
+					Width:         size.X,
+					Height:        size.Y,
+					Parallelism:   opts.Parallelism,
+					Iterations:    n,
+					NsPerOp:       perOp.Nanoseconds(),
+					MPixelsPerSec: float64(size.X*size.Y) * float64(n) / d.Seconds() / 1e6,
+					EncodedBytes:  len(data),
+				})
+			}
+		}
+	}
+	return rep, nil
+}
+
+// measure runs f on parallelism goroutines until at least min has
+// passed, returning the number of calls and the time taken.
+func measure(f func() error, min time.Duration, parallelism int) (int, time.Duration, error) {
+	if err := f(); err != nil { // warm up and check that f works
+		return 0, 0, err
+	}
+	var (
+		mu    sync.Mutex
+		n     int
+		first error
+		wg    sync.WaitGroup
+	)
+	start := time.Now()
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Since(start) < min {
+				err := f()
+				mu.Lock()
+				n++
+				if err != nil && first == nil {
+					first = err
+				}
+				stop := first != nil
+				mu.Unlock()
+				if stop {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return n, time.Since(start), first
+}
+
+// defaultImage returns colour bars above a text chart, which has both
+// flat areas and fine detail.
+func defaultImage() (image.Image, error) {
+	chart, err := testpattern.TextChart(1920, 720)
+	if err != nil {
+		return nil, err
+	}
+	bars := testpattern.ColorBars(1920, 360, 8)
+	c := convert.NewCanvas(1920, 1080, nil)
+	c.DrawImage(bars, image.Rect(0, 0, 1920, 360), convert.Options{})
+	c.DrawImage(chart, image.Rect(0, 360, 1920, 1080), convert.Options{})
+	return c.RGBA, nil
+}
@@ -0,0 +1,48 @@
+package convert
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sort"
+)
+
+// Build describes the conversion capabilities compiled into the running
+// binary.
+type Build struct {
+	Version   string   `json:"version"` // module version, "(devel)" when built from a checkout
+	GoVersion string   `json:"go_version"`
+	Formats   []Format `json:"formats"`            // formats supported for encoding
+	Backends  []string `json:"backends,omitempty"` // optional codec backends built in, such as "ffmpeg"
+	CGO       bool     `json:"cgo"`
+	Tags      string   `json:"tags,omitempty"` // build tags, if recorded
+}
+
+// BuildInfo reports the version of this package and the formats,
+// backends and build settings of the running binary, for services to
+// log which conversion capabilities are in play. CheckHealth reports
+// whether the backends can be used.
+func BuildInfo() Build {
+	b := Build{Version: "(devel)", GoVersion: runtime.Version(), Formats: Formats()}
+	for name := range backends {
+		b.Backends = append(b.Backends, name)
+	}
+	sort.Strings(b.Backends)
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	for _, m := range append([]*debug.Module{&info.Main}, info.Deps...) {
+		if m.Path == modulePath && m.Version != "" {
+			b.Version = m.Version
+		}
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "CGO_ENABLED":
+			b.CGO = s.Value == "1"
+		case "-tags":
+			b.Tags = s.Value
+		}
+	}
+	return b
+}
@@ -0,0 +1,72 @@
+package convert
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Limitation describes what converting between two formats may lose,
+// or, with a zero Kind, why the conversion cannot be done.
+type Limitation struct {
+	Kind   WarningKind
+	Reason string
+}
+
+func (l Limitation) String() string { return l.Reason }
+
+// CanConvert reports whether Convert can convert images in from to to
+// with opts, and what such conversions may lose, judged from the
+// formats' capabilities rather than any particular image. When it
+// returns false, the limitations say why. DICOM and FITS input needs
+// the dicom and fits packages imported.
+func CanConvert(from, to Format, opts Options) (bool, []Limitation) {
+	var impossible []Limitation
+	src, decodable := sources[from]
+	if !decodable {
+		impossible = append(impossible, Limitation{Reason: fmt.Sprintf("%s input cannot be decoded", from)})
+	}
+	var oe *OptionsError
+	if err := opts.ValidateFor(to); errors.As(err, &oe) {
+		for _, p := range oe.Problems {
+			impossible = append(impossible, Limitation{Reason: p})
+		}
+	}
+	if impossible != nil {
+		return false, impossible
+	}
+
+	var lims []Limitation
+	add := func(kind WarningKind) {
+		lims = append(lims, Limitation{Kind: kind, Reason: fmt.Sprintf("%s to %s: %s", from, to, kind)})
+	}
+	dst := to.Capabilities()
+	maxColors := dst.MaxColors
+	if to == PNG && opts.Indexed {
+		maxColors = 256
+	}
+	switch {
+	case src.Alpha && !dst.Alpha:
+		add(WarnAlphaDropped)
+	case src.PartialAlpha && (!dst.PartialAlpha || maxColors > 0):
+		add(WarnAlphaReduced)
+	}
+	// Convert keeps the first frame even for animated output; that is
+	// left to ConvertAnimation.
+	if src.Animation {
+		add(WarnAnimationFlattened)
+	}
+	if src.HighBitDepth && (!dst.HighBitDepth || maxColors > 0) {
+		add(WarnDepthReduced)
+	}
+	if maxColors > 0 && (src.MaxColors == 0 || src.MaxColors > maxColors) {
+		add(WarnColorsReduced)
+	}
+	// Conversions carry over no metadata other than GeoTIFF tags.
+	if src.Metadata {
+		add(WarnMetadataRemoved)
+	}
+	if dst.Lossy && !dst.Lossless {
+		add(WarnLossy)
+	}
+	return true, lims
+}
@@ -0,0 +1,162 @@
+package convert
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Canvas is an image composed from other images, text and shapes. It
+// is itself an image, ready for Encode once drawing is done. Drawing
+// blends over what is already there.
+type Canvas struct {
+	*image.RGBA
+}
+
+// NewCanvas returns a width×height canvas filled with background; nil
+// leaves it transparent.
+func NewCanvas(width, height int, background color.Color) *Canvas {
+	c := &Canvas{image.NewRGBA(image.Rect(0, 0, width, height))}
+	if background != nil {
+		draw.Draw(c.RGBA, c.Rect, image.NewUniform(background), image.Point{}, draw.Src)
+	}
+	return c
+}
+
+// DrawImage draws img into r, resampling it with opts when the sizes
+// differ. Use Fit first to keep the aspect ratio.
+func (c *Canvas) DrawImage(img image.Image, r image.Rectangle, opts Options) {
+	if r.Dx() != img.Bounds().Dx() || r.Dy() != img.Bounds().Dy() {
+		img = opts.resize(img, r.Dx(), r.Dy())
+	}
+	draw.Draw(c.RGBA, r, img, img.Bounds().Min, draw.Over)
+}
+
+// FillRect fills r with col.
+func (c *Canvas) FillRect(r image.Rectangle, col color.Color) {
+	draw.Draw(c.RGBA, r, image.NewUniform(col), image.Point{}, draw.Over)
+}
+
+// FillRoundedRect fills r with col, rounding its corners to radius.
+func (c *Canvas) FillRoundedRect(r image.Rectangle, radius float64, col color.Color) {
+	c.fillShape(r, col, roundedRect(r, radius))
+}
+
+// FillEllipse fills the ellipse inscribed in r with col.
+func (c *Canvas) FillEllipse(r image.Rectangle, col color.Color) {
+	c.fillShape(r, col, ellipse(r))
+}
+
+// FillGradient fills r with a vertical gradient from top to bottom.
+func (c *Canvas) FillGradient(r image.Rectangle, top, bottom color.Color) {
+	t := color.NRGBA64Model.Convert(top).(color.NRGBA64)
+	b := color.NRGBA64Model.Convert(bottom).(color.NRGBA64)
+	lerp := func(x, y uint16, f float64) uint16 {
+		return uint16(float64(x) + (float64(y)-float64(x))*f + 0.5)
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		f := 0.0
+		if r.Dy() > 1 {
+			f = float64(y-r.Min.Y) / float64(r.Dy()-1)
+		}
+		col := color.NRGBA64{lerp(t.R, b.R, f), lerp(t.G, b.G, f), lerp(t.B, b.B, f), lerp(t.A, b.A, f)}
+		c.FillRect(image.Rect(r.Min.X, y, r.Max.X, y+1), col)
+	}
+}
+
+// DrawLine draws a line of the given width from p to q with round caps.
+func (c *Canvas) DrawLine(p, q image.Point, width float64, col color.Color) {
+	h := width / 2
+	pad := int(math.Ceil(h))
+	r := image.Rectangle{p, q}.Canon().Inset(-pad)
+	px, py := float64(p.X), float64(p.Y)
+	dx, dy := float64(q.X-p.X), float64(q.Y-p.Y)
+	l2 := dx*dx + dy*dy
+	c.fillShape(r, col, func(x, y float64) bool {
+		t := 0.0
+		if l2 > 0 {
+			t = math.Max(0, math.Min(1, ((x-px)*dx+(y-py)*dy)/l2))
+		}
+		ex, ey := x-px-t*dx, y-py-t*dy
+		return ex*ex+ey*ey <= h*h
+	})
+}
+
+// DrawText draws s with its baseline starting at pt.
+func (c *Canvas) DrawText(s string, pt image.Point, face font.Face, col color.Color) {
+	d := &font.Drawer{
+		Dst:  c.RGBA,
+		Src:  image.NewUniform(col),
+		Face: face,
+		Dot:  fixed.P(pt.X, pt.Y),
+	}
+	d.DrawString(s)
+}
+
+// shapeSamples is the number of coverage samples per pixel along each
+// axis when antialiasing shapes.
+const shapeSamples = 4
+
+// fillShape blends col over the pixels of r in proportion to how much
+// of each the shape covers, as reported by inside for points in canvas
+// coordinates.
+func (c *Canvas) fillShape(r image.Rectangle, col color.Color, inside func(x, y float64) bool) {
+	r = r.Intersect(c.Rect)
+	if r.Empty() {
+		return
+	}
+	draw.DrawMask(c.RGBA, r, image.NewUniform(col), image.Point{}, shapeMask(r, inside), r.Min, draw.Over)
+}
+
+// shapeMask returns the coverage of each pixel of r by the shape.
+func shapeMask(r image.Rectangle, inside func(x, y float64) bool) *image.Alpha {
+	mask := image.NewAlpha(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			n := 0
+			for sy := 0; sy < shapeSamples; sy++ {
+				for sx := 0; sx < shapeSamples; sx++ {
+					if inside(float64(x)+(float64(sx)+0.5)/shapeSamples, float64(y)+(float64(sy)+0.5)/shapeSamples) {
+						n++
+					}
+				}
+			}
+			mask.Pix[mask.PixOffset(x, y)] = uint8(n * 255 / (shapeSamples * shapeSamples))
+		}
+	}
+	return mask
+}
+
+// roundedRect returns the shape of r with its corners rounded to
+// radius.
+func roundedRect(r image.Rectangle, radius float64) func(x, y float64) bool {
+	radius = math.Min(radius, float64(minInt(r.Dx(), r.Dy()))/2)
+	x0, y0 := float64(r.Min.X)+radius, float64(r.Min.Y)+radius
+	x1, y1 := float64(r.Max.X)-radius, float64(r.Max.Y)-radius
+	return func(x, y float64) bool {
+		dx := math.Max(math.Max(x0-x, x-x1), 0)
+		dy := math.Max(math.Max(y0-y, y-y1), 0)
+		return dx*dx+dy*dy <= radius*radius
+	}
+}
+
+// ellipse returns the shape of the ellipse inscribed in r.
+func ellipse(r image.Rectangle) func(x, y float64) bool {
+	cx, cy := float64(r.Min.X+r.Max.X)/2, float64(r.Min.Y+r.Max.Y)/2
+	rx, ry := float64(r.Dx())/2, float64(r.Dy())/2
+	return func(x, y float64) bool {
+		dx, dy := (x-cx)/rx, (y-cy)/ry
+		return dx*dx+dy*dy <= 1
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,129 @@
+package convert
+
+// Capabilities describes what a format can represent.
+type Capabilities struct {
+	Alpha        bool // supports transparency
+	PartialAlpha bool // supports alpha values other than fully opaque or transparent
+	Animation    bool // supports multiple frames
+	Lossless     bool // can store pixels exactly
+	Lossy        bool // has a lossy compression mode
+	HighBitDepth bool // supports more than 8 bits per sample
+	CMYK         bool // can store CMYK samples
+	Metadata     bool // can carry EXIF or similar metadata
+	MaxColors    int  // maximum distinct colors per frame, 0 for unlimited
+	MaxWidth     int
+	MaxHeight    int
+}
+
+const maxInt32 = 1<<31 - 1
+
+var capabilities = map[Format]Capabilities{
+	JPEG: {
+		Lossy:     true,
+		Metadata:  true,
+		MaxWidth:  65535,
+		MaxHeight: 65535,
+	},
+	PNG: {
+		Alpha:        true,
+		PartialAlpha: true,
+		Lossless:     true,
+		HighBitDepth: true,
+		Metadata:     true,
+		MaxWidth:     maxInt32,
+		MaxHeight:    maxInt32,
+	},
+	GIF: {
+		Alpha:     true,
+		Animation: true,
+		Lossless:  true,
+		MaxColors: 256,
+		MaxWidth:  65535,
+		MaxHeight: 65535,
+	},
+	BMP: {
+		Lossless:  true,
+		MaxWidth:  maxInt32,
+		MaxHeight: maxInt32,
+	},
+	TIFF: {
+		Alpha:        true,
+		PartialAlpha: true,
+		Lossless:     true,
+		HighBitDepth: true,
+		CMYK:         true,
+		Metadata:     true,
+		MaxWidth:     maxInt32,
+		MaxHeight:    maxInt32,
+	},
+	ANSI: {
+		Alpha:     true,
+		Lossy:     true,
+		MaxWidth:  maxInt32,
+		MaxHeight: maxInt32,
+	},
+	ASCII: {
+		Lossy:     true,
+		MaxWidth:  maxInt32,
+		MaxHeight: maxInt32,
+	},
+	Sixel: {
+		Alpha:     true,
+		Lossy:     true,
+		MaxColors: 256,
+		MaxWidth:  maxInt32,
+		MaxHeight: maxInt32,
+	},
+	ITerm2: capabilitiesPNG,
+	Kitty:  capabilitiesPNG,
+}
+
+// capabilitiesPNG is shared by the formats that embed PNG.
+var capabilitiesPNG = Capabilities{
+	Alpha:        true,
+	PartialAlpha: true,
+	Lossless:     true,
+	HighBitDepth: true,
+	MaxWidth:     maxInt32,
+	MaxHeight:    maxInt32,
+}
+
+// sources describes what files Convert decodes can hold, for each
+// format it reads. They differ from what the formats can be written
+// with in that PNG files may be APNG animations, and that DICOM and
+// FITS are not written at all. Convert decodes the first frame of
+// animations only.
+var sources = map[Format]Capabilities{
+	JPEG: capabilities[JPEG],
+	PNG:  withAnimation(capabilities[PNG]),
+	GIF:  capabilities[GIF],
+	BMP:  capabilities[BMP],
+	TIFF: capabilities[TIFF],
+	DICOM: {
+		Lossless:     true,
+		Lossy:        true,
+		HighBitDepth: true,
+		Metadata:     true,
+	},
+	FITS: {
+		Lossless:     true,
+		HighBitDepth: true,
+		Metadata:     true,
+	},
+}
+
+func withAnimation(c Capabilities) Capabilities {
+	c.Animation = true
+	return c
+}
+
+// Formats returns the formats supported for encoding.
+func Formats() []Format {
+	return []Format{JPEG, PNG, GIF, BMP, TIFF, ANSI, ASCII, Sixel, ITerm2, Kitty}
+}
+
+// Capabilities returns what the format can represent. Unknown formats
+// report no capabilities.
+func (f Format) Capabilities() Capabilities {
+	return capabilities[f]
+}
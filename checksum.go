@@ -0,0 +1,34 @@
+package convert
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FileSHA256 returns the hex SHA-256 digest of the file at path.
+func FileSHA256(path string) (string, error) {
+	f, err := os.Open(longPath(path))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeChecksum writes path.sha256 with the digest of the file at path,
+// in the format sha256sum -c reads.
+func writeChecksum(path string) error {
+	sum, err := FileSHA256(path)
+	if err != nil {
+		return err
+	}
+	line := sum + "  " + filepath.Base(path) + "\n"
+	return os.WriteFile(longPath(path+".sha256"), []byte(line), 0666)
+}
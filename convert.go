@@ -2,8 +2,11 @@
 package convert
 
 import (
+	"bufio"
 	"errors"
 	"image"
+	"image/color"
+	"image/draw"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
@@ -11,7 +14,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/imgutils-org/imgutils-convert/internal/jpegenc"
 	"golang.org/x/image/bmp"
 	"golang.org/x/image/tiff"
 )
@@ -25,11 +30,234 @@ const (
 	GIF  Format = "gif"
 	BMP  Format = "bmp"
 	TIFF Format = "tiff"
+
+	// ANSI and ASCII render images as text for terminal previews.
+	ANSI  Format = "ansi"
+	ASCII Format = "ascii"
+
+	// Sixel, ITerm2 and Kitty write images in the inline graphics
+	// protocols of terminals that support them.
+	Sixel  Format = "sixel"
+	ITerm2 Format = "iterm2"
+	Kitty  Format = "kitty"
+
+	// DICOM and FITS are read by the dicom and fits packages once
+	// they are imported. They cannot be written.
+	DICOM Format = "dicom"
+	FITS  Format = "fits"
 )
 
 // Options configures the conversion.
 type Options struct {
-	Quality int // JPEG quality (1-100), default 85
+	Quality   int            // JPEG quality (1-100), default 85
+	Colors    int            // GIF and indexed PNG palette size (2-256), default 256
+	Quantizer QuantizeMethod // palette algorithm, default Plan 9 for GIF and median cut for PNG
+	Indexed   bool           // write PNG as an 8-bit palette image
+	Interlace bool           // write Adam7-interlaced PNG or interlaced GIF
+	Thumbnail int            // embed an EXIF thumbnail of at most this many pixels per side in JPEG output
+	BigTIFF   bool           // write TIFF as BigTIFF; images too large for classic TIFF always are
+	TileSize  int            // write TIFF as tiles of this size (a multiple of 16 up to 4096), default 256 for pyramids
+
+	// TargetSSIM, if set, replaces Quality for JPEG output with the
+	// lowest quality whose decoded result has at least this SSIM
+	// against the input, such as 0.98.
+	TargetSSIM float64
+
+	// OptimizeCoding writes JPEG output with per-image Huffman tables,
+	// progressive scans and trellis quantization. Encoding is several
+	// times slower, but files are typically around 10% smaller at the
+	// same visual quality.
+	OptimizeCoding bool
+
+	// Columns sets the width in characters of ANSI and ASCII output,
+	// default the image width up to 80. For iTerm2 and Kitty output it
+	// sets the displayed width in cells, default the image's own size.
+	Columns int
+
+	// TrueColor writes ANSI output with 24-bit color escapes rather
+	// than the xterm 256-color palette.
+	TrueColor bool
+
+	// Pyramid lists increasing downsample factors, such as 2, 4, 8, of
+	// reduced-resolution levels to write after the full-size TIFF image,
+	// producing a tiled pyramidal TIFF for whole-slide viewers.
+	Pyramid []int
+
+	// LinearLight resamples thumbnails, pyramid levels and upload
+	// downscales in linear light rather than sRGB gamma space.
+	LinearLight bool
+
+	// Kernel names the resampling kernel of every resize the options
+	// drive, such as thumbnails, letterboxing, pyramid levels, uploads
+	// and proxy URLs, as registered with RegisterKernel. The default is
+	// "catmullrom".
+	Kernel string
+
+	// PixelFormat, if set, converts images to this layout before
+	// encoding, so that encoders write the matching samples, such as
+	// an 8-bit grayscale PNG or a CMYK TIFF.
+	PixelFormat PixelFormat
+
+	// Dither selects the dithering used for GIF and indexed PNG
+	// palettes, and when writing 16-bit images to JPEG or BMP.
+	Dither Dither
+
+	// GeoTIFF holds georeferencing tags to write into TIFF output.
+	// Convert and ConvertFile copy them from TIFF input when it is nil.
+	GeoTIFF *GeoTIFF
+
+	// Lossless makes Encode fail with ErrNotLossless instead of writing
+	// output that does not reproduce every input pixel.
+	Lossless bool
+	// Verify re-decodes the encoded output and fails with a
+	// *VerifyError unless it matches the input pixel for pixel.
+	// The output is buffered until the check passes.
+	Verify bool
+
+	// AllowPassthrough lets Convert and ConvertFile copy input that is
+	// already in the output format unchanged when no other option
+	// changes the image or its encoding, rather than decoding and
+	// re-encoding it. ValidatePassthrough fully decodes such input
+	// first, failing on corrupt images as a conversion would.
+	AllowPassthrough    bool
+	ValidatePassthrough bool
+
+	// Salvage makes Convert and ConvertFile decode input in no known
+	// format from the largest JPEG or PNG stream embedded in it, such
+	// as the preview in a camera's raw file or an image in a damaged
+	// container.
+	Salvage bool
+
+	// AllowTruncated makes Convert and ConvertFile decode as much of a
+	// truncated or corrupt JPEG or PNG as they can rather than fail,
+	// filling the regions that could not be decoded with TruncatedFill,
+	// default gray. JPEG decoding resumes after corrupt data at the
+	// next restart marker. Progressive JPEG and interlaced PNG images
+	// are not recovered.
+	AllowTruncated bool
+	TruncatedFill  *color.NRGBA
+
+	// PreserveTimes and PreserveMode make ConvertFile copy the input
+	// file's access and modification times and permission bits to the
+	// output. Xattrs names extended attributes to copy as well, such
+	// as "user.xdg.tags"; they are supported on Linux only, and
+	// attributes the input lacks are skipped.
+	PreserveTimes bool
+	PreserveMode  bool
+	Xattrs        []string
+
+	// ChecksumSidecar makes ConvertFile write the SHA-256 digest of
+	// each output next to it, as output.sha256 in the format that
+	// sha256sum -c verifies.
+	ChecksumSidecar bool
+
+	// EncryptionKey, if set, encrypts the encoded output with AES-GCM
+	// under this 16, 24 or 32 byte key, for NewDecryptReader to read.
+	// It is not marshalled, so keys stay out of queued jobs and logs.
+	EncryptionKey []byte `json:"-"`
+
+	// TeeWriters receive a copy of the output as it is written, such
+	// as a hash or an upload stream, so that one encode pass serves
+	// them all without buffering it. They see the bytes of the output,
+	// encrypted if EncryptionKey is set, and a failed write to any
+	// fails the conversion. TIFF output to an io.WriteSeeker is then
+	// buffered rather than streamed. They are not marshalled.
+	TeeWriters []io.Writer `json:"-"`
+
+	// WarnFunc, if set, is called for each kind of information the
+	// conversion loses, such as transparency, animation frames, high
+	// bit depth or metadata.
+	WarnFunc func(Warning) `json:"-"`
+
+	// OptimizeFrames writes animated GIF frames as only the region that
+	// changed, leaving unchanged pixels transparent, and merges
+	// identical consecutive frames.
+	OptimizeFrames bool
+
+	// FrameRate, Speed and MaxDuration retime animations: FrameRate
+	// resamples them to at most this many frames per second, Speed
+	// multiplies the playback speed and MaxDuration cuts them short,
+	// with frames that no longer show dropped.
+	FrameRate   float64
+	Speed       float64
+	MaxDuration time.Duration
+
+	// GlobalPalette quantizes all frames of an animation to one
+	// palette built from every frame, rather than one per frame, so
+	// that colours stay stable from frame to frame.
+	GlobalPalette bool
+
+	// FrameFunc, if set, is called by ConvertAnimation with each
+	// composited frame, which it may draw on, such as to watermark,
+	// caption or redact it.
+	FrameFunc func(i int, img draw.Image) error `json:"-"`
+
+	// Redact lists regions to mask in RedactStyle before encoding, and
+	// in every frame of animated output.
+	Redact      []image.Rectangle
+	RedactStyle RedactStyle
+
+	// RemoveBackground, if set, makes the background it finds in the
+	// image transparent, after redaction and before any other stage.
+	// It is in-process only and not marshalled.
+	RemoveBackground Matter `json:"-"`
+
+	// Deskew straightens scanned pages whose text is tilted, and
+	// TrimBorders then crops away blank margins and scanner edges.
+	// Both run after redaction, which uses the input's coordinates.
+	Deskew      bool
+	TrimBorders bool
+
+	// Trim crops away borders of a uniform colour, as Trim does with
+	// TrimTolerance.
+	Trim          bool
+	TrimTolerance int
+
+	// Grade, if set, maps colours through this LUT before padding and
+	// effects, such as to give a batch of images one look.
+	Grade *LUT
+
+	// Posterize reduces each colour channel to this many levels. Edges
+	// then replaces the image with its edges. Threshold converts it to
+	// black and white at this luma level (1-255), or AutoThreshold at
+	// the level Otsu's method picks.
+	Posterize     int
+	Edges         EdgeDetector
+	Threshold     int
+	AutoThreshold bool
+
+	// PadWidth and PadHeight, if both set, place the image at PadAnchor
+	// on a canvas of exactly this size, such as to give product photos
+	// uniform dimensions. Letterbox first scales it to fit the canvas.
+	// Border then adds a border of this many pixels. Padding and
+	// borders are PadColor, default white.
+	PadWidth  int
+	PadHeight int
+	PadAnchor Anchor
+	PadColor  *color.NRGBA
+	Letterbox bool
+	Border    int
+
+	// Mask multiplies the alpha of the image by the alpha of this
+	// image, resized to match. Circle then crops the result to a
+	// centered circle, or CornerRadius rounds its corners, such as for
+	// avatars. Masked areas become transparent, so use an output format
+	// with alpha. Mask is in-process only and not marshalled.
+	Mask         image.Image `json:"-"`
+	Circle       bool
+	CornerRadius float64
+
+	// Tint blends colours towards this colour by TintStrength (0-1),
+	// and Vignette darkens the corners by this much (0-1).
+	Tint         *color.NRGBA
+	TintStrength float64
+	Vignette     float64
+
+	// Polaroid sets the image in a white instant-photo frame, and
+	// Shadow, if set, then gives it a drop shadow, growing the
+	// canvas to fit.
+	Polaroid bool
+	Shadow   *Shadow
 }
 
 // DefaultOptions returns sensible defaults.
@@ -39,29 +267,175 @@ func DefaultOptions() Options {
 
 // Encode writes an image to the writer in the specified format.
 func Encode(w io.Writer, img image.Image, format Format, opts Options) error {
+	w, opts = opts.teed(w)
+	if len(opts.EncryptionKey) > 0 {
+		return opts.sealed(w, func(w io.Writer, opts Options) error {
+			return Encode(w, img, format, opts)
+		})
+	}
 	if opts.Quality <= 0 || opts.Quality > 100 {
 		opts.Quality = 85
 	}
+	img, err := opts.process(img)
+	if err != nil {
+		return err
+	}
+	if opts.PixelFormat != PixelAuto {
+		if img, err = opts.pixelFormat(img, format); err != nil {
+			return err
+		}
+	}
+	if opts.Lossless {
+		if img, err = losslessImage(img, format, opts); err != nil {
+			return err
+		}
+	}
+	if caps := format.Capabilities(); !caps.HighBitDepth && caps.MaxColors == 0 &&
+		opts.Dither != DitherDefault && opts.Dither != DitherNone && isDeep(img) {
+		img = reduceDepth(img, opts.Dither)
+	}
+	encodeWarnings(img, format, opts)
+	if opts.Verify {
+		return encodeVerified(w, img, format, opts)
+	}
+	return encodeFormat(w, img, format, opts)
+}
+
+// process runs the stages of the options that change the image itself,
+// from redaction to effects, on img.
+func (opts Options) process(img image.Image) (image.Image, error) {
+	if len(opts.Redact) > 0 {
+		img = Redact(img, opts.Redact, opts.RedactStyle)
+	}
+	if opts.RemoveBackground != nil {
+		var err error
+		if img, err = RemoveBackground(img, opts.RemoveBackground); err != nil {
+			return nil, err
+		}
+	}
+	img = opts.document(img)
+	if opts.Grade != nil {
+		if err := opts.Grade.check(); err != nil {
+			return nil, err
+		}
+		img = opts.Grade.Apply(img)
+	}
+	return opts.effects(opts.mask(opts.pad(opts.filter(img)))), nil
+}
 
+// encodeFormat writes img, already through every processing stage of
+// the options, with the encoder for format.
+func encodeFormat(w io.Writer, img image.Image, format Format, opts Options) error {
 	switch format {
 	case JPEG:
-		return jpeg.Encode(w, img, &jpeg.Options{Quality: opts.Quality})
+		if opts.TargetSSIM > 0 {
+			q, err := targetQuality(img, opts)
+			if err != nil {
+				return err
+			}
+			opts.Quality = q
+		}
+		if opts.Thumbnail > 0 {
+			return encodeJPEGWithThumbnail(w, img, opts)
+		}
+		return encodeJPEG(w, img, opts)
 	case PNG:
+		if opts.Indexed {
+			img = indexed(img, opts)
+		}
+		if opts.Interlace {
+			return encodeInterlacedPNG(w, img)
+		}
 		return png.Encode(w, img)
 	case GIF:
-		return gif.Encode(w, img, nil)
+		if opts.Interlace {
+			return encodeInterlacedGIF(w, img, opts)
+		}
+		return gif.Encode(w, img, gifOptions(opts))
 	case BMP:
 		return bmp.Encode(w, img)
 	case TIFF:
+		if opts.BigTIFF || opts.TileSize > 0 || len(opts.Pyramid) > 0 || opts.GeoTIFF != nil || opts.PixelFormat != PixelAuto || needsBigTIFF(img) {
+			return encodeTIFF(w, img, opts)
+		}
 		return tiff.Encode(w, img, nil)
+	case ANSI:
+		return encodeANSI(w, img, opts)
+	case ASCII:
+		return encodeASCII(w, img, opts)
+	case Sixel:
+		return encodeSixel(w, img, opts)
+	case ITerm2:
+		return encodeITerm2(w, img, opts)
+	case Kitty:
+		return encodeKitty(w, img, opts)
 	default:
 		return errors.New("unsupported format")
 	}
 }
 
-// Decode reads an image from the reader.
+// encodeJPEG writes img as JPEG with the encoder the options ask for.
+func encodeJPEG(w io.Writer, img image.Image, opts Options) error {
+	if opts.OptimizeCoding {
+		return jpegenc.Encode(w, img, &jpegenc.Options{Quality: opts.Quality, Progressive: true, Trellis: true})
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: opts.Quality})
+}
+
+func gifOptions(opts Options) *gif.Options {
+	if opts.Colors == 0 && opts.Quantizer == QuantizePlan9 && opts.Dither == DitherDefault {
+		return nil
+	}
+	o := &gif.Options{NumColors: opts.Colors}
+	if o.NumColors <= 0 || o.NumColors > 256 {
+		o.NumColors = 256
+	}
+	if opts.Quantizer != QuantizePlan9 {
+		o.Quantizer = drawQuantizer{opts.Quantizer}
+	}
+	if opts.Dither != DitherDefault {
+		o.Drawer = opts.Dither
+	}
+	return o
+}
+
+// indexed converts img to a palette image for PNG8 output. Transparent
+// pixels map to a transparent palette entry, which image/png writes as
+// a tRNS chunk.
+func indexed(img image.Image, opts Options) image.Image {
+	n := opts.Colors
+	if n <= 0 || n > 256 {
+		n = 256
+	}
+	if p, ok := img.(*image.Paletted); ok && len(p.Palette) <= n {
+		return p
+	}
+	m := opts.Quantizer
+	if m == QuantizePlan9 {
+		m = QuantizeMedianCut
+	}
+	return m.quantize(img, n, opts.Dither)
+}
+
+// Decode reads an image from the reader. If the built-in decoder for
+// its format fails, decoders added by RegisterDecoder are tried in
+// turn.
 func Decode(r io.Reader) (image.Image, Format, error) {
-	img, formatStr, err := image.Decode(r)
+	img, format, _, err := decodeChain(r)
+	return img, format, err
+}
+
+// decodeStd decodes an image with the built-in decoders.
+func decodeStd(r io.Reader) (image.Image, Format, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(4); string(magic) == "II*\x00" || string(magic) == "MM\x00*" {
+		img, err := decodeClassicTIFF(br)
+		if err != nil {
+			return nil, "", err
+		}
+		return img, TIFF, nil
+	}
+	img, formatStr, err := image.Decode(br)
 	if err != nil {
 		return nil, "", err
 	}
@@ -70,7 +444,16 @@ func Decode(r io.Reader) (image.Image, Format, error) {
 
 // Convert reads an image and converts it to a different format.
 func Convert(r io.Reader, w io.Writer, format Format, opts Options) error {
-	img, _, err := Decode(r)
+	data, r, err := opts.passThrough(r, format)
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		w, _ := opts.teed(w)
+		_, err := w.Write(data)
+		return err
+	}
+	img, _, _, err := decodeSource(r, format, &opts)
 	if err != nil {
 		return err
 	}
@@ -79,25 +462,70 @@ func Convert(r io.Reader, w io.Writer, format Format, opts Options) error {
 
 // ConvertFile converts an image file to a different format.
 func ConvertFile(inputPath, outputPath string, opts Options) error {
-	in, err := os.Open(inputPath)
+	_, err := convertFile(inputPath, outputPath, FormatFromExtension(outputPath), opts)
+	return err
+}
+
+// convertFile is ConvertFile writing format, returning the name of the
+// decoder that read the input, or "" if it was copied unchanged.
+func convertFile(inputPath, outputPath string, format Format, opts Options) (string, error) {
+	in, err := os.Open(longPath(inputPath))
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return "", err
+	}
 
-	img, _, err := image.Decode(in)
+	data, r, err := opts.passThrough(in, format)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if data != nil {
+		out, err := os.Create(longPath(outputPath))
+		if err != nil {
+			return "", err
+		}
+		w, _ := opts.teed(out)
+		if _, err := w.Write(data); err != nil {
+			out.Close()
+			return "", err
+		}
+		if err := out.Close(); err != nil {
+			return "", err
+		}
+		return "", opts.finishFile(inputPath, info, outputPath)
+	}
+	img, _, decoder, err := decodeSource(r, format, &opts)
+	if err != nil {
+		return "", err
 	}
 
-	out, err := os.Create(outputPath)
+	out, err := os.Create(longPath(outputPath))
 	if err != nil {
-		return err
+		return decoder, err
+	}
+	if err := Encode(out, img, format, opts); err != nil {
+		out.Close()
+		return decoder, err
+	}
+	if err := out.Close(); err != nil {
+		return decoder, err
 	}
-	defer out.Close()
+	return decoder, opts.finishFile(inputPath, info, outputPath)
+}
 
-	format := FormatFromExtension(outputPath)
-	return Encode(out, img, format, opts)
+// finishFile writes the checksum sidecar and copies the file
+// attributes that opts asks for once the output has been written.
+func (opts Options) finishFile(inputPath string, info os.FileInfo, outputPath string) error {
+	if opts.ChecksumSidecar {
+		if err := writeChecksum(outputPath); err != nil {
+			return err
+		}
+	}
+	return opts.preserveAttributes(inputPath, info, outputPath)
 }
 
 // FormatFromExtension determines the format from a file extension.
@@ -114,11 +542,44 @@ func FormatFromExtension(path string) Format {
 		return BMP
 	case ".tiff", ".tif":
 		return TIFF
+	case ".ans":
+		return ANSI
+	case ".txt":
+		return ASCII
+	case ".six", ".sixel":
+		return Sixel
+	case ".iterm2":
+		return ITerm2
+	case ".kitty":
+		return Kitty
 	default:
 		return JPEG
 	}
 }
 
+// Extension returns the conventional file extension for the format,
+// without the leading dot.
+func (f Format) Extension() string {
+	switch f {
+	case JPEG:
+		return "jpg"
+	case TIFF:
+		return "tif"
+	case ANSI:
+		return "ans"
+	case ASCII:
+		return "txt"
+	case Sixel:
+		return "six"
+	case ITerm2:
+		return "iterm2"
+	case Kitty:
+		return "kitty"
+	default:
+		return string(f)
+	}
+}
+
 // ToJPEG converts an image to JPEG format.
 func ToJPEG(img image.Image, w io.Writer, quality int) error {
 	return Encode(w, img, JPEG, Options{Quality: quality})
@@ -0,0 +1,149 @@
+// Package converttest provides helpers for testing image conversion
+// pipelines: comparing images within a tolerance, checking output
+// against golden files, generating synthetic inputs and recording the
+// conversion of a corpus to audit encoder changes.
+package converttest
+
+import (
+	"bytes"
+	"flag"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	convert "github.com/imgutils-org/imgutils-convert"
+)
+
+var update = flag.Bool("converttest.update", false, "rewrite golden files with the images produced")
+
+// Diff describes how two images differ.
+type Diff struct {
+	Pixels   int         // number of pixels with a channel differing by more than the tolerance
+	MaxDelta int         // largest channel difference, on a 0-255 scale
+	First    image.Point // first differing pixel, in the coordinates of want
+}
+
+// Compare reports the pixels of got whose channels, alpha included,
+// differ from want by more than tolerance on a 0-255 scale. Images of
+// different sizes differ in every pixel.
+func Compare(want, got image.Image, tolerance int) Diff {
+	wb, gb := want.Bounds(), got.Bounds()
+	if wb.Size() != gb.Size() {
+		return Diff{Pixels: wb.Dx() * wb.Dy(), MaxDelta: 255, First: wb.Min}
+	}
+	var d Diff
+	for y := 0; y < wb.Dy(); y++ {
+		for x := 0; x < wb.Dx(); x++ {
+			a := color.NRGBA64Model.Convert(want.At(wb.Min.X+x, wb.Min.Y+y)).(color.NRGBA64)
+			b := color.NRGBA64Model.Convert(got.At(gb.Min.X+x, gb.Min.Y+y)).(color.NRGBA64)
+			delta := 0
+			for _, c := range [][2]uint16{{a.R, b.R}, {a.G, b.G}, {a.B, b.B}, {a.A, b.A}} {
+				v := int(c[0]) - int(c[1])
+				if v < 0 {
+					v = -v
+				}
+				if v > delta {
+					delta = v
+				}
+			}
+			delta = (delta + 128) / 257
+			if delta > d.MaxDelta {
+				d.MaxDelta = delta
+			}
+			if delta > tolerance {
+				if d.Pixels == 0 {
+					d.First = image.Pt(wb.Min.X+x, wb.Min.Y+y)
+				}
+				d.Pixels++
+			}
+		}
+	}
+	return d
+}
+
+// AssertImagesEqual fails the test if got differs from want by more
+// than tolerance, as Compare measures it.
+func AssertImagesEqual(t testing.TB, want, got image.Image, tolerance int) {
+	t.Helper()
+	if ws, gs := want.Bounds().Size(), got.Bounds().Size(); ws != gs {
+		t.Fatalf("image size %v, want %v", gs, ws)
+	}
+	if d := Compare(want, got, tolerance); d.Pixels > 0 {
+		t.Fatalf("%d pixels differ by more than %d, up to %d, first at %v",
+			d.Pixels, tolerance, d.MaxDelta, d.First)
+	}
+}
+
+// Golden compares got with the PNG image in testdata/name.png, failing
+// the test if they differ by more than tolerance. Running the tests
+// with -converttest.update writes got to the file instead. When the
+// comparison fails, got is written to testdata/name.got.png for
+// inspection.
+func Golden(t testing.TB, name string, got image.Image, tolerance int) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".png")
+	if *update {
+		if err := writePNG(path, got); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("%v (run with -converttest.update to create it)", err)
+	}
+	defer f.Close()
+	want, _, err := convert.Decode(f)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	if ws, gs := want.Bounds().Size(), got.Bounds().Size(); ws == gs {
+		if d := Compare(want, got, tolerance); d.Pixels == 0 {
+			return
+		}
+	}
+	gotPath := filepath.Join("testdata", name+".got.png")
+	if err := writePNG(gotPath, got); err != nil {
+		t.Log(err)
+	}
+	AssertImagesEqual(t, want, got, tolerance)
+}
+
+// GoldenBytes compares data with the contents of testdata/name
+// exactly, for encoder output that must be byte-for-byte stable.
+// Running the tests with -converttest.update writes data to the file
+// instead.
+func GoldenBytes(t testing.TB, name string, data []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := writeFile(path, data); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -converttest.update to create it)", err)
+	}
+	if !bytes.Equal(want, data) {
+		t.Fatalf("%s: output of %d bytes differs from golden file of %d bytes", path, len(data), len(want))
+	}
+}
+
+func writePNG(path string, img image.Image) error {
+	var buf bytes.Buffer
+	if err := convert.Encode(&buf, img, convert.PNG, convert.Options{}); err != nil {
+		return err
+	}
+	return writeFile(path, buf.Bytes())
+}
+
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0666)
+}
@@ -0,0 +1,143 @@
+package converttest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	convert "github.com/imgutils-org/imgutils-convert"
+)
+
+// A Manifest records the output of converting each image of a corpus,
+// so that runs before and after an encoder change can be compared with
+// DiffManifests.
+type Manifest struct {
+	Format  convert.Format  `json:"format"`
+	Entries []ManifestEntry `json:"entries"`
+}
+
+// ManifestEntry records the conversion of one file.
+type ManifestEntry struct {
+	Path  string  `json:"path"` // slash-separated, relative to the corpus directory
+	Hash  string  `json:"hash,omitempty"`
+	Size  int     `json:"size,omitempty"`
+	PSNR  float64 `json:"psnr,omitempty"` // against the decoded input, capped at 100 dB
+	Error string  `json:"error,omitempty"`
+}
+
+// maxPSNR stands in for the infinite PSNR of an exact copy, which JSON
+// cannot represent.
+const maxPSNR = 100
+
+// RunCorpus converts every image under dir to format with opts and
+// records the SHA-256 hash and size of each output and its PSNR against
+// the input. Files that are not images are skipped; images that fail to
+// convert are recorded with the error.
+func RunCorpus(dir string, format convert.Format, opts convert.Options) (*Manifest, error) {
+	m := &Manifest{Format: format}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		e := ManifestEntry{Path: filepath.ToSlash(rel)}
+		if err := convertEntry(&e, data, format, opts); err != nil {
+			e.Error = err.Error()
+		}
+		m.Entries = append(m.Entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func convertEntry(e *ManifestEntry, data []byte, format convert.Format, opts convert.Options) error {
+	in, _, err := convert.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := convert.Convert(bytes.NewReader(data), &out, format, opts); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(out.Bytes())
+	e.Hash, e.Size = hex.EncodeToString(sum[:]), out.Len()
+	got, _, err := convert.Decode(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		return err
+	}
+	e.PSNR = math.Min(convert.PSNR(in, got), maxPSNR)
+	return nil
+}
+
+// ReadManifest reads a manifest written as JSON.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	m := new(Manifest)
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WriteTo writes m as indented JSON.
+func (m *Manifest) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(data, '\n'))
+	return int64(n), err
+}
+
+// A ManifestChange is a file whose output differs between two
+// manifests. Old is nil for added files and New for removed ones.
+type ManifestChange struct {
+	Path     string
+	Old, New *ManifestEntry
+}
+
+// DiffManifests returns the files whose output hash or error differs
+// between old and new, or that only one of them has, sorted by path.
+func DiffManifests(old, new *Manifest) []ManifestChange {
+	index := func(m *Manifest) map[string]*ManifestEntry {
+		idx := make(map[string]*ManifestEntry, len(m.Entries))
+		for i := range m.Entries {
+			idx[m.Entries[i].Path] = &m.Entries[i]
+		}
+		return idx
+	}
+	o, n := index(old), index(new)
+	var changes []ManifestChange
+	for path, e := range o {
+		if f := n[path]; f == nil || f.Hash != e.Hash || f.Error != e.Error {
+			changes = append(changes, ManifestChange{Path: path, Old: e, New: f})
+		}
+	}
+	for path, f := range n {
+		if o[path] == nil {
+			changes = append(changes, ManifestChange{Path: path, New: f})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
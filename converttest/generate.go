@@ -0,0 +1,33 @@
+package converttest
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/imgutils-org/imgutils-convert/testpattern"
+)
+
+// Gradient is testpattern.Gradient.
+func Gradient(w, h, depth int, from, to color.Color) image.Image {
+	return testpattern.Gradient(w, h, depth, from, to)
+}
+
+// ColorBars is testpattern.ColorBars.
+func ColorBars(w, h, depth int) image.Image {
+	return testpattern.ColorBars(w, h, depth)
+}
+
+// Checkerboard is testpattern.Checkerboard.
+func Checkerboard(w, h, depth, size int, a, b color.Color) image.Image {
+	return testpattern.Checkerboard(w, h, depth, size, a, b)
+}
+
+// Noise is testpattern.Noise.
+func Noise(w, h, depth int, seed int64) image.Image {
+	return testpattern.Noise(w, h, depth, seed)
+}
+
+// TextChart is testpattern.TextChart.
+func TextChart(w, h int) (image.Image, error) {
+	return testpattern.TextChart(w, h)
+}
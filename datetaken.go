@@ -0,0 +1,115 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNoDate is returned when an image records no date taken.
+var ErrNoDate = errors.New("no date taken")
+
+// EXIF date tags.
+const (
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+)
+
+// DateTaken returns when a JPEG or TIFF photo was taken, from its EXIF
+// DateTimeOriginal tag, or DateTime if that is missing. Dates without
+// an OffsetTimeOriginal tag are taken to be in the local time zone, as
+// cameras record them.
+func DateTaken(r io.Reader) (time.Time, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var t *tiffData
+	switch string(magic) {
+	case "\xff\xd8":
+		segs, err := readJPEGSegments(br)
+		if err != nil && len(segs) == 0 {
+			return time.Time{}, err
+		}
+		for _, s := range segs {
+			if s.marker == jpegMarkerAPP1 && bytes.HasPrefix(s.data, exifHeader) {
+				if t, err = parseTIFF(s.data[len(exifHeader):]); err == nil {
+					break
+				}
+			}
+		}
+	case "II", "MM":
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if t, err = parseTIFF(data); err != nil {
+			return time.Time{}, err
+		}
+	}
+	if t == nil || len(t.ifds) == 0 {
+		return time.Time{}, ErrNoDate
+	}
+	return t.dateTaken()
+}
+
+func (t *tiffData) dateTaken() (time.Time, error) {
+	ifd0 := t.ifds[0]
+	s, offset := t.ascii(ifd0, tagDateTime), ""
+	if off, ok := t.uint(ifd0, tagExifIFD); ok {
+		if exif, _, err := t.readIFD(off); err == nil {
+			if v := t.ascii(exif, tagDateTimeOriginal); v != "" {
+				s, offset = v, t.ascii(exif, tagOffsetTimeOriginal)
+			}
+		}
+	}
+	if s == "" {
+		return time.Time{}, ErrNoDate
+	}
+	if offset != "" {
+		if d, err := time.Parse("2006:01:02 15:04:05-07:00", s+offset); err == nil {
+			return d, nil
+		}
+	}
+	d, err := time.ParseInLocation("2006:01:02 15:04:05", s, time.Local)
+	if err != nil {
+		return time.Time{}, ErrNoDate
+	}
+	return d, nil
+}
+
+// ascii returns the string value of a tag, without its terminating NUL.
+func (t *tiffData) ascii(ifd map[uint16]tiffEntry, tag uint16) string {
+	e, ok := ifd[tag]
+	if !ok || e.typ != 2 {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+}
+
+// FileDateTaken returns DateTaken for the image at path, falling back
+// to the file's modification time if it records no date. Use it for
+// NameData.Time to sort outputs into folders by date, with a template
+// such as "{date:2006/01/02}/{name}.{ext}".
+func FileDateTaken(path string) (time.Time, error) {
+	f, err := os.Open(longPath(path))
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	if d, err := DateTaken(f); err == nil {
+		return d, nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
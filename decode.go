@@ -0,0 +1,94 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"math"
+
+	"github.com/imgutils-org/imgutils-convert/internal/jpegdec"
+)
+
+// DecodeOptions configures DecodeWith.
+type DecodeOptions struct {
+	// Scale, between 0 and 1, allows the image to be returned reduced
+	// to as little as this fraction of its size. Baseline JPEG images
+	// are reduced by 1/2, 1/4 or 1/8 while decoding, which skips most
+	// of the inverse DCT work; other images come back at full size.
+	Scale float64
+
+	// FastIDCT decodes baseline JPEG with a faster, slightly less
+	// accurate fixed-point inverse DCT.
+	FastIDCT bool
+}
+
+// DecodeWith reads an image from the reader like Decode, trading
+// fidelity for speed as the options allow.
+func DecodeWith(r io.Reader, opts DecodeOptions) (image.Image, Format, error) {
+	n := jpegScale(opts.Scale)
+	if n == 1 && !opts.FastIDCT {
+		return Decode(r)
+	}
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); string(magic) != "\xff\xd8" {
+		return Decode(br)
+	}
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, "", err
+	}
+	img, _, err := decodeJPEGScaled(bytes.NewReader(data), n, opts.FastIDCT)
+	if err != nil {
+		return nil, "", err
+	}
+	return img, JPEG, nil
+}
+
+// DecodePreview reads a low-resolution preview of an image, such as
+// for a placeholder shown until a full conversion completes. JPEG
+// images are decoded at 1/8 size, progressive ones from only their
+// first scans, which hold the DC coefficients and come early in the
+// file. Other images are decoded in full.
+func DecodePreview(r io.Reader) (image.Image, Format, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); string(magic) != "\xff\xd8" {
+		return Decode(br)
+	}
+	var read bytes.Buffer
+	img, err := jpegdec.Decode(io.TeeReader(br, &read), &jpegdec.Options{Preview: true})
+	if errors.Is(err, jpegdec.ErrUnsupported) {
+		if img, err = jpeg.Decode(io.MultiReader(&read, br)); err == nil {
+			b := img.Bounds()
+			img = Resize(img, (b.Dx()+7)/8, (b.Dy()+7)/8)
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return img, JPEG, nil
+}
+
+// jpegScale returns the largest n of 1, 2, 4 and 8 for which 1/n is at
+// least scale.
+func jpegScale(scale float64) int {
+	n := 1
+	for n < 8 && scale > 0 && 1/float64(2*n) >= scale*0.999 {
+		n *= 2
+	}
+	return n
+}
+
+// decodeJPEGScaled decodes a JPEG image at 1/n size, falling back to
+// image/jpeg at full size for features jpegdec lacks. It returns the
+// reduction it achieved.
+func decodeJPEGScaled(ra io.ReaderAt, n int, fast bool) (image.Image, int, error) {
+	img, err := jpegdec.Decode(io.NewSectionReader(ra, 0, math.MaxInt64), &jpegdec.Options{Scale: n, Fast: fast})
+	if errors.Is(err, jpegdec.ErrUnsupported) {
+		img, err = jpeg.Decode(io.NewSectionReader(ra, 0, math.MaxInt64))
+		n = 1
+	}
+	return img, n, err
+}
@@ -0,0 +1,64 @@
+package convert
+
+import (
+	"image"
+	"io"
+
+	"golang.org/x/image/tiff"
+)
+
+// DecodeAt reads an image of size bytes from ra like Decode. TIFF
+// images are read at random, fetching only the directories, strips
+// and tiles they need, rather than buffered in memory, such as from a
+// section of a stored zip entry or a file fetched with HTTP range
+// requests. Other formats, such as BMP, are read as a stream.
+func DecodeAt(ra io.ReaderAt, size int64) (image.Image, Format, error) {
+	var img image.Image
+	var err error
+	switch tiffMagicAt(ra) {
+	case "classic":
+		img, err = decodeClassicTIFFAt(ra, size)
+	case "big":
+		img, err = decodeBigTIFF(io.NewSectionReader(ra, 0, size))
+	default:
+		return Decode(io.NewSectionReader(ra, 0, size))
+	}
+	if err != nil {
+		if img, _, err = decodeFallback(io.NewSectionReader(ra, 0, size), TIFF, err); err != nil {
+			return nil, "", err
+		}
+	}
+	return img, TIFF, nil
+}
+
+// DecodeConfigAt returns the dimensions and color model of an image of
+// size bytes in ra, reading TIFF images at random like DecodeAt.
+func DecodeConfigAt(ra io.ReaderAt, size int64) (image.Config, Format, error) {
+	r := io.NewSectionReader(ra, 0, size)
+	switch tiffMagicAt(ra) {
+	case "classic":
+		cfg, err := tiff.DecodeConfig(r)
+		return cfg, TIFF, err
+	case "big":
+		cfg, err := decodeBigTIFFConfig(r)
+		return cfg, TIFF, err
+	}
+	cfg, name, err := image.DecodeConfig(r)
+	return cfg, Format(name), err
+}
+
+// tiffMagicAt reports whether ra holds a "classic" TIFF or a "big"
+// BigTIFF image, or neither.
+func tiffMagicAt(ra io.ReaderAt) string {
+	var magic [4]byte
+	if _, err := ra.ReadAt(magic[:], 0); err != nil {
+		return ""
+	}
+	switch string(magic[:]) {
+	case "II*\x00", "MM\x00*":
+		return "classic"
+	case "II+\x00", "MM\x00+":
+		return "big"
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package convert
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"sync"
+)
+
+// A Decoder decodes an image of the format it is registered for.
+type Decoder func(r io.Reader) (image.Image, error)
+
+// stdDecoder names the built-in decoders, which are tried first.
+const stdDecoder = "std"
+
+type namedDecoder struct {
+	name   string
+	decode Decoder
+}
+
+var decoders = struct {
+	sync.RWMutex
+	m map[Format][]namedDecoder
+}{m: map[Format][]namedDecoder{}}
+
+// RegisterDecoder adds d under name to the end of the decoders Decode
+// tries for images recognized as format, replacing any decoder
+// previously registered under that name. When the built-in decoder
+// fails, each is tried in turn on the input from the start, such as
+// one wrapping libjpeg-turbo and then a salvage function, and the
+// first image one returns is used.
+func RegisterDecoder(format Format, name string, d Decoder) {
+	decoders.Lock()
+	defer decoders.Unlock()
+	chain := decoders.m[format]
+	for i, nd := range chain {
+		if nd.name == name {
+			chain[i].decode = d
+			return
+		}
+	}
+	decoders.m[format] = append(chain, namedDecoder{name, d})
+}
+
+// Decoders returns the names of the decoders tried for format, in
+// order, starting with "std" for the built-in one.
+func Decoders(format Format) []string {
+	decoders.RLock()
+	defer decoders.RUnlock()
+	names := []string{stdDecoder}
+	for _, nd := range decoders.m[format] {
+		names = append(names, nd.name)
+	}
+	return names
+}
+
+// decodeChain decodes an image like Decode, returning the name of the
+// decoder that succeeded. The input is buffered only if decoders are
+// registered to fall back on.
+func decodeChain(r io.Reader) (image.Image, Format, string, error) {
+	decoders.RLock()
+	fallback := len(decoders.m) > 0
+	decoders.RUnlock()
+	if !fallback {
+		img, format, err := decodeStd(r)
+		return img, format, stdDecoder, err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", "", err
+	}
+	img, format, err := decodeStd(bytes.NewReader(data))
+	if err == nil {
+		return img, format, stdDecoder, nil
+	}
+	_, name, cerr := image.DecodeConfig(bytes.NewReader(data))
+	if cerr != nil && bytes.HasPrefix(data, []byte("\xff\xd8")) {
+		name = string(JPEG)
+	}
+	format = Format(name)
+	img, decoder, err := decodeFallback(bytes.NewReader(data), format, err)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return img, format, decoder, nil
+}
+
+// decodeFallback tries the decoders registered for format in turn on
+// the input in r, which the built-in decoder failed to decode with err.
+func decodeFallback(r io.Reader, format Format, err error) (image.Image, string, error) {
+	decoders.RLock()
+	chain := decoders.m[format]
+	decoders.RUnlock()
+	if len(chain) == 0 {
+		return nil, "", err
+	}
+	data, rerr := io.ReadAll(r)
+	if rerr != nil {
+		return nil, "", err
+	}
+	for _, nd := range chain {
+		if img, derr := nd.decode(bytes.NewReader(data)); derr == nil {
+			return img, nd.name, nil
+		}
+	}
+	return nil, "", err
+}
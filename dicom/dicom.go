@@ -0,0 +1,593 @@
+// Package dicom decodes the pixel data of DICOM files for previews.
+//
+// Uncompressed (implicit and explicit VR, little and big endian),
+// deflated, RLE lossless and baseline JPEG transfer syntaxes are
+// supported. Importing the package registers the "dicom" format with
+// image.Decode, which applies the default window.
+package dicom
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupported is returned for valid DICOM files using features this
+// package does not implement.
+var ErrUnsupported = errors.New("dicom: unsupported feature")
+
+// Options configures decoding.
+type Options struct {
+	Frame int // frame of a multi-frame image, default 0
+
+	// WindowCenter and WindowWidth select the range of modality values
+	// mapped to black through white. When WindowWidth is 0 the file's
+	// first window is used, or the full range of the frame's values if
+	// it has none.
+	WindowCenter float64
+	WindowWidth  float64
+}
+
+func init() {
+	image.RegisterFormat("dicom", strings.Repeat("?", 128)+"DICM", func(r io.Reader) (image.Image, error) {
+		return Decode(r, nil)
+	}, DecodeConfig)
+}
+
+// Transfer syntaxes.
+const (
+	syntaxImplicitLE = "1.2.840.10008.1.2"
+	syntaxExplicitLE = "1.2.840.10008.1.2.1"
+	syntaxDeflatedLE = "1.2.840.10008.1.2.1.99"
+	syntaxExplicitBE = "1.2.840.10008.1.2.2"
+	syntaxJPEG       = "1.2.840.10008.1.2.4.50"
+	syntaxRLE        = "1.2.840.10008.1.2.5"
+)
+
+// Tags, as group<<16 | element.
+const (
+	tagTransferSyntax   = 0x00020010
+	tagSamplesPerPixel  = 0x00280002
+	tagPhotometric      = 0x00280004
+	tagPlanarConfig     = 0x00280006
+	tagNumberOfFrames   = 0x00280008
+	tagRows             = 0x00280010
+	tagColumns          = 0x00280011
+	tagBitsAllocated    = 0x00280100
+	tagBitsStored       = 0x00280101
+	tagPixelRepr        = 0x00280103
+	tagWindowCenter     = 0x00281050
+	tagWindowWidth      = 0x00281051
+	tagRescaleIntercept = 0x00281052
+	tagRescaleSlope     = 0x00281053
+	tagPixelData        = 0x7fe00010
+	tagItem             = 0xfffee000
+	tagItemDelim        = 0xfffee00d
+	tagSequenceDelim    = 0xfffee0dd
+)
+
+const undefinedLength = 0xffffffff
+
+// maxValue bounds the size of attribute values kept in memory.
+const maxValue = 1 << 10
+
+// longVRs have a 4-byte length in explicit VR syntaxes.
+var longVRs = map[string]bool{
+	"OB": true, "OD": true, "OF": true, "OL": true, "OV": true, "OW": true,
+	"SQ": true, "SV": true, "UC": true, "UN": true, "UR": true, "UT": true, "UV": true,
+}
+
+type parser struct {
+	r        *bufio.Reader
+	order    binary.ByteOrder
+	explicit bool
+	syntax   string
+	attrs    map[uint32][]byte
+}
+
+// header holds the image attributes.
+type header struct {
+	rows, cols  int
+	samples     int
+	bits        int // bits allocated
+	stored      int // bits stored
+	signed      bool
+	planar      bool
+	frames      int
+	photometric string
+	slope       float64
+	intercept   float64
+	center      float64
+	width       float64
+}
+
+// DecodeConfig returns the dimensions and color model of a DICOM image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	p, h, err := readHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	model := color.GrayModel
+	if h.samples == 3 || (p.syntax == syntaxJPEG && h.samples != 1) {
+		model = color.RGBAModel
+	}
+	return image.Config{ColorModel: model, Width: h.cols, Height: h.rows}, nil
+}
+
+// Decode decodes a frame of a DICOM image. Grayscale images are mapped
+// to 8 bits through the window; color images are returned as RGB.
+func Decode(r io.Reader, opts *Options) (image.Image, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	p, h, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Frame < 0 || opts.Frame >= h.frames {
+		return nil, fmt.Errorf("dicom: frame %d out of range", opts.Frame)
+	}
+	if opts.WindowWidth > 0 {
+		h.center, h.width = opts.WindowCenter, opts.WindowWidth
+	}
+
+	switch p.syntax {
+	case syntaxJPEG, syntaxRLE:
+		frame, err := p.encapsulatedFrame(h, opts.Frame)
+		if err != nil {
+			return nil, err
+		}
+		if p.syntax == syntaxRLE {
+			data, err := decodeRLE(frame, h)
+			if err != nil {
+				return nil, err
+			}
+			return h.image(data, binary.LittleEndian)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(frame))
+		if err != nil {
+			return nil, err
+		}
+		if g, ok := img.(*image.Gray); ok {
+			h.bits, h.stored = 8, 8
+			h.rows, h.cols = g.Rect.Dy(), g.Rect.Dx()
+			pix := make([]byte, 0, h.rows*h.cols)
+			for y := 0; y < h.rows; y++ {
+				pix = append(pix, g.Pix[y*g.Stride:y*g.Stride+h.cols]...)
+			}
+			return h.image(pix, p.order)
+		}
+		return img, nil
+	}
+
+	size := int64(h.rows) * int64(h.cols) * int64(h.samples) * int64(h.bits/8)
+	if _, err := io.CopyN(io.Discard, p.r, size*int64(opts.Frame)); err != nil {
+		return nil, err
+	}
+	data, err := readN(p.r, size)
+	if err != nil {
+		return nil, err
+	}
+	return h.image(data, p.order)
+}
+
+// readN reads n bytes, growing the buffer as data arrives so that a
+// header claiming more than the file holds fails before allocating it.
+func readN(r io.Reader, n int64) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, n); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readHeader parses the file up to the start of the pixel data value.
+func readHeader(r io.Reader) (*parser, *header, error) {
+	p := &parser{r: bufio.NewReader(r), order: binary.LittleEndian, explicit: true, attrs: make(map[uint32][]byte)}
+	var pre [132]byte
+	if _, err := io.ReadFull(p.r, pre[:]); err != nil {
+		return nil, nil, err
+	}
+	if string(pre[128:]) != "DICM" {
+		return nil, nil, errors.New("dicom: missing DICM prefix")
+	}
+
+	// The file meta group is always explicit VR little endian.
+	for {
+		g, err := p.r.Peek(2)
+		if err != nil {
+			return nil, nil, err
+		}
+		if binary.LittleEndian.Uint16(g) != 0x0002 {
+			break
+		}
+		if _, err := p.element(); err != nil {
+			return nil, nil, err
+		}
+	}
+	p.syntax = strings.TrimRight(string(p.attrs[tagTransferSyntax]), "\x00 ")
+	switch p.syntax {
+	case syntaxImplicitLE:
+		p.explicit = false
+	case syntaxExplicitLE, syntaxJPEG, syntaxRLE:
+	case syntaxExplicitBE:
+		p.order = binary.BigEndian
+	case syntaxDeflatedLE:
+		p.r = bufio.NewReader(flate.NewReader(p.r))
+	default:
+		return nil, nil, fmt.Errorf("%w: transfer syntax %q", ErrUnsupported, p.syntax)
+	}
+
+	for {
+		tag, err := p.element()
+		if err != nil {
+			return nil, nil, err
+		}
+		if tag == tagPixelData {
+			break
+		}
+	}
+	h, err := p.header()
+	return p, h, err
+}
+
+// element reads one data element, keeping small values of interest. It
+// returns after the header of the pixel data element without reading
+// its value.
+func (p *parser) element() (uint32, error) {
+	tag, _, n, err := p.readTag()
+	if err != nil {
+		return 0, err
+	}
+	if tag == tagPixelData {
+		return tag, nil
+	}
+	if n == undefinedLength {
+		return tag, p.skipSequence()
+	}
+	if n > maxValue || (tag>>16 != 0x0002 && tag>>16 != 0x0028) {
+		_, err = p.r.Discard(int(n))
+		return tag, err
+	}
+	v := make([]byte, n)
+	if _, err := io.ReadFull(p.r, v); err != nil {
+		return tag, err
+	}
+	p.attrs[tag] = v
+	return tag, nil
+}
+
+func (p *parser) readTag() (tag uint32, vr string, n uint32, err error) {
+	var b [8]byte
+	if _, err = io.ReadFull(p.r, b[:4]); err != nil {
+		return
+	}
+	tag = uint32(p.order.Uint16(b[:]))<<16 | uint32(p.order.Uint16(b[2:]))
+	if tag>>16 == 0xfffe || !p.explicit {
+		// Items and delimiters never have a VR.
+		if _, err = io.ReadFull(p.r, b[:4]); err != nil {
+			return
+		}
+		return tag, "", p.order.Uint32(b[:]), nil
+	}
+	if _, err = io.ReadFull(p.r, b[:4]); err != nil {
+		return
+	}
+	vr = string(b[:2])
+	if !longVRs[vr] {
+		return tag, vr, uint32(p.order.Uint16(b[2:])), nil
+	}
+	if _, err = io.ReadFull(p.r, b[:4]); err != nil {
+		return
+	}
+	return tag, vr, p.order.Uint32(b[:]), nil
+}
+
+// skipSequence skips the items of an undefined-length sequence.
+func (p *parser) skipSequence() error {
+	for {
+		tag, _, n, err := p.readTag()
+		if err != nil {
+			return err
+		}
+		switch {
+		case tag == tagSequenceDelim:
+			return nil
+		case tag == tagItem && n == undefinedLength:
+			if err := p.skipItem(); err != nil {
+				return err
+			}
+		case n != undefinedLength:
+			if _, err := p.r.Discard(int(n)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// skipItem skips the elements of an undefined-length item.
+func (p *parser) skipItem() error {
+	for {
+		tag, _, n, err := p.readTag()
+		if err != nil {
+			return err
+		}
+		switch {
+		case tag == tagItemDelim:
+			return nil
+		case n == undefinedLength:
+			if err := p.skipSequence(); err != nil {
+				return err
+			}
+		default:
+			if _, err := p.r.Discard(int(n)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (p *parser) us(tag uint32, def int) int {
+	if v := p.attrs[tag]; len(v) >= 2 {
+		return int(p.order.Uint16(v))
+	}
+	return def
+}
+
+// str returns the first value of a string attribute.
+func (p *parser) str(tag uint32) string {
+	s := strings.Trim(string(p.attrs[tag]), "\x00 ")
+	if i := strings.IndexByte(s, '\\'); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+func (p *parser) num(tag uint32, def float64) float64 {
+	if v, err := strconv.ParseFloat(p.str(tag), 64); err == nil {
+		return v
+	}
+	return def
+}
+
+func (p *parser) header() (*header, error) {
+	h := &header{
+		rows:        p.us(tagRows, 0),
+		cols:        p.us(tagColumns, 0),
+		samples:     p.us(tagSamplesPerPixel, 1),
+		bits:        p.us(tagBitsAllocated, 0),
+		signed:      p.us(tagPixelRepr, 0) == 1,
+		planar:      p.us(tagPlanarConfig, 0) == 1,
+		frames:      int(p.num(tagNumberOfFrames, 1)),
+		photometric: p.str(tagPhotometric),
+		slope:       p.num(tagRescaleSlope, 1),
+		intercept:   p.num(tagRescaleIntercept, 0),
+		center:      p.num(tagWindowCenter, 0),
+		width:       p.num(tagWindowWidth, 0),
+	}
+	h.stored = p.us(tagBitsStored, h.bits)
+	if h.rows <= 0 || h.cols <= 0 || h.frames <= 0 {
+		return nil, errors.New("dicom: invalid image dimensions")
+	}
+	if h.bits != 8 && h.bits != 16 {
+		return nil, fmt.Errorf("%w: %d bits allocated", ErrUnsupported, h.bits)
+	}
+	if h.stored <= 0 || h.stored > h.bits {
+		h.stored = h.bits
+	}
+	if h.samples != 1 && h.samples != 3 {
+		return nil, fmt.Errorf("%w: %d samples per pixel", ErrUnsupported, h.samples)
+	}
+	if h.samples == 3 && h.bits != 8 {
+		return nil, fmt.Errorf("%w: %d-bit color", ErrUnsupported, h.bits)
+	}
+	if h.photometric == "PALETTE COLOR" {
+		return nil, fmt.Errorf("%w: palette color", ErrUnsupported)
+	}
+	return h, nil
+}
+
+// encapsulatedFrame returns the compressed data of a frame.
+func (p *parser) encapsulatedFrame(h *header, frame int) ([]byte, error) {
+	var frags [][]byte
+	first := true
+	for {
+		tag, _, n, err := p.readTag()
+		if err != nil {
+			return nil, err
+		}
+		if tag == tagSequenceDelim {
+			break
+		}
+		if tag != tagItem || n == undefinedLength {
+			return nil, errors.New("dicom: invalid encapsulated pixel data")
+		}
+		if first {
+			// The basic offset table is not needed when reading sequentially.
+			first = false
+			if _, err := p.r.Discard(int(n)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		b, err := readN(p.r, int64(n))
+		if err != nil {
+			return nil, err
+		}
+		frags = append(frags, b)
+	}
+
+	if len(frags) == h.frames {
+		if frame < len(frags) {
+			return frags[frame], nil
+		}
+	} else {
+		// Frames may span fragments; a JPEG frame ends with EOI.
+		var cur []byte
+		i := 0
+		for _, f := range frags {
+			cur = append(cur, f...)
+			end := bytes.TrimRight(cur, "\x00")
+			if p.syntax == syntaxJPEG && !bytes.HasSuffix(end, []byte{0xff, 0xd9}) {
+				continue
+			}
+			if i == frame {
+				return cur, nil
+			}
+			cur, i = nil, i+1
+		}
+	}
+	return nil, errors.New("dicom: frame not found")
+}
+
+// decodeRLE decodes an RLE lossless frame into little-endian samples,
+// one plane per sample.
+func decodeRLE(data []byte, h *header) ([]byte, error) {
+	if len(data) < 64 {
+		return nil, errors.New("dicom: short rle header")
+	}
+	le := binary.LittleEndian
+	nbytes := h.bits / 8
+	nseg := int(le.Uint32(data))
+	if nseg != h.samples*nbytes {
+		return nil, errors.New("dicom: unexpected rle segment count")
+	}
+	pixels := h.rows * h.cols
+	// A run of up to 128 bytes takes 2, so shorter data cannot fill
+	// the frame.
+	if pixels*nseg > 64*len(data) {
+		return nil, errors.New("dicom: short rle data")
+	}
+	out := make([]byte, pixels*nseg)
+	for s := 0; s < nseg; s++ {
+		start, end := int(le.Uint32(data[4+4*s:])), len(data)
+		if s+1 < nseg {
+			end = int(le.Uint32(data[8+4*s:]))
+		}
+		if start < 64 || start > end || end > len(data) {
+			return nil, errors.New("dicom: invalid rle segment offset")
+		}
+		seg := unpackRLE(data[start:end], pixels)
+
+		// Segments are ordered by sample, most significant byte first.
+		sample, byteIndex := s/nbytes, nbytes-1-s%nbytes
+		for i, b := range seg {
+			out[(sample*pixels+i)*nbytes+byteIndex] = b
+		}
+	}
+	h.planar = true
+	return out, nil
+}
+
+func unpackRLE(src []byte, want int) []byte {
+	dst := make([]byte, 0, want)
+	for i := 0; i < len(src) && len(dst) < want; {
+		n := int(int8(src[i]))
+		i++
+		switch {
+		case n >= 0:
+			end := i + n + 1
+			if end > len(src) {
+				end = len(src)
+			}
+			dst = append(dst, src[i:end]...)
+			i = end
+		case n != -128 && i < len(src):
+			for j := 0; j < 1-n; j++ {
+				dst = append(dst, src[i])
+			}
+			i++
+		}
+	}
+	return dst
+}
+
+// image converts native frame data to an image.
+func (h *header) image(data []byte, order binary.ByteOrder) (image.Image, error) {
+	pixels := h.rows * h.cols
+	if len(data) < pixels*h.samples*h.bits/8 {
+		return nil, errors.New("dicom: short pixel data")
+	}
+	r := image.Rect(0, 0, h.cols, h.rows)
+	if h.samples == 3 {
+		img := image.NewRGBA(r)
+		for i := 0; i < pixels; i++ {
+			var c [3]byte
+			for s := range c {
+				if h.planar {
+					c[s] = data[s*pixels+i]
+				} else {
+					c[s] = data[3*i+s]
+				}
+			}
+			if strings.HasPrefix(h.photometric, "YBR") {
+				c[0], c[1], c[2] = color.YCbCrToRGB(c[0], c[1], c[2])
+			}
+			copy(img.Pix[4*i:], c[:])
+			img.Pix[4*i+3] = 0xff
+		}
+		return img, nil
+	}
+
+	// Grayscale: stored values to modality values, then through the window.
+	values := make([]float64, pixels)
+	lo, hi := math.Inf(1), math.Inf(-1)
+	shift := uint(32 - h.stored)
+	for i := range values {
+		var v uint32
+		if h.bits == 8 {
+			v = uint32(data[i])
+		} else {
+			v = uint32(order.Uint16(data[2*i:]))
+		}
+		var s int64
+		if h.signed {
+			s = int64(int32(v<<shift) >> shift)
+		} else {
+			s = int64(v << shift >> shift)
+		}
+		f := float64(s)*h.slope + h.intercept
+		values[i] = f
+		lo, hi = math.Min(lo, f), math.Max(hi, f)
+	}
+
+	center, width := h.center, h.width
+	if width <= 0 {
+		center, width = (lo+hi+1)/2, hi-lo+1
+	}
+	img := image.NewGray(r)
+	low := center - 0.5 - (width-1)/2
+	for i, f := range values {
+		var g float64
+		switch {
+		case width <= 1:
+			if f > center-0.5 {
+				g = 255
+			}
+		case f <= low:
+		case f > center-0.5+(width-1)/2:
+			g = 255
+		default:
+			g = ((f-(center-0.5))/(width-1) + 0.5) * 255
+		}
+		y := uint8(math.Round(g))
+		if h.photometric == "MONOCHROME1" {
+			y = 255 - y
+		}
+		img.Pix[i] = y
+	}
+	return img, nil
+}
@@ -0,0 +1,143 @@
+package dicom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"testing"
+)
+
+// element encodes a data element in explicit VR little endian.
+func element(tag uint32, vr string, value []byte) []byte {
+	b := binary.LittleEndian.AppendUint16(nil, uint16(tag>>16))
+	b = binary.LittleEndian.AppendUint16(b, uint16(tag))
+	b = append(b, vr...)
+	if longVRs[vr] {
+		b = append(b, 0, 0)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(value)))
+	} else {
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	}
+	return append(b, value...)
+}
+
+func us(v uint16) []byte { return binary.LittleEndian.AppendUint16(nil, v) }
+
+// item returns the header of an item or delimiter with length n.
+func item(tag, n uint32) []byte {
+	b := binary.LittleEndian.AppendUint16(nil, uint16(tag>>16))
+	b = binary.LittleEndian.AppendUint16(b, uint16(tag))
+	return binary.LittleEndian.AppendUint32(b, n)
+}
+
+// pixelData starts an explicit VR pixel data element of length n,
+// whose value follows.
+func pixelData(n uint32) []byte {
+	b := element(tagPixelData, "OW", nil)
+	binary.LittleEndian.PutUint32(b[8:], n)
+	return b
+}
+
+// testFile returns a DICOM file in the given transfer syntax with the
+// given image attributes, followed by rest.
+func testFile(syntax string, rows, cols, bits, samples uint16, rest ...[]byte) []byte {
+	b := append(make([]byte, 128), "DICM"...)
+	b = append(b, element(tagTransferSyntax, "UI", []byte(syntax+"\x00"))...)
+	b = append(b, element(tagSamplesPerPixel, "US", us(samples))...)
+	b = append(b, element(tagRows, "US", us(rows))...)
+	b = append(b, element(tagColumns, "US", us(cols))...)
+	b = append(b, element(tagBitsAllocated, "US", us(bits))...)
+	for _, r := range rest {
+		b = append(b, r...)
+	}
+	return b
+}
+
+func TestDecodeGray(t *testing.T) {
+	pix := []byte{0, 50, 100, 200, 150, 250}
+	data := testFile(syntaxExplicitLE, 2, 3, 8, 1, pixelData(uint32(len(pix))), pix)
+	img, err := Decode(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, ok := img.(*image.Gray)
+	if !ok {
+		t.Fatalf("decoded %T, want *image.Gray", img)
+	}
+	if g.Rect != image.Rect(0, 0, 3, 2) {
+		t.Fatalf("bounds %v, want 3x2", g.Rect)
+	}
+	// Without a window, the full range of values is mapped.
+	if g.Pix[0] != 0 || g.Pix[5] != 255 {
+		t.Errorf("darkest and brightest pixels are %d and %d, want 0 and 255", g.Pix[0], g.Pix[5])
+	}
+	for i := 1; i < len(pix); i++ {
+		if (pix[i] > pix[i-1]) != (g.Pix[i] > g.Pix[i-1]) {
+			t.Errorf("pixels %d and %d are out of order: %v", i-1, i, g.Pix)
+		}
+	}
+
+	cfg, err := DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 3 || cfg.Height != 2 {
+		t.Errorf("DecodeConfig gave %dx%d, want 3x2", cfg.Width, cfg.Height)
+	}
+}
+
+// malformed are files with broken or hostile headers.
+var malformed = []struct {
+	name string
+	data []byte
+}{
+	{"empty", nil},
+	{"short preamble", make([]byte, 100)},
+	{"missing prefix", make([]byte, 132)},
+	{"no elements", append(make([]byte, 128), "DICM"...)},
+	{"unknown transfer syntax", testFile("1.2.3", 2, 2, 8, 1, pixelData(4), make([]byte, 4))},
+	{"no pixel data", testFile(syntaxExplicitLE, 2, 2, 8, 1)},
+	{"truncated element", testFile(syntaxExplicitLE, 2, 2, 8, 1)[:150]},
+	{"zero rows", testFile(syntaxExplicitLE, 0, 2, 8, 1, pixelData(4), make([]byte, 4))},
+	{"12 bits allocated", testFile(syntaxExplicitLE, 2, 2, 12, 1, pixelData(6), make([]byte, 6))},
+	{"two samples", testFile(syntaxExplicitLE, 2, 2, 8, 2, pixelData(8), make([]byte, 8))},
+	{"16-bit color", testFile(syntaxExplicitLE, 2, 2, 16, 3, pixelData(24), make([]byte, 24))},
+	{"short pixel data", testFile(syntaxExplicitLE, 2, 2, 8, 1, pixelData(4), make([]byte, 3))},
+	{"huge image, little data", testFile(syntaxExplicitLE, 65535, 65535, 8, 3, pixelData(undefinedLength), make([]byte, 16))},
+	{"huge fragment", testFile(syntaxJPEG, 2, 2, 8, 1, pixelData(undefinedLength),
+		item(tagItem, 0), item(tagItem, 0xfffffff0), make([]byte, 16))},
+	{"huge rle frame", testFile(syntaxRLE, 65535, 65535, 8, 1, pixelData(undefinedLength),
+		item(tagItem, 0), item(tagItem, 64), []byte{1, 0, 0, 0, 64}, make([]byte, 59),
+		item(tagSequenceDelim, 0))},
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	for _, tc := range malformed {
+		if _, err := Decode(bytes.NewReader(tc.data), nil); err == nil {
+			t.Errorf("%s: decoded without error", tc.name)
+		}
+	}
+}
+
+func TestDecodeUnsupported(t *testing.T) {
+	data := testFile("1.2.3", 2, 2, 8, 1, pixelData(4), make([]byte, 4))
+	if _, err := Decode(bytes.NewReader(data), nil); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("unknown transfer syntax: got %v, want ErrUnsupported", err)
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	pix := []byte{0, 50, 100, 200}
+	f.Add(testFile(syntaxExplicitLE, 2, 2, 8, 1, pixelData(4), pix))
+	for _, tc := range malformed {
+		f.Add(tc.data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cfg, err := DecodeConfig(bytes.NewReader(data))
+		img, err2 := Decode(bytes.NewReader(data), nil)
+		if err == nil && err2 == nil && (img.Bounds().Dx() != cfg.Width || img.Bounds().Dy() != cfg.Height) {
+			t.Errorf("DecodeConfig gave %dx%d, Decode %v", cfg.Width, cfg.Height, img.Bounds())
+		}
+	})
+}
@@ -0,0 +1,246 @@
+package convert
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// Dither selects how quantization error is distributed when reducing
+// an image to a palette, to black and white, or to 8 bits per sample.
+type Dither int
+
+const (
+	DitherDefault        Dither = iota // Floyd-Steinberg for palettes, none for bit-depth reduction
+	DitherNone                         // map each pixel to the nearest value
+	DitherFloydSteinberg               // error diffusion
+	DitherBayer                        // ordered dithering with an 8x8 Bayer matrix
+	DitherBlueNoise                    // ordered dithering with a 64x64 blue-noise mask
+)
+
+// palette returns the dithering used for palette output.
+func (d Dither) palette() Dither {
+	if d == DitherDefault {
+		return DitherFloydSteinberg
+	}
+	return d
+}
+
+// Draw implements draw.Drawer, mapping src onto a paletted dst with
+// the dithering method. Other destinations are drawn with draw.Src.
+func (d Dither) Draw(dst draw.Image, r image.Rectangle, src image.Image, sp image.Point) {
+	p, ok := dst.(*image.Paletted)
+	switch d = d.palette(); {
+	case d == DitherFloydSteinberg:
+		draw.FloydSteinberg.Draw(dst, r, src, sp)
+		return
+	case d == DitherNone || !ok:
+		draw.Src.Draw(dst, r, src, sp)
+		return
+	}
+
+	r = r.Intersect(dst.Bounds())
+	spread := paletteSpread(len(p.Palette))
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := color.NRGBAModel.Convert(src.At(sp.X+x-r.Min.X, sp.Y+y-r.Min.Y)).(color.NRGBA)
+			o := (d.threshold(x, y) - 0.5) * spread
+			c.R, c.G, c.B = offset8(c.R, o), offset8(c.G, o), offset8(c.B, o)
+			p.SetColorIndex(x, y, uint8(p.Palette.Index(c)))
+		}
+	}
+}
+
+// paletteSpread estimates the distance between neighbouring colors of
+// an n-color palette, which scales the ordered dithering offset.
+func paletteSpread(n int) float64 {
+	levels := math.Cbrt(float64(n)) - 1
+	if levels < 1 {
+		levels = 1
+	}
+	return 255 / levels
+}
+
+func offset8(v uint8, o float64) uint8 {
+	return clamp8(int(math.Round(float64(v) + o)))
+}
+
+// threshold returns the ordered dithering threshold in [0, 1) at (x, y).
+func (d Dither) threshold(x, y int) float64 {
+	if d == DitherBlueNoise {
+		blueNoiseOnce.Do(initBlueNoise)
+		return blueNoise[(y&(noiseSize-1))*noiseSize+(x&(noiseSize-1))]
+	}
+	return (float64(bayer(x&7, y&7)) + 0.5) / 64
+}
+
+// bayer returns the index of (x, y) in the 8x8 Bayer matrix.
+func bayer(x, y int) int {
+	v, xc := 0, x^y
+	for bit, mask := 0, 3; mask > 0; bit += 2 {
+		mask--
+		v |= (y >> uint(mask) & 1) << uint(bit)
+		v |= (xc >> uint(mask) & 1) << uint(bit+1)
+	}
+	return v
+}
+
+// Bilevel reduces img to black and white with the dithering method.
+func Bilevel(img image.Image, d Dither) *image.Paletted {
+	b := img.Bounds()
+	dst := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), color.Palette{color.Black, color.White})
+	d.Draw(dst, dst.Bounds(), Grayscale(img), image.Point{})
+	return dst
+}
+
+// reduceDepth converts a 16-bit image to 8 bits per sample with the
+// dithering method.
+func reduceDepth(img image.Image, d Dither) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	var errs [2][]float64 // Floyd-Steinberg error for this row and the next, 3 samples per pixel
+	if d == DitherFloydSteinberg {
+		errs[0], errs[1] = make([]float64, 3*(b.Dx()+2)), make([]float64, 3*(b.Dx()+2))
+	}
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := color.NRGBA64Model.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA64)
+			out := dst.Pix[y*dst.Stride+4*x:]
+			for i, v := range [3]uint16{c.R, c.G, c.B} {
+				f := float64(v) / 257
+				switch d {
+				case DitherFloydSteinberg:
+					f += errs[0][3*(x+1)+i]
+					q := math.Max(0, math.Min(255, math.Round(f)))
+					e := f - q
+					errs[0][3*(x+2)+i] += e * 7 / 16
+					errs[1][3*x+i] += e * 3 / 16
+					errs[1][3*(x+1)+i] += e * 5 / 16
+					errs[1][3*(x+2)+i] += e * 1 / 16
+					f = q
+				case DitherBayer, DitherBlueNoise:
+					f = math.Floor(f + d.threshold(x, y))
+				default:
+					f = math.Round(f)
+				}
+				out[i] = uint8(math.Max(0, math.Min(255, f)))
+			}
+			out[3] = uint8((uint32(c.A) + 128) / 257)
+		}
+		if d == DitherFloydSteinberg {
+			errs[0], errs[1] = errs[1], errs[0]
+			for i := range errs[1] {
+				errs[1][i] = 0
+			}
+		}
+	}
+	return dst
+}
+
+const noiseSize = 64
+
+var (
+	blueNoiseOnce sync.Once
+	blueNoise     []float64 // thresholds in [0, 1)
+)
+
+// initBlueNoise builds a blue-noise threshold mask with Ulichney's
+// void-and-cluster method, using a toroidal Gaussian energy filter.
+func initBlueNoise() {
+	const n = noiseSize * noiseSize
+	const sigma = 1.5
+	kernel := make([]float64, n)
+	for y := 0; y < noiseSize; y++ {
+		for x := 0; x < noiseSize; x++ {
+			dx, dy := x, y
+			if dx > noiseSize/2 {
+				dx = noiseSize - dx
+			}
+			if dy > noiseSize/2 {
+				dy = noiseSize - dy
+			}
+			kernel[y*noiseSize+x] = math.Exp(-float64(dx*dx+dy*dy) / (2 * sigma * sigma))
+		}
+	}
+
+	on := make([]bool, n)
+	energy := make([]float64, n)
+	set := func(i int, v bool) {
+		on[i] = v
+		sign := 1.0
+		if !v {
+			sign = -1
+		}
+		ix, iy := i%noiseSize, i/noiseSize
+		for y := 0; y < noiseSize; y++ {
+			row := ((y - iy + noiseSize) % noiseSize) * noiseSize
+			for x := 0; x < noiseSize; x++ {
+				energy[y*noiseSize+x] += sign * kernel[row+(x-ix+noiseSize)%noiseSize]
+			}
+		}
+	}
+	// tightestCluster returns the set pixel with the highest energy;
+	// largestVoid the unset pixel with the lowest.
+	tightestCluster := func() int {
+		best := -1
+		for i := range on {
+			if on[i] && (best < 0 || energy[i] > energy[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+	largestVoid := func() int {
+		best := -1
+		for i := range on {
+			if !on[i] && (best < 0 || energy[i] < energy[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+
+	// Start from a random pattern and relax it until moving the tightest
+	// cluster into the largest void no longer changes anything.
+	rng := rand.New(rand.NewSource(1))
+	initial := n / 10
+	for count := 0; count < initial; {
+		if i := rng.Intn(n); !on[i] {
+			set(i, true)
+			count++
+		}
+	}
+	for {
+		c := tightestCluster()
+		set(c, false)
+		v := largestVoid()
+		set(v, true)
+		if v == c {
+			break
+		}
+	}
+
+	rank := make([]int, n)
+	savedOn := append([]bool(nil), on...)
+	savedEnergy := append([]float64(nil), energy...)
+	for r := initial - 1; r >= 0; r-- {
+		c := tightestCluster()
+		set(c, false)
+		rank[c] = r
+	}
+	copy(on, savedOn)
+	copy(energy, savedEnergy)
+	for r := initial; r < n; r++ {
+		v := largestVoid()
+		set(v, true)
+		rank[v] = r
+	}
+
+	blueNoise = make([]float64, n)
+	for i, r := range rank {
+		blueNoise[i] = (float64(r) + 0.5) / n
+	}
+}
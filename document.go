@@ -0,0 +1,190 @@
+package convert
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// maxSkew is the largest skew, in degrees either way, that DetectSkew
+// looks for.
+const maxSkew = 10
+
+// DetectSkew returns the clockwise angle in degrees by which the lines
+// of text in a scanned page are tilted, up to 10 degrees either way.
+// It finds the angle at which the dark pixels of a reduced copy form
+// the sharpest horizontal rows.
+func DetectSkew(img image.Image) float64 {
+	g := Grayscale(Fit(img, 1000, 1000))
+	// Use the lower edges of dark areas, which line up along the
+	// baselines of text, so that solid areas such as scanner borders
+	// count little.
+	var xs, ys []float64
+	for y := 0; y < g.Rect.Dy()-1; y++ {
+		for x := 0; x < g.Rect.Dx(); x++ {
+			if g.Pix[y*g.Stride+x] < 128 && g.Pix[(y+1)*g.Stride+x] >= 128 {
+				xs, ys = append(xs, float64(x)), append(ys, float64(y))
+			}
+		}
+	}
+	if len(xs) == 0 {
+		return 0
+	}
+	bins := make(map[int]int)
+	score := func(deg float64) float64 {
+		s, c := math.Sincos(deg * math.Pi / 180)
+		for k := range bins {
+			delete(bins, k)
+		}
+		for i := range xs {
+			bins[int(math.Floor(ys[i]*c-xs[i]*s))]++
+		}
+		var sum float64
+		for _, n := range bins {
+			sum += float64(n) * float64(n)
+		}
+		return sum
+	}
+	search := func(lo, hi, step float64) float64 {
+		best, bestScore := 0.0, -1.0
+		for i := 0; i <= int(math.Round((hi-lo)/step)); i++ {
+			a := lo + float64(i)*step
+			if s := score(a); s > bestScore {
+				best, bestScore = a, s
+			}
+		}
+		return best
+	}
+	a := search(-maxSkew, maxSkew, 0.5)
+	return search(a-0.5, a+0.5, 0.05)
+}
+
+// Deskew returns img rotated to undo the skew DetectSkew finds, keeping
+// its size. Corners the rotation uncovers are white.
+func Deskew(img image.Image) image.Image {
+	deg := DetectSkew(img)
+	if math.Abs(deg) < 0.1 {
+		return img
+	}
+	b := img.Bounds()
+	m := Rotation(-deg, float64(b.Min.X+b.Max.X)/2, float64(b.Min.Y+b.Max.Y)/2)
+	m[2] -= float64(b.Min.X)
+	m[5] -= float64(b.Min.Y)
+	rotated := Transform(img, m, b.Dx(), b.Dy(), CatmullRom)
+	dst := newRGBAFor(img, rotated.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), rotated, image.Point{}, draw.Over)
+	return dst
+}
+
+// TrimBorders crops away margins without content, such as blank paper
+// or the dark edges a scanner leaves around a page, even where they are
+// tilted. Edge rows and columns are trimmed while they cross between
+// dark and light only a few times, as a margin does and text does not.
+func TrimBorders(img image.Image) image.Image {
+	g := Grayscale(img)
+	r := g.Rect
+	blank := func(x0, y0, dx, dy, n int) bool {
+		crossings, dark := 0, g.Pix[y0*g.Stride+x0] < 128
+		for i := 1; i < n; i++ {
+			v := g.Pix[(y0+i*dy)*g.Stride+x0+i*dx]
+			if dark && v > 160 || !dark && v < 96 {
+				dark = !dark
+				crossings++
+			}
+		}
+		return crossings <= 6
+	}
+	row := func(y int) bool { return blank(r.Min.X, y, 1, 0, r.Dx()) }
+	col := func(x int) bool { return blank(x, r.Min.Y, 0, 1, r.Dy()) }
+	// Content starts with three lines that are not blank, so that a
+	// ragged page edge does not stop trimming.
+	edge := func(blank func(int) bool, at, step int) bool {
+		return blank(at) || blank(at+step) || blank(at+2*step)
+	}
+	for changed := true; changed; {
+		changed = false
+		for r.Dy() > 2 && edge(row, r.Min.Y, 1) {
+			r.Min.Y++
+			changed = true
+		}
+		for r.Dy() > 2 && edge(row, r.Max.Y-1, -1) {
+			r.Max.Y--
+			changed = true
+		}
+		for r.Dx() > 2 && edge(col, r.Min.X, 1) {
+			r.Min.X++
+			changed = true
+		}
+		for r.Dx() > 2 && edge(col, r.Max.X-1, -1) {
+			r.Max.X--
+			changed = true
+		}
+	}
+	if r.Dx() <= 2 || r.Dy() <= 2 || r == g.Rect {
+		return img
+	}
+	cropped, err := crop(img, r.Add(img.Bounds().Min))
+	if err != nil {
+		return img
+	}
+	return cropped
+}
+
+// Trim crops away borders of the colour of img's top-left pixel, as
+// around product photos and screenshots. Pixels count as border if no
+// channel differs by more than tolerance on a 0-255 scale. Images that
+// are all border are returned unchanged.
+func Trim(img image.Image, tolerance int) image.Image {
+	b := img.Bounds()
+	if b.Empty() {
+		return img
+	}
+	br, bg, bb, ba := img.At(b.Min.X, b.Min.Y).RGBA()
+	tol := uint32(clampInt(tolerance, 0, 255)) * 0x101
+	near := func(x, y uint32) bool { return x-y <= tol || y-x <= tol }
+	border := func(x0, y0, dx, dy, n int) bool {
+		for i := 0; i < n; i++ {
+			r, g, b, a := img.At(x0+i*dx, y0+i*dy).RGBA()
+			if !near(r, br) || !near(g, bg) || !near(b, bb) || !near(a, ba) {
+				return false
+			}
+		}
+		return true
+	}
+	r := b
+	for !r.Empty() && border(r.Min.X, r.Min.Y, 1, 0, r.Dx()) {
+		r.Min.Y++
+	}
+	for !r.Empty() && border(r.Min.X, r.Max.Y-1, 1, 0, r.Dx()) {
+		r.Max.Y--
+	}
+	for !r.Empty() && border(r.Min.X, r.Min.Y, 0, 1, r.Dy()) {
+		r.Min.X++
+	}
+	for !r.Empty() && border(r.Max.X-1, r.Min.Y, 0, 1, r.Dy()) {
+		r.Max.X--
+	}
+	if r.Empty() || r == b {
+		return img
+	}
+	cropped, err := crop(img, r)
+	if err != nil {
+		return img
+	}
+	return cropped
+}
+
+// document applies the deskew and trimming options to img.
+func (opts Options) document(img image.Image) image.Image {
+	if opts.Deskew {
+		img = Deskew(img)
+	}
+	if opts.TrimBorders {
+		img = TrimBorders(img)
+	}
+	if opts.Trim {
+		img = Trim(img, opts.TrimTolerance)
+	}
+	return img
+}
@@ -0,0 +1,109 @@
+package convert
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/imgutils-org/imgutils-convert/pixels"
+)
+
+// Shadow configures DropShadow.
+type Shadow struct {
+	Offset image.Point  // shadow position relative to the image
+	Blur   int          // softness as a blur radius in pixels, 0 for a hard edge
+	Color  *color.NRGBA // default black at 50% opacity
+}
+
+// DropShadow returns img over a shadow cast by its alpha, on a
+// transparent canvas grown to hold both.
+func DropShadow(img image.Image, s Shadow) image.Image {
+	col := colorOr(s.Color, color.NRGBA{0, 0, 0, 0x80})
+	if s.Blur < 0 {
+		s.Blur = 0
+	}
+	b := img.Bounds()
+	spread := 3 * s.Blur // three box blur passes
+	sr := b.Add(s.Offset).Inset(-spread)
+	r := b.Union(sr)
+	dst := newRGBAFor(img, r.Sub(r.Min))
+
+	w, h := sr.Dx(), sr.Dy()
+	plane := make([]float64, w*h)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			_, _, _, a := img.At(x, y).RGBA()
+			plane[(y-b.Min.Y+spread)*w+x-b.Min.X+spread] = float64(a)
+		}
+	}
+	for pass := 0; s.Blur > 0 && pass < 3; pass++ {
+		plane = boxBlur(plane, w, h, s.Blur)
+	}
+	mask := image.NewAlpha(sr.Sub(r.Min))
+	for i, a := range plane {
+		mask.Pix[i] = uint8(a/0x101 + 0.5)
+	}
+	draw.DrawMask(dst, mask.Rect, image.NewUniform(col), image.Point{}, mask, mask.Rect.Min, draw.Over)
+	draw.Draw(dst, b.Sub(r.Min), img, b.Min, draw.Over)
+	return dst
+}
+
+// Polaroid returns img in a white instant-photo frame: a border of 6%
+// of its shorter side, four times as deep along the bottom.
+func Polaroid(img image.Image) image.Image {
+	b := img.Bounds()
+	n := max1(minInt(b.Dx(), b.Dy()) * 6 / 100)
+	dst := newRGBAFor(img, image.Rect(0, 0, b.Dx()+2*n, b.Dy()+5*n))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, b.Sub(b.Min).Add(image.Pt(n, n)), img, b.Min, draw.Over)
+	return dst
+}
+
+// Vignette returns img darkened towards its corners. Strength is the
+// darkening at the corners, from 0 for none to 1 for black; the centre
+// is left as it is.
+func Vignette(img image.Image, strength float64) image.Image {
+	strength = math.Max(0, math.Min(1, strength))
+	b := img.Bounds()
+	cx, cy := float64(b.Min.X+b.Max.X)/2, float64(b.Min.Y+b.Max.Y)/2
+	rx, ry := float64(b.Dx())/2, float64(b.Dy())/2
+	return pixels.MapPixels(img, func(x, y int, p pixels.Pixel[float64]) pixels.Pixel[float64] {
+		dx, dy := (float64(x)+0.5-cx)/rx, (float64(y)+0.5-cy)/ry
+		d := math.Min(1, math.Sqrt((dx*dx+dy*dy)/2))
+		f := 1 - strength*d*d*(3-2*d) // smoothstep falloff
+		p.R, p.G, p.B = p.R*f, p.G*f, p.B*f
+		return p
+	})
+}
+
+// Tint returns img with its colours blended towards col by strength,
+// from 0 for unchanged to 1 for solid col, keeping alpha.
+func Tint(img image.Image, col color.Color, strength float64) image.Image {
+	strength = math.Max(0, math.Min(1, strength))
+	t := color.NRGBA64Model.Convert(col).(color.NRGBA64)
+	tr, tg, tb := float64(t.R)/0xffff, float64(t.G)/0xffff, float64(t.B)/0xffff
+	return pixels.MapPixels(img, func(x, y int, p pixels.Pixel[float64]) pixels.Pixel[float64] {
+		p.R += (tr - p.R) * strength
+		p.G += (tg - p.G) * strength
+		p.B += (tb - p.B) * strength
+		return p
+	})
+}
+
+// effects applies the tint, vignette, frame and shadow options to img.
+func (opts Options) effects(img image.Image) image.Image {
+	if opts.Tint != nil && opts.TintStrength > 0 {
+		img = Tint(img, *opts.Tint, opts.TintStrength)
+	}
+	if opts.Vignette > 0 {
+		img = Vignette(img, opts.Vignette)
+	}
+	if opts.Polaroid {
+		img = Polaroid(img)
+	}
+	if opts.Shadow != nil {
+		img = DropShadow(img, *opts.Shadow)
+	}
+	return img
+}
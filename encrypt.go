@@ -0,0 +1,189 @@
+package convert
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Encrypted streams start with encryptMagic and a random nonce prefix,
+// followed by chunks of encryptChunk plaintext bytes sealed with
+// AES-GCM. Each chunk's nonce is the prefix, a big-endian chunk counter
+// and a byte that is 1 for the last chunk, so chunks cannot be
+// reordered, dropped or truncated undetected. The last chunk is always
+// shorter than a full one, possibly empty.
+const (
+	encryptMagic  = "IMGENC\x00\x01"
+	encryptPrefix = 7
+	encryptChunk  = 64 << 10
+)
+
+var errDecrypt = errors.New("decrypt: wrong key or corrupted data")
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	nonce   [12]byte
+	counter uint32
+	buf     []byte
+	closed  bool
+}
+
+// NewEncryptWriter returns a writer that encrypts what is written to it
+// with AES-GCM under key, which must be 16, 24 or 32 bytes, and writes
+// the result to w. Close must be called to write the final chunk; it
+// does not close w. NewDecryptReader reads the result.
+func NewEncryptWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	e := &encryptWriter{w: w, aead: aead, buf: make([]byte, 0, encryptChunk+aead.Overhead())}
+	if _, err := rand.Read(e.nonce[:encryptPrefix]); err != nil {
+		return nil, err
+	}
+	header := append([]byte(encryptMagic), e.nonce[:encryptPrefix]...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("encrypt: write after close")
+	}
+	n := len(p)
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data follows it, so
+		// that the last chunk is always short.
+		if len(e.buf) == encryptChunk {
+			if err := e.seal(false); err != nil {
+				return n - len(p), err
+			}
+		}
+		k := copy(e.buf[len(e.buf):encryptChunk], p)
+		e.buf, p = e.buf[:len(e.buf)+k], p[k:]
+	}
+	return n, nil
+}
+
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	if len(e.buf) == encryptChunk {
+		if err := e.seal(false); err != nil {
+			return err
+		}
+	}
+	return e.seal(true)
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	binary.BigEndian.PutUint32(e.nonce[encryptPrefix:], e.counter)
+	e.nonce[11] = 0
+	if last {
+		e.nonce[11] = 1
+	}
+	e.counter++
+	out := e.aead.Seal(e.buf[:0], e.nonce[:], e.buf, nil)
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(out)
+	return err
+}
+
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	nonce   [12]byte
+	counter uint32
+	chunk   []byte // ciphertext buffer
+	plain   []byte // decrypted bytes not yet read
+	done    bool
+}
+
+// NewDecryptReader returns a reader of the plaintext of a stream written
+// by NewEncryptWriter with the same key. Reads fail if the stream has
+// been altered or truncated.
+func NewDecryptReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(encryptMagic)+encryptPrefix)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errDecrypt
+	}
+	if string(header[:len(encryptMagic)]) != encryptMagic {
+		return nil, errors.New("decrypt: not an encrypted image")
+	}
+	d := &decryptReader{r: r, aead: aead, chunk: make([]byte, encryptChunk+aead.Overhead())}
+	copy(d.nonce[:], header[len(encryptMagic):])
+	return d, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next chunk.
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	last := false
+	switch err {
+	case nil:
+	case io.ErrUnexpectedEOF, io.EOF:
+		last = true
+	default:
+		return err
+	}
+	binary.BigEndian.PutUint32(d.nonce[encryptPrefix:], d.counter)
+	d.nonce[11] = 0
+	if last {
+		d.nonce[11] = 1
+	}
+	d.counter++
+	plain, err := d.aead.Open(d.chunk[:0], d.nonce[:], d.chunk[:n], nil)
+	if err != nil {
+		return errDecrypt
+	}
+	d.plain, d.done = plain, last
+	return nil
+}
+
+// sealed runs encode with a writer that encrypts to w under
+// opts.EncryptionKey.
+func (opts Options) sealed(w io.Writer, encode func(io.Writer, Options) error) error {
+	ew, err := NewEncryptWriter(w, opts.EncryptionKey)
+	if err != nil {
+		return err
+	}
+	opts.EncryptionKey = nil
+	if err := encode(ew, opts); err != nil {
+		return err
+	}
+	return ew.Close()
+}
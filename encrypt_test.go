@@ -0,0 +1,101 @@
+package convert
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, 32)
+
+func encryptBytes(t *testing.T, plain, key []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decryptBytes(data, key []byte) ([]byte, error) {
+	r, err := NewDecryptReader(bytes.NewReader(data), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, encryptChunk - 1, encryptChunk, encryptChunk + 1, 3*encryptChunk + 17} {
+		plain := randomBytes(t, n)
+		data := encryptBytes(t, plain, testKey)
+		got, err := decryptBytes(data, testKey)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Fatalf("%d bytes: round trip gave %d different bytes", n, len(got))
+		}
+	}
+}
+
+func TestEncryptChunkBoundary(t *testing.T) {
+	// A full chunk is followed by an empty last chunk, so that
+	// cutting the stream after it is detected.
+	data := encryptBytes(t, randomBytes(t, encryptChunk), testKey)
+	header := len(encryptMagic) + encryptPrefix
+	if want := header + 2*16 + encryptChunk; len(data) != want {
+		t.Fatalf("stream is %d bytes, want %d", len(data), want)
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	plain := randomBytes(t, 2*encryptChunk+100)
+	data := encryptBytes(t, plain, testKey)
+	header := len(encryptMagic) + encryptPrefix
+	sealed := encryptChunk + 16
+
+	reordered := append([]byte(nil), data...)
+	copy(reordered[header:], data[header+sealed:header+2*sealed])
+	copy(reordered[header+sealed:], data[header:header+sealed])
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+		key  []byte
+	}{
+		{"truncated after a full chunk", data[:header+sealed], testKey},
+		{"truncated mid-chunk", data[:header+sealed+100], testKey},
+		{"last chunk dropped", data[:header+2*sealed], testKey},
+		{"reordered chunks", reordered, testKey},
+		{"wrong key", data, bytes.Repeat([]byte{0x24}, 32)},
+	} {
+		got, err := decryptBytes(tc.data, tc.key)
+		if err == nil {
+			t.Errorf("%s: decrypted %d bytes without error", tc.name, len(got))
+		}
+	}
+}
+
+func TestDecryptRejectsPlainInput(t *testing.T) {
+	if _, err := decryptBytes([]byte("not encrypted at all"), testKey); err == nil {
+		t.Error("plain input decrypted without error")
+	}
+}
@@ -0,0 +1,331 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+)
+
+// ErrNoThumbnail is returned when an image has no embedded thumbnail.
+var ErrNoThumbnail = errors.New("no embedded thumbnail")
+
+// EXIF and TIFF tags.
+const (
+	tagCompression        = 0x0103
+	tagOrientation        = 0x0112
+	tagJPEGInterchange    = 0x0201
+	tagJPEGInterchangeLen = 0x0202
+)
+
+// TIFF field types.
+const (
+	tiffTypeShort = 3
+	tiffTypeLong  = 4
+)
+
+// JPEG markers.
+const (
+	jpegMarkerSOI  = 0xd8
+	jpegMarkerAPP0 = 0xe0
+	jpegMarkerAPP1 = 0xe1
+	jpegMarkerSOS  = 0xda
+)
+
+const (
+	maxJPEGSegmentPayload   = 0xffff - 2
+	defaultThumbnailQuality = 75
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// ExtractThumbnail returns the thumbnail embedded in a JPEG's EXIF or
+// JFIF extension segment. Only the segments before the image data are
+// read, so this is much faster than decoding the full image.
+func ExtractThumbnail(r io.Reader) (image.Image, error) {
+	segs, err := readJPEGSegments(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range segs {
+		switch {
+		case s.marker == jpegMarkerAPP1 && bytes.HasPrefix(s.data, exifHeader):
+			t, err := parseTIFF(s.data[len(exifHeader):])
+			if err != nil {
+				continue
+			}
+			if thumb := t.thumbnail(); thumb != nil {
+				return jpeg.Decode(bytes.NewReader(thumb))
+			}
+		case s.marker == jpegMarkerAPP0 && bytes.HasPrefix(s.data, []byte("JFXX\x00")) && len(s.data) > 5:
+			// JFIF extension; code 0x10 is a JPEG-compressed thumbnail.
+			if s.data[5] == 0x10 {
+				return jpeg.Decode(bytes.NewReader(s.data[6:]))
+			}
+		case s.marker == jpegMarkerAPP0 && bytes.HasPrefix(s.data, []byte("JFIF\x00")) && len(s.data) >= 16:
+			if img := jfifThumbnail(s.data); img != nil {
+				return img, nil
+			}
+		}
+	}
+	return nil, ErrNoThumbnail
+}
+
+// jfifThumbnail decodes the uncompressed RGB thumbnail of a JFIF APP0
+// segment.
+func jfifThumbnail(data []byte) image.Image {
+	w, h := int(data[12]), int(data[13])
+	if w == 0 || h == 0 || len(data) < 14+3*w*h {
+		return nil
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	rgb := data[14:]
+	for i := 0; i < w*h; i++ {
+		copy(img.Pix[i*4:], rgb[i*3:i*3+3])
+		img.Pix[i*4+3] = 0xff
+	}
+	return img
+}
+
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+// readJPEGSegments returns the marker segments preceding the first scan.
+func readJPEGSegments(r io.Reader) ([]jpegSegment, error) {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil {
+		return nil, err
+	}
+	if soi[0] != 0xff || soi[1] != jpegMarkerSOI {
+		return nil, errors.New("not a jpeg")
+	}
+
+	var segs []jpegSegment
+	for {
+		var m [4]byte
+		if _, err := io.ReadFull(br, m[:2]); err != nil {
+			return segs, err
+		}
+		if m[0] != 0xff {
+			return segs, errors.New("jpeg: invalid marker")
+		}
+		if m[1] == 0xff {
+			br.UnreadByte()
+			continue
+		}
+		if m[1] == jpegMarkerSOS || m[1] == 0xd9 {
+			return segs, nil
+		}
+		if _, err := io.ReadFull(br, m[2:]); err != nil {
+			return segs, err
+		}
+		n := int(binary.BigEndian.Uint16(m[2:])) - 2
+		if n < 0 {
+			return segs, errors.New("jpeg: invalid segment length")
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return segs, err
+		}
+		segs = append(segs, jpegSegment{marker: m[1], data: data})
+	}
+}
+
+// tiffData is a parsed TIFF structure, as used in EXIF segments and
+// TIFF files.
+type tiffData struct {
+	data  []byte
+	order binary.ByteOrder
+	ifds  []map[uint16]tiffEntry
+}
+
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	value []byte // raw value bytes in the file's byte order
+}
+
+var tiffTypeSize = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// parseTIFF reads the header and IFD chain of TIFF-structured data.
+func parseTIFF(data []byte) (*tiffData, error) {
+	if len(data) < 8 {
+		return nil, errors.New("tiff: short header")
+	}
+	t := &tiffData{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, errors.New("tiff: invalid byte order")
+	}
+	if t.order.Uint16(data[2:]) != 42 {
+		return nil, errors.New("tiff: invalid magic")
+	}
+
+	off := t.order.Uint32(data[4:])
+	seen := make(map[uint32]bool)
+	for off != 0 && !seen[off] && len(t.ifds) < 16 {
+		seen[off] = true
+		ifd, next, err := t.readIFD(off)
+		if err != nil {
+			if len(t.ifds) == 0 {
+				return nil, err
+			}
+			break
+		}
+		t.ifds = append(t.ifds, ifd)
+		off = next
+	}
+	return t, nil
+}
+
+// readIFD reads the directory at off and returns the offset of the next.
+func (t *tiffData) readIFD(off uint32) (map[uint16]tiffEntry, uint32, error) {
+	if int64(off)+2 > int64(len(t.data)) {
+		return nil, 0, errors.New("tiff: ifd out of range")
+	}
+	n := int(t.order.Uint16(t.data[off:]))
+	p := int(off) + 2
+	if p+12*n+4 > len(t.data) {
+		return nil, 0, errors.New("tiff: ifd out of range")
+	}
+	ifd := make(map[uint16]tiffEntry, n)
+	for i := 0; i < n; i++ {
+		e := t.data[p+12*i:]
+		tag, typ, count := t.order.Uint16(e), t.order.Uint16(e[2:]), t.order.Uint32(e[4:])
+		size := int64(tiffTypeSize[typ]) * int64(count)
+		var value []byte
+		if size <= 4 {
+			value = e[8 : 8+size]
+		} else {
+			vo := int64(t.order.Uint32(e[8:]))
+			if vo+size > int64(len(t.data)) {
+				continue
+			}
+			value = t.data[vo : vo+size]
+		}
+		ifd[tag] = tiffEntry{typ: typ, count: count, value: value}
+	}
+	return ifd, t.order.Uint32(t.data[p+12*n:]), nil
+}
+
+// uint returns the first integer value of a tag.
+func (t *tiffData) uint(ifd map[uint16]tiffEntry, tag uint16) (uint32, bool) {
+	e, ok := ifd[tag]
+	if !ok || e.count == 0 {
+		return 0, false
+	}
+	switch e.typ {
+	case tiffTypeShort:
+		return uint32(t.order.Uint16(e.value)), true
+	case tiffTypeLong:
+		return t.order.Uint32(e.value), true
+	case 1:
+		return uint32(e.value[0]), true
+	}
+	return 0, false
+}
+
+// thumbnail returns the JPEG thumbnail referenced by IFD1, if any.
+func (t *tiffData) thumbnail() []byte {
+	if len(t.ifds) < 2 {
+		return nil
+	}
+	ifd := t.ifds[1]
+	off, ok1 := t.uint(ifd, tagJPEGInterchange)
+	n, ok2 := t.uint(ifd, tagJPEGInterchangeLen)
+	if !ok1 || !ok2 || int64(off)+int64(n) > int64(len(t.data)) {
+		return nil
+	}
+	return t.data[off : off+n]
+}
+
+// exifThumbnailSegment builds an APP1 EXIF segment (without marker and
+// length) containing an empty IFD0 and an IFD1 that holds thumb.
+func exifThumbnailSegment(thumb []byte) []byte {
+	le := binary.LittleEndian
+	var b bytes.Buffer
+	b.Write(exifHeader)
+
+	// TIFF header, IFD0 at offset 8 with no entries, IFD1 right after.
+	tiff := make([]byte, 8+6+2+3*12+4)
+	copy(tiff, "II")
+	le.PutUint16(tiff[2:], 42)
+	le.PutUint32(tiff[4:], 8)
+	le.PutUint16(tiff[8:], 0)
+	le.PutUint32(tiff[10:], 14)
+
+	ifd1 := tiff[14:]
+	le.PutUint16(ifd1, 3)
+	entry := func(i int, tag, typ uint16, v uint32) {
+		e := ifd1[2+12*i:]
+		le.PutUint16(e, tag)
+		le.PutUint16(e[2:], typ)
+		le.PutUint32(e[4:], 1)
+		le.PutUint32(e[8:], v)
+	}
+	entry(0, tagCompression, tiffTypeShort, 6) // JPEG
+	entry(1, tagJPEGInterchange, tiffTypeLong, uint32(len(tiff)))
+	entry(2, tagJPEGInterchangeLen, tiffTypeLong, uint32(len(thumb)))
+	le.PutUint32(ifd1[2+36:], 0)
+
+	b.Write(tiff)
+	b.Write(thumb)
+	return b.Bytes()
+}
+
+// encodeThumbnail encodes a JPEG thumbnail of img fitting within
+// opts.Thumbnail pixels that is small enough for an APP1 segment.
+func encodeThumbnail(img image.Image, opts Options) ([]byte, error) {
+	thumb := opts.fit(img, opts.Thumbnail, opts.Thumbnail)
+	limit := maxJPEGSegmentPayload - len(exifHeader) - 64
+	for q := defaultThumbnailQuality; q > 10; q -= 15 {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: q}); err != nil {
+			return nil, err
+		}
+		if buf.Len() <= limit {
+			return buf.Bytes(), nil
+		}
+	}
+	return nil, errors.New("thumbnail too large for exif segment")
+}
+
+// encodeJPEGWithThumbnail encodes img as JPEG with an EXIF APP1 segment
+// holding a freshly generated thumbnail.
+func encodeJPEGWithThumbnail(w io.Writer, img image.Image, opts Options) error {
+	thumb, err := encodeThumbnail(img, opts)
+	if err != nil {
+		return err
+	}
+	var main bytes.Buffer
+	if err := encodeJPEG(&main, img, opts); err != nil {
+		return err
+	}
+	return writeJPEGWithSegment(w, main.Bytes(), jpegMarkerAPP1, exifThumbnailSegment(thumb))
+}
+
+// writeJPEGWithSegment writes an encoded JPEG with an extra marker
+// segment inserted right after SOI.
+func writeJPEGWithSegment(w io.Writer, data []byte, marker byte, payload []byte) error {
+	if len(data) < 2 || len(payload) > maxJPEGSegmentPayload {
+		return errors.New("jpeg: cannot insert segment")
+	}
+	hdr := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(hdr[2:], uint16(len(payload)+2))
+	for _, b := range [][]byte{data[:2], hdr, payload, data[2:]} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package convert
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenURL is returned when a URL is rejected by a Fetcher's
+// scheme, host or address policy.
+var ErrForbiddenURL = errors.New("forbidden url")
+
+// ErrTooLarge is returned when a remote image exceeds the size limit.
+var ErrTooLarge = errors.New("image too large")
+
+// Fetcher downloads remote images.
+type Fetcher struct {
+	// Client is used for requests. If nil, a client is built that
+	// refuses to connect to loopback, private and link-local addresses
+	// unless AllowPrivate is set. A custom Client bypasses that check.
+	Client *http.Client
+
+	MaxBytes       int64    // maximum response body size, default 20 MiB
+	MaxPixels      int      // maximum width*height of images ConvertPipeline decodes, resizes or pads to, default 50 megapixels
+	AllowedSchemes []string // default "http" and "https"
+	AllowedHosts   []string // nil allows any host; "*.example.com" matches subdomains
+	AllowPrivate   bool     // allow non-public destination addresses
+	Cache          FetchCache
+
+	once   sync.Once
+	client *http.Client
+}
+
+// FetchCache stores responses for conditional requests.
+type FetchCache interface {
+	Get(url string) (*CachedResponse, bool)
+	Put(url string, resp *CachedResponse)
+}
+
+// CachedResponse is a response body with its validators.
+type CachedResponse struct {
+	ETag         string
+	LastModified string
+	Body         []byte
+}
+
+var defaultFetcher = &Fetcher{}
+
+// ConvertURL fetches an image over HTTP(S) with the default Fetcher and
+// converts it to the given format.
+func ConvertURL(ctx context.Context, rawURL string, w io.Writer, format Format, opts Options) error {
+	return defaultFetcher.Convert(ctx, rawURL, w, format, opts)
+}
+
+// Convert fetches an image and converts it to the given format.
+func (f *Fetcher) Convert(ctx context.Context, rawURL string, w io.Writer, format Format, opts Options) error {
+	data, err := f.Fetch(ctx, rawURL)
+	if err != nil {
+		return err
+	}
+	return Convert(bytes.NewReader(data), w, format, opts)
+}
+
+// Fetch downloads the body at rawURL, enforcing the Fetcher's policy.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var cached *CachedResponse
+	if f.Cache != nil {
+		if c, ok := f.Cache.Get(u.String()); ok {
+			cached = c
+			if c.ETag != "" {
+				req.Header.Set("If-None-Match", c.ETag)
+			}
+			if c.LastModified != "" {
+				req.Header.Set("If-Modified-Since", c.LastModified)
+			}
+		}
+	}
+
+	resp, err := f.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		return cached.Body, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s: %s", u.Redacted(), resp.Status)
+	}
+
+	max := f.maxBytes()
+	if resp.ContentLength > max {
+		return nil, ErrTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, ErrTooLarge
+	}
+
+	if f.Cache != nil {
+		etag, lm := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+		if etag != "" || lm != "" {
+			f.Cache.Put(u.String(), &CachedResponse{ETag: etag, LastModified: lm, Body: data})
+		}
+	}
+	return data, nil
+}
+
+func (f *Fetcher) maxBytes() int64 {
+	if f.MaxBytes > 0 {
+		return f.MaxBytes
+	}
+	return 20 << 20
+}
+
+func (f *Fetcher) maxPixels() int {
+	if f.MaxPixels > 0 {
+		return f.MaxPixels
+	}
+	return 50 << 20
+}
+
+func (f *Fetcher) httpClient() *http.Client {
+	if f.Client != nil {
+		return f.Client
+	}
+	f.once.Do(func() {
+		dialer := &net.Dialer{Timeout: 10 * time.Second}
+		if !f.AllowPrivate {
+			dialer.Control = func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+					return ErrForbiddenURL
+				}
+				return nil
+			}
+		}
+		f.client = &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 5 {
+					return errors.New("too many redirects")
+				}
+				return f.checkURL(req.URL)
+			},
+		}
+	})
+	return f.client
+}
+
+func (f *Fetcher) checkURL(u *url.URL) error {
+	schemes := f.AllowedSchemes
+	if schemes == nil {
+		schemes = []string{"http", "https"}
+	}
+	if !containsFold(schemes, u.Scheme) {
+		return ErrForbiddenURL
+	}
+	if f.AllowedHosts != nil && !hostAllowed(f.AllowedHosts, u.Hostname()) {
+		return ErrForbiddenURL
+	}
+	return nil
+}
+
+func hostAllowed(patterns []string, host string) bool {
+	host = strings.ToLower(host)
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if strings.HasPrefix(p, "*.") {
+			if strings.HasSuffix(host, p[1:]) {
+				return true
+			}
+		} else if host == p {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+var nonPublicNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, s := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8",
+		"169.254.0.0/16", "172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16",
+		"198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+		"::/96", "::1/128", "64:ff9b:1::/48", "100::/64", "fc00::/7",
+		"fe80::/10", "ff00::/8",
+	} {
+		_, n, _ := net.ParseCIDR(s)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// nat64Net is the well-known NAT64 prefix, whose addresses reach the
+// IPv4 address in their last four bytes.
+var nat64Net = &net.IPNet{IP: net.ParseIP("64:ff9b::"), Mask: net.CIDRMask(96, 128)}
+
+// isPublicIP reports whether ip is a globally routable unicast address.
+// IPv4-mapped and NAT64 addresses are judged by the IPv4 address they
+// embed.
+func isPublicIP(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	} else if nat64Net.Contains(ip) {
+		ip = ip.To16()[12:]
+	}
+	for _, n := range nonPublicNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// MemoryCache is an in-memory LRU FetchCache.
+type MemoryCache struct {
+	mu      sync.Mutex
+	max     int
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	url  string
+	resp *CachedResponse
+}
+
+// NewMemoryCache returns a cache holding at most maxEntries responses.
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	return &MemoryCache{max: maxEntries, ll: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Get implements FetchCache.
+func (c *MemoryCache) Get(url string) (*CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[url]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*memoryCacheEntry).resp, true
+}
+
+// Put implements FetchCache.
+func (c *MemoryCache) Put(url string, resp *CachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[url]; ok {
+		e.Value.(*memoryCacheEntry).resp = resp
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.entries[url] = c.ll.PushFront(&memoryCacheEntry{url: url, resp: resp})
+	if c.max > 0 && c.ll.Len() > c.max {
+		last := c.ll.Back()
+		c.ll.Remove(last)
+		delete(c.entries, last.Value.(*memoryCacheEntry).url)
+	}
+}
@@ -0,0 +1,179 @@
+//go:build ffmpeg
+// +build ffmpeg
+
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// FFmpegSource is a FrameSource that decodes video by running ffmpeg
+// and reading its frames as a PPM stream.
+type FFmpegSource struct {
+	cmd    *exec.Cmd
+	out    io.ReadCloser
+	r      *bufio.Reader
+	stderr bytes.Buffer
+	waited bool
+	fps    float64
+	frame  int
+}
+
+// FFmpegOptions configures FFmpegSource.
+type FFmpegOptions struct {
+	Binary string        // path to ffmpeg, default "ffmpeg" from PATH
+	FPS    float64       // frames per second to extract, default the video's own rate
+	Width  int           // scale frames to this width, keeping the aspect ratio
+	Start  time.Duration // seek to this offset first
+}
+
+func init() {
+	backends["ffmpeg"] = func() error {
+		_, err := exec.LookPath("ffmpeg")
+		return err
+	}
+}
+
+// NewFFmpegSource starts ffmpeg on the video at path.
+func NewFFmpegSource(path string, opts FFmpegOptions) (*FFmpegSource, error) {
+	bin := opts.Binary
+	if bin == "" {
+		bin = "ffmpeg"
+	}
+	args := []string{"-nostdin", "-loglevel", "error"}
+	if opts.Start > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(opts.Start.Seconds(), 'f', 3, 64))
+	}
+	args = append(args, "-i", path)
+	var filters string
+	if opts.FPS > 0 {
+		filters = fmt.Sprintf("fps=%g", opts.FPS)
+	}
+	if opts.Width > 0 {
+		if filters != "" {
+			filters += ","
+		}
+		filters += fmt.Sprintf("scale=%d:-2", opts.Width)
+	}
+	if filters != "" {
+		args = append(args, "-vf", filters)
+	}
+	args = append(args, "-f", "image2pipe", "-vcodec", "ppm", "-")
+
+	s := &FFmpegSource{cmd: exec.Command(bin, args...), fps: opts.FPS}
+	if s.fps <= 0 {
+		s.fps = 25 // only used for timestamps when ffmpeg picks the rate
+	}
+	s.cmd.Stderr = &s.stderr
+	var err error
+	if s.out, err = s.cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if err := s.cmd.Start(); err != nil {
+		return nil, err
+	}
+	s.r = bufio.NewReaderSize(s.out, 1<<16)
+	return s, nil
+}
+
+// Next returns the next frame. Presentation times assume the frame
+// rate given in the options.
+func (s *FFmpegSource) Next() (image.Image, time.Duration, error) {
+	img, err := readPPM(s.r)
+	if err == io.EOF {
+		s.waited = true
+		if werr := s.cmd.Wait(); werr != nil {
+			if msg := bytes.TrimSpace(s.stderr.Bytes()); len(msg) > 0 {
+				return nil, 0, fmt.Errorf("ffmpeg: %v: %s", werr, msg)
+			}
+			return nil, 0, fmt.Errorf("ffmpeg: %v", werr)
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	t := time.Duration(float64(s.frame) / s.fps * float64(time.Second))
+	s.frame++
+	return img, t, nil
+}
+
+// Close stops ffmpeg if it is still running.
+func (s *FFmpegSource) Close() error {
+	if s.waited {
+		return nil
+	}
+	s.waited = true
+	s.cmd.Process.Kill()
+	s.cmd.Wait()
+	return nil
+}
+
+// readPPM reads one binary PPM image with 8-bit samples, returning
+// io.EOF at the end of the stream.
+func readPPM(r *bufio.Reader) (image.Image, error) {
+	var dims [3]int
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return nil, err
+	}
+	if string(magic) != "P6" {
+		return nil, errors.New("ffmpeg: not a ppm stream")
+	}
+	for i := range dims {
+		n, err := ppmInt(r)
+		if err != nil {
+			return nil, err
+		}
+		dims[i] = n
+	}
+	w, h, maxval := dims[0], dims[1], dims[2]
+	if maxval != 255 || w <= 0 || h <= 0 {
+		return nil, errors.New("ffmpeg: unsupported ppm frame")
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	row := make([]byte, 3*w)
+	for y := 0; y < h; y++ {
+		if _, err := io.ReadFull(r, row); err != nil {
+			return nil, err
+		}
+		p := img.Pix[y*img.Stride:]
+		for x := 0; x < w; x++ {
+			p[4*x], p[4*x+1], p[4*x+2], p[4*x+3] = row[3*x], row[3*x+1], row[3*x+2], 0xff
+		}
+	}
+	return img, nil
+}
+
+// ppmInt reads a whitespace-delimited decimal header field, skipping
+// comments, and the single whitespace byte after it.
+func ppmInt(r *bufio.Reader) (int, error) {
+	n, digits := 0, 0
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case c == '#' && digits == 0:
+			if _, err := r.ReadString('\n'); err != nil {
+				return 0, err
+			}
+		case c >= '0' && c <= '9':
+			n = n*10 + int(c-'0')
+			digits++
+		case digits > 0:
+			return n, nil
+		}
+	}
+}
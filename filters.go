@@ -0,0 +1,198 @@
+package convert
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/imgutils-org/imgutils-convert/pixels"
+)
+
+// Posterize returns img with each colour channel reduced to levels
+// evenly spaced values, keeping alpha.
+func Posterize(img image.Image, levels int) image.Image {
+	if levels < 2 {
+		levels = 2
+	}
+	n := float64(levels - 1)
+	return pixels.MapPixels(img, func(x, y int, p pixels.Pixel[float64]) pixels.Pixel[float64] {
+		p.R, p.G, p.B = math.Round(p.R*n)/n, math.Round(p.G*n)/n, math.Round(p.B*n)/n
+		return p
+	})
+}
+
+// Threshold reduces img to black and white: pixels whose luma is at
+// least level become white.
+func Threshold(img image.Image, level int) *image.Paletted {
+	g := Grayscale(img)
+	dst := image.NewPaletted(g.Rect, color.Palette{color.Black, color.White})
+	for i, v := range g.Pix[:len(dst.Pix)] {
+		if int(v) >= level {
+			dst.Pix[i] = 1
+		}
+	}
+	return dst
+}
+
+// Otsu returns the threshold level that best separates the luma of img
+// into dark and light classes, by Otsu's method, for use with
+// Threshold.
+func Otsu(img image.Image) int {
+	return otsu(Grayscale(img).Pix)
+}
+
+func otsu(samples []uint8) int {
+	var hist [256]float64
+	for _, v := range samples {
+		hist[v]++
+	}
+	var total, sum float64
+	for i, n := range hist {
+		total += n
+		sum += float64(i) * n
+	}
+	var w0, sum0, best float64
+	level := 128
+	for t := 0; t < 255; t++ {
+		w0 += hist[t]
+		sum0 += float64(t) * hist[t]
+		w1 := total - w0
+		if w0 == 0 || w1 == 0 {
+			continue
+		}
+		m0, m1 := sum0/w0, (sum-sum0)/w1
+		if v := w0 * w1 * (m0 - m1) * (m0 - m1); v > best {
+			best, level = v, t+1
+		}
+	}
+	return level
+}
+
+// Sobel returns the gradient magnitude of img's luma by the Sobel
+// operator, with strong edges bright on black.
+func Sobel(img image.Image) *image.Gray {
+	g := Grayscale(img)
+	w, h := g.Rect.Dx(), g.Rect.Dy()
+	plane := make([]float64, len(g.Pix))
+	for i, v := range g.Pix {
+		plane[i] = float64(v)
+	}
+	mag, _ := sobel(plane, w, h)
+	dst := image.NewGray(g.Rect)
+	for i, m := range mag {
+		dst.Pix[i] = uint8(math.Min(255, m/4+0.5))
+	}
+	return dst
+}
+
+// sobel returns the gradient magnitude and direction of each sample of
+// a w×h plane, clamping at the edges.
+func sobel(p []float64, w, h int) (mag, dir []float64) {
+	mag, dir = make([]float64, len(p)), make([]float64, len(p))
+	at := func(x, y int) float64 { return p[clampInt(y, 0, h-1)*w+clampInt(x, 0, w-1)] }
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+			mag[y*w+x], dir[y*w+x] = math.Hypot(gx, gy), math.Atan2(gy, gx)
+		}
+	}
+	return mag, dir
+}
+
+// Canny returns the edges of img found by the Canny detector as white
+// one-pixel lines on black. Low and high are the hysteresis thresholds
+// as fractions of the strongest gradient: edges start at gradients
+// above high and continue through those above low.
+func Canny(img image.Image, low, high float64) *image.Gray {
+	g := Grayscale(img)
+	w, h := g.Rect.Dx(), g.Rect.Dy()
+	plane := make([]float64, len(g.Pix))
+	for i, v := range g.Pix {
+		plane[i] = float64(v)
+	}
+	mag, dir := sobel(blur(plane, w, h, gaussianWindow(1.4, 2)), w, h)
+
+	// Keep only local maxima across the gradient direction.
+	thin := make([]float64, len(mag))
+	var max float64
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			a := math.Mod(dir[i]*180/math.Pi+180, 180)
+			var d int
+			switch {
+			case a < 22.5 || a >= 157.5:
+				d = 1
+			case a < 67.5:
+				d = w + 1
+			case a < 112.5:
+				d = w
+			default:
+				d = w - 1
+			}
+			if mag[i] >= mag[i-d] && mag[i] >= mag[i+d] {
+				thin[i] = mag[i]
+				max = math.Max(max, mag[i])
+			}
+		}
+	}
+
+	dst := image.NewGray(g.Rect)
+	lo, hi := low*max, high*max
+	var stack []int
+	for i, m := range thin {
+		if m > hi && dst.Pix[i] == 0 {
+			dst.Pix[i] = 0xff
+			stack = append(stack, i)
+		}
+		for len(stack) > 0 {
+			j := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := j%w, j/w
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || ny < 0 || nx >= w || ny >= h {
+						continue
+					}
+					if k := ny*w + nx; dst.Pix[k] == 0 && thin[k] > lo {
+						dst.Pix[k] = 0xff
+						stack = append(stack, k)
+					}
+				}
+			}
+		}
+	}
+	return dst
+}
+
+// EdgeDetector selects the edge detection stage of Options.
+type EdgeDetector int
+
+const (
+	EdgesNone  EdgeDetector = iota
+	EdgesSobel              // gradient magnitude
+	EdgesCanny              // thin edges, hysteresis thresholds 0.1 and 0.3
+)
+
+// filter applies the posterize, edge detection and threshold options
+// to img.
+func (opts Options) filter(img image.Image) image.Image {
+	if opts.Posterize > 0 {
+		img = Posterize(img, opts.Posterize)
+	}
+	switch opts.Edges {
+	case EdgesSobel:
+		img = Sobel(img)
+	case EdgesCanny:
+		img = Canny(img, 0.1, 0.3)
+	}
+	switch {
+	case opts.AutoThreshold:
+		img = Threshold(img, Otsu(img))
+	case opts.Threshold > 0:
+		img = Threshold(img, opts.Threshold)
+	}
+	return img
+}
@@ -0,0 +1,326 @@
+// Package fits decodes the primary image of FITS files with a display
+// stretch. Importing the package registers the "fits" format with
+// image.Decode, which applies a linear stretch to the first plane.
+package fits
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Stretch maps normalized data values to display brightness.
+type Stretch int
+
+const (
+	Linear Stretch = iota
+	Log            // log(1 + a·x) / log(1 + a), with a = 1000
+	Asinh          // asinh(x / β) / asinh(1 / β), with β = 0.1
+	Sqrt
+)
+
+const (
+	logScale   = 1000
+	asinhSoft  = 0.1
+	blockSize  = 2880
+	cardSize   = 80
+	maxHeaders = 1 << 12 // header blocks
+	maxPixels  = 1 << 27 // per plane, each decoded to 8 bytes
+)
+
+// Options configures decoding.
+type Options struct {
+	Stretch Stretch
+
+	// Min and Max are the data values mapped to black and white. When
+	// they are equal, each channel's range of finite values is used.
+	Min, Max float64
+
+	// Channels lists the planes (NAXIS3 indices) used for red, green
+	// and blue. With fewer than three entries the first listed plane,
+	// or plane 0, is decoded as grayscale.
+	Channels []int
+}
+
+func init() {
+	image.RegisterFormat("fits", "SIMPLE  =", func(r io.Reader) (image.Image, error) {
+		return Decode(r, nil)
+	}, DecodeConfig)
+}
+
+// header holds the keywords of a FITS header.
+type header map[string]string
+
+func (h header) int(key string, def int) int {
+	if v, err := strconv.Atoi(h[key]); err == nil {
+		return v
+	}
+	return def
+}
+
+func (h header) float(key string, def float64) float64 {
+	// FITS allows D as the exponent marker.
+	if v, err := strconv.ParseFloat(strings.Replace(h[key], "D", "E", 1), 64); err == nil {
+		return v
+	}
+	return def
+}
+
+// readHeader reads the header blocks of the primary HDU.
+func readHeader(r io.Reader) (header, error) {
+	h := make(header)
+	block := make([]byte, blockSize)
+	for n := 0; n < maxHeaders; n++ {
+		if _, err := io.ReadFull(r, block); err != nil {
+			return nil, err
+		}
+		for i := 0; i < blockSize; i += cardSize {
+			card := string(block[i : i+cardSize])
+			key := strings.TrimSpace(card[:8])
+			if key == "END" {
+				if h["SIMPLE"] != "T" {
+					return nil, errors.New("fits: not a simple fits file")
+				}
+				return h, nil
+			}
+			if card[8:10] != "= " {
+				continue // COMMENT, HISTORY and blank cards
+			}
+			h[key] = cardValue(card[10:])
+		}
+	}
+	return nil, errors.New("fits: header too long")
+}
+
+// cardValue returns a card value without its comment or string quotes.
+func cardValue(v string) string {
+	v = strings.TrimSpace(v)
+	if strings.HasPrefix(v, "'") {
+		if end := strings.Index(v[1:], "'"); end >= 0 {
+			return strings.TrimRight(v[1:end+1], " ")
+		}
+		return v[1:]
+	}
+	if i := strings.IndexByte(v, '/'); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v)
+}
+
+type layout struct {
+	bitpix        int
+	width, height int
+	planes        int
+	bscale, bzero float64
+	blank         int64
+	hasBlank      bool
+}
+
+func (h header) layout() (*layout, error) {
+	l := &layout{
+		bitpix: h.int("BITPIX", 0),
+		width:  h.int("NAXIS1", 0),
+		height: h.int("NAXIS2", 1),
+		planes: h.int("NAXIS3", 1),
+		bscale: h.float("BSCALE", 1),
+		bzero:  h.float("BZERO", 0),
+	}
+	switch l.bitpix {
+	case 8, 16, 32, 64, -32, -64:
+	default:
+		return nil, fmt.Errorf("fits: invalid BITPIX %d", l.bitpix)
+	}
+	if naxis := h.int("NAXIS", 0); naxis < 1 || naxis > 3 {
+		return nil, fmt.Errorf("fits: unsupported NAXIS %d", naxis)
+	}
+	if l.width <= 0 || l.height <= 0 || l.planes <= 0 {
+		return nil, errors.New("fits: invalid dimensions")
+	}
+	if l.width > maxPixels || l.height > maxPixels/l.width {
+		return nil, fmt.Errorf("fits: %dx%d image is too large", l.width, l.height)
+	}
+	if _, ok := h["BLANK"]; ok {
+		b, err := strconv.ParseInt(h["BLANK"], 10, 64)
+		l.blank, l.hasBlank = b, err == nil
+	}
+	return l, nil
+}
+
+// DecodeConfig returns the dimensions of the primary image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	h, err := readHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	l, err := h.layout()
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.Gray16Model, Width: l.width, Height: l.height}, nil
+}
+
+// Decode decodes the primary image as *image.Gray16, or *image.RGBA64
+// when three channels are mapped. FITS rows run bottom to top; the
+// image is flipped so that it displays upright.
+func Decode(r io.Reader, opts *Options) (image.Image, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	br := bufio.NewReader(r)
+	h, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	l, err := h.layout()
+	if err != nil {
+		return nil, err
+	}
+
+	channels := opts.Channels
+	if len(channels) < 3 {
+		if len(channels) == 0 {
+			channels = []int{0}
+		}
+		channels = channels[:1]
+	} else {
+		channels = channels[:3]
+	}
+	last := 0
+	for _, c := range channels {
+		if c < 0 || c >= l.planes {
+			return nil, fmt.Errorf("fits: plane %d out of range", c)
+		}
+		if c > last {
+			last = c
+		}
+	}
+
+	// Planes are stored one after another; read up to the last needed.
+	planes := make(map[int][]float64)
+	for p := 0; p <= last; p++ {
+		data, err := l.readPlane(br)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range channels {
+			if c == p {
+				planes[p] = data
+			}
+		}
+	}
+
+	rect := image.Rect(0, 0, l.width, l.height)
+	values := make([][]uint16, len(channels))
+	for i, c := range channels {
+		values[i] = stretch(planes[c], opts)
+	}
+	if len(channels) == 1 {
+		img := image.NewGray16(rect)
+		for y := 0; y < l.height; y++ {
+			row := values[0][(l.height-1-y)*l.width:]
+			for x := 0; x < l.width; x++ {
+				img.SetGray16(x, y, color.Gray16{row[x]})
+			}
+		}
+		return img, nil
+	}
+	img := image.NewRGBA64(rect)
+	for y := 0; y < l.height; y++ {
+		i := (l.height - 1 - y) * l.width
+		for x := 0; x < l.width; x++ {
+			img.SetRGBA64(x, y, color.RGBA64{values[0][i+x], values[1][i+x], values[2][i+x], 0xffff})
+		}
+	}
+	return img, nil
+}
+
+// readPlane reads one plane as physical values; blank pixels are NaN.
+func (l *layout) readPlane(r io.Reader) ([]float64, error) {
+	n := l.width * l.height
+	size := l.bitpix / 8
+	if size < 0 {
+		size = -size
+	}
+	// Read before allocating for the whole plane, so that a header
+	// claiming more data than the file holds fails cheaply.
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(n*size)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	raw := buf.Bytes()
+	be := binary.BigEndian
+	out := make([]float64, n)
+	for i := range out {
+		b := raw[i*size:]
+		var v float64
+		var iv int64
+		switch l.bitpix {
+		case 8:
+			iv = int64(b[0])
+		case 16:
+			iv = int64(int16(be.Uint16(b)))
+		case 32:
+			iv = int64(int32(be.Uint32(b)))
+		case 64:
+			iv = int64(be.Uint64(b))
+		case -32:
+			v = float64(math.Float32frombits(be.Uint32(b)))
+		case -64:
+			v = math.Float64frombits(be.Uint64(b))
+		}
+		if l.bitpix > 0 {
+			if l.hasBlank && iv == l.blank {
+				out[i] = math.NaN()
+				continue
+			}
+			v = float64(iv)
+		}
+		out[i] = v*l.bscale + l.bzero
+	}
+	return out, nil
+}
+
+// stretch maps physical values to 16-bit display values.
+func stretch(data []float64, opts *Options) []uint16 {
+	lo, hi := opts.Min, opts.Max
+	if lo == hi {
+		lo, hi = math.Inf(1), math.Inf(-1)
+		for _, v := range data {
+			if !math.IsNaN(v) && !math.IsInf(v, 0) {
+				lo, hi = math.Min(lo, v), math.Max(hi, v)
+			}
+		}
+	}
+	out := make([]uint16, len(data))
+	if !(hi > lo) {
+		return out
+	}
+	for i, v := range data {
+		if math.IsNaN(v) {
+			continue
+		}
+		t := (v - lo) / (hi - lo)
+		t = math.Max(0, math.Min(1, t))
+		switch opts.Stretch {
+		case Log:
+			t = math.Log1p(logScale*t) / math.Log1p(logScale)
+		case Asinh:
+			t = math.Asinh(t/asinhSoft) / math.Asinh(1/asinhSoft)
+		case Sqrt:
+			t = math.Sqrt(t)
+		}
+		out[i] = uint16(math.Round(t * 0xffff))
+	}
+	return out
+}
@@ -0,0 +1,128 @@
+package fits
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// testHeader returns header blocks holding the given cards and END.
+func testHeader(cards ...string) []byte {
+	var b strings.Builder
+	for _, c := range append(cards, "END") {
+		fmt.Fprintf(&b, "%-80s", c)
+	}
+	for b.Len()%blockSize != 0 {
+		b.WriteByte(' ')
+	}
+	return []byte(b.String())
+}
+
+func card(key string, value any) string {
+	return fmt.Sprintf("%-8s= %20v", key, value)
+}
+
+// testFile returns a FITS file with a 16-bit image of the given values,
+// stored bottom row first.
+func testFile(width, height int, values ...int16) []byte {
+	data := testHeader(card("SIMPLE", "T"), card("BITPIX", 16), card("NAXIS", 2),
+		card("NAXIS1", width), card("NAXIS2", height))
+	for _, v := range values {
+		data = binary.BigEndian.AppendUint16(data, uint16(v))
+	}
+	for len(data)%blockSize != 0 {
+		data = append(data, 0)
+	}
+	return data
+}
+
+func TestDecode(t *testing.T) {
+	data := testFile(3, 2, -100, 0, 100, 200, 300, 400)
+	img, err := Decode(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, ok := img.(*image.Gray16)
+	if !ok {
+		t.Fatalf("decoded %T, want *image.Gray16", img)
+	}
+	if g.Rect != image.Rect(0, 0, 3, 2) {
+		t.Fatalf("bounds %v, want 3x2", g.Rect)
+	}
+	// The first stored row is the bottom one, and the range of values
+	// is stretched to black through white.
+	for _, tc := range []struct {
+		x, y int
+		want uint16
+	}{
+		{0, 1, 0},
+		{2, 0, 0xffff},
+		{1, 1, 0x3333},
+	} {
+		if got := g.Gray16At(tc.x, tc.y); got != (color.Gray16{tc.want}) {
+			t.Errorf("pixel (%d, %d) = %#04x, want %#04x", tc.x, tc.y, got.Y, tc.want)
+		}
+	}
+
+	cfg, err := DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 3 || cfg.Height != 2 {
+		t.Errorf("DecodeConfig gave %dx%d, want 3x2", cfg.Width, cfg.Height)
+	}
+}
+
+// malformed are files with broken or hostile headers.
+var malformed = []struct {
+	name string
+	data []byte
+}{
+	{"empty", nil},
+	{"short header", testFile(1, 1, 0)[:1000]},
+	{"no END", bytes.Repeat([]byte(fmt.Sprintf("%-80s", "COMMENT")), 36)},
+	{"not simple", testHeader(card("SIMPLE", "F"), card("BITPIX", 8), card("NAXIS", 1), card("NAXIS1", 1))},
+	{"invalid BITPIX", testHeader(card("SIMPLE", "T"), card("BITPIX", 12), card("NAXIS", 1), card("NAXIS1", 1))},
+	{"NAXIS 0", testHeader(card("SIMPLE", "T"), card("BITPIX", 8), card("NAXIS", 0))},
+	{"NAXIS 4", testHeader(card("SIMPLE", "T"), card("BITPIX", 8), card("NAXIS", 4), card("NAXIS1", 1))},
+	{"zero width", testHeader(card("SIMPLE", "T"), card("BITPIX", 8), card("NAXIS", 1), card("NAXIS1", 0))},
+	{"negative height", testHeader(card("SIMPLE", "T"), card("BITPIX", 8), card("NAXIS", 2), card("NAXIS1", 1), card("NAXIS2", -1))},
+	{"overflowing size", testHeader(card("SIMPLE", "T"), card("BITPIX", -64), card("NAXIS", 2),
+		card("NAXIS1", 1<<32), card("NAXIS2", 1<<32))},
+	{"huge image, no data", testHeader(card("SIMPLE", "T"), card("BITPIX", -64), card("NAXIS", 2),
+		card("NAXIS1", 1<<13), card("NAXIS2", 1<<13))},
+	{"short data", testFile(3, 2, 1, 2, 3)[:blockSize+5]},
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	for _, tc := range malformed {
+		if _, err := Decode(bytes.NewReader(tc.data), nil); err == nil {
+			t.Errorf("%s: decoded without error", tc.name)
+		}
+	}
+}
+
+func TestDecodePlaneOutOfRange(t *testing.T) {
+	data := testFile(1, 1, 0)
+	if _, err := Decode(bytes.NewReader(data), &Options{Channels: []int{1}}); err == nil {
+		t.Error("plane 1 of a single-plane image decoded without error")
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add(testFile(3, 2, -100, 0, 100, 200, 300, 400))
+	for _, tc := range malformed {
+		f.Add(tc.data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cfg, err := DecodeConfig(bytes.NewReader(data))
+		img, err2 := Decode(bytes.NewReader(data), nil)
+		if err == nil && err2 == nil && (img.Bounds().Dx() != cfg.Width || img.Bounds().Dy() != cfg.Height) {
+			t.Errorf("DecodeConfig gave %dx%d, Decode %v", cfg.Width, cfg.Height, img.Bounds())
+		}
+	})
+}
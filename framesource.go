@@ -0,0 +1,71 @@
+package convert
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"time"
+)
+
+// FrameSource yields the frames of a video or other image sequence.
+// Building with the ffmpeg tag adds FFmpegSource, which reads any video
+// ffmpeg can.
+type FrameSource interface {
+	// Next returns the next frame and its presentation time from the
+	// start, or io.EOF after the last frame.
+	Next() (image.Image, time.Duration, error)
+	Close() error
+}
+
+// ReadAnimation reads up to max frames from src, or all of them if max
+// is zero, into an animation that plays once. Delays follow the
+// frames' presentation times; the last frame repeats the previous
+// delay.
+func ReadAnimation(src FrameSource, max int) (*Animation, error) {
+	return ReadAnimationArena(src, max, nil)
+}
+
+// ReadAnimationArena is like ReadAnimation but allocates the frames from
+// arena, if it is not nil, so that long sequences can spill to disk.
+// The animation must not be used after the arena is released.
+func ReadAnimationArena(src FrameSource, max int, arena *Arena) (*Animation, error) {
+	a := &Animation{LoopCount: -1}
+	var times []time.Duration
+	for max <= 0 || len(a.Frames) < max {
+		img, t, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		b := img.Bounds()
+		if len(a.Frames) > 0 && (b.Dx() != a.Frames[0].Rect.Dx() || b.Dy() != a.Frames[0].Rect.Dy()) {
+			return nil, errors.New("frame size changed")
+		}
+		r := image.Rect(0, 0, b.Dx(), b.Dy())
+		var f *image.RGBA
+		if arena != nil {
+			if f, err = arena.NewRGBA(r); err != nil {
+				return nil, err
+			}
+		} else {
+			f = image.NewRGBA(r)
+		}
+		draw.Draw(f, f.Rect, img, b.Min, draw.Src)
+		a.Frames = append(a.Frames, f)
+		times = append(times, t)
+	}
+	if len(a.Frames) == 0 {
+		return nil, errors.New("no frames")
+	}
+	a.Delays = make([]int, len(a.Frames))
+	for i := 1; i < len(times); i++ {
+		a.Delays[i-1] = int((times[i] - times[i-1] + 5*time.Millisecond) / (10 * time.Millisecond))
+	}
+	if n := len(times); n > 1 {
+		a.Delays[n-1] = a.Delays[n-2]
+	}
+	return a, nil
+}
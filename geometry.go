@@ -0,0 +1,174 @@
+package convert
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Geometry is a resize in the form of an ImageMagick geometry string,
+// as parsed by ParseGeometry.
+type Geometry struct {
+	Width, Height float64 // target size, in pixels or percent; 0 if not given
+	Percent       bool    // Width and Height are percentages of the image size
+	Area          float64 // scale to at most this many pixels, instead of Width and Height
+
+	Exact       bool // "!": resize to Width x Height, ignoring the aspect ratio
+	Fill        bool // "^": cover Width x Height rather than fit within it
+	OnlyShrink  bool // ">": leave images that would be enlarged unchanged
+	OnlyEnlarge bool // "<": leave images that would be shrunk unchanged
+}
+
+// ParseGeometry parses an ImageMagick geometry string such as
+// "800x600", "800x600>", "300x300^", "320x", "x240", "50%", "50x25%",
+// "640x480!" or "100000@". Offsets, which ImageMagick ignores when
+// resizing, are rejected.
+func ParseGeometry(s string) (Geometry, error) {
+	var g Geometry
+	invalid := fmt.Errorf("invalid geometry %q", s)
+	var area bool
+	dims := strings.Map(func(r rune) rune {
+		switch r {
+		case '%':
+			g.Percent = true
+		case '@':
+			area = true
+		case '!':
+			g.Exact = true
+		case '^':
+			g.Fill = true
+		case '>':
+			g.OnlyShrink = true
+		case '<':
+			g.OnlyEnlarge = true
+		case ' ':
+		default:
+			return r
+		}
+		return -1
+	}, s)
+	if strings.ContainsAny(dims, "+-") {
+		return Geometry{}, fmt.Errorf("geometry %q has an offset, which does not apply to resizing", s)
+	}
+	number := func(s string) (float64, bool) {
+		if s == "" {
+			return 0, true
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		return v, err == nil && v >= 0 && !math.IsInf(v, 0) && (g.Percent || v == math.Trunc(v))
+	}
+
+	if area {
+		v, ok := number(dims)
+		if !ok || v < 1 || g.Percent || g.Exact || g.Fill {
+			return Geometry{}, invalid
+		}
+		g.Area = v
+		return g, nil
+	}
+	ws, hs := dims, ""
+	if i := strings.IndexAny(dims, "xX"); i >= 0 {
+		ws, hs = dims[:i], dims[i+1:]
+	}
+	var ok1, ok2 bool
+	g.Width, ok1 = number(ws)
+	g.Height, ok2 = number(hs)
+	switch {
+	case !ok1 || !ok2, g.Width == 0 && g.Height == 0, g.OnlyShrink && g.OnlyEnlarge:
+		return Geometry{}, invalid
+	case g.Percent && g.Height == 0:
+		g.Height = g.Width
+	case g.Percent && g.Width == 0:
+		g.Width = g.Height
+	}
+	if (g.Exact || g.Fill) && (g.Width == 0 || g.Height == 0) {
+		return Geometry{}, invalid
+	}
+	return g, nil
+}
+
+// String returns g in the syntax of ParseGeometry.
+func (g Geometry) String() string {
+	num := func(v float64) string {
+		if v == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	var b strings.Builder
+	switch {
+	case g.Area > 0:
+		b.WriteString(num(g.Area) + "@")
+	case g.Percent && g.Width == g.Height:
+		b.WriteString(num(g.Width) + "%")
+	default:
+		b.WriteString(num(g.Width))
+		if g.Height > 0 {
+			b.WriteString("x" + num(g.Height))
+		} else if !g.Percent {
+			b.WriteString("x")
+		}
+		if g.Percent {
+			b.WriteString("%")
+		}
+	}
+	for _, f := range []struct {
+		set bool
+		c   string
+	}{{g.Exact, "!"}, {g.Fill, "^"}, {g.OnlyShrink, ">"}, {g.OnlyEnlarge, "<"}} {
+		if f.set {
+			b.WriteString(f.c)
+		}
+	}
+	return b.String()
+}
+
+// Size returns the size g resizes a w x h image to. The zero Geometry
+// leaves it unchanged.
+func (g Geometry) Size(w, h int) (int, int) {
+	if w <= 0 || h <= 0 || g == (Geometry{}) {
+		return w, h
+	}
+	fw, fh := float64(w), float64(h)
+	sx, sy := 1.0, 1.0
+	switch {
+	case g.Area > 0:
+		sx = math.Sqrt(g.Area / (fw * fh))
+		sy = sx
+	case g.Percent:
+		sx, sy = g.Width/100, g.Height/100
+	case g.Exact:
+		sx, sy = g.Width/fw, g.Height/fh
+	case g.Width == 0:
+		sx = g.Height / fh
+		sy = sx
+	case g.Height == 0:
+		sx = g.Width / fw
+		sy = sx
+	case g.Fill:
+		sx = math.Max(g.Width/fw, g.Height/fh)
+		sy = sx
+	default:
+		sx = math.Min(g.Width/fw, g.Height/fh)
+		sy = sx
+	}
+	round := math.Round
+	if g.Area > 0 {
+		round = math.Floor
+	}
+	nw, nh := max1(int(round(fw*sx))), max1(int(round(fh*sy)))
+	if g.OnlyShrink && nw >= w && nh >= h || g.OnlyEnlarge && nw <= w && nh <= h {
+		return w, h
+	}
+	return nw, nh
+}
+
+// ResizeGeometry scales an image as the geometry g says, using
+// Catmull-Rom resampling. Images g leaves as they are are returned
+// unchanged.
+func ResizeGeometry(img image.Image, g Geometry) image.Image {
+	w, h := g.Size(img.Bounds().Dx(), img.Bounds().Dy())
+	return Resize(img, w, h)
+}
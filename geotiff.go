@@ -0,0 +1,83 @@
+package convert
+
+import (
+	"errors"
+	"io"
+
+	"github.com/imgutils-org/imgutils-convert/internal/tiffio"
+)
+
+// ErrNoGeoTIFF is returned when a TIFF has no georeferencing tags.
+var ErrNoGeoTIFF = errors.New("no geotiff tags")
+
+// GeoTIFF holds the georeferencing tags of a GeoTIFF. Values are kept
+// as stored; GeoKeyDirectory entries are not interpreted.
+type GeoTIFF struct {
+	ModelPixelScale []float64 // 33550, scale (x, y, z)
+	ModelTiepoint   []float64 // 33922, (i, j, k, x, y, z) tuples
+	ModelTransform  []float64 // 34264, 4x4 row-major matrix
+	GeoKeyDirectory []uint16  // 34735
+	GeoDoubleParams []float64 // 34736
+	GeoASCIIParams  string    // 34737
+	GDALMetadata    string    // 42112
+	GDALNoData      string    // 42113
+}
+
+// ReadGeoTIFF returns the georeferencing tags of the first image of a
+// TIFF, or ErrNoGeoTIFF.
+func ReadGeoTIFF(r io.Reader) (*GeoTIFF, error) {
+	f, err := openTIFF(r)
+	if err != nil {
+		return nil, err
+	}
+	g := geoTIFF(f.IFDs[0])
+	if g == nil {
+		return nil, ErrNoGeoTIFF
+	}
+	return g, nil
+}
+
+func geoTIFF(d *tiffio.IFD) *GeoTIFF {
+	g := &GeoTIFF{
+		ModelPixelScale: d.Floats(tiffio.TagModelPixelScale),
+		ModelTiepoint:   d.Floats(tiffio.TagModelTiepoint),
+		ModelTransform:  d.Floats(tiffio.TagModelTransform),
+		GeoDoubleParams: d.Floats(tiffio.TagGeoDoubleParams),
+		GeoASCIIParams:  d.ASCII(tiffio.TagGeoASCIIParams),
+		GDALMetadata:    d.ASCII(tiffio.TagGDALMetadata),
+		GDALNoData:      d.ASCII(tiffio.TagGDALNoData),
+	}
+	for _, v := range d.Uints(tiffio.TagGeoKeyDirectory) {
+		g.GeoKeyDirectory = append(g.GeoKeyDirectory, uint16(v))
+	}
+	if g.ModelPixelScale == nil && g.ModelTiepoint == nil && g.ModelTransform == nil && g.GeoKeyDirectory == nil {
+		return nil
+	}
+	return g
+}
+
+// entries returns the tags to write for g.
+func (g *GeoTIFF) entries() map[uint16]tiffio.Entry {
+	m := make(map[uint16]tiffio.Entry)
+	doubles := func(tag uint16, v []float64) {
+		if len(v) > 0 {
+			m[tag] = tiffio.Doubles(v...)
+		}
+	}
+	ascii := func(tag uint16, s string) {
+		if s != "" {
+			m[tag] = tiffio.ASCII(s)
+		}
+	}
+	doubles(tiffio.TagModelPixelScale, g.ModelPixelScale)
+	doubles(tiffio.TagModelTiepoint, g.ModelTiepoint)
+	doubles(tiffio.TagModelTransform, g.ModelTransform)
+	doubles(tiffio.TagGeoDoubleParams, g.GeoDoubleParams)
+	ascii(tiffio.TagGeoASCIIParams, g.GeoASCIIParams)
+	ascii(tiffio.TagGDALMetadata, g.GDALMetadata)
+	ascii(tiffio.TagGDALNoData, g.GDALNoData)
+	if len(g.GeoKeyDirectory) > 0 {
+		m[tiffio.TagGeoKeyDirectory] = tiffio.Shorts(g.GeoKeyDirectory...)
+	}
+	return m
+}
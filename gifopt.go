@@ -0,0 +1,129 @@
+package convert
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+)
+
+// OptimizeGIF rewrites an animated GIF with OptimizeFrames set, which
+// typically makes animations with static backgrounds much smaller.
+func OptimizeGIF(r io.Reader, w io.Writer, opts Options) error {
+	opts.OptimizeFrames = true
+	return ConvertAnimation(r, w, opts)
+}
+
+// gifFrame is a frame to write: the region it paints, with transparent
+// pixels leaving the screen as it was.
+type gifFrame struct {
+	img      *image.RGBA
+	delay    int
+	disposal byte
+}
+
+// optimizeFrames merges identical consecutive frames, adding up their
+// delays, and reduces each frame to the bounds of what changed from the
+// screen the decoder will show, with unchanged pixels transparent.
+// Where a frame needs pixels to become transparent, the previous frame
+// is extended to cover them and disposed to the background.
+func optimizeFrames(a *Animation) (image.Rectangle, []*gifFrame) {
+	screen := a.Frames[0].Rect
+	var out []*gifFrame
+	var want []*image.RGBA // composited frame each output frame shows
+	for i, f := range a.Frames {
+		delay := 0
+		if i < len(a.Delays) {
+			delay = a.Delays[i]
+		}
+		if n := len(want); n > 0 && bytes.Equal(want[n-1].Pix, f.Pix) {
+			out[n-1].delay += delay
+			continue
+		}
+		want = append(want, f)
+		out = append(out, &gifFrame{delay: delay})
+	}
+
+	canvas := image.NewRGBA(screen) // what the screen holds before each frame
+	for i, f := range want {
+		if clear := clearRect(canvas, f); !clear.Empty() {
+			prev := out[i-1]
+			r := prev.img.Rect.Union(clear)
+			grown := image.NewRGBA(r)
+			draw.Draw(grown, prev.img.Rect, prev.img, prev.img.Rect.Min, draw.Src)
+			prev.img, prev.disposal = grown, gif.DisposalBackground
+			draw.Draw(canvas, r, image.Transparent, image.Point{}, draw.Src)
+		}
+
+		r := diffRect(canvas, f)
+		if r.Empty() {
+			r = image.Rect(0, 0, 1, 1)
+		}
+		img := image.NewRGBA(r)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				o := f.PixOffset(x, y)
+				if !bytes.Equal(f.Pix[o:o+4], canvas.Pix[o:o+4]) {
+					copy(img.Pix[img.PixOffset(x, y):], f.Pix[o:o+4])
+				}
+			}
+		}
+		out[i].img = img
+		copy(canvas.Pix, f.Pix)
+	}
+	return screen, out
+}
+
+// clearRect returns the bounds of the pixels that are transparent in f
+// but not on the screen, which painting over the screen cannot clear.
+func clearRect(screen, f *image.RGBA) image.Rectangle {
+	var r image.Rectangle
+	for y := f.Rect.Min.Y; y < f.Rect.Max.Y; y++ {
+		for x := f.Rect.Min.X; x < f.Rect.Max.X; x++ {
+			o := f.PixOffset(x, y)
+			if f.Pix[o+3] == 0 && screen.Pix[o+3] != 0 {
+				r = r.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return r
+}
+
+// diffRect returns the bounds of the pixels that differ between the
+// screen and f.
+func diffRect(screen, f *image.RGBA) image.Rectangle {
+	var r image.Rectangle
+	for y := f.Rect.Min.Y; y < f.Rect.Max.Y; y++ {
+		row := f.PixOffset(f.Rect.Min.X, y)
+		if bytes.Equal(f.Pix[row:row+4*f.Rect.Dx()], screen.Pix[row:row+4*f.Rect.Dx()]) {
+			continue
+		}
+		for x := f.Rect.Min.X; x < f.Rect.Max.X; x++ {
+			o := f.PixOffset(x, y)
+			if !bytes.Equal(f.Pix[o:o+4], screen.Pix[o:o+4]) {
+				r = r.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return r
+}
+
+// encodeOptimizedGIF writes a with optimizeFrames applied.
+func encodeOptimizedGIF(w io.Writer, a *Animation, opts Options) error {
+	screen, frames := optimizeFrames(a)
+	g := &gif.GIF{
+		LoopCount: a.LoopCount,
+		Config:    image.Config{Width: screen.Dx(), Height: screen.Dy()},
+	}
+	global := opts.globalPalette(a, true)
+	if global != nil {
+		g.Config.ColorModel = global
+	}
+	for _, f := range frames {
+		g.Image = append(g.Image, opts.quantizeFrame(f.img, global))
+		g.Delay = append(g.Delay, f.delay)
+		g.Disposal = append(g.Disposal, f.disposal)
+	}
+	return gif.EncodeAll(w, g)
+}
@@ -1,3 +1,10 @@
 module github.com/imgutils-org/imgutils-convert
 
-go 1.16
+go 1.26.0
+
+require golang.org/x/image v0.46.0
+
+require (
+	golang.org/x/sys v0.48.0 // indirect
+	golang.org/x/text v0.42.0 // indirect
+)
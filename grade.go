@@ -0,0 +1,270 @@
+package convert
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/imgutils-org/imgutils-convert/pixels"
+)
+
+// LUT is a 3D colour lookup table with Size samples along each axis,
+// interpolated trilinearly. Data holds RGB outputs in the 0-1 range
+// with red varying fastest, then green, then blue.
+type LUT struct {
+	Size     int
+	Data     []float32
+	Min, Max [3]float32 // input domain, default 0-1
+}
+
+// NewLUT samples f, which maps RGB in the 0-1 range to RGB, into a
+// table of size samples per axis.
+func NewLUT(size int, f func(r, g, b float64) (float64, float64, float64)) *LUT {
+	l := &LUT{Size: size, Data: make([]float32, 0, 3*size*size*size), Max: [3]float32{1, 1, 1}}
+	s := float64(size - 1)
+	for b := 0; b < size; b++ {
+		for g := 0; g < size; g++ {
+			for r := 0; r < size; r++ {
+				or, og, ob := f(float64(r)/s, float64(g)/s, float64(b)/s)
+				l.Data = append(l.Data, float32(or), float32(og), float32(ob))
+			}
+		}
+	}
+	return l
+}
+
+// maxLUTSize bounds the samples per axis of a LUT, as the largest
+// .cube tables and level 16 Hald CLUTs have.
+const maxLUTSize = 256
+
+// check reports a table whose size and data do not match, which lookup
+// would index out of range.
+func (l *LUT) check() error {
+	if l.Size < 2 || l.Size > maxLUTSize {
+		return fmt.Errorf("LUT size %d is outside 2-%d", l.Size, maxLUTSize)
+	}
+	if n := 3 * l.Size * l.Size * l.Size; len(l.Data) != n {
+		return fmt.Errorf("LUT of size %d has %d values, not %d", l.Size, len(l.Data), n)
+	}
+	return nil
+}
+
+// Apply returns img with its colours mapped through the table,
+// keeping alpha. A malformed table, one that Validate rejects, leaves
+// img unchanged.
+func (l *LUT) Apply(img image.Image) image.Image {
+	if l.check() != nil {
+		return img
+	}
+	return pixels.MapPixels(img, func(x, y int, p pixels.Pixel[float32]) pixels.Pixel[float32] {
+		p.R, p.G, p.B = l.lookup(p.R, p.G, p.B)
+		return p
+	})
+}
+
+// lookup interpolates the table at an input colour.
+func (l *LUT) lookup(r, g, b float32) (float32, float32, float32) {
+	n := l.Size
+	var i [3]int
+	var f [3]float32
+	for c, v := range [3]float32{r, g, b} {
+		if d := l.Max[c] - l.Min[c]; d > 0 {
+			v = (v - l.Min[c]) / d
+		}
+		v *= float32(n - 1)
+		if v < 0 {
+			v = 0
+		}
+		if v > float32(n-1) {
+			v = float32(n - 1)
+		}
+		i[c] = int(v)
+		if i[c] == n-1 {
+			i[c] = n - 2
+		}
+		f[c] = v - float32(i[c])
+	}
+	var out [3]float32
+	for corner := 0; corner < 8; corner++ {
+		w := float32(1)
+		idx := 0
+		for c, stride := range [3]int{1, n, n * n} {
+			if corner>>uint(c)&1 == 1 {
+				w *= f[c]
+				idx += (i[c] + 1) * stride
+			} else {
+				w *= 1 - f[c]
+				idx += i[c] * stride
+			}
+		}
+		if w == 0 {
+			continue
+		}
+		for c := range out {
+			out[c] += w * l.Data[3*idx+c]
+		}
+	}
+	return out[0], out[1], out[2]
+}
+
+// ParseCube reads a 3D LUT in the Adobe/Resolve .cube format.
+func ParseCube(r io.Reader) (*LUT, error) {
+	l := &LUT{Max: [3]float32{1, 1, 1}}
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		f := strings.Fields(s.Text())
+		if len(f) == 0 || strings.HasPrefix(f[0], "#") {
+			continue
+		}
+		var err error
+		switch f[0] {
+		case "TITLE":
+		case "LUT_1D_SIZE":
+			return nil, errors.New("cube: 1D LUTs are not supported")
+		case "LUT_3D_SIZE":
+			if len(f) != 2 {
+				return nil, fmt.Errorf("cube: line %d: bad size", line)
+			}
+			if l.Size, err = strconv.Atoi(f[1]); err != nil || l.Size < 2 || l.Size > 256 {
+				return nil, fmt.Errorf("cube: line %d: bad size", line)
+			}
+		case "DOMAIN_MIN", "DOMAIN_MAX":
+			dom := &l.Min
+			if f[0] == "DOMAIN_MAX" {
+				dom = &l.Max
+			}
+			if len(f) != 4 {
+				return nil, fmt.Errorf("cube: line %d: bad domain", line)
+			}
+			for c := range dom {
+				if dom[c], err = parseFloat32(f[c+1]); err != nil {
+					return nil, fmt.Errorf("cube: line %d: %w", line, err)
+				}
+			}
+		case "LUT_3D_INPUT_RANGE":
+			if len(f) != 3 {
+				return nil, fmt.Errorf("cube: line %d: bad input range", line)
+			}
+			lo, err1 := parseFloat32(f[1])
+			hi, err2 := parseFloat32(f[2])
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("cube: line %d: bad input range", line)
+			}
+			l.Min, l.Max = [3]float32{lo, lo, lo}, [3]float32{hi, hi, hi}
+		default:
+			if len(f) != 3 || l.Size == 0 {
+				return nil, fmt.Errorf("cube: line %d: unexpected %q", line, f[0])
+			}
+			for _, v := range f {
+				x, err := parseFloat32(v)
+				if err != nil {
+					return nil, fmt.Errorf("cube: line %d: %w", line, err)
+				}
+				l.Data = append(l.Data, x)
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if l.Size == 0 || len(l.Data) != 3*l.Size*l.Size*l.Size {
+		return nil, errors.New("cube: wrong number of table entries")
+	}
+	return l, nil
+}
+
+func parseFloat32(s string) (float32, error) {
+	v, err := strconv.ParseFloat(s, 32)
+	return float32(v), err
+}
+
+// HaldCLUT reads a LUT from a Hald CLUT image: a square of level³
+// pixels per side holding a table of level² samples per axis, in
+// raster order with red varying fastest.
+func HaldCLUT(img image.Image) (*LUT, error) {
+	b := img.Bounds()
+	level := int(math.Round(math.Cbrt(float64(b.Dx()))))
+	if b.Dx() != b.Dy() || level < 2 || level*level*level != b.Dx() {
+		return nil, errors.New("hald: image is not a Hald CLUT")
+	}
+	l := &LUT{Size: level * level, Max: [3]float32{1, 1, 1}}
+	l.Data = make([]float32, 0, 3*b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			l.Data = append(l.Data, float32(c.R)/0xffff, float32(c.G)/0xffff, float32(c.B)/0xffff)
+		}
+	}
+	return l, nil
+}
+
+// Duotone returns a LUT that maps the luma of each colour onto a
+// gradient from shadow to highlight.
+func Duotone(shadow, highlight color.Color) *LUT {
+	s := color.NRGBA64Model.Convert(shadow).(color.NRGBA64)
+	h := color.NRGBA64Model.Convert(highlight).(color.NRGBA64)
+	lerp := func(a, b uint16, t float64) float64 {
+		return (float64(a) + (float64(b)-float64(a))*t) / 0xffff
+	}
+	return NewLUT(17, func(r, g, b float64) (float64, float64, float64) {
+		y := 0.299*r + 0.587*g + 0.114*b
+		return lerp(s.R, h.R, y), lerp(s.G, h.G, y), lerp(s.B, h.B, y)
+	})
+}
+
+// colorMatrix returns a LUT that multiplies colours by m.
+func colorMatrix(m [3][3]float64) *LUT {
+	return NewLUT(17, func(r, g, b float64) (float64, float64, float64) {
+		return m[0][0]*r + m[0][1]*g + m[0][2]*b,
+			m[1][0]*r + m[1][1]*g + m[1][2]*b,
+			m[2][0]*r + m[2][1]*g + m[2][2]*b
+	})
+}
+
+var luts = struct {
+	sync.RWMutex
+	m map[string]*LUT
+}{m: map[string]*LUT{
+	"sepia": colorMatrix([3][3]float64{{0.393, 0.769, 0.189}, {0.349, 0.686, 0.168}, {0.272, 0.534, 0.131}}),
+	"mono":  colorMatrix([3][3]float64{{0.299, 0.587, 0.114}, {0.299, 0.587, 0.114}, {0.299, 0.587, 0.114}}),
+	"warm":  colorMatrix([3][3]float64{{1.08, 0, 0}, {0, 1.02, 0}, {0, 0, 0.9}}),
+	"cool":  colorMatrix([3][3]float64{{0.9, 0, 0}, {0, 1.02, 0}, {0, 0, 1.08}}),
+}}
+
+// RegisterLUT makes a LUT available by name to LookupLUT, replacing any
+// LUT previously registered under that name, such as to name a brand
+// look loaded with ParseCube.
+func RegisterLUT(name string, l *LUT) {
+	luts.Lock()
+	luts.m[name] = l
+	luts.Unlock()
+}
+
+// LookupLUT returns the LUT registered under name. The built-in
+// filters are "sepia", "mono", "warm" and "cool".
+func LookupLUT(name string) (*LUT, bool) {
+	luts.RLock()
+	l, ok := luts.m[name]
+	luts.RUnlock()
+	return l, ok
+}
+
+// LUTNames returns the names of all registered LUTs.
+func LUTNames() []string {
+	luts.RLock()
+	names := make([]string, 0, len(luts.m))
+	for name := range luts.m {
+		names = append(names, name)
+	}
+	luts.RUnlock()
+	sort.Strings(names)
+	return names
+}
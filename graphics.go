@@ -0,0 +1,144 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// kittyChunk is the largest base64 payload of one Kitty graphics
+// escape sequence.
+const kittyChunk = 4096
+
+// encodeSixel writes img as a DEC Sixel sequence, quantized to a
+// palette like indexed PNG output. Mostly transparent pixels are left
+// unpainted.
+func encodeSixel(w io.Writer, img image.Image, opts Options) error {
+	p := indexed(img, opts).(*image.Paletted)
+	b := p.Bounds()
+	bw := bufio.NewWriter(w)
+
+	// P2 = 1 keeps unpainted pixels at the terminal background.
+	fmt.Fprintf(bw, "\x1bP0;1q\"1;1;%d;%d", b.Dx(), b.Dy())
+	opaque := make([]bool, len(p.Palette))
+	for i, c := range p.Palette {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		if opaque[i] = n.A >= 0x80; opaque[i] {
+			fmt.Fprintf(bw, "#%d;2;%d;%d;%d", i, percent(n.R), percent(n.G), percent(n.B))
+		}
+	}
+
+	masks := make([][]byte, len(p.Palette))
+	for y := b.Min.Y; y < b.Max.Y; y += 6 {
+		used := make([]bool, len(p.Palette))
+		for i := range masks {
+			masks[i] = masks[i][:0]
+		}
+		for r := 0; r < 6 && y+r < b.Max.Y; r++ {
+			row := p.Pix[(y+r-b.Min.Y)*p.Stride:]
+			for x := 0; x < b.Dx(); x++ {
+				i := row[x]
+				if !opaque[i] {
+					continue
+				}
+				if !used[i] {
+					used[i] = true
+					masks[i] = append(masks[i], make([]byte, b.Dx())...)
+				}
+				masks[i][x] |= 1 << r
+			}
+		}
+		first := true
+		for i, m := range masks {
+			if !used[i] {
+				continue
+			}
+			if !first {
+				bw.WriteByte('$')
+			}
+			first = false
+			fmt.Fprintf(bw, "#%d", i)
+			writeSixels(bw, m)
+		}
+		bw.WriteByte('-')
+	}
+	bw.WriteString("\x1b\\")
+	return bw.Flush()
+}
+
+// writeSixels writes one band of sixel masks, run-length encoding
+// repeats.
+func writeSixels(bw *bufio.Writer, m []byte) {
+	for x := 0; x < len(m); {
+		n := 1
+		for x+n < len(m) && m[x+n] == m[x] {
+			n++
+		}
+		c := '?' + m[x]
+		if n > 3 {
+			fmt.Fprintf(bw, "!%d%c", n, c)
+		} else {
+			for k := 0; k < n; k++ {
+				bw.WriteByte(c)
+			}
+		}
+		x += n
+	}
+}
+
+// percent scales an 8-bit sample to the 0-100 range of Sixel colors.
+func percent(v uint8) int {
+	return (int(v)*100 + 127) / 255
+}
+
+// encodeITerm2 writes img as a PNG in an iTerm2 inline image escape
+// sequence.
+func encodeITerm2(w io.Writer, img image.Image, opts Options) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	args := fmt.Sprintf("inline=1;size=%d", buf.Len())
+	if opts.Columns > 0 {
+		args += fmt.Sprintf(";width=%d", opts.Columns)
+	}
+	_, err := fmt.Fprintf(w, "\x1b]1337;File=%s:%s\a", args, base64.StdEncoding.EncodeToString(buf.Bytes()))
+	return err
+}
+
+// encodeKitty writes img as a PNG transmitted and displayed with the
+// Kitty graphics protocol, split into chunks as the protocol requires.
+func encodeKitty(w io.Writer, img image.Image, opts Options) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+	args := "a=T,f=100"
+	if opts.Columns > 0 {
+		args += fmt.Sprintf(",c=%d", opts.Columns)
+	}
+	bw := bufio.NewWriter(w)
+	for first := true; first || len(data) > 0; first = false {
+		chunk := data
+		if len(chunk) > kittyChunk {
+			chunk = chunk[:kittyChunk]
+		}
+		data = data[len(chunk):]
+		more := 0
+		if len(data) > 0 {
+			more = 1
+		}
+		if first {
+			fmt.Fprintf(bw, "\x1b_G%s,m=%d;%s\x1b\\", args, more, chunk)
+		} else {
+			fmt.Fprintf(bw, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,82 @@
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"runtime"
+)
+
+// HealthOptions configures HealthHandler.
+type HealthOptions struct {
+	QueueDepth func() int // optional, reports jobs waiting or in flight
+	MaxQueue   int        // queue depth at which the service is not ready, 0 for no limit
+	MaxHeap    uint64     // heap bytes in use at which the service is not ready, 0 for no limit
+}
+
+// Health is the status reported by HealthHandler.
+type Health struct {
+	Ready      bool              `json:"ready"`
+	Reason     string            `json:"reason,omitempty"`
+	Formats    []Format          `json:"formats"`
+	Backends   map[string]string `json:"backends,omitempty"` // optional codec backends: "ok" or why they are unavailable
+	QueueDepth int               `json:"queue_depth"`
+	HeapInuse  uint64            `json:"heap_inuse"`
+}
+
+// backends holds probes for codecs built in with build tags, keyed by
+// name, which report whether the backend can be used.
+var backends = map[string]func() error{}
+
+// CheckHealth reports the codecs available and whether the service is
+// within the queue and memory limits of opts.
+func CheckHealth(opts HealthOptions) Health {
+	h := Health{Ready: true, Formats: Formats()}
+	if len(backends) > 0 {
+		h.Backends = make(map[string]string, len(backends))
+		for name, probe := range backends {
+			h.Backends[name] = "ok"
+			if err := probe(); err != nil {
+				h.Backends[name] = err.Error()
+			}
+		}
+	}
+	if opts.QueueDepth != nil {
+		h.QueueDepth = opts.QueueDepth()
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	h.HeapInuse = ms.HeapInuse
+
+	switch {
+	case opts.MaxQueue > 0 && h.QueueDepth >= opts.MaxQueue:
+		h.Ready, h.Reason = false, fmt.Sprintf("queue depth %d", h.QueueDepth)
+	case opts.MaxHeap > 0 && h.HeapInuse >= opts.MaxHeap:
+		h.Ready, h.Reason = false, fmt.Sprintf("heap in use %d bytes", h.HeapInuse)
+	}
+	return h
+}
+
+// HealthHandler returns a handler serving CheckHealth as JSON at paths
+// ending in /healthz, which always succeed while the process is
+// serving, and /readyz, which fail with 503 Service Unavailable when
+// the service is not ready to take more work.
+func HealthHandler(opts HealthOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Base(r.URL.Path)
+		if name != "healthz" && name != "readyz" {
+			http.NotFound(w, r)
+			return
+		}
+		h := CheckHealth(opts)
+		status := http.StatusOK
+		if name == "readyz" && !h.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(h)
+	})
+}
@@ -0,0 +1,406 @@
+// Package iiif serves images through the IIIF Image API 3.0.
+//
+// Requests have the form
+//
+//	{prefix}/{identifier}/{region}/{size}/{rotation}/{quality}.{format}
+//	{prefix}/{identifier}/info.json
+//
+// Rotation is limited to multiples of 90 degrees, optionally mirrored.
+package iiif
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	convert "github.com/imgutils-org/imgutils-convert"
+)
+
+// Source opens images by identifier. worker.BlobSource implementations
+// satisfy it.
+type Source interface {
+	Open(ctx context.Context, id string) (io.ReadCloser, error)
+}
+
+type fsSource struct{ fsys fs.FS }
+
+func (s fsSource) Open(ctx context.Context, id string) (io.ReadCloser, error) {
+	return s.fsys.Open(id)
+}
+
+// FS returns a Source that opens identifiers as paths in fsys.
+func FS(fsys fs.FS) Source { return fsSource{fsys} }
+
+// Handler implements the IIIF Image API over a Source.
+type Handler struct {
+	Source  Source
+	Prefix  string // URL path prefix before the identifier, such as "/iiif"
+	BaseURL string // URL of Prefix used for ids in info.json, derived from the request if empty
+
+	MaxWidth  int // largest output width, default 4096
+	MaxHeight int // largest output height, default 4096
+	TileSize  int // tile size advertised in info.json, default 512
+
+	Options convert.Options // encoding options
+}
+
+const (
+	defaultMax      = 4096
+	defaultTileSize = 512
+	context3        = "http://iiif.io/api/image/3/context.json"
+)
+
+var formats = map[string]convert.Format{
+	"jpg": convert.JPEG,
+	"png": convert.PNG,
+	"gif": convert.GIF,
+	"tif": convert.TIFF,
+	"bmp": convert.BMP,
+}
+
+var contentTypes = map[convert.Format]string{
+	convert.JPEG: "image/jpeg",
+	convert.PNG:  "image/png",
+	convert.GIF:  "image/gif",
+	convert.TIFF: "image/tiff",
+	convert.BMP:  "image/bmp",
+}
+
+// httpError is an error with an HTTP status.
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string { return e.msg }
+
+func badRequest(msg string) error     { return &httpError{http.StatusBadRequest, msg} }
+func notImplemented(msg string) error { return &httpError{http.StatusNotImplemented, msg} }
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if err := h.serve(w, r); err != nil {
+		status := http.StatusInternalServerError
+		var he *httpError
+		switch {
+		case errors.As(err, &he):
+			status = he.status
+		case errors.Is(err, fs.ErrNotExist):
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+	}
+}
+
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return &httpError{http.StatusMethodNotAllowed, "method not allowed"}
+	}
+	p := strings.TrimPrefix(r.URL.EscapedPath(), h.Prefix)
+	parts := strings.Split(strings.TrimPrefix(p, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		// The base URI redirects to the image information.
+		http.Redirect(w, r, h.Prefix+"/"+parts[0]+"/info.json", http.StatusSeeOther)
+		return nil
+	case len(parts) == 2 && parts[1] == "info.json":
+		id, err := url.PathUnescape(parts[0])
+		if err != nil {
+			return badRequest("invalid identifier")
+		}
+		return h.info(w, r, id, parts[0])
+	case len(parts) == 5:
+		for i, s := range parts {
+			var err error
+			if parts[i], err = url.PathUnescape(s); err != nil {
+				return badRequest("invalid path segment")
+			}
+		}
+		return h.image(w, r, parts[0], parts[1:])
+	}
+	return &httpError{http.StatusNotFound, "not found"}
+}
+
+// open returns the source image with random access. The caller must
+// close the returned Closer.
+func (h *Handler) open(ctx context.Context, id string) (io.ReaderAt, io.Closer, image.Config, error) {
+	rc, err := h.Source.Open(ctx, id)
+	if err != nil {
+		return nil, nil, image.Config{}, err
+	}
+	ra, ok := rc.(io.ReaderAt)
+	if !ok {
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			rc.Close()
+			return nil, nil, image.Config{}, err
+		}
+		ra = bytes.NewReader(data)
+	}
+	cfg, _, err := image.DecodeConfig(io.NewSectionReader(ra, 0, math.MaxInt64))
+	if err != nil {
+		rc.Close()
+		return nil, nil, image.Config{}, &httpError{http.StatusUnsupportedMediaType, err.Error()}
+	}
+	return ra, rc, cfg, nil
+}
+
+func (h *Handler) maxSize() (int, int) {
+	mw, mh := h.MaxWidth, h.MaxHeight
+	if mw <= 0 {
+		mw = defaultMax
+	}
+	if mh <= 0 {
+		mh = defaultMax
+	}
+	return mw, mh
+}
+
+func (h *Handler) info(w http.ResponseWriter, r *http.Request, id, escaped string) error {
+	_, c, cfg, err := h.open(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	c.Close()
+	base := h.BaseURL
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host + h.Prefix
+	}
+	tile := h.TileSize
+	if tile <= 0 {
+		tile = defaultTileSize
+	}
+	var factors []int
+	for f := 1; f == 1 || cfg.Width/f >= tile || cfg.Height/f >= tile; f *= 2 {
+		factors = append(factors, f)
+	}
+	mw, mh := h.maxSize()
+
+	info := map[string]interface{}{
+		"@context":  context3,
+		"id":        strings.TrimSuffix(base, "/") + "/" + escaped,
+		"type":      "ImageService3",
+		"protocol":  "http://iiif.io/api/image",
+		"profile":   "level2",
+		"width":     cfg.Width,
+		"height":    cfg.Height,
+		"maxWidth":  mw,
+		"maxHeight": mh,
+		"tiles": []map[string]interface{}{
+			{"width": tile, "scaleFactors": factors},
+		},
+		"extraQualities": []string{"color", "gray", "bitonal"},
+		"extraFormats":   []string{"gif", "tif", "bmp"},
+		"extraFeatures":  []string{"mirroring", "rotationBy90s", "regionByPct", "sizeByPct", "sizeUpscaling"},
+	}
+	w.Header().Set("Content-Type", `application/ld+json;profile="`+context3+`"`)
+	return json.NewEncoder(w).Encode(info)
+}
+
+func (h *Handler) image(w http.ResponseWriter, r *http.Request, id string, params []string) error {
+	dot := strings.LastIndexByte(params[3], '.')
+	if dot < 0 {
+		return badRequest("missing format")
+	}
+	quality, ext := params[3][:dot], params[3][dot+1:]
+	format, ok := formats[ext]
+	if !ok {
+		return notImplemented("unsupported format " + ext)
+	}
+	switch quality {
+	case "default", "color", "gray", "bitonal":
+	default:
+		return badRequest("invalid quality " + quality)
+	}
+	mirror, degrees, err := parseRotation(params[2])
+	if err != nil {
+		return err
+	}
+
+	ra, c, cfg, err := h.open(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	region, err := parseRegion(params[0], cfg.Width, cfg.Height)
+	if err != nil {
+		return err
+	}
+	mw, mh := h.maxSize()
+	size, err := parseSize(params[1], region.Dx(), region.Dy(), mw, mh)
+	if err != nil {
+		return err
+	}
+
+	scale := math.Max(float64(size.X)/float64(region.Dx()), float64(size.Y)/float64(region.Dy()))
+	img, err := convert.DecodeRegion(io.NewSectionReader(ra, 0, math.MaxInt64), region, math.Min(scale, 1))
+	if err != nil {
+		return err
+	}
+	if b := img.Bounds(); b.Dx() != size.X || b.Dy() != size.Y {
+		img = convert.Resize(img, size.X, size.Y)
+	}
+	if mirror {
+		img = convert.Flip(img)
+	}
+	if img, err = convert.Rotate(img, degrees); err != nil {
+		return err
+	}
+	switch quality {
+	case "gray":
+		img = convert.Grayscale(img)
+	case "bitonal":
+		img = convert.Bilevel(img, h.Options.Dither)
+	}
+
+	var buf bytes.Buffer
+	if err := convert.Encode(&buf, img, format, h.Options); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", contentTypes[format])
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("Link", `<http://iiif.io/api/image/3/level2.json>;rel="profile"`)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// parseRegion parses full, square, x,y,w,h and pct:x,y,w,h regions and
+// clips them to the image.
+func parseRegion(s string, width, height int) (image.Rectangle, error) {
+	full := image.Rect(0, 0, width, height)
+	switch s {
+	case "full":
+		return full, nil
+	case "square":
+		n := width
+		if height < n {
+			n = height
+		}
+		x, y := (width-n)/2, (height-n)/2
+		return image.Rect(x, y, x+n, y+n), nil
+	}
+
+	pct := strings.HasPrefix(s, "pct:")
+	v, err := parseNumbers(strings.TrimPrefix(s, "pct:"), 4)
+	if err != nil || (!pct && (v[0] != math.Trunc(v[0]) || v[1] != math.Trunc(v[1]))) {
+		return image.Rectangle{}, badRequest("invalid region " + s)
+	}
+	if pct {
+		v[0], v[2] = v[0]*float64(width)/100, v[2]*float64(width)/100
+		v[1], v[3] = v[1]*float64(height)/100, v[3]*float64(height)/100
+	}
+	r := image.Rect(int(math.Round(v[0])), int(math.Round(v[1])),
+		int(math.Round(v[0]+v[2])), int(math.Round(v[1]+v[3]))).Intersect(full)
+	if v[2] <= 0 || v[3] <= 0 || r.Empty() {
+		return image.Rectangle{}, badRequest("region is empty or outside the image")
+	}
+	return r, nil
+}
+
+// parseSize parses a size for a region of rw×rh pixels.
+func parseSize(s string, rw, rh, maxW, maxH int) (image.Point, error) {
+	upscale := strings.HasPrefix(s, "^")
+	s = strings.TrimPrefix(s, "^")
+	invalid := badRequest("invalid size " + s)
+
+	var w, h int
+	switch {
+	case s == "max" || s == "full":
+		w, h = rw, rh
+		if upscale || w > maxW || h > maxH {
+			w, h = fit(rw, rh, maxW, maxH)
+		}
+	case strings.HasPrefix(s, "pct:"):
+		n, err := strconv.ParseFloat(s[4:], 64)
+		if err != nil || n <= 0 {
+			return image.Point{}, invalid
+		}
+		w, h = int(math.Round(float64(rw)*n/100)), int(math.Round(float64(rh)*n/100))
+	default:
+		confined := strings.HasPrefix(s, "!")
+		i := strings.IndexByte(s, ',')
+		if i < 0 {
+			return image.Point{}, invalid
+		}
+		ws, hs := strings.TrimPrefix(s[:i], "!"), s[i+1:]
+		var err1, err2 error
+		if ws != "" {
+			w, err1 = strconv.Atoi(ws)
+		}
+		if hs != "" {
+			h, err2 = strconv.Atoi(hs)
+		}
+		if err1 != nil || err2 != nil || w < 0 || h < 0 || (ws == "" && hs == "") || (confined && (ws == "" || hs == "")) {
+			return image.Point{}, invalid
+		}
+		switch {
+		case confined:
+			w, h = fit(rw, rh, w, h)
+		case ws == "":
+			w = int(math.Round(float64(rw) * float64(h) / float64(rh)))
+		case hs == "":
+			h = int(math.Round(float64(rh) * float64(w) / float64(rw)))
+		}
+	}
+	if w < 1 || h < 1 {
+		return image.Point{}, badRequest("size is empty")
+	}
+	if !upscale && (w > rw || h > rh) {
+		return image.Point{}, badRequest("size larger than region requires ^")
+	}
+	if w > maxW || h > maxH {
+		return image.Point{}, badRequest("size exceeds maximum")
+	}
+	return image.Pt(w, h), nil
+}
+
+// fit scales w×h to the largest size within maxW×maxH.
+func fit(w, h, maxW, maxH int) (int, int) {
+	s := math.Min(float64(maxW)/float64(w), float64(maxH)/float64(h))
+	return int(math.Max(1, math.Round(float64(w)*s))), int(math.Max(1, math.Round(float64(h)*s)))
+}
+
+func parseRotation(s string) (mirror bool, degrees int, err error) {
+	mirror = strings.HasPrefix(s, "!")
+	v, perr := strconv.ParseFloat(strings.TrimPrefix(s, "!"), 64)
+	if perr != nil || v < 0 || v > 360 {
+		return false, 0, badRequest("invalid rotation " + s)
+	}
+	if v != math.Trunc(v) || int(v)%90 != 0 {
+		return false, 0, notImplemented("rotation must be a multiple of 90 degrees")
+	}
+	return mirror, int(v), nil
+}
+
+func parseNumbers(s string, n int) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != n {
+		return nil, errors.New("wrong number of values")
+	}
+	v := make([]float64, n)
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(p, 64)
+		if err != nil || f < 0 {
+			return nil, errors.New("invalid number")
+		}
+		v[i] = f
+	}
+	return v, nil
+}
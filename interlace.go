@@ -0,0 +1,423 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+)
+
+// Interlaced reports whether an encoded image uses progressive
+// rendering: Adam7 for PNG, interlaced frames for GIF, or progressive
+// scans for JPEG. Other formats report false.
+func Interlaced(r io.Reader) (bool, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(8)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case string(head) == pngHeader:
+		ihdr := make([]byte, 8+8+13)
+		if _, err := io.ReadFull(br, ihdr); err != nil {
+			return false, err
+		}
+		return ihdr[28] == 1, nil
+	case string(head[:3]) == "GIF":
+		return gifInterlaced(br)
+	case head[0] == 0xff && head[1] == 0xd8:
+		return jpegProgressive(br)
+	}
+	return false, nil
+}
+
+func gifInterlaced(r *bufio.Reader) (bool, error) {
+	var hdr [13]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return false, err
+	}
+	if hdr[10]&0x80 != 0 {
+		if _, err := r.Discard(3 << (hdr[10]&7 + 1)); err != nil {
+			return false, err
+		}
+	}
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return false, err
+		}
+		switch c {
+		case 0x21: // extension
+			if _, err := r.ReadByte(); err != nil {
+				return false, err
+			}
+			if err := skipGIFBlocks(r); err != nil {
+				return false, err
+			}
+		case 0x2c: // image descriptor
+			var desc [9]byte
+			if _, err := io.ReadFull(r, desc[:]); err != nil {
+				return false, err
+			}
+			return desc[8]&0x40 != 0, nil
+		default:
+			return false, nil
+		}
+	}
+}
+
+func skipGIFBlocks(r *bufio.Reader) error {
+	for {
+		n, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		if _, err := r.Discard(int(n)); err != nil {
+			return err
+		}
+	}
+}
+
+func jpegProgressive(r *bufio.Reader) (bool, error) {
+	if _, err := r.Discard(2); err != nil {
+		return false, err
+	}
+	for {
+		var m [4]byte
+		if _, err := io.ReadFull(r, m[:2]); err != nil {
+			return false, err
+		}
+		if m[0] != 0xff {
+			return false, errors.New("jpeg: invalid marker")
+		}
+		marker := m[1]
+		if marker == 0xff || marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			continue
+		}
+		switch marker {
+		case 0xc2, 0xc6, 0xca, 0xce:
+			return true, nil
+		case 0xc0, 0xc1, 0xc3, 0xc5, 0xc7, 0xc9, 0xcb, 0xcd, 0xcf, 0xda, 0xd9:
+			return false, nil
+		}
+		if _, err := io.ReadFull(r, m[2:]); err != nil {
+			return false, err
+		}
+		if _, err := r.Discard(int(binary.BigEndian.Uint16(m[2:])) - 2); err != nil {
+			return false, err
+		}
+	}
+}
+
+// encodeInterlacedGIF writes img as a GIF whose rows are stored in the
+// four-pass interlaced order.
+func encodeInterlacedGIF(w io.Writer, img image.Image, opts Options) error {
+	p := gifPaletted(img, opts)
+	b := p.Bounds()
+	il := image.NewPaletted(b, p.Palette)
+	row := 0
+	for _, pass := range [4][2]int{{0, 8}, {4, 8}, {2, 4}, {1, 2}} {
+		for y := pass[0]; y < b.Dy(); y += pass[1] {
+			copy(il.Pix[row*il.Stride:row*il.Stride+b.Dx()], p.Pix[y*p.Stride:y*p.Stride+b.Dx()])
+			row++
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, il, &gif.Options{NumColors: len(p.Palette)}); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	i, err := gifDescriptorOffset(data)
+	if err != nil {
+		return err
+	}
+	data[i+9] |= 0x40
+	_, err = w.Write(data)
+	return err
+}
+
+// gifPaletted maps img to the palette the GIF encoder would choose.
+func gifPaletted(img image.Image, opts Options) *image.Paletted {
+	n := opts.Colors
+	if n <= 0 || n > 256 {
+		n = 256
+	}
+	if p, ok := img.(*image.Paletted); ok && len(p.Palette) <= n {
+		return p
+	}
+	b := img.Bounds()
+	p := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), opts.Quantizer.Palette(img, n))
+	opts.Dither.Draw(p, p.Bounds(), img, b.Min)
+	return p
+}
+
+// gifDescriptorOffset returns the offset of the first image descriptor
+// in an encoded GIF.
+func gifDescriptorOffset(data []byte) (int, error) {
+	if len(data) < 13 {
+		return 0, errors.New("gif: short header")
+	}
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&7 + 1)
+	}
+	for i < len(data) {
+		switch data[i] {
+		case 0x2c:
+			if i+10 > len(data) {
+				return 0, errors.New("gif: short image descriptor")
+			}
+			return i, nil
+		case 0x21:
+			i += 2
+			for i < len(data) && data[i] != 0 {
+				i += int(data[i]) + 1
+			}
+			i++
+		default:
+			return 0, errors.New("gif: unexpected block")
+		}
+	}
+	return 0, errors.New("gif: no image descriptor")
+}
+
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+// adam7 lists the x offset, y offset, x step and y step of each pass.
+var adam7 = [7][4]int{
+	{0, 0, 8, 8},
+	{4, 0, 8, 8},
+	{0, 4, 4, 8},
+	{2, 0, 4, 4},
+	{0, 2, 2, 4},
+	{1, 0, 2, 2},
+	{0, 1, 1, 2},
+}
+
+// PNG color types.
+const (
+	pngGray     = 0
+	pngRGB      = 2
+	pngPaletted = 3
+	pngRGBA     = 6
+)
+
+// encodeInterlacedPNG writes img as an Adam7-interlaced PNG. The
+// standard library encoder only writes non-interlaced images.
+func encodeInterlacedPNG(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 {
+		return errors.New("png: invalid image size")
+	}
+
+	pw := &pngWriter{w: w}
+	ctype, depth := pngColorType(img)
+	var ihdr [13]byte
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(b.Dx()))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(b.Dy()))
+	ihdr[8] = byte(depth)
+	ihdr[9] = byte(ctype)
+	ihdr[12] = 1 // Adam7
+
+	pw.write([]byte(pngHeader))
+	pw.chunk("IHDR", ihdr[:])
+	if p, ok := img.(*image.Paletted); ok {
+		pw.palette(p.Palette)
+	}
+
+	var data bytes.Buffer
+	zw := zlib.NewWriter(&data)
+	channels := map[int]int{pngGray: 1, pngRGB: 3, pngPaletted: 1, pngRGBA: 4}[ctype]
+	bpp := channels * depth / 8
+	for _, pass := range adam7 {
+		width := (b.Dx() - pass[0] + pass[2] - 1) / pass[2]
+		if width <= 0 || pass[1] >= b.Dy() {
+			continue
+		}
+		prev := make([]byte, width*bpp)
+		cur := make([]byte, width*bpp)
+		for y := pass[1]; y < b.Dy(); y += pass[3] {
+			for i := 0; i < width; i++ {
+				x := pass[0] + i*pass[2]
+				pngPixel(cur[i*bpp:(i+1)*bpp], img, b.Min.X+x, b.Min.Y+y, ctype, depth)
+			}
+			zw.Write(filterRow(cur, prev, bpp))
+			prev, cur = cur, prev
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	pw.chunk("IDAT", data.Bytes())
+	pw.chunk("IEND", nil)
+	return pw.err
+}
+
+func pngColorType(img image.Image) (ctype, depth int) {
+	opaque := isOpaque(img)
+	switch img.(type) {
+	case *image.Paletted:
+		return pngPaletted, 8
+	case *image.Gray:
+		return pngGray, 8
+	case *image.Gray16:
+		return pngGray, 16
+	}
+	depth = 8
+	switch img.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model:
+		depth = 16
+	}
+	if opaque {
+		return pngRGB, depth
+	}
+	return pngRGBA, depth
+}
+
+// pngPixel writes the samples of the pixel at (x, y) into dst.
+func pngPixel(dst []byte, img image.Image, x, y, ctype, depth int) {
+	switch ctype {
+	case pngPaletted:
+		dst[0] = img.(*image.Paletted).ColorIndexAt(x, y)
+		return
+	case pngGray:
+		g := color.Gray16Model.Convert(img.At(x, y)).(color.Gray16)
+		if depth == 16 {
+			binary.BigEndian.PutUint16(dst, g.Y)
+		} else {
+			dst[0] = uint8(g.Y >> 8)
+		}
+		return
+	}
+
+	c := toNRGBA64(img.At(x, y))
+	samples := []uint16{c.R, c.G, c.B, c.A}
+	if ctype == pngRGB {
+		samples = samples[:3]
+	}
+	for i, s := range samples {
+		if depth == 16 {
+			binary.BigEndian.PutUint16(dst[i*2:], s)
+		} else {
+			dst[i] = uint8(s >> 8)
+		}
+	}
+}
+
+// toNRGBA64 converts c to non-premultiplied form, reading NRGBA colors
+// directly so that they do not lose precision by being premultiplied.
+func toNRGBA64(c color.Color) color.NRGBA64 {
+	switch c := c.(type) {
+	case color.NRGBA:
+		return color.NRGBA64{uint16(c.R) * 0x101, uint16(c.G) * 0x101, uint16(c.B) * 0x101, uint16(c.A) * 0x101}
+	case color.NRGBA64:
+		return c
+	}
+	return color.NRGBA64Model.Convert(c).(color.NRGBA64)
+}
+
+// filterRow returns the filter type byte followed by the filtered row,
+// choosing the filter with the smallest sum of absolute values.
+func filterRow(cur, prev []byte, bpp int) []byte {
+	best, bestSum := []byte(nil), -1
+	out := make([]byte, len(cur)+1)
+	for ft := byte(0); ft < 5; ft++ {
+		out[0] = ft
+		sum := 0
+		for i, x := range cur {
+			var a, b, c byte
+			if i >= bpp {
+				a, c = cur[i-bpp], prev[i-bpp]
+			}
+			b = prev[i]
+			var v byte
+			switch ft {
+			case 0:
+				v = x
+			case 1:
+				v = x - a
+			case 2:
+				v = x - b
+			case 3:
+				v = x - byte((int(a)+int(b))/2)
+			case 4:
+				v = x - paeth(a, b, c)
+			}
+			out[i+1] = v
+			sum += abs(int(int8(v)))
+		}
+		if bestSum < 0 || sum < bestSum {
+			bestSum = sum
+			best = append(best[:0], out...)
+		}
+	}
+	return best
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+type pngWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (pw *pngWriter) chunk(name string, data []byte) {
+	if pw.err != nil {
+		return
+	}
+	var hdr [8]byte
+	binary.BigEndian.PutUint32(hdr[:4], uint32(len(data)))
+	copy(hdr[4:], name)
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[4:])
+	crc.Write(data)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	pw.write(hdr[:])
+	pw.write(data)
+	pw.write(sum[:])
+}
+
+func (pw *pngWriter) write(b []byte) {
+	if pw.err == nil {
+		_, pw.err = pw.w.Write(b)
+	}
+}
+
+func (pw *pngWriter) palette(p color.Palette) {
+	plte := make([]byte, 0, 3*len(p))
+	trns := make([]byte, 0, len(p))
+	last := -1
+	for i, c := range p {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		plte = append(plte, n.R, n.G, n.B)
+		trns = append(trns, n.A)
+		if n.A != 0xff {
+			last = i
+		}
+	}
+	pw.chunk("PLTE", plte)
+	if last >= 0 {
+		pw.chunk("tRNS", trns[:last+1])
+	}
+}
@@ -0,0 +1,187 @@
+package jpegdec
+
+import "math"
+
+// idctBasis[s][x*s+u] is k(u)/√8 · cos((2x+1)uπ/2s), where k(0) = 1
+// and k(u) = √2 otherwise. Evaluating the lowest s×s coefficients of a
+// block with it gives the block reduced to s×s samples.
+var idctBasis = func() (t [5][]float64) {
+	for _, s := range []int{2, 4} {
+		t[s] = make([]float64, s*s)
+		for x := 0; x < s; x++ {
+			for u := 0; u < s; u++ {
+				k := 1.0
+				if u > 0 {
+					k = math.Sqrt2
+				}
+				t[s][x*s+u] = k / math.Sqrt(8) * math.Cos(float64((2*x+1)*u)*math.Pi/float64(2*s))
+			}
+		}
+	}
+	return t
+}()
+
+// idctScaled writes the s×s samples of a dequantized block in natural
+// order to out.
+func idctScaled(blk *[64]int32, out []uint8, stride, s int) {
+	b := idctBasis[s]
+	var tmp [64]float64
+	for v := 0; v < s; v++ {
+		row := blk[8*v:]
+		for x := 0; x < s; x++ {
+			var sum float64
+			for u := 0; u < s; u++ {
+				sum += b[x*s+u] * float64(row[u])
+			}
+			tmp[v*s+x] = sum
+		}
+	}
+	for y := 0; y < s; y++ {
+		for x := 0; x < s; x++ {
+			var sum float64
+			for v := 0; v < s; v++ {
+				sum += b[y*s+v] * tmp[v*s+x]
+			}
+			out[y*stride+x] = clamp(int32(math.Round(sum)) + 128)
+		}
+	}
+}
+
+// aanScale[i] is 2^14 · a(row)·a(col) with a(0) = 1 and
+// a(k) = √2·cos(kπ/16), the factors the AAN inverse DCT leaves out.
+var aanScale = func() (t [64]int32) {
+	for i := range t {
+		t[i] = int32(math.Round(1 << 14 * aanFloat[i]))
+	}
+	return t
+}()
+
+// idctFloat is the floating-point AAN inverse DCT of libjpeg's
+// JDCT_FLOAT, which is as accurate as the direct transform.
+func idctFloat(blk *[64]int32, out []uint8, stride int) {
+	var ws [64]float64
+	for i := range ws {
+		ws[i] = float64(blk[i]) * aanFloat[i]
+	}
+	for c := 0; c < 8; c++ {
+		if ws[8+c] == 0 && ws[16+c] == 0 && ws[24+c] == 0 && ws[32+c] == 0 && ws[40+c] == 0 && ws[48+c] == 0 && ws[56+c] == 0 {
+			for r := 1; r < 8; r++ {
+				ws[8*r+c] = ws[c]
+			}
+			continue
+		}
+		aanf(ws[c:], 8)
+	}
+	for r := 0; r < 8; r++ {
+		row := ws[8*r:]
+		aanf(row, 1)
+		for x := 0; x < 8; x++ {
+			out[r*stride+x] = clamp(int32(math.Round(row[x]/8)) + 128)
+		}
+	}
+}
+
+// aanFloat[i] is aanScale[i] without the fixed-point factor.
+var aanFloat = func() (t [64]float64) {
+	for i := range t {
+		t[i] = aanFactor(i/8) * aanFactor(i%8)
+	}
+	return t
+}()
+
+func aanFactor(k int) float64 {
+	if k == 0 {
+		return 1
+	}
+	return math.Sqrt2 * math.Cos(float64(k)*math.Pi/16)
+}
+
+// aanf transforms the 8 values p[0], p[step], ... in place.
+func aanf(p []float64, step int) {
+	tmp0, tmp1, tmp2, tmp3 := p[0], p[2*step], p[4*step], p[6*step]
+	tmp10, tmp11 := tmp0+tmp2, tmp0-tmp2
+	tmp13 := tmp1 + tmp3
+	tmp12 := (tmp1-tmp3)*math.Sqrt2 - tmp13
+	tmp0, tmp3 = tmp10+tmp13, tmp10-tmp13
+	tmp1, tmp2 = tmp11+tmp12, tmp11-tmp12
+
+	tmp4, tmp5, tmp6, tmp7 := p[step], p[3*step], p[5*step], p[7*step]
+	z13, z10 := tmp6+tmp5, tmp6-tmp5
+	z11, z12 := tmp4+tmp7, tmp4-tmp7
+	tmp7 = z11 + z13
+	tmp11 = (z11 - z13) * math.Sqrt2
+	z5 := (z10 + z12) * 1.847759065
+	tmp10 = z12*1.082392200 - z5
+	tmp12 = z10*-2.613125930 + z5
+	tmp6 = tmp12 - tmp7
+	tmp5 = tmp11 - tmp6
+	tmp4 = tmp10 + tmp5
+
+	p[0], p[7*step] = tmp0+tmp7, tmp0-tmp7
+	p[step], p[6*step] = tmp1+tmp6, tmp1-tmp6
+	p[2*step], p[5*step] = tmp2+tmp5, tmp2-tmp5
+	p[4*step], p[3*step] = tmp3+tmp4, tmp3-tmp4
+}
+
+// Fixed-point constants of the AAN inverse DCT, with 8 fractional bits.
+const (
+	fix1_082392200 = 277
+	fix1_414213562 = 362
+	fix1_847759065 = 473
+	fix2_613125930 = 669
+)
+
+// idctFast is the integer inverse DCT of libjpeg's JDCT_IFAST: the
+// same algorithm in 8-bit fixed point, which is faster but less
+// accurate.
+func idctFast(blk *[64]int32, out []uint8, stride int) {
+	var ws [64]int32
+	for i := range ws {
+		ws[i] = (blk[i]*aanScale[i] + 1<<11) >> 12 // 2 fractional bits
+	}
+	for c := 0; c < 8; c++ {
+		if ws[8+c]|ws[16+c]|ws[24+c]|ws[32+c]|ws[40+c]|ws[48+c]|ws[56+c] == 0 {
+			for r := 1; r < 8; r++ {
+				ws[8*r+c] = ws[c]
+			}
+			continue
+		}
+		aan(ws[c:], 8)
+	}
+	for r := 0; r < 8; r++ {
+		row := ws[8*r:]
+		aan(row, 1)
+		for x := 0; x < 8; x++ {
+			out[r*stride+x] = clamp((row[x]+16)>>5 + 128)
+		}
+	}
+}
+
+func mul(v, c int32) int32 { return v * c >> 8 }
+
+// aan transforms the 8 values p[0], p[step], ... in place.
+func aan(p []int32, step int) {
+	tmp0, tmp1, tmp2, tmp3 := p[0], p[2*step], p[4*step], p[6*step]
+	tmp10, tmp11 := tmp0+tmp2, tmp0-tmp2
+	tmp13 := tmp1 + tmp3
+	tmp12 := mul(tmp1-tmp3, fix1_414213562) - tmp13
+	tmp0, tmp3 = tmp10+tmp13, tmp10-tmp13
+	tmp1, tmp2 = tmp11+tmp12, tmp11-tmp12
+
+	tmp4, tmp5, tmp6, tmp7 := p[step], p[3*step], p[5*step], p[7*step]
+	z13, z10 := tmp6+tmp5, tmp6-tmp5
+	z11, z12 := tmp4+tmp7, tmp4-tmp7
+	tmp7 = z11 + z13
+	tmp11 = mul(z11-z13, fix1_414213562)
+	z5 := mul(z10+z12, fix1_847759065)
+	tmp10 = mul(z12, fix1_082392200) - z5
+	tmp12 = mul(z10, -fix2_613125930) + z5
+	tmp6 = tmp12 - tmp7
+	tmp5 = tmp11 - tmp6
+	tmp4 = tmp10 + tmp5
+
+	p[0], p[7*step] = tmp0+tmp7, tmp0-tmp7
+	p[step], p[6*step] = tmp1+tmp6, tmp1-tmp6
+	p[2*step], p[5*step] = tmp2+tmp5, tmp2-tmp5
+	p[4*step], p[3*step] = tmp3+tmp4, tmp3-tmp4
+}
@@ -0,0 +1,795 @@
+// Package jpegdec decodes baseline JPEG images with the inverse DCT
+// evaluated at 1/1, 1/2, 1/4 or 1/8 size, so that a reduced image costs
+// a fraction of a full decode. Progressive images are decoded only as
+// previews, from their DC scans. Other progressive, arithmetic-coded,
+// 12-bit, RGB-coded and CMYK images return ErrUnsupported; callers fall
+// back to image/jpeg for them.
+package jpegdec
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+)
+
+// ErrUnsupported is returned for valid JPEG images using features this
+// package does not implement.
+var ErrUnsupported = errors.New("jpegdec: unsupported feature")
+
+// Options configures decoding.
+type Options struct {
+	Scale   int  // 1, 2, 4 or 8: decode at 1/Scale of the full size, default 1
+	Fast    bool // use a faster, less accurate integer inverse DCT at full size
+	Partial bool // return what decodes of truncated or corrupt images with a *PartialError
+	Preview bool // decode at 1/8 size, progressive images from their first DC scans only
+}
+
+// PartialError is returned with the image when Options.Partial is set
+// and the image data ends early or is corrupt. The samples of the
+// image are undefined within the Missing regions, where decoding
+// failed; decoding resumes after the next restart marker, if the image
+// has them.
+type PartialError struct {
+	Missing []image.Rectangle
+	Err     error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("jpegdec: %d regions not decoded: %v", len(e.Missing), e.Err)
+}
+
+func (e *PartialError) Unwrap() error { return e.Err }
+
+// errTruncated is the cause of a PartialError for data that ends early.
+var errTruncated = errors.New("jpegdec: truncated data")
+
+// unzig maps zigzag order to natural order.
+var unzig = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10, 17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34, 27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36, 29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46, 53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+type huffman struct {
+	lut     [256]uint16 // value<<8 | length for codes of up to 8 bits, indexed by the next 8 bits
+	maxcode [17]int32   // largest code of each length, -1 if there is none
+	mincode [17]int32
+	valptr  [17]int32
+	vals    []byte
+}
+
+type component struct {
+	id      byte
+	h, v    int // sampling factors
+	tq      int // quantization table
+	td, ta  int // DC and AC Huffman tables of the current scan
+	bw, bh  int // blocks per row and column, padded to whole MCUs
+	pred    int32
+	plane   []uint8
+	stride  int
+	scanned bool
+	dc      bool // DC coefficients decoded, for progressive previews
+}
+
+type decoder struct {
+	r       *bufio.Reader
+	bits    uint32 // pending entropy-coded bits, most significant first
+	nbits   uint
+	marker  byte              // marker met while reading entropy-coded data
+	eof     bool              // entropy-coded data ended early
+	err     error             // first corruption met, for partial images
+	missing []image.Rectangle // full-size regions not decoded, for partial images
+	scale   int
+	fast    bool
+	partial bool
+	quant   [4][64]int32 // zigzag order
+	defined [4]bool
+	dc, ac  [4]*huffman
+	restart int
+
+	preview     bool
+	progressive bool
+	al          uint // successive approximation shift of the current DC scan
+
+	comps         []*component
+	width, height int
+	hmax, vmax    int
+	mcux, mcuy    int
+	adobe         bool
+	transform     byte
+}
+
+// Decode reads a JPEG image from r. Three-component images are
+// returned as *image.YCbCr and grayscale ones as *image.Gray, with
+// dimensions rounded up when scaled.
+func Decode(r io.Reader, o *Options) (image.Image, error) {
+	d := &decoder{r: bufio.NewReader(r), scale: 1}
+	if o != nil {
+		switch o.Scale {
+		case 2, 4, 8:
+			d.scale = o.Scale
+		}
+		d.fast = o.Fast && d.scale == 1
+		d.partial = o.Partial
+		if o.Preview {
+			d.preview, d.scale, d.fast = true, 8, false
+		}
+	}
+	var soi [2]byte
+	if _, err := io.ReadFull(d.r, soi[:]); err != nil {
+		return nil, err
+	}
+	if soi != [2]byte{0xff, 0xd8} {
+		return nil, errors.New("jpegdec: missing SOI marker")
+	}
+	if err := d.segments(); err != nil {
+		if !d.partial || d.comps == nil {
+			return nil, err
+		}
+		return d.partialImage(err)
+	}
+	if d.partial {
+		return d.partialImage(errTruncated)
+	}
+	return d.image()
+}
+
+// segments reads the marker segments up to EOI, decoding each scan.
+func (d *decoder) segments() error {
+	for {
+		m, err := d.nextMarker()
+		if err != nil {
+			return err
+		}
+		if m == 0xd9 { // EOI
+			return nil
+		}
+		if m == 0x01 || m >= 0xd0 && m <= 0xd7 {
+			continue // TEM and stray RSTn have no length
+		}
+		n, err := d.readUint16()
+		if err != nil {
+			return err
+		}
+		if n < 2 {
+			return errors.New("jpegdec: bad segment length")
+		}
+		n -= 2
+		switch {
+		case m == 0xc0 || m == 0xc1:
+			err = d.readSOF(n)
+		case m == 0xc2 && d.preview:
+			d.progressive = true
+			err = d.readSOF(n)
+		case m >= 0xc2 && m <= 0xcf && m != 0xc4 && m != 0xc8 && m != 0xcc:
+			return ErrUnsupported // progressive, lossless, hierarchical or arithmetic
+		case m == 0xc4:
+			err = d.readDHT(n)
+		case m == 0xdb:
+			err = d.readDQT(n)
+		case m == 0xdd:
+			err = d.readDRI(n)
+		case m == 0xda:
+			if err = d.readSOS(n); err == nil && d.progressive && d.dcDone() {
+				return nil // the rest refines detail a preview lacks
+			}
+		case m == 0xee:
+			err = d.readAdobe(n)
+		default:
+			_, err = d.r.Discard(n)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// partialImage returns the image decoded so far, and a *PartialError
+// with cause err unless every component was decoded in full.
+func (d *decoder) partialImage(err error) (image.Image, error) {
+	if errors.Is(err, ErrUnsupported) {
+		return nil, err
+	}
+	img, ierr := d.image()
+	if ierr != nil {
+		return nil, ierr
+	}
+	missing := d.missing
+	for _, c := range d.comps {
+		if !c.scanned {
+			missing = []image.Rectangle{image.Rect(0, 0, d.width, d.height)}
+		}
+	}
+	if len(missing) == 0 {
+		return img, nil
+	}
+	if d.err != nil {
+		err = d.err
+	} else if err == io.EOF {
+		err = errTruncated
+	}
+	pe := &PartialError{Err: err}
+	for _, r := range missing {
+		r = image.Rect(r.Min.X/d.scale, r.Min.Y/d.scale, (r.Max.X+d.scale-1)/d.scale, (r.Max.Y+d.scale-1)/d.scale)
+		pe.Missing = append(pe.Missing, r.Intersect(img.Bounds()))
+	}
+	return img, pe
+}
+
+// nextMarker returns the code of the next marker.
+func (d *decoder) nextMarker() (byte, error) {
+	if m := d.marker; m != 0 {
+		d.marker = 0
+		return m, nil
+	}
+	for {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if c != 0xff {
+			continue // garbage between segments
+		}
+		for c == 0xff {
+			if c, err = d.r.ReadByte(); err != nil {
+				return 0, err
+			}
+		}
+		if c != 0 {
+			return c, nil
+		}
+	}
+}
+
+func (d *decoder) readUint16() (int, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(d.r, b[:]); err != nil {
+		return 0, err
+	}
+	return int(b[0])<<8 | int(b[1]), nil
+}
+
+func (d *decoder) readFull(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(d.r, b)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+func (d *decoder) readSOF(n int) error {
+	b, err := d.readFull(n)
+	if err != nil {
+		return err
+	}
+	if d.comps != nil {
+		return errors.New("jpegdec: multiple frames")
+	}
+	if len(b) < 6 || b[0] != 8 {
+		return ErrUnsupported // only 8-bit precision
+	}
+	d.height, d.width = int(b[1])<<8|int(b[2]), int(b[3])<<8|int(b[4])
+	nc := int(b[5])
+	if d.width == 0 || d.height == 0 {
+		return ErrUnsupported // height defined by a DNL marker
+	}
+	if nc != 1 && nc != 3 {
+		return ErrUnsupported
+	}
+	if len(b) < 6+3*nc {
+		return errors.New("jpegdec: short SOF segment")
+	}
+	for i := 0; i < nc; i++ {
+		p := b[6+3*i:]
+		c := &component{id: p[0], h: int(p[1] >> 4), v: int(p[1] & 15), tq: int(p[2])}
+		if c.h < 1 || c.h > 4 || c.v < 1 || c.v > 4 || c.tq > 3 {
+			return errors.New("jpegdec: bad component parameters")
+		}
+		if nc == 1 {
+			c.h, c.v = 1, 1 // a single component is never interleaved
+		}
+		d.comps = append(d.comps, c)
+		if c.h > d.hmax {
+			d.hmax = c.h
+		}
+		if c.v > d.vmax {
+			d.vmax = c.v
+		}
+	}
+	d.mcux = (d.width + 8*d.hmax - 1) / (8 * d.hmax)
+	d.mcuy = (d.height + 8*d.vmax - 1) / (8 * d.vmax)
+	s := 8 / d.scale
+	for _, c := range d.comps {
+		c.bw, c.bh = d.mcux*c.h, d.mcuy*c.v
+		c.stride = c.bw * s
+		c.plane = make([]uint8, c.stride*c.bh*s)
+	}
+	return nil
+}
+
+func (d *decoder) readDQT(n int) error {
+	b, err := d.readFull(n)
+	if err != nil {
+		return err
+	}
+	for len(b) > 0 {
+		pq, tq := b[0]>>4, int(b[0]&15)
+		if tq > 3 || pq > 1 {
+			return errors.New("jpegdec: bad DQT segment")
+		}
+		size := 64 << pq
+		if len(b) < 1+size {
+			return errors.New("jpegdec: short DQT segment")
+		}
+		for i := 0; i < 64; i++ {
+			if pq == 0 {
+				d.quant[tq][i] = int32(b[1+i])
+			} else {
+				d.quant[tq][i] = int32(b[1+2*i])<<8 | int32(b[2+2*i])
+			}
+		}
+		d.defined[tq] = true
+		b = b[1+size:]
+	}
+	return nil
+}
+
+func (d *decoder) readDHT(n int) error {
+	b, err := d.readFull(n)
+	if err != nil {
+		return err
+	}
+	for len(b) > 0 {
+		if len(b) < 17 {
+			return errors.New("jpegdec: short DHT segment")
+		}
+		tc, th := b[0]>>4, int(b[0]&15)
+		if tc > 1 || th > 3 {
+			return errors.New("jpegdec: bad DHT segment")
+		}
+		total := 0
+		for _, c := range b[1:17] {
+			total += int(c)
+		}
+		if total > 256 || len(b) < 17+total {
+			return errors.New("jpegdec: bad DHT segment")
+		}
+		h, err := newHuffman(b[1:17], b[17:17+total])
+		if err != nil {
+			return err
+		}
+		if tc == 0 {
+			d.dc[th] = h
+		} else {
+			d.ac[th] = h
+		}
+		b = b[17+total:]
+	}
+	return nil
+}
+
+func newHuffman(counts, vals []byte) (*huffman, error) {
+	h := &huffman{vals: vals}
+	code, k := int32(0), int32(0)
+	for n := 1; n <= 16; n++ {
+		h.valptr[n], h.mincode[n] = k, code
+		for i := 0; i < int(counts[n-1]); i++ {
+			if code >= 1<<uint(n) {
+				return nil, errors.New("jpegdec: bad Huffman table")
+			}
+			if n <= 8 {
+				shift := uint(8 - n)
+				for j := int32(0); j < 1<<shift; j++ {
+					h.lut[code<<shift|j] = uint16(vals[k])<<8 | uint16(n)
+				}
+			}
+			code++
+			k++
+		}
+		h.maxcode[n] = code - 1
+		if counts[n-1] == 0 {
+			h.maxcode[n] = -1
+		}
+		code <<= 1
+	}
+	return h, nil
+}
+
+func (d *decoder) readDRI(n int) error {
+	if n != 2 {
+		return errors.New("jpegdec: bad DRI segment")
+	}
+	v, err := d.readUint16()
+	d.restart = v
+	return err
+}
+
+func (d *decoder) readAdobe(n int) error {
+	b, err := d.readFull(n)
+	if err != nil {
+		return err
+	}
+	if len(b) >= 12 && string(b[:5]) == "Adobe" {
+		d.adobe, d.transform = true, b[11]
+	}
+	return nil
+}
+
+func (d *decoder) readSOS(n int) error {
+	if d.comps == nil {
+		return errors.New("jpegdec: missing SOF marker")
+	}
+	b, err := d.readFull(n)
+	if err != nil {
+		return err
+	}
+	if len(b) < 1 || len(b) < 4+2*int(b[0]) {
+		return errors.New("jpegdec: short SOS segment")
+	}
+	ns := int(b[0])
+	ss, se, ah, al := b[1+2*ns], b[2+2*ns], b[3+2*ns]>>4, b[3+2*ns]&15
+	if d.progressive && (ss != 0 || ah != 0) {
+		return nil // AC or refinement scan, skipped with its data
+	}
+	if d.progressive && se != 0 {
+		return errors.New("jpegdec: bad progressive DC scan")
+	}
+	d.al = uint(al)
+	var comps []*component
+	for i := 0; i < ns; i++ {
+		id, t := b[1+2*i], b[2+2*i]
+		var c *component
+		for _, fc := range d.comps {
+			if fc.id == id {
+				c = fc
+			}
+		}
+		if c == nil {
+			return errors.New("jpegdec: unknown component in scan")
+		}
+		c.td, c.ta = int(t>>4), int(t&15)
+		if c.td > 3 || c.ta > 3 || d.dc[c.td] == nil || d.ac[c.ta] == nil && !d.progressive || !d.defined[c.tq] {
+			return errors.New("jpegdec: undefined table in scan")
+		}
+		c.pred = 0
+		c.scanned, c.dc = true, true
+		comps = append(comps, c)
+	}
+	return d.scan(comps)
+}
+
+// dcDone reports whether every component has had a DC scan.
+func (d *decoder) dcDone() bool {
+	for _, c := range d.comps {
+		if !c.dc {
+			return false
+		}
+	}
+	return true
+}
+
+// scan decodes the entropy-coded data of a scan into the planes. With
+// Options.Partial, corrupt data is skipped to the next restart marker,
+// or to the end of the scan, and recorded as missing.
+func (d *decoder) scan(comps []*component) error {
+	d.bits, d.nbits = 0, 0
+	var blk [64]int32
+	sc := scanLayout{cols: d.mcux, mcus: d.mcux * d.mcuy, w: 8 * d.hmax, h: 8 * d.vmax}
+	if len(comps) == 1 {
+		// Non-interleaved: one block per MCU, covering only the
+		// component's own samples.
+		c := comps[0]
+		cw := (d.width*c.h + d.hmax - 1) / d.hmax
+		ch := (d.height*c.v + d.vmax - 1) / d.vmax
+		sc.cols = (cw + 7) / 8
+		sc.mcus = sc.cols * ((ch + 7) / 8)
+		sc.w, sc.h = 8*d.hmax/c.h, 8*d.vmax/c.v
+	}
+	decode := func(mcu int) error {
+		mx, my := mcu%sc.cols, mcu/sc.cols
+		if len(comps) == 1 {
+			return d.block(comps[0], &blk, mx, my)
+		}
+		for _, c := range comps {
+			for v := 0; v < c.v; v++ {
+				for h := 0; h < c.h; h++ {
+					if err := d.block(c, &blk, mx*c.h+h, my*c.v+v); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	for mcu := 0; mcu < sc.mcus; mcu++ {
+		err := decode(mcu)
+		if err == nil && d.restart > 0 && (mcu+1)%d.restart == 0 && mcu+1 < sc.mcus {
+			err = d.readRestart(comps, (mcu+1)/d.restart-1)
+		}
+		if !d.partial {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err == nil && !d.eof {
+			continue
+		}
+		if err == nil {
+			err = errTruncated
+		}
+		if d.err == nil {
+			d.err = err
+		}
+		// The whole restart interval is suspect, since corruption is
+		// often detected only some way past where it starts.
+		start, next := mcu, sc.mcus
+		if d.restart > 0 {
+			start -= mcu % d.restart
+			if !d.eof {
+				next = d.resync(comps, mcu/d.restart, sc.mcus)
+			}
+		}
+		sc.missing(d, start, next)
+		mcu = next - 1
+	}
+	return nil
+}
+
+// scanLayout describes the MCUs of a scan: how many there are, how
+// many make up a row, and the size of each in full-size image pixels.
+type scanLayout struct {
+	cols, mcus int
+	w, h       int
+}
+
+// missing records MCUs [start, end) of the scan as missing.
+func (sc scanLayout) missing(d *decoder, start, end int) {
+	for mcu := start; mcu < end; {
+		row := mcu / sc.cols
+		last := (row + 1) * sc.cols
+		if last > end {
+			last = end
+		}
+		r := image.Rect(mcu%sc.cols*sc.w, row*sc.h, ((last-1)%sc.cols+1)*sc.w, (row+1)*sc.h)
+		d.missing = append(d.missing, r.Intersect(image.Rect(0, 0, d.width, d.height)))
+		mcu = last
+	}
+}
+
+// readRestart reads the RST marker that ends restart interval n and
+// resets the decoder for the next. With Options.Partial, the marker
+// must be numbered for n; otherwise any RST marker is accepted.
+func (d *decoder) readRestart(comps []*component, n int) error {
+	m, err := d.nextMarker()
+	if err != nil {
+		return err
+	}
+	if m < 0xd0 || m > 0xd7 {
+		d.marker = m
+		return errors.New("jpegdec: missing RST marker")
+	}
+	if d.partial && int(m-0xd0) != n%8 {
+		return errors.New("jpegdec: RST marker out of sequence")
+	}
+	d.reset(comps)
+	return nil
+}
+
+func (d *decoder) reset(comps []*component) {
+	d.bits, d.nbits = 0, 0
+	for _, c := range comps {
+		c.pred = 0
+	}
+}
+
+// resync skips the rest of restart interval n, which is corrupt, to
+// the next RST marker, and returns the MCU that follows it, or mcus
+// if the scan has no more markers.
+func (d *decoder) resync(comps []*component, n, mcus int) int {
+	m, err := d.nextMarker()
+	if err != nil {
+		d.eof = true
+		return mcus
+	}
+	if m < 0xd0 || m > 0xd7 {
+		d.marker = m // the next segment
+		return mcus
+	}
+	// The marker ends interval next-1, for the first such interval
+	// after n with its number.
+	next := n + 1
+	for (next-1)%8 != int(m-0xd0) {
+		next++
+	}
+	d.reset(comps)
+	if mcu := next * d.restart; mcu < mcus {
+		return mcu
+	}
+	return mcus
+}
+
+// block decodes one block and writes its samples at block (bx, by).
+func (d *decoder) block(c *component, blk *[64]int32, bx, by int) error {
+	*blk = [64]int32{}
+	q := &d.quant[c.tq]
+	t, err := d.decodeHuffman(d.dc[c.td])
+	if err != nil {
+		return err
+	}
+	if t > 16 {
+		return errors.New("jpegdec: bad DC coefficient")
+	}
+	c.pred += d.receive(uint(t))
+	blk[0] = c.pred << d.al * q[0]
+
+	ac := d.ac[c.ta]
+	for k := 1; k < 64 && !d.progressive; k++ {
+		rs, err := d.decodeHuffman(ac)
+		if err != nil {
+			return err
+		}
+		r, s := int(rs>>4), uint(rs&15)
+		if s == 0 {
+			if r != 15 {
+				break // end of block
+			}
+			k += 15
+			continue
+		}
+		if k += r; k > 63 {
+			return errors.New("jpegdec: bad AC coefficient")
+		}
+		blk[unzig[k]] = d.receive(s) * q[k]
+	}
+
+	s := 8 / d.scale
+	out := c.plane[by*s*c.stride+bx*s:]
+	switch {
+	case s == 1:
+		out[0] = clamp(int32(math.Round(float64(blk[0])/8)) + 128)
+	case d.fast:
+		idctFast(blk, out, c.stride)
+	case s == 8:
+		idctFloat(blk, out, c.stride)
+	default:
+		idctScaled(blk, out, c.stride, s)
+	}
+	return nil
+}
+
+func (d *decoder) fill() {
+	for d.nbits <= 24 {
+		var b byte
+		if d.marker == 0 {
+			c, err := d.r.ReadByte()
+			if err == nil && c == 0xff {
+				for c == 0xff && err == nil {
+					c, err = d.r.ReadByte()
+				}
+				if c == 0 {
+					c = 0xff
+				} else if err == nil {
+					d.marker, c = c, 0
+				}
+			}
+			if err != nil {
+				// Truncated data decodes as zeros, as in libjpeg.
+				d.marker, d.eof, c = 0xd9, true, 0
+			}
+			b = c
+		}
+		d.bits |= uint32(b) << (24 - d.nbits)
+		d.nbits += 8
+	}
+}
+
+func (d *decoder) getBits(n uint) int32 {
+	if n == 0 {
+		return 0
+	}
+	if d.nbits < n {
+		d.fill()
+	}
+	v := int32(d.bits >> (32 - n))
+	d.bits <<= n
+	d.nbits -= n
+	return v
+}
+
+// receive reads an n-bit magnitude-coded value.
+func (d *decoder) receive(n uint) int32 {
+	v := d.getBits(n)
+	if n > 0 && v < 1<<(n-1) {
+		v += -1<<n + 1
+	}
+	return v
+}
+
+func (d *decoder) decodeHuffman(h *huffman) (byte, error) {
+	if d.nbits < 16 {
+		d.fill()
+	}
+	if e := h.lut[d.bits>>24]; e != 0 {
+		n := uint(e & 0xff)
+		d.bits <<= n
+		d.nbits -= n
+		return byte(e >> 8), nil
+	}
+	for n := uint(9); n <= 16; n++ {
+		code := int32(d.bits >> (32 - n))
+		if code <= h.maxcode[n] {
+			d.bits <<= n
+			d.nbits -= n
+			return h.vals[h.valptr[n]+code-h.mincode[n]], nil
+		}
+	}
+	return 0, errors.New("jpegdec: bad Huffman code")
+}
+
+func clamp(v int32) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// image assembles the decoded planes.
+func (d *decoder) image() (image.Image, error) {
+	if d.comps == nil {
+		return nil, errors.New("jpegdec: missing SOF marker")
+	}
+	w, h := (d.width+d.scale-1)/d.scale, (d.height+d.scale-1)/d.scale
+	rect := image.Rect(0, 0, w, h)
+	if len(d.comps) == 1 {
+		c := d.comps[0]
+		img := image.NewGray(rect)
+		for y := 0; y < h; y++ {
+			copy(img.Pix[y*img.Stride:y*img.Stride+w], c.plane[y*c.stride:])
+		}
+		return img, nil
+	}
+
+	y, cb, cr := d.comps[0], d.comps[1], d.comps[2]
+	if d.adobe && d.transform == 0 {
+		return nil, ErrUnsupported // RGB samples
+	}
+	if y.h != d.hmax || y.v != d.vmax || cb.h != cr.h || cb.v != cr.v || y.h%cb.h != 0 || y.v%cb.v != 0 {
+		return nil, ErrUnsupported
+	}
+	var ratio image.YCbCrSubsampleRatio
+	switch [2]int{y.h / cb.h, y.v / cb.v} {
+	case [2]int{1, 1}:
+		ratio = image.YCbCrSubsampleRatio444
+	case [2]int{2, 1}:
+		ratio = image.YCbCrSubsampleRatio422
+	case [2]int{2, 2}:
+		ratio = image.YCbCrSubsampleRatio420
+	case [2]int{1, 2}:
+		ratio = image.YCbCrSubsampleRatio440
+	case [2]int{4, 1}:
+		ratio = image.YCbCrSubsampleRatio411
+	case [2]int{4, 2}:
+		ratio = image.YCbCrSubsampleRatio410
+	default:
+		return nil, ErrUnsupported
+	}
+	img := image.NewYCbCr(rect, ratio)
+	for row := 0; row < h; row++ {
+		copy(img.Y[row*img.YStride:row*img.YStride+w], y.plane[row*y.stride:])
+	}
+	for row := 0; row < len(img.Cb)/img.CStride && row*cb.stride < len(cb.plane); row++ {
+		copy(img.Cb[row*img.CStride:(row+1)*img.CStride], cb.plane[row*cb.stride:])
+		copy(img.Cr[row*img.CStride:(row+1)*img.CStride], cr.plane[row*cr.stride:])
+	}
+	return img, nil
+}
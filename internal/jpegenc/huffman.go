@@ -0,0 +1,115 @@
+package jpegenc
+
+// huffTable is a Huffman table in the form stored in a DHT segment,
+// along with the codes derived from it.
+type huffTable struct {
+	bits [17]byte // bits[n] is the number of codes of length n
+	vals []byte   // symbols in order of increasing code length
+	code [256]uint16
+	size [256]byte // code length of each symbol, 0 if absent
+}
+
+// optimalTable builds a length-limited Huffman table for the symbol
+// frequencies, following Annex K.2 of the JPEG specification.
+func optimalTable(freq *[256]int64) *huffTable {
+	var f [257]int64
+	copy(f[:], freq[:])
+	if *freq == ([256]int64{}) {
+		f[0] = 1 // a DHT segment must define at least one code
+	}
+	f[256] = 1 // reserve a code point so that no code is all ones
+	var codesize [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+	for {
+		c1, c2 := -1, -1
+		for i := range f {
+			if f[i] != 0 && (c1 < 0 || f[i] <= f[c1]) {
+				c1 = i
+			}
+		}
+		for i := range f {
+			if f[i] != 0 && i != c1 && (c2 < 0 || f[i] <= f[c2]) {
+				c2 = i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+		f[c1] += f[c2]
+		f[c2] = 0
+		codesize[c1]++
+		for others[c1] >= 0 {
+			c1 = others[c1]
+			codesize[c1]++
+		}
+		others[c1] = c2
+		codesize[c2]++
+		for others[c2] >= 0 {
+			c2 = others[c2]
+			codesize[c2]++
+		}
+	}
+
+	var bits [33]int
+	for _, n := range codesize {
+		if n > 0 {
+			bits[n]++
+		}
+	}
+	for i := 32; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+	i := 16
+	for bits[i] == 0 {
+		i--
+	}
+	bits[i]-- // drop the reserved code point
+
+	t := &huffTable{}
+	for n := 1; n <= 16; n++ {
+		t.bits[n] = byte(bits[n])
+	}
+	for n := 1; n <= 32; n++ {
+		for s := 0; s < 256; s++ {
+			if codesize[s] == n {
+				t.vals = append(t.vals, byte(s))
+			}
+		}
+	}
+	t.derive()
+	return t
+}
+
+// derive assigns canonical codes to the table's symbols.
+func (t *huffTable) derive() {
+	code, k := uint16(0), 0
+	for n := 1; n <= 16; n++ {
+		for i := 0; i < int(t.bits[n]); i++ {
+			s := t.vals[k]
+			t.code[s], t.size[s] = code, byte(n)
+			code++
+			k++
+		}
+		code <<= 1
+	}
+}
+
+// segment returns the body of a DHT segment defining t as table id of
+// the class (0 for DC, 1 for AC).
+func (t *huffTable) segment(class, id int) []byte {
+	b := []byte{byte(class<<4 | id)}
+	b = append(b, t.bits[1:]...)
+	return append(b, t.vals...)
+}
@@ -0,0 +1,557 @@
+// Package jpegenc is a JPEG encoder that trades encoding time for
+// smaller files than image/jpeg writes at the same quality: Huffman
+// tables are optimized for each image, scans may be progressive, and
+// coefficients may be chosen by trellis quantization.
+package jpegenc
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// Options configures encoding.
+type Options struct {
+	Quality     int  // 1-100, default 75
+	Progressive bool // write spectral-selection progressive scans
+	Trellis     bool // choose coefficients by rate-distortion optimization
+}
+
+// unzig maps zigzag order to natural order.
+var unzig = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10, 17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34, 27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36, 29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46, 53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// Quantization tables from Annex K of the JPEG specification, in
+// natural order.
+var baseQuant = [2][64]int32{{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}, {
+	17, 18, 24, 47, 99, 99, 99, 99,
+	18, 21, 26, 66, 99, 99, 99, 99,
+	24, 26, 56, 99, 99, 99, 99, 99,
+	47, 66, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+}}
+
+// lambda weighs estimated bits against squared quantization error, in
+// units of the quantization step, when trellis quantizing.
+const lambda = 0.04
+
+type component struct {
+	id     byte
+	h, v   int // sampling factors
+	table  int // quantization and Huffman table, 0 for luma and 1 for chroma
+	bw, bh int // blocks per row and column, padded to whole MCUs
+	sw, sh int // blocks covering the component, coded by non-interleaved scans
+	plane  []uint8
+	blocks [][64]int32 // quantized coefficients in zigzag order
+}
+
+type encoder struct {
+	w          *bufio.Writer
+	quant      [2][64]int32
+	comps      []*component
+	mcux, mcuy int
+
+	counting bool          // gather symbol statistics instead of writing
+	freq     [4][256]int64 // DC 0, DC 1, AC 0 and AC 1 statistics
+	tables   [4]*huffTable // in the same order
+	acc      uint32        // pending output bits
+	nacc     uint          // number of pending bits
+	eobrun   int           // pending end-of-band run of a progressive AC scan
+}
+
+// Encode writes img to w as a JPEG image. Grayscale images are written
+// with one component, others as YCbCr with 4:2:0 chroma subsampling.
+func Encode(w io.Writer, img image.Image, o *Options) error {
+	if o == nil {
+		o = &Options{}
+	}
+	quality := o.Quality
+	if quality <= 0 {
+		quality = 75
+	} else if quality > 100 {
+		quality = 100
+	}
+	b := img.Bounds()
+	if b.Dx() >= 1<<16 || b.Dy() >= 1<<16 {
+		return errors.New("jpegenc: image is too large to encode")
+	}
+	if b.Empty() {
+		return errors.New("jpegenc: image is empty")
+	}
+
+	e := &encoder{w: bufio.NewWriter(w)}
+	e.setQuality(quality)
+	e.planes(img)
+	e.transform(o.Trellis)
+
+	e.w.Write([]byte{0xff, 0xd8})
+	e.writeDQT()
+	e.writeSOF(b.Dx(), b.Dy(), o.Progressive)
+	for _, s := range e.script(o.Progressive) {
+		e.writeScan(s.comps, s.ss, s.se)
+	}
+	e.w.Write([]byte{0xff, 0xd9})
+	return e.w.Flush()
+}
+
+// scanSpec selects coefficients ss..se of some components.
+type scanSpec struct {
+	comps  []*component
+	ss, se int
+}
+
+// script returns the scans to write. Progressive images send the DC
+// coefficients first, then the low and finally the high luma
+// frequencies, with the chroma frequencies in between.
+func (e *encoder) script(progressive bool) []scanSpec {
+	if !progressive {
+		return []scanSpec{{e.comps, 0, 63}}
+	}
+	y := e.comps[:1]
+	scans := []scanSpec{{e.comps, 0, 0}, {y, 1, 5}}
+	for _, c := range e.comps[1:] {
+		scans = append(scans, scanSpec{[]*component{c}, 1, 63})
+	}
+	return append(scans, scanSpec{y, 6, 63})
+}
+
+func (e *encoder) setQuality(quality int) {
+	scale := 200 - 2*quality
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	for t := range e.quant {
+		for i, v := range baseQuant[t] {
+			q := (v*int32(scale) + 50) / 100
+			if q < 1 {
+				q = 1
+			} else if q > 255 {
+				q = 255
+			}
+			e.quant[t][i] = q
+		}
+	}
+}
+
+// planes converts img into padded component planes.
+func (e *encoder) planes(img image.Image) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	gray := img.ColorModel() == color.GrayModel || img.ColorModel() == color.Gray16Model
+	if gray {
+		e.comps = []*component{{id: 1, h: 1, v: 1}}
+	} else {
+		e.comps = []*component{{id: 1, h: 2, v: 2}, {id: 2, h: 1, v: 1, table: 1}, {id: 3, h: 1, v: 1, table: 1}}
+	}
+	hmax := e.comps[0].h
+	e.mcux, e.mcuy = (w+8*hmax-1)/(8*hmax), (h+8*hmax-1)/(8*hmax)
+	for _, c := range e.comps {
+		c.bw, c.bh = e.mcux*c.h, e.mcuy*c.v
+		cw, ch := (w*c.h+hmax-1)/hmax, (h*c.v+hmax-1)/hmax
+		c.sw, c.sh = (cw+7)/8, (ch+7)/8
+		c.plane = make([]uint8, 64*c.bw*c.bh)
+		c.blocks = make([][64]int32, c.bw*c.bh)
+	}
+
+	// Sample with edge replication up to whole MCUs.
+	pw, ph := 8*e.comps[0].bw, 8*e.comps[0].bh
+	var cb, cr []uint8
+	if !gray {
+		cb, cr = make([]uint8, pw*ph), make([]uint8, pw*ph)
+	}
+	for y := 0; y < ph; y++ {
+		sy := b.Min.Y + min(y, h-1)
+		for x := 0; x < pw; x++ {
+			c := img.At(b.Min.X+min(x, w-1), sy)
+			i := y*pw + x
+			if gray {
+				e.comps[0].plane[i] = color.GrayModel.Convert(c).(color.Gray).Y
+				continue
+			}
+			r, g, bl, _ := c.RGBA()
+			e.comps[0].plane[i], cb[i], cr[i] = color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(bl>>8))
+		}
+	}
+	if gray {
+		return
+	}
+	for k, full := range [][]uint8{cb, cr} {
+		c := e.comps[k+1]
+		cw := 8 * c.bw
+		for y := 0; y < 8*c.bh; y++ {
+			for x := 0; x < cw; x++ {
+				i := 2*y*pw + 2*x
+				s := int(full[i]) + int(full[i+1]) + int(full[i+pw]) + int(full[i+pw+1])
+				c.plane[y*cw+x] = uint8((s + 2) >> 2)
+			}
+		}
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// cosine[u][x] is C(u)/2 · cos((2x+1)uπ/16), the orthonormal 8-point
+// DCT basis.
+var cosine = func() (t [8][8]float64) {
+	for u := 0; u < 8; u++ {
+		c := 0.5
+		if u == 0 {
+			c = 0.5 / math.Sqrt2
+		}
+		for x := 0; x < 8; x++ {
+			t[u][x] = c * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return t
+}()
+
+// fdct computes the 2-D forward DCT of a level-shifted block in natural
+// order.
+func fdct(in, out *[64]float64) {
+	var tmp [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			var s float64
+			for x := 0; x < 8; x++ {
+				s += cosine[u][x] * in[8*y+x]
+			}
+			tmp[8*y+u] = s
+		}
+	}
+	for u := 0; u < 8; u++ {
+		for v := 0; v < 8; v++ {
+			var s float64
+			for y := 0; y < 8; y++ {
+				s += cosine[v][y] * tmp[8*y+u]
+			}
+			out[8*v+u] = s
+		}
+	}
+}
+
+// transform computes the quantized coefficients of every block. With
+// trellis quantization, a first rounding pass provides the code lengths
+// that the second pass uses to estimate rates.
+func (e *encoder) transform(trellis bool) {
+	e.eachBlock(func(c *component, dct *[64]float64, out *[64]int32) {
+		q := &e.quant[c.table]
+		for z, n := range unzig {
+			out[z] = int32(math.Round(dct[n] / float64(q[n])))
+		}
+	})
+	if !trellis {
+		return
+	}
+	e.counting = true
+	e.scan(e.comps, 0, 63)
+	ac := [2]*huffTable{optimalTable(&e.freq[2]), optimalTable(&e.freq[3])}
+	e.freq = [4][256]int64{}
+	e.counting = false
+	e.eachBlock(func(c *component, dct *[64]float64, out *[64]int32) {
+		quantizeTrellis(dct, &e.quant[c.table], ac[c.table], out)
+	})
+}
+
+func (e *encoder) eachBlock(f func(c *component, dct *[64]float64, out *[64]int32)) {
+	var in, dct [64]float64
+	for _, c := range e.comps {
+		stride := 8 * c.bw
+		for by := 0; by < c.bh; by++ {
+			for bx := 0; bx < c.bw; bx++ {
+				p := c.plane[8*by*stride+8*bx:]
+				for y := 0; y < 8; y++ {
+					for x := 0; x < 8; x++ {
+						in[8*y+x] = float64(p[y*stride+x]) - 128
+					}
+				}
+				fdct(&in, &dct)
+				f(c, &dct, &c.blocks[by*c.bw+bx])
+			}
+		}
+	}
+}
+
+// quantizeTrellis quantizes a block in natural order into zigzag order.
+// The DC coefficient is rounded; the AC coefficients minimize squared
+// error, in units of the quantization step, plus lambda times the bits
+// they cost with the code lengths of ac.
+func quantizeTrellis(dct *[64]float64, q *[64]int32, ac *huffTable, out *[64]int32) {
+	out[0] = int32(math.Round(dct[0] / float64(q[0])))
+	bits := func(s int) float64 {
+		if n := ac.size[s]; n > 0 {
+			return float64(n)
+		}
+		return 16
+	}
+
+	var t [64]float64    // coefficients in units of the quantization step
+	var zero [64]float64 // zero[k] is the error of zeroing coefficients 1..k
+	for z := 1; z < 64; z++ {
+		n := unzig[z]
+		t[z] = dct[n] / float64(q[n])
+		zero[z] = zero[z-1] + t[z]*t[z]
+	}
+	// cost[i] is the least cost of coding coefficients 1..i with i the
+	// last nonzero one; cost[0] is the empty prefix.
+	var cost [64]float64
+	var prev [64]int
+	var val [64]int32
+	for i := 1; i < 64; i++ {
+		cost[i] = math.Inf(1)
+		a := math.Abs(t[i])
+		x := int32(a + 0.5)
+		for v := x; v >= 1 && v >= x-1; v-- {
+			size := bitLength(v)
+			d := (a - float64(v)) * (a - float64(v))
+			for j := i - 1; j >= 0; j-- {
+				if math.IsInf(cost[j], 1) {
+					continue
+				}
+				run := i - 1 - j
+				r := float64(run>>4)*bits(0xf0) + bits((run&15)<<4|size) + float64(size)
+				if c := cost[j] + zero[i-1] - zero[j] + d + lambda*r; c < cost[i] {
+					cost[i], prev[i], val[i] = c, j, v
+				}
+			}
+		}
+	}
+	last, best := 0, math.Inf(1)
+	for i := 0; i < 64; i++ {
+		c := cost[i] + zero[63] - zero[i]
+		if i < 63 {
+			c += lambda * bits(0x00)
+		}
+		if c < best {
+			last, best = i, c
+		}
+	}
+	for z := 1; z < 64; z++ {
+		out[z] = 0
+	}
+	for i := last; i > 0; i = prev[i] {
+		out[i] = val[i]
+		if t[i] < 0 {
+			out[i] = -val[i]
+		}
+	}
+}
+
+// bitLength returns the number of bits in the magnitude of v.
+func bitLength(v int32) int {
+	if v < 0 {
+		v = -v
+	}
+	n := 0
+	for ; v != 0; v >>= 1 {
+		n++
+	}
+	return n
+}
+
+func (e *encoder) segment(marker byte, body []byte) {
+	n := len(body) + 2
+	e.w.Write([]byte{0xff, marker, byte(n >> 8), byte(n)})
+	e.w.Write(body)
+}
+
+func (e *encoder) writeDQT() {
+	var body []byte
+	for t := 0; t < len(e.comps) && t < 2; t++ {
+		body = append(body, byte(t))
+		for _, n := range unzig {
+			body = append(body, byte(e.quant[t][n]))
+		}
+	}
+	e.segment(0xdb, body)
+}
+
+func (e *encoder) writeSOF(w, h int, progressive bool) {
+	marker := byte(0xc0)
+	if progressive {
+		marker = 0xc2
+	}
+	body := []byte{8, byte(h >> 8), byte(h), byte(w >> 8), byte(w), byte(len(e.comps))}
+	for _, c := range e.comps {
+		body = append(body, c.id, byte(c.h<<4|c.v), byte(c.table))
+	}
+	e.segment(marker, body)
+}
+
+// writeScan writes one scan of coefficients ss..se of comps, with
+// Huffman tables optimized for it.
+func (e *encoder) writeScan(comps []*component, ss, se int) {
+	e.freq = [4][256]int64{}
+	e.counting = true
+	e.scan(comps, ss, se)
+	e.counting = false
+
+	var dht []byte
+	used := [4]bool{}
+	for _, c := range comps {
+		if ss == 0 {
+			used[c.table] = true
+		}
+		if se > 0 {
+			used[2+c.table] = true
+		}
+	}
+	for t, u := range used {
+		if u {
+			e.tables[t] = optimalTable(&e.freq[t])
+			dht = append(dht, e.tables[t].segment(t/2, t%2)...)
+		}
+	}
+	e.segment(0xc4, dht)
+
+	sos := []byte{byte(len(comps))}
+	for _, c := range comps {
+		sos = append(sos, c.id, byte(c.table<<4|c.table))
+	}
+	sos = append(sos, byte(ss), byte(se), 0)
+	e.segment(0xda, sos)
+	e.scan(comps, ss, se)
+	e.flush()
+}
+
+// scan codes the blocks of a scan in order: by MCU when it has several
+// components, otherwise block by block over the component.
+func (e *encoder) scan(comps []*component, ss, se int) {
+	pred := make([]int32, len(comps))
+	e.eobrun = 0
+	if len(comps) == 1 {
+		c := comps[0]
+		for by := 0; by < c.sh; by++ {
+			for bx := 0; bx < c.sw; bx++ {
+				e.block(c, &c.blocks[by*c.bw+bx], &pred[0], ss, se)
+			}
+		}
+	} else {
+		for my := 0; my < e.mcuy; my++ {
+			for mx := 0; mx < e.mcux; mx++ {
+				for i, c := range comps {
+					for v := 0; v < c.v; v++ {
+						for h := 0; h < c.h; h++ {
+							e.block(c, &c.blocks[(my*c.v+v)*c.bw+mx*c.h+h], &pred[i], ss, se)
+						}
+					}
+				}
+			}
+		}
+	}
+	e.emitEOBRun(comps[0])
+}
+
+func (e *encoder) block(c *component, b *[64]int32, pred *int32, ss, se int) {
+	baseline := ss == 0
+	if ss == 0 {
+		d := b[0] - *pred
+		*pred = b[0]
+		n := bitLength(d)
+		e.symbol(c.table, n)
+		e.value(d, n)
+		if se == 0 {
+			return
+		}
+		ss = 1
+	}
+	ac := 2 + c.table
+	run := 0
+	for k := ss; k <= se; k++ {
+		v := b[k]
+		if v == 0 {
+			run++
+			continue
+		}
+		e.emitEOBRun(c)
+		for ; run > 15; run -= 16 {
+			e.symbol(ac, 0xf0)
+		}
+		n := bitLength(v)
+		e.symbol(ac, run<<4|n)
+		e.value(v, n)
+		run = 0
+	}
+	switch {
+	case run == 0:
+	case baseline:
+		e.symbol(ac, 0x00)
+	default:
+		if e.eobrun++; e.eobrun == 0x7fff {
+			e.emitEOBRun(c)
+		}
+	}
+}
+
+// emitEOBRun codes the pending end-of-band run of a progressive scan.
+func (e *encoder) emitEOBRun(c *component) {
+	if e.eobrun == 0 {
+		return
+	}
+	n := bitLength(int32(e.eobrun)) - 1
+	e.symbol(2+c.table, n<<4)
+	e.bits(uint32(e.eobrun), uint(n))
+	e.eobrun = 0
+}
+
+// symbol codes s with Huffman table t, or counts it.
+func (e *encoder) symbol(t, s int) {
+	if e.counting {
+		e.freq[t][s]++
+		return
+	}
+	e.bits(uint32(e.tables[t].code[s]), uint(e.tables[t].size[s]))
+}
+
+// value writes the n low bits of v in the JPEG magnitude encoding.
+func (e *encoder) value(v int32, n int) {
+	if v < 0 {
+		v--
+	}
+	e.bits(uint32(v), uint(n))
+}
+
+func (e *encoder) bits(v uint32, n uint) {
+	if e.counting || n == 0 {
+		return
+	}
+	e.acc = e.acc<<n | v&(1<<n-1)
+	for e.nacc += n; e.nacc >= 8; e.nacc -= 8 {
+		b := byte(e.acc >> (e.nacc - 8))
+		e.w.WriteByte(b)
+		if b == 0xff {
+			e.w.WriteByte(0)
+		}
+	}
+	e.acc &= 1<<e.nacc - 1
+}
+
+// flush pads the last byte of a scan with one bits.
+func (e *encoder) flush() {
+	if e.nacc > 0 {
+		e.bits(1<<(8-e.nacc)-1, 8-e.nacc)
+	}
+}
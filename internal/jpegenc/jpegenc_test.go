@@ -0,0 +1,123 @@
+package jpegenc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+// gradient returns a smooth test image whose size is not a multiple of
+// the 16x16 MCU.
+func gradient(gray bool) image.Image {
+	r := image.Rect(0, 0, 45, 29)
+	if gray {
+		img := image.NewGray(r)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				img.SetGray(x, y, color.Gray{uint8(x*4 + y*2)})
+			}
+		}
+		return img
+	}
+	img := image.NewRGBA(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 5), uint8(y * 8), uint8(128 + x - y), 255})
+		}
+	}
+	return img
+}
+
+// meanError returns the mean absolute difference between the channels
+// of a and b, in 8-bit steps.
+func meanError(a, b image.Image) float64 {
+	var sum float64
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			r1, g1, b1, _ := a.At(x, y).RGBA()
+			r2, g2, b2, _ := b.At(x, y).RGBA()
+			for _, d := range []float64{float64(r1) - float64(r2), float64(g1) - float64(g2), float64(b1) - float64(b2)} {
+				sum += math.Abs(d) / 257
+			}
+		}
+	}
+	return sum / float64(3*r.Dx()*r.Dy())
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, gray := range []bool{false, true} {
+		img := gradient(gray)
+		for _, o := range []Options{
+			{},
+			{Quality: 95},
+			{Quality: 95, Progressive: true},
+			{Quality: 95, Trellis: true},
+			{Quality: 95, Progressive: true, Trellis: true},
+		} {
+			var buf bytes.Buffer
+			if err := Encode(&buf, img, &o); err != nil {
+				t.Fatalf("gray %v, %+v: %v", gray, o, err)
+			}
+			got, err := jpeg.Decode(&buf)
+			if err != nil {
+				t.Errorf("gray %v, %+v: decode: %v", gray, o, err)
+				continue
+			}
+			if got.Bounds() != img.Bounds() {
+				t.Errorf("gray %v, %+v: decoded %v, want %v", gray, o, got.Bounds(), img.Bounds())
+				continue
+			}
+			if _, ok := got.(*image.Gray); ok != gray {
+				t.Errorf("gray %v, %+v: decoded as %T", gray, o, got)
+			}
+			// The error is compared with that of image/jpeg at the same
+			// quality, which also subsamples chroma.
+			q := o.Quality
+			if q == 0 {
+				q = 75
+			}
+			var ref bytes.Buffer
+			if err := jpeg.Encode(&ref, img, &jpeg.Options{Quality: q}); err != nil {
+				t.Fatal(err)
+			}
+			want, err := jpeg.Decode(&ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			limit := meanError(img, want)*1.1 + 0.1
+			if e := meanError(img, got); e > limit {
+				t.Errorf("gray %v, %+v: mean error %.2f, want at most %.2f", gray, o, e, limit)
+			}
+		}
+	}
+}
+
+func TestQualitySize(t *testing.T) {
+	img := gradient(false)
+	prev := 0
+	for _, q := range []int{10, 50, 90, 100} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, &Options{Quality: q}); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() <= prev {
+			t.Errorf("quality %d gave %d bytes, no more than the %d of a lower quality", q, buf.Len(), prev)
+		}
+		prev = buf.Len()
+	}
+}
+
+func TestEncodeRejectsSize(t *testing.T) {
+	for _, r := range []image.Rectangle{
+		image.Rect(0, 0, 0, 0),
+		image.Rect(0, 0, 1<<16, 1),
+	} {
+		if err := Encode(&bytes.Buffer{}, image.NewGray(r), nil); err == nil {
+			t.Errorf("%v image encoded without error", r)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package tiffio
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+)
+
+// seekBuffer is an in-memory io.WriteSeeker.
+type seekBuffer struct {
+	data []byte
+	pos  int
+}
+
+func (b *seekBuffer) Write(p []byte) (int, error) {
+	if n := b.pos + len(p); n > len(b.data) {
+		b.data = append(b.data, make([]byte, n-len(b.data))...)
+	}
+	copy(b.data[b.pos:], p)
+	b.pos += len(p)
+	return len(p), nil
+}
+
+func (b *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += int64(b.pos)
+	case io.SeekEnd:
+		offset += int64(len(b.data))
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before start")
+	}
+	b.pos = int(offset)
+	return offset, nil
+}
+
+// testImages returns small images of each pixel format the writer
+// stores, with sizes that do not divide into strips or tiles evenly.
+func testImages() map[string]image.Image {
+	r := image.Rect(0, 0, 37, 21)
+	gray, gray16 := image.NewGray(r), image.NewGray16(r)
+	rgba, nrgba, nrgba64 := image.NewRGBA(r), image.NewNRGBA(r), image.NewNRGBA64(r)
+	cmyk := image.NewCMYK(r)
+	pal := image.NewPaletted(r, color.Palette{color.Black, color.White, color.RGBA{200, 30, 90, 255}})
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			v := uint8(x*7 + y*13)
+			gray.SetGray(x, y, color.Gray{v})
+			gray16.SetGray16(x, y, color.Gray16{uint16(x*1800 + y*97)})
+			rgba.SetRGBA(x, y, color.RGBA{v, uint8(x * 5), uint8(y * 11), 255})
+			nrgba.SetNRGBA(x, y, color.NRGBA{v, uint8(x * 5), uint8(y * 11), uint8(x * 6)})
+			nrgba64.SetNRGBA64(x, y, color.NRGBA64{uint16(x * 1700), uint16(y * 3000), 0x8000, 0xffff})
+			cmyk.SetCMYK(x, y, color.CMYK{v, uint8(x * 5), uint8(y * 11), 40})
+			pal.SetColorIndex(x, y, uint8((x+y)%3))
+		}
+	}
+	return map[string]image.Image{
+		"gray": gray, "gray16": gray16, "rgba": rgba, "nrgba": nrgba,
+		"nrgba64": nrgba64, "cmyk": cmyk, "paletted": pal,
+	}
+}
+
+// sameImage reports the first pixel at which a and b differ.
+func sameImage(a, b image.Image) (image.Point, bool) {
+	if a.Bounds() != b.Bounds() {
+		return image.Point{}, false
+	}
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			ca := color.NRGBA64Model.Convert(a.At(x, y))
+			cb := color.NRGBA64Model.Convert(b.At(x, y))
+			if ca != cb {
+				return image.Pt(x, y), false
+			}
+		}
+	}
+	return image.Point{}, true
+}
+
+func TestRoundTrip(t *testing.T) {
+	layouts := []struct {
+		name string
+		opts ImageOptions
+	}{
+		{"strips", ImageOptions{}},
+		{"uncompressed", ImageOptions{Compression: CompressionNone}},
+		{"predictor", ImageOptions{Predictor: true}},
+		{"tiles", ImageOptions{TileSize: 16, Predictor: true}},
+		{"large tiles", ImageOptions{TileSize: 512}},
+	}
+	for name, img := range testImages() {
+		for _, l := range layouts {
+			for _, big := range []bool{false, true} {
+				var buf seekBuffer
+				w, err := NewWriter(&buf, big)
+				if err != nil {
+					t.Fatal(err)
+				}
+				opts := l.opts
+				if err := w.WriteImage(img, &opts); err != nil {
+					t.Errorf("%s, %s, big %v: %v", name, l.name, big, err)
+					continue
+				}
+				f, err := Open(bytes.NewReader(buf.data))
+				if err != nil {
+					t.Errorf("%s, %s, big %v: Open: %v", name, l.name, big, err)
+					continue
+				}
+				if f.Big != big || len(f.IFDs) != 1 {
+					t.Errorf("%s, %s, big %v: read Big %v with %d directories", name, l.name, big, f.Big, len(f.IFDs))
+					continue
+				}
+				got, err := f.Decode(f.IFDs[0])
+				if err != nil {
+					t.Errorf("%s, %s, big %v: Decode: %v", name, l.name, big, err)
+					continue
+				}
+				if at, ok := sameImage(img, got); !ok {
+					t.Errorf("%s, %s, big %v: decoded %v differs at %v", name, l.name, big, got.Bounds(), at)
+				}
+			}
+		}
+	}
+}
+
+func TestRoundTripDirectories(t *testing.T) {
+	imgs := testImages()
+	full, half := imgs["rgba"], image.NewGray(image.Rect(0, 0, 19, 11))
+
+	// The file starts after other data, as when appended to a stream.
+	buf := &seekBuffer{data: []byte("prefix")}
+	buf.pos = len(buf.data)
+	w, err := NewWriter(buf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra := map[uint16]Entry{TagSoftware: ASCII("tiffio test"), TagModelPixelScale: Doubles(0.5, 0.25, 0)}
+	if err := w.WriteImage(full, &ImageOptions{Extra: extra}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteImage(half, &ImageOptions{Reduced: true, TileSize: 16}); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Open(bytes.NewReader(buf.data[len("prefix"):]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.IFDs) != 2 {
+		t.Fatalf("read %d directories, want 2", len(f.IFDs))
+	}
+	d0, d1 := f.IFDs[0], f.IFDs[1]
+	if d0.Reduced() || !d1.Reduced() {
+		t.Errorf("Reduced = %v, %v, want false, true", d0.Reduced(), d1.Reduced())
+	}
+	if got := d0.ASCII(TagSoftware); got != "tiffio test" {
+		t.Errorf("Software = %q, want %q", got, "tiffio test")
+	}
+	if got := d0.Floats(TagModelPixelScale); len(got) != 3 || got[0] != 0.5 || got[1] != 0.25 {
+		t.Errorf("ModelPixelScale = %v, want [0.5 0.25 0]", got)
+	}
+	for i, want := range []image.Image{full, half} {
+		got, err := f.Decode(f.IFDs[i])
+		if err != nil {
+			t.Fatalf("directory %d: %v", i, err)
+		}
+		if at, ok := sameImage(want, got); !ok {
+			t.Errorf("directory %d: decoded %v differs at %v", i, got.Bounds(), at)
+		}
+	}
+
+	region := image.Rect(5, 3, 30, 17)
+	got, err := f.DecodeRegion(d0, region)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if at, ok := sameImage(full.(*image.RGBA).SubImage(region), got); !ok {
+		t.Errorf("region decoded as %v differs at %v", got.Bounds(), at)
+	}
+}
+
+func TestWriteRejectsTileSize(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	for _, size := range []int{8, 24, 65536} {
+		var buf seekBuffer
+		w, err := NewWriter(&buf, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteImage(img, &ImageOptions{TileSize: size}); err == nil {
+			t.Errorf("tile size %d accepted", size)
+		}
+	}
+}
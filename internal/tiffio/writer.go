@@ -0,0 +1,481 @@
+package tiffio
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+)
+
+// ImageOptions configures how WriteImage stores an image.
+type ImageOptions struct {
+	Compression uint16           // CompressionNone or CompressionDeflate, default Deflate
+	Predictor   bool             // apply horizontal differencing before compression
+	TileSize    int              // write square tiles of this size (a multiple of 16, at most 65535) instead of strips, cut to the image size
+	Reduced     bool             // mark the image as a reduced-resolution version of the first
+	Extra       map[uint16]Entry // additional tags, with little-endian values
+}
+
+// stripBytes is the target uncompressed size of a strip.
+const stripBytes = 64 << 10
+
+// maxTileSize is the largest tile size, as TileWidth and TileLength are
+// written as SHORT values.
+const maxTileSize = 65535
+
+// Writer writes TIFF files one image at a time. Pixel data is encoded
+// and written strip by strip or tile by tile, so only the directory
+// offsets are kept in memory.
+type Writer struct {
+	w    io.WriteSeeker
+	big  bool
+	base int64 // position of the header in w, from which offsets count
+	off  int64 // offset of the end of the file
+	next int64 // offset of the pointer to the next directory
+}
+
+var le = binary.LittleEndian
+
+// NewWriter writes a little-endian TIFF header to w at its current
+// position, which offsets in the file are then relative to. If big is
+// set, the file is written as BigTIFF, which is not limited to 4 GiB.
+// It fails if w cannot report its position, as pipes cannot.
+func NewWriter(w io.WriteSeeker, big bool) (*Writer, error) {
+	base, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	tw := &Writer{w: w, big: big, base: base}
+	var hdr []byte
+	if big {
+		hdr = make([]byte, 16)
+		copy(hdr, "II")
+		le.PutUint16(hdr[2:], 43)
+		le.PutUint16(hdr[4:], 8)
+		tw.next = 8
+	} else {
+		hdr = make([]byte, 8)
+		copy(hdr, "II")
+		le.PutUint16(hdr[2:], 42)
+		tw.next = 4
+	}
+	return tw, tw.write(hdr)
+}
+
+func (w *Writer) write(b []byte) error {
+	n, err := w.w.Write(b)
+	w.off += int64(n)
+	if err == nil && !w.big && w.off > 1<<32-1 {
+		err = errors.New("tiffio: file too large for classic tiff")
+	}
+	return err
+}
+
+// align pads the file to an even offset, as TIFF requires for
+// directories and values.
+func (w *Writer) align() error {
+	if w.off%2 == 0 {
+		return nil
+	}
+	return w.write([]byte{0})
+}
+
+func (w *Writer) offsetSize() int {
+	if w.big {
+		return 8
+	}
+	return 4
+}
+
+// pixelFormat describes how pixels are stored as samples.
+type pixelFormat struct {
+	photometric uint16
+	samples     int
+	depth       int
+	alpha       bool
+	palette     color.Palette
+}
+
+func formatOf(img image.Image) pixelFormat {
+	switch m := img.(type) {
+	case *image.Paletted:
+		if len(m.Palette) <= 256 && opaquePalette(m.Palette) {
+			return pixelFormat{photometric: 3, samples: 1, depth: 8, palette: m.Palette}
+		}
+	case *image.Gray:
+		return pixelFormat{photometric: 1, samples: 1, depth: 8}
+	case *image.Gray16:
+		return pixelFormat{photometric: 1, samples: 1, depth: 16}
+	case *image.CMYK:
+		return pixelFormat{photometric: 5, samples: 4, depth: 8}
+	}
+	f := pixelFormat{photometric: 2, samples: 3, depth: 8}
+	switch img.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model, color.Alpha16Model:
+		f.depth = 16
+	}
+	if !opaque(img) {
+		f.samples, f.alpha = 4, true
+	}
+	return f
+}
+
+func opaquePalette(p color.Palette) bool {
+	for _, c := range p {
+		if _, _, _, a := c.RGBA(); a != 0xffff {
+			return false
+		}
+	}
+	return true
+}
+
+func opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// WriteImage appends img to the file as a new directory.
+func (w *Writer) WriteImage(img image.Image, opts *ImageOptions) error {
+	if opts == nil {
+		opts = &ImageOptions{}
+	}
+	compression := opts.Compression
+	if compression == 0 {
+		compression = CompressionDeflate
+	}
+	if compression != CompressionNone && compression != CompressionDeflate {
+		return ErrUnsupported
+	}
+	if opts.TileSize < 0 || opts.TileSize%16 != 0 {
+		return errors.New("tiffio: tile size must be a multiple of 16")
+	}
+	if opts.TileSize > maxTileSize {
+		return errors.New("tiffio: tile size is over 65535")
+	}
+
+	b := img.Bounds()
+	if b.Empty() {
+		return errors.New("tiffio: empty image")
+	}
+	pf := formatOf(img)
+	bpp := pf.samples * pf.depth / 8
+	chunkW, chunkH := b.Dx(), stripBytes/(b.Dx()*bpp)
+	if opts.TileSize > 0 {
+		// Tiles no larger than the image, rounded up to 16, hold it
+		// just as well.
+		chunkW, chunkH = min16(opts.TileSize, b.Dx()), min16(opts.TileSize, b.Dy())
+	}
+	if chunkH < 1 {
+		chunkH = 1
+	}
+	if chunkH > b.Dy() && opts.TileSize == 0 {
+		chunkH = b.Dy()
+	}
+
+	across := (b.Dx() + chunkW - 1) / chunkW
+	down := (b.Dy() + chunkH - 1) / chunkH
+	offsets := make([]uint64, 0, across*down)
+	counts := make([]uint64, 0, across*down)
+	var buf bytes.Buffer
+	for cy := 0; cy < down; cy++ {
+		for cx := 0; cx < across; cx++ {
+			rows := chunkH
+			if opts.TileSize == 0 && (cy+1)*chunkH > b.Dy() {
+				rows = b.Dy() - cy*chunkH
+			}
+			raw := make([]byte, chunkW*rows*bpp)
+			origin := b.Min.Add(image.Pt(cx*chunkW, cy*chunkH))
+			fillChunk(raw, img, pf, origin, chunkW, rows)
+			if opts.Predictor {
+				applyPredictor(raw, chunkW*bpp, bpp, pf.depth)
+			}
+
+			data := raw
+			if compression == CompressionDeflate {
+				buf.Reset()
+				zw := zlib.NewWriter(&buf)
+				zw.Write(raw)
+				if err := zw.Close(); err != nil {
+					return err
+				}
+				data = buf.Bytes()
+			}
+			if err := w.align(); err != nil {
+				return err
+			}
+			offsets = append(offsets, uint64(w.off))
+			counts = append(counts, uint64(len(data)))
+			if err := w.write(data); err != nil {
+				return err
+			}
+		}
+	}
+
+	entries := map[uint16]Entry{
+		TagImageWidth:      w.longs(uint64(b.Dx())),
+		TagImageLength:     w.longs(uint64(b.Dy())),
+		TagCompression:     Shorts(compression),
+		TagPhotometric:     Shorts(pf.photometric),
+		TagSamplesPerPixel: Shorts(uint16(pf.samples)),
+		TagPlanarConfig:    Shorts(1),
+		TagXResolution:     rational(72, 1),
+		TagYResolution:     rational(72, 1),
+		TagResolutionUnit:  Shorts(2),
+	}
+	depths := make([]uint16, pf.samples)
+	for i := range depths {
+		depths[i] = uint16(pf.depth)
+	}
+	entries[TagBitsPerSample] = Shorts(depths...)
+	if opts.Reduced {
+		entries[TagNewSubfileType] = w.longs(1)
+	}
+	if opts.Predictor {
+		entries[TagPredictor] = Shorts(2)
+	}
+	if pf.alpha {
+		entries[TagExtraSamples] = Shorts(2) // unassociated alpha
+	}
+	if pf.palette != nil {
+		cmap := make([]uint16, 3*256)
+		for i, c := range pf.palette {
+			r, g, bl, _ := c.RGBA()
+			cmap[i], cmap[256+i], cmap[512+i] = uint16(r), uint16(g), uint16(bl)
+		}
+		entries[TagColorMap] = Shorts(cmap...)
+	}
+	if opts.TileSize > 0 {
+		entries[TagTileWidth] = Shorts(uint16(chunkW))
+		entries[TagTileLength] = Shorts(uint16(chunkH))
+		entries[TagTileOffsets] = w.offsets(offsets)
+		entries[TagTileByteCounts] = w.offsets(counts)
+	} else {
+		entries[TagRowsPerStrip] = w.longs(uint64(chunkH))
+		entries[TagStripOffsets] = w.offsets(offsets)
+		entries[TagStripByteCounts] = w.offsets(counts)
+	}
+	for tag, e := range opts.Extra {
+		entries[tag] = e
+	}
+	return w.writeIFD(entries)
+}
+
+// min16 returns the smaller of size and n rounded up to a multiple of
+// 16.
+func min16(size, n int) int {
+	if n = (n + 15) &^ 15; n < size {
+		return n
+	}
+	return size
+}
+
+// writeIFD writes a directory and links it into the chain.
+func (w *Writer) writeIFD(entries map[uint16]Entry) error {
+	if err := w.align(); err != nil {
+		return err
+	}
+	tags := make([]int, 0, len(entries))
+	for tag := range entries {
+		tags = append(tags, int(tag))
+	}
+	sort.Ints(tags)
+
+	osz := w.offsetSize()
+	countSize, entrySize := 2, 12
+	if w.big {
+		countSize, entrySize = 8, 20
+	}
+	start := w.off
+	dir := make([]byte, countSize+len(tags)*entrySize+osz)
+	var values []byte
+	valueOff := start + int64(len(dir))
+	if w.big {
+		le.PutUint64(dir, uint64(len(tags)))
+	} else {
+		le.PutUint16(dir, uint16(len(tags)))
+	}
+	for i, tag := range tags {
+		e := entries[uint16(tag)]
+		p := dir[countSize+i*entrySize:]
+		le.PutUint16(p, uint16(tag))
+		le.PutUint16(p[2:], e.Type)
+		field := p[8:12]
+		if w.big {
+			le.PutUint64(p[4:], e.Count)
+			field = p[12:20]
+		} else {
+			le.PutUint32(p[4:], uint32(e.Count))
+		}
+		if len(e.Value) <= osz {
+			copy(field, e.Value)
+			continue
+		}
+		off := uint64(valueOff + int64(len(values)))
+		if w.big {
+			le.PutUint64(field, off)
+		} else {
+			le.PutUint32(field, uint32(off))
+		}
+		values = append(values, e.Value...)
+		if len(values)%2 != 0 {
+			values = append(values, 0)
+		}
+	}
+	if err := w.write(dir); err != nil {
+		return err
+	}
+	if err := w.write(values); err != nil {
+		return err
+	}
+
+	// Point the previous directory, or the header, at this one.
+	ptr := make([]byte, osz)
+	if w.big {
+		le.PutUint64(ptr, uint64(start))
+	} else {
+		le.PutUint32(ptr, uint32(start))
+	}
+	if _, err := w.w.Seek(w.base+w.next, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(ptr); err != nil {
+		return err
+	}
+	if _, err := w.w.Seek(w.base+w.off, io.SeekStart); err != nil {
+		return err
+	}
+	w.next = start + int64(countSize+len(tags)*entrySize)
+	return nil
+}
+
+// fillChunk writes the samples of the w×h pixels at origin into raw.
+// Pixels outside the image, as in edge tiles, are left zero.
+func fillChunk(raw []byte, img image.Image, pf pixelFormat, origin image.Point, w, h int) {
+	b := img.Bounds()
+	bpp := pf.samples * pf.depth / 8
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := origin.Add(image.Pt(x, y))
+			if !p.In(b) {
+				continue
+			}
+			out := raw[(y*w+x)*bpp:]
+			if pf.palette != nil {
+				out[0] = img.(*image.Paletted).ColorIndexAt(p.X, p.Y)
+				continue
+			}
+			if pf.photometric == 5 {
+				c := img.(*image.CMYK).CMYKAt(p.X, p.Y)
+				out[0], out[1], out[2], out[3] = c.C, c.M, c.Y, c.K
+				continue
+			}
+			var s [4]uint16
+			if pf.samples == 1 {
+				s[0] = color.Gray16Model.Convert(img.At(p.X, p.Y)).(color.Gray16).Y
+			} else if c, ok := img.At(p.X, p.Y).(color.NRGBA); ok {
+				// Converting through premultiplied RGBA would round
+				// translucent colors.
+				s = [4]uint16{uint16(c.R) * 0x101, uint16(c.G) * 0x101, uint16(c.B) * 0x101, uint16(c.A) * 0x101}
+			} else {
+				c := color.NRGBA64Model.Convert(img.At(p.X, p.Y)).(color.NRGBA64)
+				s = [4]uint16{c.R, c.G, c.B, c.A}
+			}
+			for i := 0; i < pf.samples; i++ {
+				if pf.depth == 8 {
+					out[i] = uint8(s[i] >> 8)
+				} else {
+					le.PutUint16(out[2*i:], s[i])
+				}
+			}
+		}
+	}
+}
+
+func applyPredictor(data []byte, rowBytes, bpp, depth int) {
+	for row := 0; row+rowBytes <= len(data); row += rowBytes {
+		line := data[row : row+rowBytes]
+		if depth == 8 {
+			for i := len(line) - 1; i >= bpp; i-- {
+				line[i] -= line[i-bpp]
+			}
+			continue
+		}
+		for i := len(line) - 2; i >= bpp; i -= 2 {
+			le.PutUint16(line[i:], le.Uint16(line[i:])-le.Uint16(line[i-bpp:]))
+		}
+	}
+}
+
+// Shorts returns an entry holding SHORT values.
+func Shorts(v ...uint16) Entry {
+	b := make([]byte, 2*len(v))
+	for i, x := range v {
+		le.PutUint16(b[2*i:], x)
+	}
+	return Entry{Type: TypeShort, Count: uint64(len(v)), Value: b}
+}
+
+// Doubles returns an entry holding DOUBLE values.
+func Doubles(v ...float64) Entry {
+	b := make([]byte, 8*len(v))
+	for i, x := range v {
+		le.PutUint64(b[8*i:], math.Float64bits(x))
+	}
+	return Entry{Type: TypeDouble, Count: uint64(len(v)), Value: b}
+}
+
+// ASCII returns an entry holding a NUL-terminated string.
+func ASCII(s string) Entry {
+	return Entry{Type: TypeASCII, Count: uint64(len(s) + 1), Value: append([]byte(s), 0)}
+}
+
+func rational(num, den uint32) Entry {
+	b := make([]byte, 8)
+	le.PutUint32(b, num)
+	le.PutUint32(b[4:], den)
+	return Entry{Type: TypeRational, Count: 1, Value: b}
+}
+
+// longs encodes LONG values, or LONG8 values in BigTIFF files when
+// they do not fit.
+func (w *Writer) longs(v ...uint64) Entry {
+	big := false
+	for _, x := range v {
+		big = big || x > 1<<32-1
+	}
+	if big && w.big {
+		return w.offsets(v)
+	}
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		le.PutUint32(b[4*i:], uint32(x))
+	}
+	return Entry{Type: TypeLong, Count: uint64(len(v)), Value: b}
+}
+
+// offsets encodes file offsets and byte counts.
+func (w *Writer) offsets(v []uint64) Entry {
+	if !w.big {
+		return w.longs(v...)
+	}
+	b := make([]byte, 8*len(v))
+	for i, x := range v {
+		le.PutUint64(b[8*i:], x)
+	}
+	return Entry{Type: TypeLong8, Count: uint64(len(v)), Value: b}
+}
@@ -0,0 +1,113 @@
+package convert
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// Kernel is a resampling filter. At is evaluated for |x| < Support and
+// is assumed to be zero elsewhere; it should be symmetric around zero.
+type Kernel interface {
+	Support() float64
+	At(x float64) float64
+}
+
+// KernelFunc adapts a function to the Kernel interface.
+type KernelFunc struct {
+	Radius float64
+	F      func(x float64) float64
+}
+
+func (k KernelFunc) Support() float64     { return k.Radius }
+func (k KernelFunc) At(x float64) float64 { return k.F(x) }
+
+// BCKernel is a Mitchell-Netravali cubic filter with parameters B and C.
+// B=1/3, C=1/3 is the Mitchell filter, B=1, C=0 the cubic B-spline and
+// B=0, C=0.5 Catmull-Rom.
+type BCKernel struct {
+	B, C float64
+}
+
+func (k BCKernel) Support() float64 { return 2 }
+
+func (k BCKernel) At(x float64) float64 {
+	b, c := k.B, k.C
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return ((12-9*b-6*c)*x*x*x + (-18+12*b+6*c)*x*x + (6 - 2*b)) / 6
+	case x < 2:
+		return ((-b-6*c)*x*x*x + (6*b+30*c)*x*x + (-12*b-48*c)*x + (8*b + 24*c)) / 6
+	}
+	return 0
+}
+
+// LanczosKernel is a windowed sinc filter with A lobes.
+type LanczosKernel struct {
+	A int
+}
+
+func (k LanczosKernel) Support() float64 { return float64(k.A) }
+
+func (k LanczosKernel) At(x float64) float64 {
+	a := float64(k.A)
+	if x == 0 {
+		return 1
+	}
+	if x <= -a || x >= a {
+		return 0
+	}
+	px := math.Pi * x
+	return a * math.Sin(px) * math.Sin(px/a) / (px * px)
+}
+
+// Built-in kernels.
+var (
+	Box        Kernel = KernelFunc{0.5, func(x float64) float64 { return 1 }}
+	Triangle   Kernel = KernelFunc{1, func(x float64) float64 { return 1 - math.Abs(x) }}
+	CatmullRom Kernel = BCKernel{B: 0, C: 0.5}
+	Mitchell   Kernel = BCKernel{B: 1.0 / 3, C: 1.0 / 3}
+	BSpline    Kernel = BCKernel{B: 1, C: 0}
+	Lanczos3   Kernel = LanczosKernel{A: 3}
+)
+
+var kernels = struct {
+	sync.RWMutex
+	m map[string]Kernel
+}{m: map[string]Kernel{
+	"box":        Box,
+	"triangle":   Triangle,
+	"catmullrom": CatmullRom,
+	"mitchell":   Mitchell,
+	"bspline":    BSpline,
+	"lanczos3":   Lanczos3,
+}}
+
+// RegisterKernel makes a kernel available by name to LookupKernel,
+// replacing any kernel previously registered under that name.
+func RegisterKernel(name string, k Kernel) {
+	kernels.Lock()
+	kernels.m[name] = k
+	kernels.Unlock()
+}
+
+// LookupKernel returns the kernel registered under name.
+func LookupKernel(name string) (Kernel, bool) {
+	kernels.RLock()
+	k, ok := kernels.m[name]
+	kernels.RUnlock()
+	return k, ok
+}
+
+// KernelNames returns the names of all registered kernels.
+func KernelNames() []string {
+	kernels.RLock()
+	names := make([]string, 0, len(kernels.m))
+	for name := range kernels.m {
+		names = append(names, name)
+	}
+	kernels.RUnlock()
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+// +build !windows
+
+package convert
+
+// longPath returns p; only Windows limits path length.
+func longPath(p string) string { return p }
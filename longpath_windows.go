@@ -0,0 +1,24 @@
+package convert
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// longPath returns p in the \\?\ form that lifts the MAX_PATH limit if
+// it is long enough to need it. The os package only does this for
+// absolute paths, so long relative paths would otherwise fail. UNC
+// paths (\\server\share\...) become \\?\UNC\server\share\....
+func longPath(p string) string {
+	if len(p) < 248 || strings.HasPrefix(p, `\\?\`) || strings.HasPrefix(p, `\\.\`) {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
@@ -0,0 +1,69 @@
+package convert
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLongPath(t *testing.T) {
+	long := strings.Repeat(`abcdefghij\`, 25) + "a.png"
+	abs, err := filepath.Abs(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name, in, want string
+	}{
+		{"short", `dir\a.png`, `dir\a.png`},
+		{"long relative", long, `\\?\` + abs},
+		{"long absolute", `C:\` + long, `\\?\C:\` + long},
+		{"long UNC", `\\server\share\` + long, `\\?\UNC\server\share\` + long},
+		{"already long form", `\\?\C:\` + long, `\\?\C:\` + long},
+		{"already long UNC form", `\\?\UNC\server\share\` + long, `\\?\UNC\server\share\` + long},
+		{"device path", `\\.\` + long, `\\.\` + long},
+	} {
+		if got := longPath(tc.in); got != tc.want {
+			t.Errorf("%s: longPath(%q) = %q, want %q", tc.name, tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestConvertFileLongRelativePath(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	dir := filepath.Join(strings.Repeat("d", 100), strings.Repeat("e", 100), strings.Repeat("f", 100))
+	if err := os.MkdirAll(longPath(dir), 0777); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 8, 8)), PNG, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	in, out := filepath.Join(dir, "in.png"), filepath.Join(dir, "out.jpg")
+	if err := os.WriteFile(longPath(in), buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ConvertFile(in, out, Options{PreserveTimes: true, ChecksumSidecar: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(longPath(out + ".sha256")); err != nil {
+		t.Errorf("checksum sidecar: %v", err)
+	}
+	if _, err := FileSHA256(out); err != nil {
+		t.Error(err)
+	}
+	if !UpToDate(in, out) {
+		t.Error("UpToDate = false, want true")
+	}
+}
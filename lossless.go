@@ -0,0 +1,162 @@
+package convert
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// ErrNotLossless is returned when Options.Lossless is set and the
+// requested conversion cannot preserve every pixel.
+var ErrNotLossless = errors.New("conversion is not lossless")
+
+// VerifyError is returned when Options.Verify is set and the re-decoded
+// output differs from the input.
+type VerifyError struct {
+	X, Y      int
+	Want, Got color.Color
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("verify: pixel (%d,%d) is %v, want %v", e.X, e.Y, e.Got, e.Want)
+}
+
+// losslessImage checks that encoding img in format with opts preserves
+// every pixel exactly. For palette formats it returns an equivalent
+// *image.Paletted with an exact palette, since the encoders would
+// otherwise quantize.
+func losslessImage(img image.Image, format Format, opts Options) (image.Image, error) {
+	switch format {
+	case JPEG, ANSI, ASCII, Sixel:
+		return nil, fmt.Errorf("%w: %s is a lossy format", ErrNotLossless, format)
+	case GIF:
+		return exactPaletted(img, opts.Colors)
+	case PNG:
+		if opts.Indexed {
+			return exactPaletted(img, opts.Colors)
+		}
+	case BMP:
+		if isDeep(img) {
+			return nil, fmt.Errorf("%w: bmp does not support 16-bit samples", ErrNotLossless)
+		}
+		if !isOpaque(img) {
+			return nil, fmt.Errorf("%w: bmp does not support transparency", ErrNotLossless)
+		}
+	}
+	return img, nil
+}
+
+// exactPaletted converts img to a palette image without loss, failing
+// if it needs more than n colors or has partially transparent pixels.
+func exactPaletted(img image.Image, n int) (*image.Paletted, error) {
+	if n <= 0 || n > 256 {
+		n = 256
+	}
+	if p, ok := img.(*image.Paletted); ok && len(p.Palette) <= n {
+		return p, nil
+	}
+	if isDeep(img) {
+		return nil, fmt.Errorf("%w: palette images use 8-bit samples", ErrNotLossless)
+	}
+
+	b := img.Bounds()
+	dst := image.NewPaletted(b, nil)
+	index := make(map[color.RGBA]uint8)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			if c.A != 0 && c.A != 0xff {
+				return nil, fmt.Errorf("%w: palette images do not support partial transparency", ErrNotLossless)
+			}
+			i, ok := index[c]
+			if !ok {
+				if len(dst.Palette) == n {
+					return nil, fmt.Errorf("%w: image has more than %d colors", ErrNotLossless, n)
+				}
+				i = uint8(len(dst.Palette))
+				index[c] = i
+				dst.Palette = append(dst.Palette, c)
+			}
+			dst.SetColorIndex(x, y, i)
+		}
+	}
+	return dst, nil
+}
+
+// isOpaque reports whether every pixel of img is fully opaque.
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isDeep reports whether img has samples that do not survive
+// truncation to 8 bits.
+func isDeep(img image.Image) bool {
+	switch img.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model, color.Alpha16Model:
+	default:
+		return false
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			if c.R%0x101 != 0 || c.G%0x101 != 0 || c.B%0x101 != 0 || c.A%0x101 != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// encodeVerified encodes the processed img into a buffer, decodes the
+// result and compares it with img before writing it to w.
+func encodeVerified(w io.Writer, img image.Image, format Format, opts Options) error {
+	var buf bytes.Buffer
+	if err := encodeFormat(&buf, img, format, opts); err != nil {
+		return err
+	}
+	got, _, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	if err := compareImages(img, got); err != nil {
+		return err
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// compareImages returns a *VerifyError for the first pixel that differs
+// between want and got.
+func compareImages(want, got image.Image) error {
+	wb, gb := want.Bounds(), got.Bounds()
+	if wb.Size() != gb.Size() {
+		return fmt.Errorf("verify: size is %v, want %v", gb.Size(), wb.Size())
+	}
+	for y := 0; y < wb.Dy(); y++ {
+		for x := 0; x < wb.Dx(); x++ {
+			wc := want.At(wb.Min.X+x, wb.Min.Y+y)
+			gc := got.At(gb.Min.X+x, gb.Min.Y+y)
+			wr, wg, wbl, wa := wc.RGBA()
+			gr, gg, gbl, ga := gc.RGBA()
+			if wr != gr || wg != gg || wbl != gbl || wa != ga {
+				return &VerifyError{X: wb.Min.X + x, Y: wb.Min.Y + y, Want: wc, Got: gc}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package convert
+
+import (
+	"image"
+	"image/draw"
+)
+
+// RoundCorners returns img with its corners rounded to radius pixels,
+// leaving them transparent with antialiased edges.
+func RoundCorners(img image.Image, radius float64) image.Image {
+	b := img.Bounds()
+	return applyMask(img, shapeMask(b, roundedRect(b, radius)))
+}
+
+// Circle returns the largest centered square of img masked to the
+// circle inscribed in it, as for avatars.
+func Circle(img image.Image) image.Image {
+	b := img.Bounds()
+	n := minInt(b.Dx(), b.Dy())
+	r := image.Rect(0, 0, n, n).Add(b.Min).Add(image.Pt((b.Dx()-n)/2, (b.Dy()-n)/2))
+	return applyMask(img, shapeMask(r, ellipse(r)))
+}
+
+// ApplyMask returns img with its alpha multiplied by the alpha of mask,
+// which is resized to img's size if they differ.
+func ApplyMask(img, mask image.Image) image.Image {
+	b, mb := img.Bounds(), mask.Bounds()
+	if mb.Dx() != b.Dx() || mb.Dy() != b.Dy() {
+		mask = Resize(mask, b.Dx(), b.Dy())
+		mb = mask.Bounds()
+	}
+	m := image.NewAlpha(b)
+	draw.Draw(m, b, mask, mb.Min, draw.Src)
+	return applyMask(img, m)
+}
+
+// applyMask returns the part of img inside the mask's bounds with its
+// alpha multiplied by the mask.
+func applyMask(img image.Image, mask *image.Alpha) image.Image {
+	r := mask.Rect
+	dst := newRGBAFor(img, r.Sub(r.Min))
+	draw.DrawMask(dst, dst.Bounds(), img, r.Min, mask, r.Min, draw.Src)
+	return dst
+}
+
+// mask applies the corner radius, circle and mask options to img.
+func (opts Options) mask(img image.Image) image.Image {
+	if opts.Mask != nil {
+		img = ApplyMask(img, opts.Mask)
+	}
+	if opts.Circle {
+		img = Circle(img)
+	} else if opts.CornerRadius > 0 {
+		img = RoundCorners(img, opts.CornerRadius)
+	}
+	return img
+}
@@ -0,0 +1,32 @@
+package convert
+
+import (
+	"image"
+	"image/color"
+)
+
+// EstimateDecodedSize returns roughly how many bytes of pixel data
+// decoding an image described by cfg allocates, from its dimensions and
+// colour model, so that callers can refuse images that would not fit a
+// memory budget before decoding them. YCbCr images are counted without
+// chroma subsampling, so the estimate errs high for typical JPEGs.
+func EstimateDecodedSize(cfg image.Config) int64 {
+	return int64(cfg.Width) * int64(cfg.Height) * bytesPerPixel(cfg.ColorModel)
+}
+
+func bytesPerPixel(m color.Model) int64 {
+	switch m {
+	case color.GrayModel, color.AlphaModel:
+		return 1
+	case color.Gray16Model, color.Alpha16Model:
+		return 2
+	case color.YCbCrModel:
+		return 3
+	case color.RGBA64Model, color.NRGBA64Model:
+		return 8
+	}
+	if _, ok := m.(color.Palette); ok {
+		return 1
+	}
+	return 4
+}
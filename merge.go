@@ -0,0 +1,184 @@
+package convert
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// An OptionField names a field of Options, for Merge.
+type OptionField int
+
+const (
+	FieldQuality OptionField = iota
+	FieldColors
+	FieldQuantizer
+	FieldIndexed
+	FieldInterlace
+	FieldThumbnail
+	FieldBigTIFF
+	FieldTileSize
+	FieldTargetSSIM
+	FieldOptimizeCoding
+	FieldColumns
+	FieldTrueColor
+	FieldPyramid
+	FieldLinearLight
+	FieldKernel
+	FieldPixelFormat
+	FieldDither
+	FieldGeoTIFF
+	FieldLossless
+	FieldVerify
+	FieldAllowPassthrough
+	FieldValidatePassthrough
+	FieldSalvage
+	FieldAllowTruncated
+	FieldTruncatedFill
+	FieldPreserveTimes
+	FieldPreserveMode
+	FieldXattrs
+	FieldChecksumSidecar
+	FieldEncryptionKey
+	FieldTeeWriters
+	FieldWarnFunc
+	FieldOptimizeFrames
+	FieldFrameRate
+	FieldSpeed
+	FieldMaxDuration
+	FieldGlobalPalette
+	FieldFrameFunc
+	FieldRedact
+	FieldRedactStyle
+	FieldRemoveBackground
+	FieldDeskew
+	FieldTrimBorders
+	FieldTrim
+	FieldTrimTolerance
+	FieldGrade
+	FieldPosterize
+	FieldEdges
+	FieldThreshold
+	FieldAutoThreshold
+	FieldPadWidth
+	FieldPadHeight
+	FieldPadAnchor
+	FieldPadColor
+	FieldLetterbox
+	FieldBorder
+	FieldMask
+	FieldCircle
+	FieldCornerRadius
+	FieldTint
+	FieldTintStrength
+	FieldVignette
+	FieldPolaroid
+	FieldShadow
+)
+
+var optionFieldNames = [...]string{
+	FieldQuality:             "Quality",
+	FieldColors:              "Colors",
+	FieldQuantizer:           "Quantizer",
+	FieldIndexed:             "Indexed",
+	FieldInterlace:           "Interlace",
+	FieldThumbnail:           "Thumbnail",
+	FieldBigTIFF:             "BigTIFF",
+	FieldTileSize:            "TileSize",
+	FieldTargetSSIM:          "TargetSSIM",
+	FieldOptimizeCoding:      "OptimizeCoding",
+	FieldColumns:             "Columns",
+	FieldTrueColor:           "TrueColor",
+	FieldPyramid:             "Pyramid",
+	FieldLinearLight:         "LinearLight",
+	FieldKernel:              "Kernel",
+	FieldPixelFormat:         "PixelFormat",
+	FieldDither:              "Dither",
+	FieldGeoTIFF:             "GeoTIFF",
+	FieldLossless:            "Lossless",
+	FieldVerify:              "Verify",
+	FieldAllowPassthrough:    "AllowPassthrough",
+	FieldValidatePassthrough: "ValidatePassthrough",
+	FieldSalvage:             "Salvage",
+	FieldAllowTruncated:      "AllowTruncated",
+	FieldTruncatedFill:       "TruncatedFill",
+	FieldPreserveTimes:       "PreserveTimes",
+	FieldPreserveMode:        "PreserveMode",
+	FieldXattrs:              "Xattrs",
+	FieldChecksumSidecar:     "ChecksumSidecar",
+	FieldEncryptionKey:       "EncryptionKey",
+	FieldTeeWriters:          "TeeWriters",
+	FieldWarnFunc:            "WarnFunc",
+	FieldOptimizeFrames:      "OptimizeFrames",
+	FieldFrameRate:           "FrameRate",
+	FieldSpeed:               "Speed",
+	FieldMaxDuration:         "MaxDuration",
+	FieldGlobalPalette:       "GlobalPalette",
+	FieldFrameFunc:           "FrameFunc",
+	FieldRedact:              "Redact",
+	FieldRedactStyle:         "RedactStyle",
+	FieldRemoveBackground:    "RemoveBackground",
+	FieldDeskew:              "Deskew",
+	FieldTrimBorders:         "TrimBorders",
+	FieldTrim:                "Trim",
+	FieldTrimTolerance:       "TrimTolerance",
+	FieldGrade:               "Grade",
+	FieldPosterize:           "Posterize",
+	FieldEdges:               "Edges",
+	FieldThreshold:           "Threshold",
+	FieldAutoThreshold:       "AutoThreshold",
+	FieldPadWidth:            "PadWidth",
+	FieldPadHeight:           "PadHeight",
+	FieldPadAnchor:           "PadAnchor",
+	FieldPadColor:            "PadColor",
+	FieldLetterbox:           "Letterbox",
+	FieldBorder:              "Border",
+	FieldMask:                "Mask",
+	FieldCircle:              "Circle",
+	FieldCornerRadius:        "CornerRadius",
+	FieldTint:                "Tint",
+	FieldTintStrength:        "TintStrength",
+	FieldVignette:            "Vignette",
+	FieldPolaroid:            "Polaroid",
+	FieldShadow:              "Shadow",
+}
+
+func init() {
+	t := reflect.TypeOf(Options{})
+	if len(optionFieldNames) != t.NumField() {
+		panic("convert: OptionField constants do not cover Options")
+	}
+	for i, name := range optionFieldNames {
+		if t.Field(i).Name != name {
+			panic("convert: OptionField constants out of order at " + name)
+		}
+	}
+}
+
+// String returns the name of the field.
+func (f OptionField) String() string {
+	if f < 0 || int(f) >= len(optionFieldNames) {
+		return fmt.Sprintf("OptionField(%d)", int(f))
+	}
+	return optionFieldNames[f]
+}
+
+// Merge returns opts with each field that is set in override replacing
+// its own, so that a base profile can be adjusted per request. A field
+// is set if it is not its type's zero value, or if it is listed in
+// explicit, which makes zero values such as Quality 0 (the default) or
+// Lossless false override the base too.
+func (opts Options) Merge(override Options, explicit ...OptionField) Options {
+	dst := reflect.ValueOf(&opts).Elem()
+	src := reflect.ValueOf(override)
+	for _, f := range explicit {
+		if f >= 0 && int(f) < src.NumField() {
+			dst.Field(int(f)).Set(src.Field(int(f)))
+		}
+	}
+	for i := 0; i < src.NumField(); i++ {
+		if f := src.Field(i); !f.IsZero() && dst.Field(i).CanSet() {
+			dst.Field(i).Set(f)
+		}
+	}
+	return opts
+}
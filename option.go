@@ -0,0 +1,45 @@
+package convert
+
+// An Option sets fields of Options, for building them with NewOptions
+// or adjusting them with Options.With at call sites that read better as
+// a list of settings.
+type Option func(*Options)
+
+// NewOptions returns Options with each option applied in order.
+func NewOptions(options ...Option) Options {
+	return Options{}.With(options...)
+}
+
+// With returns a copy of opts with each option applied in order.
+func (opts Options) With(options ...Option) Options {
+	for _, o := range options {
+		o(&opts)
+	}
+	return opts
+}
+
+// WithQuality sets the JPEG quality.
+func WithQuality(q int) Option {
+	return func(o *Options) { o.Quality = q }
+}
+
+// WithColors sets the palette size for GIF and indexed PNG output.
+func WithColors(n int) Option {
+	return func(o *Options) { o.Colors = n }
+}
+
+// WithLossless makes encoding fail with ErrNotLossless rather than
+// write output that does not reproduce every pixel.
+func WithLossless() Option {
+	return func(o *Options) { o.Lossless = true }
+}
+
+// WithVerify re-decodes the output and fails unless it matches the input.
+func WithVerify() Option {
+	return func(o *Options) { o.Verify = true }
+}
+
+// WithWarnings calls f with the information each conversion loses.
+func WithWarnings(f func(Warning)) Option {
+	return func(o *Options) { o.WarnFunc = f }
+}
@@ -0,0 +1,79 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"io"
+)
+
+// Orientation returns the EXIF orientation of a JPEG (1-8), or 1 if the
+// image has no orientation tag or is not a JPEG.
+func Orientation(r io.Reader) (int, error) {
+	o, _, err := readOrientation(bufio.NewReader(r))
+	return o, err
+}
+
+// Dimensions returns the width and height an image is displayed at,
+// swapping the stored dimensions when the EXIF orientation rotates the
+// image by 90 degrees. Only the image header is read.
+func Dimensions(r io.Reader) (width, height int, err error) {
+	br := bufio.NewReader(r)
+	o, prefix, err := readOrientation(br)
+	if err != nil {
+		return 0, 0, err
+	}
+	cfg, _, err := image.DecodeConfig(io.MultiReader(bytes.NewReader(prefix), br))
+	if err != nil {
+		return 0, 0, err
+	}
+	if o >= 5 && o <= 8 {
+		return cfg.Height, cfg.Width, nil
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// AspectRatio returns the displayed width divided by the displayed height.
+func AspectRatio(r io.Reader) (float64, error) {
+	w, h, err := Dimensions(r)
+	if err != nil {
+		return 0, err
+	}
+	if h == 0 {
+		return 0, nil
+	}
+	return float64(w) / float64(h), nil
+}
+
+// readOrientation reads the EXIF orientation from br if it holds a JPEG.
+// It returns the bytes it consumed so the caller can decode the stream
+// again.
+func readOrientation(br *bufio.Reader) (int, []byte, error) {
+	head, err := br.Peek(2)
+	if err != nil {
+		return 0, nil, err
+	}
+	if head[0] != 0xff || head[1] != jpegMarkerSOI {
+		return 1, nil, nil
+	}
+
+	var consumed bytes.Buffer
+	segs, err := readJPEGSegments(io.TeeReader(br, &consumed))
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, s := range segs {
+		if s.marker != jpegMarkerAPP1 || !bytes.HasPrefix(s.data, exifHeader) {
+			continue
+		}
+		t, err := parseTIFF(s.data[len(exifHeader):])
+		if err != nil || len(t.ifds) == 0 {
+			break
+		}
+		if o, ok := t.uint(t.ifds[0], tagOrientation); ok && o >= 1 && o <= 8 {
+			return int(o), consumed.Bytes(), nil
+		}
+		break
+	}
+	return 1, consumed.Bytes(), nil
+}
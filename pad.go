@@ -0,0 +1,96 @@
+package convert
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Anchor selects where Pad places an image on its canvas.
+type Anchor int
+
+const (
+	AnchorCenter Anchor = iota
+	AnchorTop
+	AnchorBottom
+	AnchorLeft
+	AnchorRight
+	AnchorTopLeft
+	AnchorTopRight
+	AnchorBottomLeft
+	AnchorBottomRight
+)
+
+// offset returns where to place a w×h image on a cw×ch canvas.
+func (a Anchor) offset(w, h, cw, ch int) image.Point {
+	p := image.Pt((cw-w)/2, (ch-h)/2)
+	switch a {
+	case AnchorLeft, AnchorTopLeft, AnchorBottomLeft:
+		p.X = 0
+	case AnchorRight, AnchorTopRight, AnchorBottomRight:
+		p.X = cw - w
+	}
+	switch a {
+	case AnchorTop, AnchorTopLeft, AnchorTopRight:
+		p.Y = 0
+	case AnchorBottom, AnchorBottomLeft, AnchorBottomRight:
+		p.Y = ch - h
+	}
+	return p
+}
+
+// AddBorder returns img surrounded by a border width pixels wide in c,
+// or white if c is nil.
+func AddBorder(img image.Image, width int, c color.Color) image.Image {
+	if width <= 0 {
+		return img
+	}
+	b := img.Bounds()
+	return Pad(img, b.Dx()+2*width, b.Dy()+2*width, AnchorCenter, c)
+}
+
+// Pad returns img placed at anchor on a width×height canvas filled with
+// c, or white if c is nil. Images larger than the canvas in either
+// dimension are cropped around the anchor.
+func Pad(img image.Image, width, height int, anchor Anchor, c color.Color) image.Image {
+	b := img.Bounds()
+	if width <= 0 || height <= 0 || (width == b.Dx() && height == b.Dy()) {
+		return img
+	}
+	if c == nil {
+		c = color.White
+	}
+	r := image.Rect(0, 0, width, height)
+	dst := newRGBAFor(img, r)
+	draw.Draw(dst, r, image.NewUniform(c), image.Point{}, draw.Src)
+	off := anchor.offset(b.Dx(), b.Dy(), width, height)
+	draw.Draw(dst, b.Sub(b.Min).Add(off), img, b.Min, draw.Over)
+	return dst
+}
+
+// pad applies the letterbox, padding and border options to img.
+func (opts Options) pad(img image.Image) image.Image {
+	if opts.PadWidth > 0 && opts.PadHeight > 0 {
+		if opts.Letterbox {
+			b := img.Bounds()
+			w, h := opts.PadWidth, opts.PadHeight
+			if b.Dx()*h > b.Dy()*w {
+				h = max1(b.Dy() * w / b.Dx())
+			} else {
+				w = max1(b.Dx() * h / b.Dy())
+			}
+			img = opts.resize(img, w, h)
+		}
+		img = Pad(img, opts.PadWidth, opts.PadHeight, opts.PadAnchor, colorOr(opts.PadColor, nil))
+	}
+	return AddBorder(img, opts.Border, colorOr(opts.PadColor, nil))
+}
+
+// colorOr returns *c, or def if c is nil. Options hold colours as
+// *color.NRGBA so that they marshal to and from JSON.
+func colorOr(c *color.NRGBA, def color.Color) color.Color {
+	if c == nil {
+		return def
+	}
+	return *c
+}
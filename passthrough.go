@@ -0,0 +1,103 @@
+package convert
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"reflect"
+)
+
+// passThrough reads r and, if Options.AllowPassthrough is set, no
+// option changes the image or how it is encoded, and the input is
+// already in format, returns the input to be written out unchanged.
+// Otherwise it returns a reader of the input for decoding.
+func (opts Options) passThrough(r io.Reader, format Format) ([]byte, io.Reader, error) {
+	if !opts.AllowPassthrough {
+		return nil, r, nil
+	}
+	if !reflect.DeepEqual(opts.affecting(format), Options{}) {
+		return nil, r, nil
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, name, err := image.DecodeConfig(bytes.NewReader(data)); err != nil || Format(name) != format {
+		return nil, bytes.NewReader(data), nil
+	}
+	if opts.ValidatePassthrough {
+		if _, _, err := Decode(bytes.NewReader(data)); err != nil {
+			return nil, nil, err
+		}
+	}
+	return data, nil, nil
+}
+
+// affecting returns the options that would change an image already in
+// format, or how it is encoded, with every other field cleared: those
+// that only check or report on the conversion or apply to the output
+// file, those that do not apply to format or to still images, those
+// that only qualify an option that is unset, and a Quality that
+// Encode would use anyway.
+func (opts Options) affecting(format Format) Options {
+	o := opts
+	o.AllowPassthrough, o.ValidatePassthrough = false, false
+	o.Lossless, o.Verify = false, false
+	o.WarnFunc, o.FrameFunc = nil, nil
+	o.PreserveTimes, o.PreserveMode, o.Xattrs = false, false, nil
+	o.ChecksumSidecar, o.Salvage = false, false
+	o.TeeWriters = nil
+
+	if format != JPEG || o.Quality <= 0 || o.Quality > 100 || o.Quality == DefaultOptions().Quality {
+		o.Quality = 0
+	}
+	if format != JPEG {
+		o.TargetSSIM, o.OptimizeCoding, o.Thumbnail = 0, false, 0
+	}
+	// Dithering reduces 16-bit samples too, but the formats that need
+	// that cannot hold them in the first place.
+	if palette := format == GIF || format == Sixel || format == PNG && o.Indexed; !palette {
+		o.Colors, o.Quantizer, o.Dither = 0, QuantizePlan9, DitherDefault
+	}
+	if format != PNG {
+		o.Indexed = false
+	}
+	if format != PNG && format != GIF {
+		o.Interlace = false
+	}
+	if format != TIFF {
+		o.BigTIFF, o.TileSize, o.Pyramid, o.GeoTIFF = false, 0, nil, nil
+	}
+	o.Columns, o.TrueColor = 0, false
+	o.OptimizeFrames, o.GlobalPalette = false, false
+	o.FrameRate, o.Speed, o.MaxDuration = 0, 0, 0
+	// The resizes these select are driven by options compared here.
+	o.LinearLight, o.Kernel = false, ""
+
+	if !o.AllowTruncated {
+		o.TruncatedFill = nil
+	}
+	if !o.Trim {
+		o.TrimTolerance = 0
+	}
+	if len(o.Redact) == 0 {
+		o.Redact, o.RedactStyle = nil, RedactBlur
+	}
+	if o.PadWidth <= 0 {
+		o.PadAnchor = AnchorCenter
+		if o.Border <= 0 {
+			o.PadColor = nil
+		}
+	}
+	if o.Tint == nil || o.TintStrength <= 0 {
+		o.Tint, o.TintStrength = nil, 0
+	}
+	if len(o.Pyramid) == 0 {
+		o.Pyramid = nil
+	}
+	if len(o.EncryptionKey) == 0 {
+		o.EncryptionKey = nil
+	}
+	return o
+}
@@ -0,0 +1,127 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"io"
+)
+
+// Pipeline is the processing an image proxy URL asks for, as parsed by
+// ParseImgproxyPath and ParseThumborPath: a source image, the
+// operations applied to it in a fixed order, and the output encoding.
+type Pipeline struct {
+	Source string // URL of the source image
+
+	Trim          bool // first trim borders, as Trim does
+	TrimTolerance int
+	Crop          image.Rectangle // then crop to this region of the image, empty for none
+	CropSize      image.Point     // or to this size about Gravity; 0 keeps that dimension
+	Geometry      Geometry        // then resize
+	Extent        image.Point     // then crop to at most this size about Gravity, as after a Fill resize
+	Gravity       Anchor
+	Rotate        int  // then rotate clockwise by a multiple of 90 degrees
+	FlipX, FlipY  bool // then mirror left to right and top to bottom
+	Grayscale     bool
+
+	Format  Format  // output format, "" for the source's
+	Options Options // encoding and any further processing, such as Quality or padding
+}
+
+// Apply runs the operations of p on img. Options are left for Encode.
+func (p *Pipeline) Apply(img image.Image) (image.Image, error) {
+	return p.apply(img, 0)
+}
+
+// apply is Apply, failing before resizing to more than maxPixels
+// pixels unless maxPixels is 0.
+func (p *Pipeline) apply(img image.Image, maxPixels int) (image.Image, error) {
+	var err error
+	if p.Trim {
+		img = Trim(img, p.TrimTolerance)
+	}
+	if !p.Crop.Empty() {
+		if img, err = crop(img, p.Crop.Add(img.Bounds().Min)); err != nil {
+			return nil, err
+		}
+	}
+	if img, err = cropAbout(img, p.CropSize, p.Gravity); err != nil {
+		return nil, err
+	}
+	w, h := p.Geometry.Size(img.Bounds().Dx(), img.Bounds().Dy())
+	if maxPixels > 0 && int64(w)*int64(h) > int64(maxPixels) {
+		return nil, errors.New("resized image too large")
+	}
+	img = p.Options.resize(img, w, h)
+	if img, err = cropAbout(img, p.Extent, p.Gravity); err != nil {
+		return nil, err
+	}
+	if img, err = Rotate(img, p.Rotate); err != nil {
+		return nil, err
+	}
+	if p.FlipX {
+		img = Flip(img)
+	}
+	if p.FlipY {
+		if img, err = Rotate(Flip(img), 180); err != nil {
+			return nil, err
+		}
+	}
+	if p.Grayscale {
+		img = Grayscale(img)
+	}
+	return img, nil
+}
+
+// cropAbout crops img to at most size about anchor. A zero dimension
+// keeps that of img.
+func cropAbout(img image.Image, size image.Point, anchor Anchor) (image.Image, error) {
+	b := img.Bounds()
+	w, h := size.X, size.Y
+	if w <= 0 || w > b.Dx() {
+		w = b.Dx()
+	}
+	if h <= 0 || h > b.Dy() {
+		h = b.Dy()
+	}
+	if w == b.Dx() && h == b.Dy() {
+		return img, nil
+	}
+	at := b.Min.Add(anchor.offset(w, h, b.Dx(), b.Dy()))
+	return crop(img, image.Rectangle{at, at.Add(image.Pt(w, h))})
+}
+
+// ConvertPipeline fetches the source of p, applies it and writes the
+// result to w, returning the format written. Sources, resizes and
+// padding of more than MaxPixels pixels are refused.
+func (f *Fetcher) ConvertPipeline(ctx context.Context, p *Pipeline, w io.Writer) (Format, error) {
+	tooLarge := func(w, h int) bool { return int64(w)*int64(h) > int64(f.maxPixels()) }
+	if tooLarge(p.Options.PadWidth, p.Options.PadHeight) {
+		return "", errors.New("padded image too large")
+	}
+	data, err := f.Fetch(ctx, p.Source)
+	if err != nil {
+		return "", err
+	}
+	if w, h, err := Dimensions(bytes.NewReader(data)); err == nil && tooLarge(w, h) {
+		return "", errors.New("image dimensions too large")
+	}
+	img, format, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	// Decoders that Dimensions does not know are checked once decoded.
+	if b := img.Bounds(); tooLarge(b.Dx(), b.Dy()) {
+		return "", errors.New("image dimensions too large")
+	}
+	if img, err = p.apply(img, f.maxPixels()); err != nil {
+		return "", err
+	}
+	if p.Format != "" {
+		format = p.Format
+	} else if format.Capabilities() == (Capabilities{}) {
+		format = PNG
+	}
+	return format, Encode(w, img, format, p.Options)
+}
@@ -0,0 +1,95 @@
+package convert
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// PixelFormat selects the pixel layout of converted images, and with
+// it the samples that encoders write.
+type PixelFormat int
+
+const (
+	PixelAuto   PixelFormat = iota // keep the decoded layout
+	PixelRGBA                      // *image.RGBA, premultiplied alpha
+	PixelNRGBA                     // *image.NRGBA, straight alpha
+	PixelRGB                       // opaque *image.NRGBA, transparency composited onto black
+	PixelGray                      // *image.Gray
+	PixelGray16                    // *image.Gray16
+	PixelCMYK                      // *image.CMYK, converted without a color profile
+)
+
+// ConvertPixels returns img in the pixel format f. Images already in
+// that format, and all images for PixelAuto, are returned unchanged.
+func ConvertPixels(img image.Image, f PixelFormat) image.Image {
+	b := img.Bounds()
+	r := image.Rect(0, 0, b.Dx(), b.Dy())
+	var dst draw.Image
+	switch f {
+	case PixelRGBA:
+		if _, ok := img.(*image.RGBA); ok {
+			return img
+		}
+		dst = image.NewRGBA(r)
+	case PixelNRGBA:
+		if _, ok := img.(*image.NRGBA); ok {
+			return img
+		}
+		dst = image.NewNRGBA(r)
+	case PixelRGB:
+		if m, ok := img.(*image.NRGBA); ok && m.Opaque() {
+			return img
+		}
+		m := image.NewNRGBA(r)
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				c := color.RGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.RGBA)
+				m.SetNRGBA(x, y, color.NRGBA{c.R, c.G, c.B, 0xff})
+			}
+		}
+		return m
+	case PixelGray:
+		if _, ok := img.(*image.Gray); ok {
+			return img
+		}
+		dst = image.NewGray(r)
+	case PixelGray16:
+		if _, ok := img.(*image.Gray16); ok {
+			return img
+		}
+		dst = image.NewGray16(r)
+	case PixelCMYK:
+		if _, ok := img.(*image.CMYK); ok {
+			return img
+		}
+		dst = image.NewCMYK(r)
+	default:
+		return img
+	}
+	draw.Draw(dst, r, img, b.Min, draw.Src)
+	return dst
+}
+
+// pixelFormat converts img to the pixel format the options ask for,
+// narrowed to what format can store, and reports what that loses.
+func (opts Options) pixelFormat(img image.Image, format Format) (image.Image, error) {
+	f := opts.PixelFormat
+	caps := format.Capabilities()
+	if f == PixelCMYK && !caps.CMYK {
+		return nil, errors.New("format does not support cmyk samples")
+	}
+	if f == PixelGray16 && !caps.HighBitDepth {
+		f = PixelGray
+	}
+	if opts.WarnFunc != nil {
+		if f != PixelRGBA && f != PixelNRGBA && !isOpaque(img) {
+			opts.warn(WarnAlphaDropped, format)
+		}
+		if f != PixelGray16 && isDeep(img) {
+			opts.warn(WarnDepthReduced, format)
+		}
+	}
+	return ConvertPixels(img, f), nil
+}
@@ -0,0 +1,236 @@
+// Package pixels iterates over and maps the pixels of images, reading
+// and writing the pixel buffers of the standard image types directly
+// rather than through image.At and Set, which box each colour in an
+// interface.
+package pixels
+
+import (
+	"image"
+	"image/color"
+)
+
+// Sample is the channel type callbacks work in: uint8 values range
+// over 0-255, uint16 over 0-65535 and floats over 0-1.
+type Sample interface {
+	uint8 | uint16 | float32 | float64
+}
+
+// Pixel is a colour with straight, not premultiplied, alpha.
+type Pixel[S Sample] struct {
+	R, G, B, A S
+}
+
+// ForEachPixel calls f with each pixel of img, row by row.
+func ForEachPixel[S Sample](img image.Image, f func(x, y int, p Pixel[S])) {
+	b := img.Bounds()
+	row := make([]uint16, 4*b.Dx())
+	px := make([]Pixel[S], b.Dx())
+	read := reader(img)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		read(y, row)
+		toPixels(px, row)
+		for i, p := range px {
+			f(b.Min.X+i, y, p)
+		}
+	}
+}
+
+// MapPixels returns a copy of img with each pixel replaced by what f
+// returns for it, with channels clamped to their range. The copy is an
+// *image.NRGBA64 if img has 16-bit samples and an *image.NRGBA
+// otherwise, with the same bounds.
+func MapPixels[S Sample](img image.Image, f func(x, y int, p Pixel[S]) Pixel[S]) image.Image {
+	b := img.Bounds()
+	var pix []uint8
+	var stride int
+	deep := isDeep(img)
+	var out image.Image
+	if deep {
+		m := image.NewNRGBA64(b)
+		pix, stride, out = m.Pix, m.Stride, m
+	} else {
+		m := image.NewNRGBA(b)
+		pix, stride, out = m.Pix, m.Stride, m
+	}
+	row := make([]uint16, 4*b.Dx())
+	px := make([]Pixel[S], b.Dx())
+	read := reader(img)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		read(y, row)
+		toPixels(px, row)
+		for i, p := range px {
+			px[i] = f(b.Min.X+i, y, p)
+		}
+		fromPixels(row, px)
+		dst := pix[(y-b.Min.Y)*stride:]
+		if deep {
+			for i, v := range row {
+				dst[2*i], dst[2*i+1] = uint8(v>>8), uint8(v)
+			}
+		} else {
+			for i, v := range row {
+				dst[i] = uint8(v >> 8)
+			}
+		}
+	}
+	return out
+}
+
+// toPixels converts a row of straight-alpha 16-bit RGBA to pixels.
+func toPixels[S Sample](dst []Pixel[S], row []uint16) {
+	var s S
+	switch any(s).(type) {
+	case uint8:
+		for i := range dst {
+			r := row[4*i : 4*i+4]
+			dst[i] = Pixel[S]{S(r[0] >> 8), S(r[1] >> 8), S(r[2] >> 8), S(r[3] >> 8)}
+		}
+	case uint16:
+		for i := range dst {
+			r := row[4*i : 4*i+4]
+			dst[i] = Pixel[S]{S(r[0]), S(r[1]), S(r[2]), S(r[3])}
+		}
+	default:
+		for i := range dst {
+			r := row[4*i : 4*i+4]
+			dst[i] = Pixel[S]{S(float32(r[0]) / 0xffff), S(float32(r[1]) / 0xffff), S(float32(r[2]) / 0xffff), S(float32(r[3]) / 0xffff)}
+		}
+	}
+}
+
+// fromPixels converts pixels to a row of 16-bit RGBA, clamping floats
+// to 0-1.
+func fromPixels[S Sample](row []uint16, src []Pixel[S]) {
+	var s S
+	switch any(s).(type) {
+	case uint8:
+		for i, p := range src {
+			r := row[4*i : 4*i+4]
+			r[0], r[1], r[2], r[3] = uint16(p.R)*0x101, uint16(p.G)*0x101, uint16(p.B)*0x101, uint16(p.A)*0x101
+		}
+	case uint16:
+		for i, p := range src {
+			r := row[4*i : 4*i+4]
+			r[0], r[1], r[2], r[3] = uint16(p.R), uint16(p.G), uint16(p.B), uint16(p.A)
+		}
+	default:
+		unit := func(v S) uint16 {
+			switch {
+			case v <= 0:
+				return 0
+			case v >= 1:
+				return 0xffff
+			}
+			return uint16(float32(v)*0xffff + 0.5)
+		}
+		for i, p := range src {
+			r := row[4*i : 4*i+4]
+			r[0], r[1], r[2], r[3] = unit(p.R), unit(p.G), unit(p.B), unit(p.A)
+		}
+	}
+}
+
+// isDeep reports whether img has 16-bit samples.
+func isDeep(img image.Image) bool {
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16, *image.Alpha16:
+		return true
+	}
+	return false
+}
+
+// reader returns a function that reads row y of img into row as
+// straight-alpha 16-bit RGBA.
+func reader(img image.Image) func(y int, row []uint16) {
+	b := img.Bounds()
+	switch m := img.(type) {
+	case *image.NRGBA:
+		return func(y int, row []uint16) {
+			p := m.Pix[m.PixOffset(b.Min.X, y):]
+			for i := range row {
+				row[i] = uint16(p[i]) * 0x101
+			}
+		}
+	case *image.RGBA:
+		return func(y int, row []uint16) {
+			p := m.Pix[m.PixOffset(b.Min.X, y):]
+			for i := 0; i < len(row); i += 4 {
+				unpremultiply(row[i:i+4], uint32(p[i])*0x101, uint32(p[i+1])*0x101, uint32(p[i+2])*0x101, uint32(p[i+3])*0x101)
+			}
+		}
+	case *image.Gray:
+		return func(y int, row []uint16) {
+			p := m.Pix[m.PixOffset(b.Min.X, y):]
+			for i := 0; i < len(row); i += 4 {
+				v := uint16(p[i/4]) * 0x101
+				row[i], row[i+1], row[i+2], row[i+3] = v, v, v, 0xffff
+			}
+		}
+	case *image.YCbCr:
+		return func(y int, row []uint16) {
+			for i, x := 0, b.Min.X; i < len(row); i, x = i+4, x+1 {
+				c := m.YCbCrAt(x, y)
+				r, g, bl := color.YCbCrToRGB(c.Y, c.Cb, c.Cr)
+				row[i], row[i+1], row[i+2], row[i+3] = uint16(r)*0x101, uint16(g)*0x101, uint16(bl)*0x101, 0xffff
+			}
+		}
+	case *image.Paletted:
+		pal := make([][4]uint16, len(m.Palette))
+		for i, c := range m.Palette {
+			n := color.NRGBA64Model.Convert(c).(color.NRGBA64)
+			pal[i] = [4]uint16{n.R, n.G, n.B, n.A}
+		}
+		return func(y int, row []uint16) {
+			p := m.Pix[m.PixOffset(b.Min.X, y):]
+			for i := 0; i < len(row); i += 4 {
+				if k := int(p[i/4]); k < len(pal) {
+					copy(row[i:i+4], pal[k][:])
+				} else {
+					row[i], row[i+1], row[i+2], row[i+3] = 0, 0, 0, 0xffff
+				}
+			}
+		}
+	case *image.NRGBA64:
+		return func(y int, row []uint16) {
+			p := m.Pix[m.PixOffset(b.Min.X, y):]
+			for i := range row {
+				row[i] = uint16(p[2*i])<<8 | uint16(p[2*i+1])
+			}
+		}
+	case *image.RGBA64:
+		return func(y int, row []uint16) {
+			p := m.Pix[m.PixOffset(b.Min.X, y):]
+			at := func(i int) uint32 { return uint32(p[2*i])<<8 | uint32(p[2*i+1]) }
+			for i := 0; i < len(row); i += 4 {
+				unpremultiply(row[i:i+4], at(i), at(i+1), at(i+2), at(i+3))
+			}
+		}
+	case *image.Gray16:
+		return func(y int, row []uint16) {
+			p := m.Pix[m.PixOffset(b.Min.X, y):]
+			for i := 0; i < len(row); i += 4 {
+				v := uint16(p[i/2])<<8 | uint16(p[i/2+1])
+				row[i], row[i+1], row[i+2], row[i+3] = v, v, v, 0xffff
+			}
+		}
+	}
+	return func(y int, row []uint16) {
+		for i, x := 0, b.Min.X; i < len(row); i, x = i+4, x+1 {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			row[i], row[i+1], row[i+2], row[i+3] = c.R, c.G, c.B, c.A
+		}
+	}
+}
+
+// unpremultiply stores the straight-alpha form of a premultiplied
+// colour in dst.
+func unpremultiply(dst []uint16, r, g, b, a uint32) {
+	switch a {
+	case 0:
+		dst[0], dst[1], dst[2], dst[3] = 0, 0, 0, 0
+	case 0xffff:
+		dst[0], dst[1], dst[2], dst[3] = uint16(r), uint16(g), uint16(b), 0xffff
+	default:
+		dst[0], dst[1], dst[2], dst[3] = uint16(r*0xffff/a), uint16(g*0xffff/a), uint16(b*0xffff/a), uint16(a)
+	}
+}
@@ -0,0 +1,207 @@
+package convert
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// ResizeMode selects how PreprocessBatch brings images to the tensor
+// size.
+type ResizeMode int
+
+const (
+	Stretch    ResizeMode = iota // scale to the exact size, ignoring the aspect ratio
+	Letterbox                    // scale to fit and pad the remainder
+	CenterCrop                   // scale to cover and crop the centre
+)
+
+// TensorSpec describes the input tensor of a model.
+type TensorSpec struct {
+	Width, Height int
+	Mode          ResizeMode
+
+	// Layout is HWC for an NHWC batch or CHW for NCHW.
+	Layout Layout
+
+	// Channels is 3 for RGB or 1 for gray; zero means 3.
+	Channels int
+
+	// Mean and Std normalize each channel as (v - Mean) / Std, with v
+	// on a 0-1 scale. They hold one value per channel, or none for a
+	// mean of 0 and a deviation of 1.
+	Mean, Std []float32
+
+	// Pad fills letterbox borders and shows through transparency;
+	// nil is black.
+	Pad color.Color
+
+	// Concurrency is the number of images processed in parallel;
+	// zero means one per CPU.
+	Concurrency int
+
+	// Options picks the resampling, as for Encode.
+	Options Options
+}
+
+// Tensor is a batch of preprocessed images.
+type Tensor struct {
+	Shape [4]int // NHWC or NCHW, following the spec's layout
+	Data  []float32
+}
+
+// PreprocessBatch decodes the images, resizes them to the spec,
+// normalizes their samples and packs them into a single float32
+// tensor. It fails with the first error, naming the image's index.
+func PreprocessBatch(readers []io.Reader, spec TensorSpec) (*Tensor, error) {
+	if spec.Width <= 0 || spec.Height <= 0 {
+		return nil, errors.New("preprocess: width and height are required")
+	}
+	if spec.Channels == 0 {
+		spec.Channels = 3
+	}
+	if spec.Channels != 1 && spec.Channels != 3 {
+		return nil, errors.New("preprocess: channels must be 1 or 3")
+	}
+	if spec.Layout != HWC && spec.Layout != CHW {
+		return nil, errors.New("preprocess: unknown layout")
+	}
+	for _, s := range [][]float32{spec.Mean, spec.Std} {
+		if len(s) != 0 && len(s) != spec.Channels {
+			return nil, errors.New("preprocess: mean and std need one value per channel")
+		}
+	}
+	for _, v := range spec.Std {
+		if v == 0 {
+			return nil, errors.New("preprocess: std must not be zero")
+		}
+	}
+	if spec.Pad == nil {
+		spec.Pad = color.Black
+	}
+	n := spec.Concurrency
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+
+	t := &Tensor{Shape: [4]int{len(readers), spec.Height, spec.Width, spec.Channels}}
+	if spec.Layout == CHW {
+		t.Shape = [4]int{len(readers), spec.Channels, spec.Height, spec.Width}
+	}
+	size := spec.Width * spec.Height * spec.Channels
+	t.Data = make([]float32, len(readers)*size)
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, n)
+	errs := make([]error, len(readers))
+	for i, r := range readers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, r io.Reader) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = spec.preprocess(t.Data[i*size:(i+1)*size], r)
+		}(i, r)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("preprocess: image %d: %w", i, err)
+		}
+	}
+	return t, nil
+}
+
+// WriteNPY writes the tensor as a NumPy .npy file.
+func (t *Tensor) WriteNPY(w io.Writer) error {
+	if _, err := w.Write(npyHeader(SampleFloat32, t.Shape[:])); err != nil {
+		return err
+	}
+	buf := make([]byte, 4*len(t.Data))
+	for i, v := range t.Data {
+		u := math.Float32bits(v)
+		buf[4*i], buf[4*i+1], buf[4*i+2], buf[4*i+3] = byte(u), byte(u>>8), byte(u>>16), byte(u>>24)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// preprocess decodes one image from r into dst.
+func (spec TensorSpec) preprocess(dst []float32, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	var dopts DecodeOptions
+	if w, h, err := Dimensions(bytes.NewReader(data)); err == nil && w > 0 && h > 0 {
+		// JPEG can be decoded at a reduced size that still covers the
+		// resized area.
+		sx, sy := float64(spec.Width)/float64(w), float64(spec.Height)/float64(h)
+		dopts.Scale = math.Max(sx, sy)
+		if spec.Mode == Letterbox {
+			dopts.Scale = math.Min(sx, sy)
+		}
+	}
+	img, _, err := DecodeWith(bytes.NewReader(data), dopts)
+	if err != nil {
+		return err
+	}
+	canvas := spec.fitCanvas(img)
+
+	plane := spec.Width * spec.Height
+	for y := 0; y < spec.Height; y++ {
+		for x := 0; x < spec.Width; x++ {
+			p := canvas.Pix[y*canvas.Stride+4*x:]
+			v := [3]float32{float32(p[0]) / 255, float32(p[1]) / 255, float32(p[2]) / 255}
+			if spec.Channels == 1 {
+				v[0] = 0.299*v[0] + 0.587*v[1] + 0.114*v[2]
+			}
+			for c := 0; c < spec.Channels; c++ {
+				s := v[c]
+				if spec.Mean != nil {
+					s -= spec.Mean[c]
+				}
+				if spec.Std != nil {
+					s /= spec.Std[c]
+				}
+				if spec.Layout == CHW {
+					dst[c*plane+y*spec.Width+x] = s
+				} else {
+					dst[(y*spec.Width+x)*spec.Channels+c] = s
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// fitCanvas resizes img according to the spec and composites it onto
+// an opaque canvas of the tensor size.
+func (spec TensorSpec) fitCanvas(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	w, h := spec.Width, spec.Height
+	switch spec.Mode {
+	case Letterbox, CenterCrop:
+		s := math.Min(float64(w)/float64(b.Dx()), float64(h)/float64(b.Dy()))
+		if spec.Mode == CenterCrop {
+			s = math.Max(float64(w)/float64(b.Dx()), float64(h)/float64(b.Dy()))
+		}
+		w = max1(int(math.Round(float64(b.Dx()) * s)))
+		h = max1(int(math.Round(float64(b.Dy()) * s)))
+	}
+	img = spec.Options.resize(img, w, h)
+
+	canvas := image.NewRGBA(image.Rect(0, 0, spec.Width, spec.Height))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(spec.Pad), image.Point{}, draw.Src)
+	b = img.Bounds()
+	off := image.Pt((spec.Width-b.Dx())/2, (spec.Height-b.Dy())/2)
+	draw.Draw(canvas, b.Sub(b.Min).Add(off), img, b.Min, draw.Over)
+	return canvas
+}
@@ -0,0 +1,32 @@
+package convert
+
+import (
+	"errors"
+	"os"
+)
+
+var errXattrsUnsupported = errors.New("extended attributes are not supported on this system")
+
+// preserveAttributes copies the file attributes that opts asks for from
+// the file at src, described by info as it was before being read, to
+// the one at dst.
+func (opts Options) preserveAttributes(src string, info os.FileInfo, dst string) error {
+	if !opts.PreserveTimes && !opts.PreserveMode && len(opts.Xattrs) == 0 {
+		return nil
+	}
+	src, dst = longPath(src), longPath(dst)
+	if opts.PreserveMode {
+		if err := os.Chmod(dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if len(opts.Xattrs) > 0 {
+		if err := copyXattrs(src, dst, opts.Xattrs); err != nil {
+			return err
+		}
+	}
+	if opts.PreserveTimes {
+		return os.Chtimes(dst, atime(info), info.ModTime())
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package convert
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func atime(info os.FileInfo) time.Time {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Atimespec.Unix())
+	}
+	return info.ModTime()
+}
+
+func copyXattrs(src, dst string, names []string) error {
+	return errXattrsUnsupported
+}
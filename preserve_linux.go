@@ -0,0 +1,36 @@
+package convert
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+func atime(info os.FileInfo) time.Time {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Atim.Unix())
+	}
+	return info.ModTime()
+}
+
+// copyXattrs copies the named extended attributes that src has to dst.
+func copyXattrs(src, dst string, names []string) error {
+	for _, name := range names {
+		n, err := syscall.Getxattr(src, name, nil)
+		if errors.Is(err, syscall.ENODATA) {
+			continue
+		}
+		if err != nil {
+			return &os.PathError{Op: "getxattr", Path: src, Err: err}
+		}
+		buf := make([]byte, n)
+		if n, err = syscall.Getxattr(src, name, buf); err != nil {
+			return &os.PathError{Op: "getxattr", Path: src, Err: err}
+		}
+		if err := syscall.Setxattr(dst, name, buf[:n], 0); err != nil {
+			return &os.PathError{Op: "setxattr", Path: dst, Err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package convert
+
+import (
+	"os"
+	"time"
+)
+
+func atime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
+
+func copyXattrs(src, dst string, names []string) error {
+	return errXattrsUnsupported
+}
@@ -0,0 +1,402 @@
+package convert
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ParseImgproxyPath parses the processing options and source of an
+// imgproxy URL path, such as
+//
+//	/rs:fill:300:200/q:80/plain/https://example.com/a.jpg@png
+//	/rs:fit:640:0/g:no/aHR0cHM6Ly9leGFtcGxlLmNvbS9hLmpwZw.png
+//
+// path is what follows the signature segment, as returned by
+// URLSigner.Verify. Resizing, gravity, cropping, extending, trimming,
+// rotation, flipping, quality, background and format options are
+// supported; others, and presets, are rejected.
+func ParseImgproxyPath(path string) (*Pipeline, error) {
+	p := &Pipeline{}
+	var rt string
+	var w, h int
+	var dpr float64 = 1
+	var enlarge, extend bool
+	extendGravity := AnchorCenter
+	segs := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, seg := range segs {
+		if seg == "plain" {
+			src, ext := strings.Join(segs[i+1:], "/"), ""
+			if j := strings.LastIndexByte(src, '@'); j >= 0 {
+				src, ext = src[:j], src[j+1:]
+			}
+			u, err := url.PathUnescape(src)
+			if err != nil {
+				return nil, fmt.Errorf("imgproxy: invalid source URL: %w", err)
+			}
+			p.Source = u
+			if err := p.setFormat(ext); err != nil {
+				return nil, fmt.Errorf("imgproxy: %w", err)
+			}
+			break
+		}
+		if !strings.Contains(seg, ":") {
+			src, ext := strings.Join(segs[i:], ""), ""
+			if j := strings.LastIndexByte(src, '.'); j >= 0 {
+				src, ext = src[:j], src[j+1:]
+			}
+			u, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(src, "="))
+			if err != nil {
+				return nil, fmt.Errorf("imgproxy: invalid source URL: %w", err)
+			}
+			p.Source = string(u)
+			if err := p.setFormat(ext); err != nil {
+				return nil, fmt.Errorf("imgproxy: %w", err)
+			}
+			break
+		}
+
+		args := strings.Split(seg, ":")
+		name, args := args[0], args[1:]
+		arg := func(i int) string {
+			if i < len(args) {
+				return args[i]
+			}
+			return ""
+		}
+		var err error
+		switch name {
+		case "resize", "rs":
+			rt = arg(0)
+			if len(args) > 0 {
+				args = args[1:]
+			}
+			fallthrough
+		case "size", "s":
+			w, err = proxyInt(arg(0), w)
+			if err == nil {
+				h, err = proxyInt(arg(1), h)
+			}
+			enlarge, extend = proxyBool(arg(2), enlarge), proxyBool(arg(3), extend)
+		case "resizing_type", "rt":
+			rt = arg(0)
+		case "width", "w":
+			w, err = proxyInt(arg(0), w)
+		case "height", "h":
+			h, err = proxyInt(arg(0), h)
+		case "enlarge", "el":
+			enlarge = proxyBool(arg(0), false)
+		case "extend", "ex":
+			extend = proxyBool(arg(0), false)
+			if arg(1) != "" {
+				extendGravity, err = imgproxyGravity(arg(1))
+			}
+		case "gravity", "g":
+			p.Gravity, err = imgproxyGravity(arg(0))
+		case "crop", "c":
+			p.CropSize.X, err = proxyInt(arg(0), 0)
+			if err == nil {
+				p.CropSize.Y, err = proxyInt(arg(1), 0)
+			}
+			if err == nil && arg(2) != "" {
+				p.Gravity, err = imgproxyGravity(arg(2))
+			}
+		case "trim", "t":
+			p.Trim = true
+			p.TrimTolerance, err = proxyInt(arg(0), 10)
+		case "rotate", "rot":
+			p.Rotate, err = strconv.Atoi(arg(0))
+			if err == nil && p.Rotate%90 != 0 {
+				err = fmt.Errorf("rotation %d is not a multiple of 90", p.Rotate)
+			}
+		case "flip", "fl":
+			p.FlipX, p.FlipY = proxyBool(arg(0), false), proxyBool(arg(1), false)
+		case "quality", "q":
+			p.Options.Quality, err = proxyInt(arg(0), 0)
+		case "dpr":
+			dpr, err = strconv.ParseFloat(arg(0), 64)
+			if err == nil && !(dpr > 0 && dpr <= 8) {
+				err = fmt.Errorf("dpr %g is outside 0-8", dpr)
+			}
+		case "background", "bg":
+			p.Options.PadColor, err = imgproxyColor(args)
+		case "format", "f", "ext":
+			err = p.setFormat(arg(0))
+		default:
+			return nil, fmt.Errorf("imgproxy: unsupported option %q", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("imgproxy: option %q: %w", seg, err)
+		}
+	}
+	if p.Source == "" {
+		return nil, errors.New("imgproxy: no source URL")
+	}
+
+	w, h = int(math.Round(float64(w)*dpr)), int(math.Round(float64(h)*dpr))
+	if w == 0 && h == 0 {
+		return p, nil
+	}
+	p.Geometry = Geometry{Width: float64(w), Height: float64(h), OnlyShrink: !enlarge}
+	switch rt {
+	case "", "fit":
+	case "force":
+		p.Geometry.Exact = w > 0 && h > 0
+	case "fill", "fill-down", "auto":
+		if w > 0 && h > 0 {
+			p.Geometry.Fill = true
+			p.Extent = image.Pt(w, h)
+		}
+	default:
+		return nil, fmt.Errorf("imgproxy: unsupported resizing type %q", rt)
+	}
+	if extend && w > 0 && h > 0 {
+		p.Options.PadWidth, p.Options.PadHeight, p.Options.PadAnchor = w, h, extendGravity
+	}
+	return p, nil
+}
+
+// proxyInt parses a non-negative integer argument, returning def for
+// an empty one.
+func proxyInt(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return n, nil
+}
+
+// proxyBool parses an imgproxy boolean argument, returning def for an
+// empty one.
+func proxyBool(s string, def bool) bool {
+	if s == "" {
+		return def
+	}
+	return s == "1" || s == "t" || s == "true"
+}
+
+var imgproxyGravities = map[string]Anchor{
+	"ce": AnchorCenter, "sm": AnchorCenter,
+	"no": AnchorTop, "so": AnchorBottom, "ea": AnchorRight, "we": AnchorLeft,
+	"noea": AnchorTopRight, "nowe": AnchorTopLeft, "soea": AnchorBottomRight, "sowe": AnchorBottomLeft,
+}
+
+// imgproxyGravity parses an imgproxy gravity type. Smart gravity falls
+// back to the centre, as imgproxy does when it finds nothing of note.
+func imgproxyGravity(s string) (Anchor, error) {
+	a, ok := imgproxyGravities[s]
+	if !ok {
+		return 0, fmt.Errorf("unsupported gravity %q", s)
+	}
+	return a, nil
+}
+
+// imgproxyColor parses a background of red, green and blue arguments
+// or a single hex colour.
+func imgproxyColor(args []string) (*color.NRGBA, error) {
+	var rgb [3]uint8
+	switch len(args) {
+	case 1:
+		b, err := hex.DecodeString(args[0])
+		if err != nil || len(b) != 3 {
+			return nil, fmt.Errorf("invalid colour %q", args[0])
+		}
+		copy(rgb[:], b)
+	case 3:
+		for i, a := range args {
+			n, err := strconv.Atoi(a)
+			if err != nil || n < 0 || n > 255 {
+				return nil, fmt.Errorf("invalid colour %q", strings.Join(args, ":"))
+			}
+			rgb[i] = uint8(n)
+		}
+	default:
+		return nil, fmt.Errorf("invalid colour %q", strings.Join(args, ":"))
+	}
+	return &color.NRGBA{rgb[0], rgb[1], rgb[2], 0xff}, nil
+}
+
+var proxyFormats = map[string]Format{
+	"jpg": JPEG, "jpeg": JPEG, "png": PNG, "gif": GIF, "bmp": BMP, "tiff": TIFF, "tif": TIFF,
+}
+
+// setFormat sets the output format named by an extension, if any.
+func (p *Pipeline) setFormat(ext string) error {
+	if ext == "" {
+		return nil
+	}
+	f, ok := proxyFormats[strings.ToLower(ext)]
+	if !ok {
+		return fmt.Errorf("unsupported format %q", ext)
+	}
+	p.Format = f
+	return nil
+}
+
+var (
+	thumborTrim   = regexp.MustCompile(`^trim(:top-left)?(?::(\d+))?$`)
+	thumborCrop   = regexp.MustCompile(`^(\d+)x(\d+):(\d+)x(\d+)$`)
+	thumborSize   = regexp.MustCompile(`^(-?)(\d*|orig)x(-?)(\d*|orig)$`)
+	thumborFilter = regexp.MustCompile(`^(\w+)\(([^()]*)\)(?::|$)`)
+)
+
+// ParseThumborPath parses the operations and image of a thumbor URL
+// path, such as
+//
+//	/unsafe/trim/10x20:300x400/fit-in/-300x200/left/top/filters:quality(80)/example.com/a.jpg
+//
+// A leading "unsafe" segment is skipped; verify signed paths first and
+// pass what follows the signature. The quality, format, grayscale,
+// rotate, upscale, no_upscale, strip_exif and strip_icc filters are
+// supported; others, and the adaptive and full fit-in modes, are
+// rejected. Smart cropping falls back to the alignment given.
+func ParseThumborPath(path string) (*Pipeline, error) {
+	p := &Pipeline{}
+	segs := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(segs) > 0 && segs[0] == "unsafe" {
+		segs = segs[1:]
+	}
+	var fitIn, sized, upscale bool
+	var w, h int
+	var halign, valign string
+	i := 0
+	next := func(re *regexp.Regexp) []string {
+		if i < len(segs) {
+			if m := re.FindStringSubmatch(segs[i]); m != nil {
+				i++
+				return m
+			}
+		}
+		return nil
+	}
+	word := func(words ...string) string {
+		if i < len(segs) {
+			for _, w := range words {
+				if segs[i] == w {
+					i++
+					return w
+				}
+			}
+		}
+		return ""
+	}
+
+	if i < len(segs) && strings.HasPrefix(segs[i], "trim") && !thumborTrim.MatchString(segs[i]) {
+		return nil, fmt.Errorf("thumbor: %s is not supported", segs[i])
+	}
+	if m := next(thumborTrim); m != nil {
+		p.Trim = true
+		if m[2] != "" {
+			p.TrimTolerance, _ = strconv.Atoi(m[2])
+		}
+	}
+	if m := next(thumborCrop); m != nil {
+		var v [4]int
+		for j := range v {
+			v[j], _ = strconv.Atoi(m[j+1])
+		}
+		p.Crop = image.Rect(v[0], v[1], v[2], v[3])
+	}
+	switch word("fit-in", "adaptive-fit-in", "full-fit-in") {
+	case "fit-in":
+		fitIn = true
+	case "":
+	default:
+		return nil, fmt.Errorf("thumbor: %s is not supported", segs[i-1])
+	}
+	if m := next(thumborSize); m != nil {
+		if m[2] == "orig" || m[4] == "orig" {
+			return nil, errors.New("thumbor: orig sizes are not supported")
+		}
+		sized = true
+		p.FlipX, p.FlipY = m[1] == "-", m[3] == "-"
+		w, _ = strconv.Atoi(m[2])
+		h, _ = strconv.Atoi(m[4])
+	}
+	halign = word("left", "center", "right")
+	valign = word("top", "middle", "bottom")
+	word("smart")
+	upscale = !fitIn
+	if i < len(segs) && strings.HasPrefix(segs[i], "filters:") {
+		s := strings.TrimPrefix(segs[i], "filters:")
+		i++
+		for s != "" {
+			m := thumborFilter.FindStringSubmatch(s)
+			if m == nil {
+				return nil, fmt.Errorf("thumbor: invalid filters %q", s)
+			}
+			s = s[len(m[0]):]
+			var err error
+			switch name, arg := m[1], m[2]; name {
+			case "quality":
+				p.Options.Quality, err = proxyInt(arg, 0)
+			case "format":
+				err = p.setFormat(arg)
+			case "grayscale":
+				p.Grayscale = true
+			case "rotate":
+				p.Rotate, err = strconv.Atoi(arg)
+				if err == nil && p.Rotate%90 != 0 {
+					err = fmt.Errorf("rotation %d is not a multiple of 90", p.Rotate)
+				}
+			case "upscale":
+				upscale = true
+			case "no_upscale":
+				upscale = false
+			case "strip_exif", "strip_icc":
+			default:
+				return nil, fmt.Errorf("thumbor: unsupported filter %q", name)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("thumbor: filter %q: %w", m[0], err)
+			}
+		}
+	}
+	if i >= len(segs) || strings.Join(segs[i:], "") == "" {
+		return nil, errors.New("thumbor: no image URL")
+	}
+	src := strings.Join(segs[i:], "/")
+	if u, err := url.PathUnescape(src); err == nil {
+		src = u
+	}
+	p.Source = src
+
+	p.Gravity = thumborAnchor(halign, valign)
+	if !sized || w == 0 && h == 0 {
+		return p, nil
+	}
+	p.Geometry = Geometry{Width: float64(w), Height: float64(h), OnlyShrink: !upscale}
+	if !fitIn && w > 0 && h > 0 {
+		p.Geometry.Fill = true
+		p.Extent = image.Pt(w, h)
+	}
+	return p, nil
+}
+
+// thumborAnchor returns the Anchor of a thumbor alignment.
+func thumborAnchor(halign, valign string) Anchor {
+	anchors := map[[2]string]Anchor{
+		{"left", ""}: AnchorLeft, {"right", ""}: AnchorRight,
+		{"", "top"}: AnchorTop, {"", "bottom"}: AnchorBottom,
+		{"left", "top"}: AnchorTopLeft, {"right", "top"}: AnchorTopRight,
+		{"left", "bottom"}: AnchorBottomLeft, {"right", "bottom"}: AnchorBottomRight,
+	}
+	if halign == "center" {
+		halign = ""
+	}
+	if valign == "middle" {
+		valign = ""
+	}
+	return anchors[[2]string{halign, valign}]
+}
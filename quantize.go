@@ -0,0 +1,567 @@
+package convert
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"sort"
+)
+
+// QuantizeMethod selects a color quantization algorithm.
+type QuantizeMethod int
+
+const (
+	QuantizePlan9     QuantizeMethod = iota // fixed Plan 9 palette, the stdlib default
+	QuantizeMedianCut                       // recursive box splitting at the weighted median
+	QuantizeOctree                          // octree reduction
+	QuantizeKMeans                          // k-means refinement of a median cut palette
+	QuantizeNeuQuant                        // Kohonen neural network (Dekker 1994)
+)
+
+// maxQuantizeSamples bounds the number of pixels examined when building
+// a palette; larger images are sampled on a regular grid.
+const maxQuantizeSamples = 1 << 18
+
+// Quantize reduces img to a palette of at most n colors using median
+// cut. If dither is set, Floyd-Steinberg error diffusion is applied when
+// mapping pixels to the palette.
+func Quantize(img image.Image, n int, dither bool) *image.Paletted {
+	return QuantizeMedianCut.Quantize(img, n, dither)
+}
+
+// Quantize reduces img to a palette of at most n colors using the method.
+func (m QuantizeMethod) Quantize(img image.Image, n int, dither bool) *image.Paletted {
+	if dither {
+		return m.quantize(img, n, DitherFloydSteinberg)
+	}
+	return m.quantize(img, n, DitherNone)
+}
+
+func (m QuantizeMethod) quantize(img image.Image, n int, d Dither) *image.Paletted {
+	b := img.Bounds()
+	dst := image.NewPaletted(b, m.Palette(img, n))
+	d.Draw(dst, b, img, b.Min)
+	return dst
+}
+
+// Palette returns a palette of at most n colors for img. If img has
+// transparent pixels, one entry is reserved for fully transparent black.
+func (m QuantizeMethod) Palette(img image.Image, n int) color.Palette {
+	if n <= 0 || n > 256 {
+		n = 256
+	}
+	if m == QuantizePlan9 {
+		return plan9Palette(n)
+	}
+
+	samples, transparent := quantizeSamples(img)
+	if transparent {
+		n--
+	}
+	var p color.Palette
+	if n > 0 && len(samples) > 0 {
+		switch m {
+		case QuantizeOctree:
+			p = octreePalette(samples, n)
+		case QuantizeKMeans:
+			p = kmeansPalette(samples, n)
+		case QuantizeNeuQuant:
+			p = neuquantPalette(samples, n)
+		default:
+			p = medianCutPalette(samples, n)
+		}
+	}
+	if transparent {
+		p = append(p, color.RGBA{})
+	}
+	if len(p) == 0 {
+		p = color.Palette{color.RGBA{A: 0xff}}
+	}
+	return p
+}
+
+// drawQuantizer adapts a QuantizeMethod to the image/draw.Quantizer
+// interface used by image/gif.
+type drawQuantizer struct {
+	method QuantizeMethod
+}
+
+func (q drawQuantizer) Quantize(p color.Palette, m image.Image) color.Palette {
+	return append(p, q.method.Palette(m, cap(p)-len(p))...)
+}
+
+func plan9Palette(n int) color.Palette {
+	if n > len(palette.Plan9) {
+		n = len(palette.Plan9)
+	}
+	return append(color.Palette(nil), palette.Plan9[:n]...)
+}
+
+// qcolor is an opaque RGB sample.
+type qcolor [3]uint8
+
+// quantizeSamples returns the opaque pixels of img, sampled down to at
+// most maxQuantizeSamples, and whether any pixel is mostly transparent.
+func quantizeSamples(img image.Image) ([]qcolor, bool) {
+	b := img.Bounds()
+	step := 1
+	for (b.Dx()/step)*(b.Dy()/step) > maxQuantizeSamples {
+		step++
+	}
+	samples := make([]qcolor, 0, (b.Dx()/step+1)*(b.Dy()/step+1))
+	transparent := false
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A < 0x80 {
+				transparent = true
+				continue
+			}
+			samples = append(samples, qcolor{c.R, c.G, c.B})
+		}
+	}
+	return samples, transparent
+}
+
+// histogram buckets samples by their top 5 bits per channel.
+type histEntry struct {
+	sum   [3]int
+	count int
+}
+
+func (h histEntry) mean() qcolor {
+	return qcolor{
+		uint8(h.sum[0] / h.count),
+		uint8(h.sum[1] / h.count),
+		uint8(h.sum[2] / h.count),
+	}
+}
+
+func histogram(samples []qcolor) []histEntry {
+	m := make(map[int]*histEntry)
+	for _, s := range samples {
+		k := int(s[0]>>3)<<10 | int(s[1]>>3)<<5 | int(s[2]>>3)
+		e := m[k]
+		if e == nil {
+			e = &histEntry{}
+			m[k] = e
+		}
+		e.sum[0] += int(s[0])
+		e.sum[1] += int(s[1])
+		e.sum[2] += int(s[2])
+		e.count++
+	}
+	h := make([]histEntry, 0, len(m))
+	for _, e := range m {
+		h = append(h, *e)
+	}
+	return h
+}
+
+type colorBox struct {
+	entries []histEntry
+	count   int
+	lo, hi  qcolor
+}
+
+func newColorBox(entries []histEntry) *colorBox {
+	b := &colorBox{entries: entries, lo: qcolor{255, 255, 255}}
+	for _, e := range entries {
+		c := e.mean()
+		for i := 0; i < 3; i++ {
+			if c[i] < b.lo[i] {
+				b.lo[i] = c[i]
+			}
+			if c[i] > b.hi[i] {
+				b.hi[i] = c[i]
+			}
+		}
+		b.count += e.count
+	}
+	return b
+}
+
+func (b *colorBox) longestAxis() (int, int) {
+	axis, span := 0, -1
+	for i := 0; i < 3; i++ {
+		if s := int(b.hi[i]) - int(b.lo[i]); s > span {
+			axis, span = i, s
+		}
+	}
+	return axis, span
+}
+
+func (b *colorBox) mean() qcolor {
+	var e histEntry
+	for _, h := range b.entries {
+		for i := 0; i < 3; i++ {
+			e.sum[i] += h.sum[i]
+		}
+		e.count += h.count
+	}
+	return e.mean()
+}
+
+func medianCutPalette(samples []qcolor, n int) color.Palette {
+	boxes := []*colorBox{newColorBox(histogram(samples))}
+	for len(boxes) < n {
+		// Split the box with the largest population-weighted extent.
+		best, bestScore := -1, 0
+		for i, b := range boxes {
+			if len(b.entries) < 2 {
+				continue
+			}
+			_, span := b.longestAxis()
+			if score := span * b.count; best < 0 || score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		b := boxes[best]
+		axis, _ := b.longestAxis()
+		sort.Slice(b.entries, func(i, j int) bool {
+			return b.entries[i].sum[axis]*b.entries[j].count < b.entries[j].sum[axis]*b.entries[i].count
+		})
+		half, split := 0, 1
+		for i, e := range b.entries[:len(b.entries)-1] {
+			half += e.count
+			split = i + 1
+			if half*2 >= b.count {
+				break
+			}
+		}
+		boxes[best] = newColorBox(b.entries[:split])
+		boxes = append(boxes, newColorBox(b.entries[split:]))
+	}
+
+	p := make(color.Palette, len(boxes))
+	for i, b := range boxes {
+		c := b.mean()
+		p[i] = color.RGBA{c[0], c[1], c[2], 0xff}
+	}
+	return p
+}
+
+func kmeansPalette(samples []qcolor, n int) color.Palette {
+	hist := histogram(samples)
+	init := medianCutPalette(samples, n)
+	centers := make([][3]float64, len(init))
+	for i, c := range init {
+		rgba := c.(color.RGBA)
+		centers[i] = [3]float64{float64(rgba.R), float64(rgba.G), float64(rgba.B)}
+	}
+
+	for iter := 0; iter < 10; iter++ {
+		sums := make([][3]float64, len(centers))
+		counts := make([]float64, len(centers))
+		moved := false
+		for _, e := range hist {
+			c := e.mean()
+			best, bestD := 0, -1.0
+			for i, k := range centers {
+				d := sq(float64(c[0])-k[0]) + sq(float64(c[1])-k[1]) + sq(float64(c[2])-k[2])
+				if bestD < 0 || d < bestD {
+					best, bestD = i, d
+				}
+			}
+			w := float64(e.count)
+			for i := 0; i < 3; i++ {
+				sums[best][i] += float64(c[i]) * w
+			}
+			counts[best] += w
+		}
+		for i := range centers {
+			if counts[i] == 0 {
+				continue
+			}
+			for j := 0; j < 3; j++ {
+				v := sums[i][j] / counts[i]
+				if v-centers[i][j] > 0.5 || centers[i][j]-v > 0.5 {
+					moved = true
+				}
+				centers[i][j] = v
+			}
+		}
+		if !moved {
+			break
+		}
+	}
+
+	p := make(color.Palette, len(centers))
+	for i, k := range centers {
+		p[i] = color.RGBA{uint8(k[0] + 0.5), uint8(k[1] + 0.5), uint8(k[2] + 0.5), 0xff}
+	}
+	return p
+}
+
+func sq(f float64) float64 { return f * f }
+
+type octreeNode struct {
+	sum      [3]int
+	count    int
+	leaf     bool
+	children [8]*octreeNode
+}
+
+func octreePalette(samples []qcolor, n int) color.Palette {
+	const depth = 6
+	root := &octreeNode{}
+	var levels [depth][]*octreeNode
+	leaves := 0
+
+	for _, s := range samples {
+		node := root
+		for level := 0; level < depth; level++ {
+			shift := 7 - uint(level)
+			i := int(s[0]>>shift&1)<<2 | int(s[1]>>shift&1)<<1 | int(s[2]>>shift&1)
+			child := node.children[i]
+			if child == nil {
+				child = &octreeNode{leaf: level == depth-1}
+				node.children[i] = child
+				if child.leaf {
+					leaves++
+				} else {
+					levels[level] = append(levels[level], child)
+				}
+			}
+			node = child
+		}
+		node.sum[0] += int(s[0])
+		node.sum[1] += int(s[1])
+		node.sum[2] += int(s[2])
+		node.count++
+	}
+
+	// Fold the least populated nodes at the deepest level into their
+	// parents until the leaf count fits.
+	for level := depth - 2; level >= 0 && leaves > n; level-- {
+		nodes := levels[level]
+		for _, node := range nodes {
+			node.count = subtreeCount(node)
+		}
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].count < nodes[j].count })
+		for _, node := range nodes {
+			if leaves <= n {
+				break
+			}
+			merged := 0
+			for i, c := range node.children {
+				if c == nil {
+					continue
+				}
+				node.sum[0] += c.sum[0]
+				node.sum[1] += c.sum[1]
+				node.sum[2] += c.sum[2]
+				node.children[i] = nil
+				merged++
+			}
+			node.leaf = true
+			leaves -= merged - 1
+		}
+	}
+	if leaves > n {
+		// Only the root's children are left, which happens when n < 8.
+		return medianCutPalette(samples, n)
+	}
+
+	var p color.Palette
+	var walk func(*octreeNode)
+	walk = func(node *octreeNode) {
+		if node.leaf {
+			if node.count > 0 {
+				p = append(p, color.RGBA{
+					uint8(node.sum[0] / node.count),
+					uint8(node.sum[1] / node.count),
+					uint8(node.sum[2] / node.count),
+					0xff,
+				})
+			}
+			return
+		}
+		for _, c := range node.children {
+			if c != nil {
+				walk(c)
+			}
+		}
+	}
+	walk(root)
+	return p
+}
+
+func subtreeCount(node *octreeNode) int {
+	if node.leaf {
+		return node.count
+	}
+	n := 0
+	for _, c := range node.children {
+		if c != nil {
+			n += subtreeCount(c)
+		}
+	}
+	return n
+}
+
+// NeuQuant parameters, from Dekker's reference implementation.
+const (
+	nqCycles          = 100
+	nqNetBiasShift    = 4
+	nqIntBiasShift    = 16
+	nqIntBias         = 1 << nqIntBiasShift
+	nqGammaShift      = 10
+	nqBetaShift       = 10
+	nqBeta            = nqIntBias >> nqBetaShift
+	nqBetaGamma       = nqIntBias << (nqGammaShift - nqBetaShift)
+	nqRadiusBiasShift = 6
+	nqRadiusBias      = 1 << nqRadiusBiasShift
+	nqRadiusDec       = 30
+	nqAlphaBiasShift  = 10
+	nqInitAlpha       = 1 << nqAlphaBiasShift
+	nqRadBiasShift    = 8
+	nqRadBias         = 1 << nqRadBiasShift
+	nqAlphaRadBShift  = nqAlphaBiasShift + nqRadBiasShift
+	nqAlphaRadBias    = 1 << nqAlphaRadBShift
+	nqSampleFactor    = 10
+)
+
+func neuquantPalette(samples []qcolor, n int) color.Palette {
+	network := make([][3]int, n)
+	bias := make([]int, n)
+	freq := make([]int, n)
+	for i := range network {
+		v := (i << (nqNetBiasShift + 8)) / n
+		network[i] = [3]int{v, v, v}
+		freq[i] = nqIntBias / n
+	}
+
+	contest := func(c [3]int) int {
+		bestd, bestbiasd := int(^uint(0)>>1), int(^uint(0)>>1)
+		bestpos, bestbiaspos := 0, 0
+		for i, p := range network {
+			dist := abs(p[0]-c[0]) + abs(p[1]-c[1]) + abs(p[2]-c[2])
+			if dist < bestd {
+				bestd, bestpos = dist, i
+			}
+			biasdist := dist - (bias[i] >> (nqIntBiasShift - nqNetBiasShift))
+			if biasdist < bestbiasd {
+				bestbiasd, bestbiaspos = biasdist, i
+			}
+			betafreq := freq[i] >> nqBetaShift
+			freq[i] -= betafreq
+			bias[i] += betafreq << nqGammaShift
+		}
+		freq[bestpos] += nqBeta
+		bias[bestpos] -= nqBetaGamma
+		return bestbiaspos
+	}
+
+	initRadius := (n >> 3) * nqRadiusBias
+	radpower := make([]int, n>>3+1)
+	setRadpower := func(rad, alpha int) {
+		for i := 0; i < rad; i++ {
+			radpower[i] = alpha * (((rad*rad - i*i) * nqRadBias) / (rad * rad))
+		}
+	}
+
+	alphadec := 30 + (nqSampleFactor-1)/3
+	total := len(samples) / nqSampleFactor
+	if total < 1 {
+		total = len(samples)
+	}
+	delta := total / nqCycles
+	if delta == 0 {
+		delta = 1
+	}
+	alpha, radius := nqInitAlpha, initRadius
+	rad := radius >> nqRadiusBiasShift
+	if rad <= 1 {
+		rad = 0
+	}
+	setRadpower(rad, alpha)
+
+	step := 1
+	for _, prime := range []int{499, 491, 487, 503} {
+		if len(samples)%prime != 0 {
+			step = prime
+			break
+		}
+	}
+	if len(samples) < 503 {
+		step = 1
+	}
+
+	pos := 0
+	for i := 0; i < total; {
+		s := samples[pos]
+		c := [3]int{int(s[0]) << nqNetBiasShift, int(s[1]) << nqNetBiasShift, int(s[2]) << nqNetBiasShift}
+		j := contest(c)
+
+		p := &network[j]
+		for k := 0; k < 3; k++ {
+			p[k] -= alpha * (p[k] - c[k]) / nqInitAlpha
+		}
+		if rad > 0 {
+			lo, hi := j-rad, j+rad
+			if lo < -1 {
+				lo = -1
+			}
+			if hi > n {
+				hi = n
+			}
+			for up, down, m := j+1, j-1, 1; up < hi || down > lo; m++ {
+				a := radpower[m]
+				if up < hi {
+					q := &network[up]
+					for k := 0; k < 3; k++ {
+						q[k] -= a * (q[k] - c[k]) / nqAlphaRadBias
+					}
+					up++
+				}
+				if down > lo {
+					q := &network[down]
+					for k := 0; k < 3; k++ {
+						q[k] -= a * (q[k] - c[k]) / nqAlphaRadBias
+					}
+					down--
+				}
+			}
+		}
+
+		pos = (pos + step) % len(samples)
+		i++
+		if i%delta == 0 {
+			alpha -= alpha / alphadec
+			radius -= radius / nqRadiusDec
+			rad = radius >> nqRadiusBiasShift
+			if rad <= 1 {
+				rad = 0
+			}
+			setRadpower(rad, alpha)
+		}
+	}
+
+	p := make(color.Palette, n)
+	for i, c := range network {
+		p[i] = color.RGBA{clamp8(c[0] >> nqNetBiasShift), clamp8(c[1] >> nqNetBiasShift), clamp8(c[2] >> nqNetBiasShift), 0xff}
+	}
+	return p
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func clamp8(n int) uint8 {
+	if n < 0 {
+		return 0
+	}
+	if n > 255 {
+		return 255
+	}
+	return uint8(n)
+}
@@ -0,0 +1,196 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strings"
+)
+
+// Layout selects the order of samples in raw pixel buffers.
+type Layout int
+
+const (
+	HWC Layout = iota // interleaved: rows of pixels, each with all of its channels
+	CHW               // planar: one full plane per channel
+)
+
+// SampleType selects how raw samples are stored.
+type SampleType int
+
+const (
+	SampleAuto    SampleType = iota // Uint16 for 16-bit images, otherwise Uint8
+	SampleUint8                     // 0-255
+	SampleUint16                    // 0-65535, little-endian
+	SampleFloat32                   // 0-1, little-endian IEEE 754
+)
+
+// RawOptions configures raw pixel export.
+type RawOptions struct {
+	Layout Layout
+	Type   SampleType
+
+	// Channels is 1 for gray, 3 for RGB or 4 for RGBA with straight
+	// alpha. Zero picks 1 for gray images, 3 for opaque ones and 4
+	// otherwise. Dropped alpha is composited onto black.
+	Channels int
+}
+
+// resolve fills in the defaults of o for img.
+func (o RawOptions) resolve(img image.Image) (RawOptions, error) {
+	if o.Type == SampleAuto {
+		o.Type = SampleUint8
+		if isDeep(img) {
+			o.Type = SampleUint16
+		}
+	}
+	if o.Channels == 0 {
+		switch {
+		case img.ColorModel() == color.GrayModel || img.ColorModel() == color.Gray16Model:
+			o.Channels = 1
+		case isOpaque(img):
+			o.Channels = 3
+		default:
+			o.Channels = 4
+		}
+	}
+	if o.Channels != 1 && o.Channels != 3 && o.Channels != 4 {
+		return o, errors.New("raw: channels must be 1, 3 or 4")
+	}
+	if o.Layout != HWC && o.Layout != CHW {
+		return o, errors.New("raw: unknown layout")
+	}
+	if o.Type < SampleUint8 || o.Type > SampleFloat32 {
+		return o, errors.New("raw: unknown sample type")
+	}
+	return o, nil
+}
+
+func (t SampleType) size() int {
+	switch t {
+	case SampleUint16:
+		return 2
+	case SampleFloat32:
+		return 4
+	}
+	return 1
+}
+
+// shape returns the dimensions of the sample array for a w×h image.
+func (o RawOptions) shape(w, h int) []int {
+	if o.Layout == CHW {
+		return []int{o.Channels, h, w}
+	}
+	return []int{h, w, o.Channels}
+}
+
+// Raw returns the samples of img as a contiguous buffer, with rows
+// from top to bottom.
+func Raw(img image.Image, opts RawOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeRaw(&buf, img, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteNPY writes the samples of img as a NumPy .npy file, with shape
+// (height, width, channels) for HWC or (channels, height, width) for CHW.
+func WriteNPY(w io.Writer, img image.Image, opts RawOptions) error {
+	opts, err := opts.resolve(img)
+	if err != nil {
+		return err
+	}
+	b := img.Bounds()
+	if _, err := w.Write(npyHeader(opts.Type, opts.shape(b.Dx(), b.Dy()))); err != nil {
+		return err
+	}
+	return writeRaw(w, img, opts)
+}
+
+// npyHeader returns a version 1.0 .npy header for a C-ordered array.
+func npyHeader(t SampleType, shape []int) []byte {
+	descr := map[SampleType]string{SampleUint8: "|u1", SampleUint16: "<u2", SampleFloat32: "<f4"}[t]
+	dims := make([]string, len(shape))
+	for i, n := range shape {
+		dims[i] = fmt.Sprint(n)
+	}
+	dict := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': (%s), }", descr, strings.Join(dims, ", "))
+	// Pad with spaces so that the data starts on a 64-byte boundary.
+	const prefix = 10
+	n := prefix + len(dict) + 1
+	dict += strings.Repeat(" ", (64-n%64)%64) + "\n"
+
+	hdr := make([]byte, prefix, prefix+len(dict))
+	copy(hdr, "\x93NUMPY\x01\x00")
+	binary.LittleEndian.PutUint16(hdr[8:], uint16(len(dict)))
+	return append(hdr, dict...)
+}
+
+// writeRaw writes the samples of img to w.
+func writeRaw(w io.Writer, img image.Image, opts RawOptions) error {
+	opts, err := opts.resolve(img)
+	if err != nil {
+		return err
+	}
+	b := img.Bounds()
+	bw := bufio.NewWriter(w)
+	size := opts.Type.size()
+	row := make([]byte, b.Dx()*opts.Channels*size)
+	samples := make([]uint16, opts.Channels)
+	put := func(out []byte, v uint16) {
+		switch opts.Type {
+		case SampleUint8:
+			out[0] = uint8(v >> 8)
+		case SampleUint16:
+			binary.LittleEndian.PutUint16(out, v)
+		case SampleFloat32:
+			binary.LittleEndian.PutUint32(out, math.Float32bits(float32(v)/0xffff))
+		}
+	}
+
+	planes, channels := 1, opts.Channels
+	if opts.Layout == CHW {
+		planes, channels = opts.Channels, 1
+	}
+	for p := 0; p < planes; p++ {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				pixelSamples(samples, img.At(x, y))
+				out := row[(x-b.Min.X)*channels*size:]
+				if opts.Layout == CHW {
+					put(out, samples[p])
+					continue
+				}
+				for c, v := range samples {
+					put(out[c*size:], v)
+				}
+			}
+			if _, err := bw.Write(row[:b.Dx()*channels*size]); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// pixelSamples stores the 16-bit gray, RGB or RGBA samples of c in s,
+// according to its length.
+func pixelSamples(s []uint16, c color.Color) {
+	switch len(s) {
+	case 1:
+		s[0] = color.Gray16Model.Convert(c).(color.Gray16).Y
+	case 3:
+		r, g, b, _ := c.RGBA()
+		s[0], s[1], s[2] = uint16(r), uint16(g), uint16(b)
+	default:
+		n := color.NRGBA64Model.Convert(c).(color.NRGBA64)
+		s[0], s[1], s[2], s[3] = n.R, n.G, n.B, n.A
+	}
+}
@@ -0,0 +1,134 @@
+package convert
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// RedactStyle selects how Redact masks a region.
+type RedactStyle int
+
+const (
+	RedactBlur     RedactStyle = iota // heavy box blur confined to the region
+	RedactPixelate                    // flat blocks of the region's mean colour
+	RedactFill                        // solid black
+)
+
+// Redact returns a copy of img with each of rects masked in style, such
+// as to hide faces, licence plates or other personal details. Blur and
+// pixelate strengths scale with each region's shorter side, so that the
+// content cannot be recovered, and read only pixels inside the region.
+func Redact(img image.Image, rects []image.Rectangle, style RedactStyle) image.Image {
+	b := img.Bounds()
+	dst := newRGBAFor(img, b)
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+	redact(dst, rects, style)
+	return dst
+}
+
+// redact masks rects of img in place.
+func redact(img draw.Image, rects []image.Rectangle, style RedactStyle) {
+	for _, r := range rects {
+		r = r.Intersect(img.Bounds())
+		if r.Empty() {
+			continue
+		}
+		switch style {
+		case RedactFill:
+			draw.Draw(img, r, image.Black, image.Point{}, draw.Src)
+		case RedactPixelate:
+			n := max1(minInt(r.Dx(), r.Dy()) / 8)
+			if n < 8 {
+				n = 8
+			}
+			for y := r.Min.Y; y < r.Max.Y; y += n {
+				for x := r.Min.X; x < r.Max.X; x += n {
+					block := image.Rect(x, y, x+n, y+n).Intersect(r)
+					draw.Draw(img, block, image.NewUniform(meanColor(img, block)), image.Point{}, draw.Src)
+				}
+			}
+		default:
+			blurRect(img, r)
+		}
+	}
+}
+
+// meanColor returns the average premultiplied colour of r in img.
+func meanColor(img image.Image, r image.Rectangle) color.Color {
+	var sum [4]uint64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			cr, cg, cb, ca := img.At(x, y).RGBA()
+			sum[0], sum[1], sum[2], sum[3] = sum[0]+uint64(cr), sum[1]+uint64(cg), sum[2]+uint64(cb), sum[3]+uint64(ca)
+		}
+	}
+	n := uint64(r.Dx() * r.Dy())
+	return color.RGBA64{uint16(sum[0] / n), uint16(sum[1] / n), uint16(sum[2] / n), uint16(sum[3] / n)}
+}
+
+// blurRect applies three passes of a box blur, approximating a
+// Gaussian, to r of img, treating pixels outside r as its edge pixels.
+func blurRect(img draw.Image, r image.Rectangle) {
+	w, h := r.Dx(), r.Dy()
+	radius := minInt(w, h) / 6
+	if radius < 4 {
+		radius = 4
+	}
+	var planes [4][]float64
+	for i := range planes {
+		planes[i] = make([]float64, 0, w*h)
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			cr, cg, cb, ca := img.At(x, y).RGBA()
+			planes[0] = append(planes[0], float64(cr))
+			planes[1] = append(planes[1], float64(cg))
+			planes[2] = append(planes[2], float64(cb))
+			planes[3] = append(planes[3], float64(ca))
+		}
+	}
+	for i := range planes {
+		for pass := 0; pass < 3; pass++ {
+			planes[i] = boxBlur(planes[i], w, h, radius)
+		}
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			img.Set(r.Min.X+x, r.Min.Y+y, color.RGBA64{
+				uint16(planes[0][i] + 0.5), uint16(planes[1][i] + 0.5),
+				uint16(planes[2][i] + 0.5), uint16(planes[3][i] + 0.5),
+			})
+		}
+	}
+}
+
+// boxBlur averages each sample of a w×h plane with its neighbours up to
+// radius away along each axis, clamping at the edges. Running sums keep
+// the cost independent of the radius.
+func boxBlur(src []float64, w, h, radius int) []float64 {
+	tmp := make([]float64, len(src))
+	boxBlurLines(tmp, src, w, h, 1, w, radius)
+	dst := make([]float64, len(src))
+	boxBlurLines(dst, tmp, h, w, w, 1, radius)
+	return dst
+}
+
+// boxBlurLines blurs count lines of n samples each, stride apart
+// within a line and step apart between lines.
+func boxBlurLines(dst, src []float64, n, count, stride, step, radius int) {
+	at := func(base, i int) float64 { return src[base+clampInt(i, 0, n-1)*stride] }
+	scale := 1 / float64(2*radius+1)
+	for l := 0; l < count; l++ {
+		base := l * step
+		var sum float64
+		for i := -radius; i <= radius; i++ {
+			sum += at(base, i)
+		}
+		for i := 0; i < n; i++ {
+			dst[base+i*stride] = sum * scale
+			sum += at(base, i+radius+1) - at(base, i-radius)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package convert
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"math"
+
+	"github.com/imgutils-org/imgutils-convert/internal/tiffio"
+)
+
+// DecodeRegion decodes the part of an image inside rect, scaled by
+// scale (0 < scale <= 1). For TIFF input only the strips or tiles that
+// intersect rect are read, and the smallest reduced-resolution level
+// (SubIFD or reduced-resolution page) that is at least scale times the
+// full size is used. Baseline JPEG input is reduced by 1/2, 1/4 or 1/8
+// while decoding when scale allows. If r implements io.ReaderAt, as
+// *os.File does, it is read at random; otherwise it is buffered in
+// memory. Other formats are decoded in full and cropped.
+func DecodeRegion(r io.Reader, rect image.Rectangle, scale float64) (image.Image, error) {
+	if scale <= 0 || scale > 1 {
+		scale = 1
+	}
+	ra, ok := r.(io.ReaderAt)
+	if !ok {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		ra = bytes.NewReader(data)
+	}
+
+	var magic [4]byte
+	if _, err := ra.ReadAt(magic[:], 0); err != nil {
+		return nil, err
+	}
+	cfg, _, err := DecodeConfigAt(ra, math.MaxInt64)
+	if err != nil {
+		return nil, err
+	}
+	if rect = rect.Intersect(image.Rect(0, 0, cfg.Width, cfg.Height)); rect.Empty() {
+		return nil, errors.New("region outside image")
+	}
+	var img image.Image
+	switch m := string(magic[:]); {
+	case m == "II*\x00" || m == "MM\x00*" || m == "II+\x00" || m == "MM\x00+":
+		img, err = decodeTIFFRegion(ra, rect, scale)
+	case m[:2] == "\xff\xd8":
+		img, err = decodeJPEGRegion(ra, rect, scale)
+	default:
+		img, _, err = Decode(io.NewSectionReader(ra, 0, math.MaxInt64))
+		if err == nil {
+			img, err = crop(img, rect)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	w := max1(int(math.Round(float64(rect.Dx()) * scale)))
+	h := max1(int(math.Round(float64(rect.Dy()) * scale)))
+	if b := img.Bounds(); b.Dx() == w && b.Dy() == h {
+		return img, nil
+	}
+	return Resize(img, w, h), nil
+}
+
+func decodeTIFFRegion(ra io.ReaderAt, rect image.Rectangle, scale float64) (image.Image, error) {
+	f, err := tiffio.Open(ra)
+	if err != nil {
+		return nil, err
+	}
+	base := f.IFDs[0]
+	if base.Width() <= 0 {
+		return nil, errors.New("tiff: invalid dimensions")
+	}
+	levels := append([]*tiffio.IFD{base}, base.Sub...)
+	for _, d := range f.IFDs[1:] {
+		if d.Reduced() {
+			levels = append(levels, d)
+		}
+	}
+
+	// Pick the smallest level that still has enough resolution.
+	level, ls := base, 1.0
+	for _, d := range levels {
+		s := float64(d.Width()) / float64(base.Width())
+		if s >= scale*0.999 && s < ls {
+			level, ls = d, s
+		}
+	}
+	r := image.Rect(
+		int(math.Floor(float64(rect.Min.X)*ls)), int(math.Floor(float64(rect.Min.Y)*ls)),
+		int(math.Ceil(float64(rect.Max.X)*ls)), int(math.Ceil(float64(rect.Max.Y)*ls)),
+	)
+	img, err := f.DecodeRegion(level, r)
+	if errors.Is(err, tiffio.ErrUnsupported) && level == base {
+		// Fall back to the full decoder for features tiffio lacks.
+		if img, _, err = Decode(io.NewSectionReader(ra, 0, math.MaxInt64)); err == nil {
+			return crop(img, r)
+		}
+	}
+	return img, err
+}
+
+func decodeJPEGRegion(ra io.ReaderAt, rect image.Rectangle, scale float64) (image.Image, error) {
+	img, n, err := decodeJPEGScaled(ra, jpegScale(scale), false)
+	if err != nil {
+		return nil, err
+	}
+	return crop(img, image.Rect(
+		rect.Min.X/n, rect.Min.Y/n, (rect.Max.X+n-1)/n, (rect.Max.Y+n-1)/n,
+	))
+}
+
+// crop returns the part of img inside r.
+func crop(img image.Image, r image.Rectangle) (image.Image, error) {
+	r = r.Intersect(img.Bounds())
+	if r.Empty() {
+		return nil, errors.New("region outside image")
+	}
+	if s, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r), nil
+	}
+	return nil, errors.New("image does not support cropping")
+}
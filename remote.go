@@ -0,0 +1,229 @@
+package convert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// remoteBlockSize is the unit in which RemoteFile fetches and caches.
+const remoteBlockSize = 64 << 10
+
+// RemoteFile reads a remote file with HTTP range requests, fetching
+// only the blocks that reads touch and caching them, so that
+// DecodeConfigAt and DecodeRegion read the headers and tiles of a
+// huge TIFF rather than downloading all of it. If the server does not
+// support ranges, the whole body is downloaded when it is opened.
+type RemoteFile struct {
+	*io.SectionReader
+	rr *rangeReader
+}
+
+// Open opens rawURL for reading at random, enforcing the Fetcher's
+// policy. MaxBytes limits the bytes downloaded rather than the size of
+// the file; reads past it fail with ErrTooLarge. The file is read with
+// ctx and fails if it changes on the server after it is opened.
+func (f *Fetcher) Open(ctx context.Context, rawURL string) (*RemoteFile, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkURL(u); err != nil {
+		return nil, err
+	}
+	rr := &rangeReader{f: f, ctx: ctx, url: u, blocks: map[int64][]byte{}}
+	if err := rr.open(); err != nil {
+		return nil, err
+	}
+	return &RemoteFile{SectionReader: io.NewSectionReader(rr, 0, rr.size), rr: rr}, nil
+}
+
+// Fetched returns the number of bytes downloaded so far.
+func (r *RemoteFile) Fetched() int64 {
+	r.rr.mu.Lock()
+	defer r.rr.mu.Unlock()
+	return r.rr.fetched
+}
+
+// DecodeConfig returns the dimensions and color model of the image at
+// rawURL, fetching only its headers where the format allows.
+func (f *Fetcher) DecodeConfig(ctx context.Context, rawURL string) (image.Config, Format, error) {
+	rf, err := f.Open(ctx, rawURL)
+	if err != nil {
+		return image.Config{}, "", err
+	}
+	return DecodeConfigAt(rf, rf.Size())
+}
+
+// DecodeRegion decodes part of the image at rawURL like DecodeRegion,
+// fetching only the strips or tiles of TIFF images that it needs.
+func (f *Fetcher) DecodeRegion(ctx context.Context, rawURL string, rect image.Rectangle, scale float64) (image.Image, error) {
+	rf, err := f.Open(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeRegion(rf, rect, scale)
+}
+
+// rangeReader is the io.ReaderAt behind a RemoteFile.
+type rangeReader struct {
+	f    *Fetcher
+	ctx  context.Context
+	url  *url.URL
+	size int64
+	etag string
+
+	mu      sync.Mutex
+	blocks  map[int64][]byte // by block index
+	fetched int64
+}
+
+// open fetches the first block, learning the size of the file and
+// whether the server supports ranges.
+func (rr *rangeReader) open() error {
+	resp, err := rr.get(0, remoteBlockSize, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		i := strings.LastIndexByte(resp.Header.Get("Content-Range"), '/')
+		if i < 0 {
+			return errors.New("fetch: bad Content-Range")
+		}
+		if rr.size, err = strconv.ParseInt(resp.Header.Get("Content-Range")[i+1:], 10, 64); err != nil {
+			return fmt.Errorf("fetch: bad Content-Range: %w", err)
+		}
+		rr.etag = resp.Header.Get("ETag")
+		return rr.store(0, resp.Body)
+	case http.StatusOK:
+		// No range support: keep the whole body.
+		max := rr.f.maxBytes()
+		data, err := io.ReadAll(io.LimitReader(resp.Body, max+1))
+		if err != nil {
+			return err
+		}
+		if int64(len(data)) > max {
+			return ErrTooLarge
+		}
+		rr.size, rr.fetched = int64(len(data)), int64(len(data))
+		for i := int64(0); i*remoteBlockSize < rr.size; i++ {
+			end := (i + 1) * remoteBlockSize
+			if end > rr.size {
+				end = rr.size
+			}
+			rr.blocks[i] = data[i*remoteBlockSize : end]
+		}
+		return nil
+	}
+	return fmt.Errorf("fetch %s: %s", rr.url.Redacted(), resp.Status)
+}
+
+func (rr *rangeReader) get(off, n int64, ifRange string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(rr.ctx, http.MethodGet, rr.url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	if ifRange != "" {
+		req.Header.Set("If-Range", ifRange)
+	}
+	return rr.f.httpClient().Do(req)
+}
+
+// store caches the blocks read from body, starting with block first.
+func (rr *rangeReader) store(first int64, body io.Reader) error {
+	for i := first; ; i++ {
+		block := make([]byte, remoteBlockSize)
+		n, err := io.ReadFull(body, block)
+		if n > 0 {
+			rr.blocks[i] = block[:n]
+			rr.fetched += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ReadAt implements io.ReaderAt, fetching the missing blocks that p
+// covers with one request.
+func (rr *rangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= rr.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > rr.size {
+		end = rr.size
+	}
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	first, last := off/remoteBlockSize, (end-1)/remoteBlockSize
+	lo, hi := int64(-1), int64(-1)
+	for i := first; i <= last; i++ {
+		if _, ok := rr.blocks[i]; !ok {
+			if lo < 0 {
+				lo = i
+			}
+			hi = i
+		}
+	}
+	if lo >= 0 {
+		n := (hi - lo + 1) * remoteBlockSize
+		if rr.fetched+n > rr.f.maxBytes() {
+			return 0, ErrTooLarge
+		}
+		if err := rr.fetch(lo, n); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	for i := first; i <= last && n < len(p); i++ {
+		block := rr.blocks[i]
+		start := int64(0)
+		if i == first {
+			start = off - i*remoteBlockSize
+		}
+		if start >= int64(len(block)) {
+			return n, io.ErrUnexpectedEOF
+		}
+		n += copy(p[n:], block[start:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fetch downloads n bytes from block first on.
+func (rr *rangeReader) fetch(first, n int64) error {
+	ifRange := rr.etag
+	if strings.HasPrefix(ifRange, "W/") {
+		ifRange = "" // weak validators may not be used with If-Range
+	}
+	resp, err := rr.get(first*remoteBlockSize, n, ifRange)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return rr.store(first, resp.Body)
+	case http.StatusOK:
+		return errors.New("fetch: remote file changed or ranges no longer supported")
+	}
+	return fmt.Errorf("fetch %s: %s", rr.url.Redacted(), resp.Status)
+}
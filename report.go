@@ -0,0 +1,85 @@
+package convert
+
+import (
+	"encoding/json"
+	"image"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record describes the conversion of one file.
+type Record struct {
+	Input        string   `json:"input"`
+	Output       string   `json:"output"`
+	InputFormat  Format   `json:"input_format,omitempty"`
+	Decoder      string   `json:"decoder,omitempty"`
+	OutputFormat Format   `json:"output_format"`
+	InputSize    int64    `json:"input_size"`
+	OutputSize   int64    `json:"output_size,omitempty"`
+	OutputSHA256 string   `json:"output_sha256,omitempty"`
+	DurationMS   float64  `json:"duration_ms"`
+	Warnings     []string `json:"warnings,omitempty"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// ConvertFileRecord runs ConvertFile and describes the outcome, which
+// includes any error, also returned.
+func ConvertFileRecord(inputPath, outputPath string, opts Options) (Record, error) {
+	return convertFileRecord(inputPath, outputPath, FormatFromExtension(outputPath), opts)
+}
+
+// convertFileRecord is ConvertFileRecord writing format.
+func convertFileRecord(inputPath, outputPath string, format Format, opts Options) (Record, error) {
+	rec := Record{Input: inputPath, Output: outputPath, OutputFormat: format}
+	if f, err := os.Open(longPath(inputPath)); err == nil {
+		if _, name, err := image.DecodeConfig(f); err == nil {
+			rec.InputFormat = Format(name)
+		}
+		if info, err := f.Stat(); err == nil {
+			rec.InputSize = info.Size()
+		}
+		f.Close()
+	}
+	warn := opts.WarnFunc
+	opts.WarnFunc = func(w Warning) {
+		rec.Warnings = append(rec.Warnings, w.String())
+		if warn != nil {
+			warn(w)
+		}
+	}
+
+	start := time.Now()
+	decoder, err := convertFile(inputPath, outputPath, format, opts)
+	rec.Decoder = decoder
+	rec.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		rec.Error = err.Error()
+		return rec, err
+	}
+	if info, err := os.Stat(longPath(outputPath)); err == nil {
+		rec.OutputSize = info.Size()
+	}
+	rec.OutputSHA256, _ = FileSHA256(outputPath)
+	return rec, nil
+}
+
+// Report writes Records as newline-delimited JSON, one per line, for
+// pipelines to consume. It is safe for concurrent use.
+type Report struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewReport returns a Report that writes to w.
+func NewReport(w io.Writer) *Report {
+	return &Report{enc: json.NewEncoder(w)}
+}
+
+// Write writes rec as one line.
+func (r *Report) Write(rec Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(rec)
+}
@@ -0,0 +1,58 @@
+package convert
+
+import (
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// Resize scales an image to exactly width x height using Catmull-Rom
+// resampling.
+func Resize(img image.Image, width, height int) image.Image {
+	return ResizeKernel(img, width, height, CatmullRom)
+}
+
+// ResizeKernel scales an image to exactly width x height using the
+// given resampling kernel.
+func ResizeKernel(img image.Image, width, height int, k Kernel) image.Image {
+	b := img.Bounds()
+	if width <= 0 || height <= 0 || (width == b.Dx() && height == b.Dy()) {
+		return img
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	dk := &draw.Kernel{Support: k.Support(), At: k.At}
+	dk.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// Fit scales an image down so that it fits within maxWidth x maxHeight,
+// preserving its aspect ratio. A zero limit leaves that dimension
+// unconstrained. Images that already fit are returned unchanged.
+func Fit(img image.Image, maxWidth, maxHeight int) image.Image {
+	w, h := fitSize(img.Bounds().Dx(), img.Bounds().Dy(), maxWidth, maxHeight)
+	return Resize(img, w, h)
+}
+
+// fitSize returns the dimensions of a w x h image scaled down to fit
+// within maxW x maxH.
+func fitSize(w, h, maxW, maxH int) (int, int) {
+	if w <= 0 || h <= 0 {
+		return w, h
+	}
+	if maxW > 0 && w > maxW {
+		h = max1(h * maxW / w)
+		w = maxW
+	}
+	if maxH > 0 && h > maxH {
+		w = max1(w * maxH / h)
+		h = maxH
+	}
+	return w, h
+}
+
+func max1(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
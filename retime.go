@@ -0,0 +1,100 @@
+package convert
+
+import (
+	"math"
+	"time"
+)
+
+// minGIFDelay is the shortest delay browsers honour; they play shorter
+// ones, including none, at defaultGIFDelay.
+const (
+	minGIFDelay     = 2
+	defaultGIFDelay = 10
+)
+
+// retimed reports whether the options change animation timing.
+func (opts Options) retimed() bool {
+	return opts.FrameRate > 0 || opts.Speed > 0 || opts.MaxDuration > 0
+}
+
+// retime returns a with the timing the options ask for. Delays that
+// browsers would not honour are first read as they play them. Frames
+// that fall between two ticks of the frame rate, or after the maximum
+// duration, are dropped.
+func (opts Options) retime(a *Animation) *Animation {
+	if !opts.retimed() || len(a.Frames) == 0 {
+		return a
+	}
+	speed := opts.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	// Start times in hundredths of a second, with the end of the last
+	// frame appended.
+	start := make([]float64, len(a.Frames)+1)
+	for i := range a.Frames {
+		d := defaultGIFDelay
+		if i < len(a.Delays) && a.Delays[i] >= minGIFDelay {
+			d = a.Delays[i]
+		}
+		start[i+1] = start[i] + float64(d)/speed
+	}
+	end := start[len(a.Frames)]
+	if opts.MaxDuration > 0 {
+		end = math.Min(end, float64(opts.MaxDuration)/float64(10*time.Millisecond))
+	}
+
+	// Sample the source at each tick, or at every frame start without
+	// a frame rate.
+	var ticks []float64
+	if opts.FrameRate > 0 {
+		for t, step := 0.0, 100/opts.FrameRate; t < end; t += step {
+			ticks = append(ticks, t)
+		}
+	} else {
+		for _, t := range start[:len(a.Frames)] {
+			if t < end {
+				ticks = append(ticks, t)
+			}
+		}
+	}
+
+	out := &Animation{LoopCount: a.LoopCount}
+	src, shown := 0, -1
+	var times []float64
+	for _, t := range ticks {
+		for src+1 < len(a.Frames) && start[src+1] <= t {
+			src++
+		}
+		if src == shown {
+			continue
+		}
+		shown = src
+		out.Frames = append(out.Frames, a.Frames[src])
+		times = append(times, t)
+	}
+	times = append(times, end)
+
+	// Round the times rather than each delay, so that errors do not
+	// accumulate. Frames too short to play are dropped in favour of the
+	// next one, or the previous one at the end.
+	frames, kept := out.Frames, 0
+	for i, s := 0, math.Round(times[0]); i < len(frames); i++ {
+		e := math.Round(times[i+1])
+		switch d := int(e - s); {
+		case d >= minGIFDelay:
+			out.Frames[kept] = frames[i]
+			out.Delays = append(out.Delays, d)
+			kept++
+			s = e
+		case i == len(frames)-1 && kept > 0:
+			out.Delays[kept-1] += d
+		case i == len(frames)-1:
+			out.Frames[kept] = frames[i]
+			out.Delays = append(out.Delays, minGIFDelay)
+			kept++
+		}
+	}
+	out.Frames = out.Frames[:kept]
+	return out
+}
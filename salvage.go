@@ -0,0 +1,59 @@
+package convert
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+)
+
+// errNothingSalvaged is returned when Options.Salvage finds no image
+// stream in unrecognized input.
+var errNothingSalvaged = errors.New("salvage: no embedded JPEG or PNG found")
+
+// salvageMagic lists the signatures of the streams salvage looks for.
+var salvageMagic = [][]byte{
+	[]byte("\xff\xd8\xff"),
+	[]byte(pngHeader),
+}
+
+// salvageReader returns a reader of r's contents for decoding. If
+// Options.Salvage is set and the input is in no format Decode reads,
+// it returns a reader of the largest JPEG or PNG stream embedded in
+// it instead, such as the preview in a camera's raw file.
+func (opts Options) salvageReader(r io.Reader) (io.Reader, error) {
+	if !opts.Salvage {
+		return r, nil
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := image.DecodeConfig(bytes.NewReader(data)); !errors.Is(err, image.ErrFormat) {
+		return bytes.NewReader(data), nil
+	}
+	start, best := -1, 0
+	for _, magic := range salvageMagic {
+		for i := 0; ; i++ {
+			j := bytes.Index(data[i:], magic)
+			if j < 0 {
+				break
+			}
+			i += j
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(data[i:]))
+			if err != nil {
+				continue
+			}
+			if n := cfg.Width * cfg.Height; n > best {
+				// Confirm the stream decodes, not just its header.
+				if _, _, err := image.Decode(bytes.NewReader(data[i:])); err == nil {
+					start, best = i, n
+				}
+			}
+		}
+	}
+	if start < 0 {
+		return nil, errNothingSalvaged
+	}
+	return bytes.NewReader(data[start:]), nil
+}
@@ -0,0 +1,332 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/imgutils-org/imgutils-convert/internal/tiffio"
+)
+
+// DecodeScanlines decodes an image from r and calls f with each row,
+// top to bottom, as 8-bit non-premultiplied RGBA, four bytes per
+// pixel. Row is reused between calls; f must not keep it. A non-nil
+// error from f stops decoding and is returned.
+//
+// Non-interlaced PNG images are inflated a row at a time, and TIFF
+// images decoded a strip or row of tiles at a time, so that streaming
+// consumers such as printers and tile cutters need not hold the whole
+// image. Other images are decoded in full first.
+func DecodeScanlines(r io.Reader, f func(y int, row []byte) error) error {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(pngHeader) + 8 + 13)
+	switch {
+	case len(magic) == len(pngHeader)+8+13 && string(magic[:len(pngHeader)]) == pngHeader &&
+		string(magic[len(pngHeader)+4:len(pngHeader)+8]) == "IHDR" && magic[len(magic)-1] == 0:
+		return pngScanlines(br, f)
+	case bytes.HasPrefix(magic, []byte("II*\x00")) || bytes.HasPrefix(magic, []byte("MM\x00*")) ||
+		bytes.HasPrefix(magic, []byte("II+\x00")) || bytes.HasPrefix(magic, []byte("MM\x00+")):
+		return tiffScanlines(br, f)
+	}
+	img, _, err := Decode(br)
+	if err != nil {
+		return err
+	}
+	return imageScanlines(img, f)
+}
+
+// imageScanlines calls f with each row of img.
+func imageScanlines(img image.Image, f func(y int, row []byte) error) error {
+	b := img.Bounds()
+	row := make([]byte, 4*b.Dx())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for i, x := 0, b.Min.X; x < b.Max.X; i, x = i+4, x+1 {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			row[i], row[i+1], row[i+2], row[i+3] = c.R, c.G, c.B, c.A
+		}
+		if err := f(y-b.Min.Y, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tiffScanlines decodes a TIFF image a band of strips or tiles at a
+// time. The input is buffered unless it supports random access.
+func tiffScanlines(r io.Reader, f func(y int, row []byte) error) error {
+	tf, err := openTIFF(r)
+	if err != nil {
+		return err
+	}
+	d := tf.IFDs[0]
+	w, h := d.Width(), d.Height()
+	band := int(d.Uint(tiffio.TagTileLength, 0))
+	if band <= 0 {
+		band = int(d.Uint(tiffio.TagRowsPerStrip, uint64(h)))
+	}
+	if band <= 0 || band > h {
+		band = h
+	}
+	for y := 0; y < h; y += band {
+		img, err := tf.DecodeRegion(d, image.Rect(0, y, w, y+band))
+		if err != nil {
+			return err
+		}
+		if err := imageScanlines(img, func(dy int, row []byte) error {
+			return f(y+dy, row)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pngScanlines decodes a non-interlaced PNG image a row at a time.
+func pngScanlines(r io.Reader, f func(y int, row []byte) error) error {
+	if _, err := io.ReadFull(r, make([]byte, len(pngHeader))); err != nil {
+		return err
+	}
+	var ihdr, plte, trns []byte
+	var idat *idatReader
+	for idat == nil {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return err
+		}
+		n, typ := binary.BigEndian.Uint32(hdr[:4]), string(hdr[4:])
+		if typ == "IDAT" {
+			idat = &idatReader{r: r, n: n}
+			break
+		}
+		if n > 1<<24 {
+			return errors.New("png: chunk too large")
+		}
+		data := make([]byte, n+4) // with CRC
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		switch typ {
+		case "IHDR":
+			ihdr = data[:n]
+		case "PLTE":
+			plte = data[:n]
+		case "tRNS":
+			trns = data[:n]
+		case "IEND":
+			return errors.New("png: no image data")
+		}
+	}
+	if len(ihdr) != 13 {
+		return errors.New("png: bad IHDR")
+	}
+	p, err := newPNGRows(ihdr, plte, trns)
+	if err != nil {
+		return err
+	}
+	zr, err := zlib.NewReader(idat)
+	if err != nil {
+		return err
+	}
+	cur, prev := make([]byte, 1+p.stride), make([]byte, 1+p.stride)
+	row := make([]byte, 4*p.width)
+	for y := 0; y < p.height; y++ {
+		if _, err := io.ReadFull(zr, cur); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if err := unfilterRow(cur, prev[1:], p.bpp); err != nil {
+			return err
+		}
+		p.nrgba(row, cur[1:])
+		if err := f(y, row); err != nil {
+			return err
+		}
+		cur, prev = prev, cur
+	}
+	return nil
+}
+
+// idatReader reads the data of consecutive IDAT chunks, starting n
+// bytes before the end of the first.
+type idatReader struct {
+	r   io.Reader
+	n   uint32
+	end bool
+}
+
+func (ir *idatReader) Read(p []byte) (int, error) {
+	for ir.n == 0 {
+		if ir.end {
+			return 0, io.EOF
+		}
+		var hdr [12]byte // CRC of this chunk, then the next header
+		if _, err := io.ReadFull(ir.r, hdr[:]); err != nil {
+			return 0, err
+		}
+		if string(hdr[8:]) != "IDAT" {
+			ir.end = true
+			return 0, io.EOF
+		}
+		ir.n = binary.BigEndian.Uint32(hdr[4:8])
+	}
+	if uint32(len(p)) > ir.n {
+		p = p[:ir.n]
+	}
+	n, err := ir.r.Read(p)
+	ir.n -= uint32(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// unfilterRow reverses the PNG filter of cur, whose first byte is the
+// filter type, with prev the unfiltered previous row.
+func unfilterRow(cur, prev []byte, bpp int) error {
+	ft, row := cur[0], cur[1:]
+	switch ft {
+	case 0:
+	case 1:
+		for i := bpp; i < len(row); i++ {
+			row[i] += row[i-bpp]
+		}
+	case 2:
+		for i := range row {
+			row[i] += prev[i]
+		}
+	case 3:
+		for i := range row {
+			var a byte
+			if i >= bpp {
+				a = row[i-bpp]
+			}
+			row[i] += byte((int(a) + int(prev[i])) / 2)
+		}
+	case 4:
+		for i := range row {
+			var a, c byte
+			if i >= bpp {
+				a, c = row[i-bpp], prev[i-bpp]
+			}
+			row[i] += paeth(a, prev[i], c)
+		}
+	default:
+		return errors.New("png: bad filter type")
+	}
+	return nil
+}
+
+// pngRows converts unfiltered PNG rows to 8-bit RGBA.
+type pngRows struct {
+	width, height int
+	depth         int
+	ctype         byte
+	stride, bpp   int
+	palette       [256][4]byte
+	key           []byte // tRNS colour key of gray and RGB images
+}
+
+func newPNGRows(ihdr, plte, trns []byte) (*pngRows, error) {
+	p := &pngRows{
+		width:  int(binary.BigEndian.Uint32(ihdr)),
+		height: int(binary.BigEndian.Uint32(ihdr[4:])),
+		depth:  int(ihdr[8]),
+		ctype:  ihdr[9],
+	}
+	channels := map[byte]int{pngGray: 1, pngRGB: 3, pngPaletted: 1, 4: 2, pngRGBA: 4}[p.ctype]
+	switch {
+	case channels == 0, p.depth != 1 && p.depth != 2 && p.depth != 4 && p.depth != 8 && p.depth != 16,
+		p.depth < 8 && p.ctype != pngGray && p.ctype != pngPaletted, p.depth == 16 && p.ctype == pngPaletted:
+		return nil, errors.New("png: bad color type or bit depth")
+	case p.width <= 0 || p.height <= 0 || p.width > 1<<24/channels:
+		return nil, errors.New("png: bad dimensions")
+	}
+	p.stride = (p.width*channels*p.depth + 7) / 8
+	p.bpp = (channels*p.depth + 7) / 8
+	switch p.ctype {
+	case pngPaletted:
+		for i := 0; i+2 < len(plte) && i/3 < 256; i += 3 {
+			p.palette[i/3] = [4]byte{plte[i], plte[i+1], plte[i+2], 0xff}
+		}
+		for i, a := range trns {
+			if i < 256 {
+				p.palette[i][3] = a
+			}
+		}
+	case pngGray, pngRGB:
+		p.key = trns
+	}
+	return p, nil
+}
+
+// nrgba converts an unfiltered row to RGBA in dst.
+func (p *pngRows) nrgba(dst, src []byte) {
+	wide := p.depth == 16
+	sample := func(i int) byte { // channel i of the row, as 8 bits
+		if wide {
+			return src[2*i]
+		}
+		return src[i]
+	}
+	keyed := func(off, n int) bool { // the n channels from channel off match the colour key
+		if len(p.key) < 2*n {
+			return false
+		}
+		for c := 0; c < n; c++ {
+			v := int(src[off+c])
+			if wide {
+				v = int(binary.BigEndian.Uint16(src[2*(off+c):]))
+			}
+			if v != int(binary.BigEndian.Uint16(p.key[2*c:])) {
+				return false
+			}
+		}
+		return true
+	}
+	for x := 0; x < p.width; x++ {
+		px := dst[4*x : 4*x+4]
+		switch p.ctype {
+		case pngGray:
+			var v byte
+			if p.depth < 8 {
+				shift := 8 - p.depth - x*p.depth%8
+				s := src[x*p.depth/8] >> uint(shift) & (1<<p.depth - 1)
+				v = s * (0xff / (1<<p.depth - 1))
+				px[0], px[1], px[2], px[3] = v, v, v, 0xff
+				if len(p.key) >= 2 && int(s) == int(binary.BigEndian.Uint16(p.key)) {
+					px[3] = 0
+				}
+				continue
+			}
+			v = sample(x)
+			px[0], px[1], px[2], px[3] = v, v, v, 0xff
+			if keyed(x, 1) {
+				px[3] = 0
+			}
+		case pngPaletted:
+			i := int(src[x*p.depth/8])
+			if p.depth < 8 {
+				shift := 8 - p.depth - x*p.depth%8
+				i = i >> uint(shift) & (1<<p.depth - 1)
+			}
+			copy(px, p.palette[i][:])
+		case pngRGB:
+			px[0], px[1], px[2], px[3] = sample(3*x), sample(3*x+1), sample(3*x+2), 0xff
+			if keyed(3*x, 3) {
+				px[3] = 0
+			}
+		case 4: // gray with alpha
+			v := sample(2 * x)
+			px[0], px[1], px[2], px[3] = v, v, v, sample(2*x+1)
+		case pngRGBA:
+			px[0], px[1], px[2], px[3] = sample(4*x), sample(4*x+1), sample(4*x+2), sample(4*x+3)
+		}
+	}
+}
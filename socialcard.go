@@ -0,0 +1,163 @@
+package convert
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+)
+
+// Standard social card sizes.
+var (
+	SizeOpenGraph = image.Pt(1200, 630) // Facebook, LinkedIn and most link previews
+	SizeTwitter   = image.Pt(1200, 600) // X/Twitter summary_large_image
+	SizeSquare    = image.Pt(1080, 1080)
+)
+
+// SocialCard is a template for Open Graph and similar link preview
+// images: a background, an optional logo at the top left and a title
+// with an optional subtitle at the bottom left.
+type SocialCard struct {
+	Size image.Point // default SizeOpenGraph
+
+	Background      color.Color // default a dark slate
+	BackgroundImage image.Image // scaled to cover the card and centred
+
+	// GradientTop and GradientBottom, if either is set, overlay a
+	// vertical gradient on the background to keep text legible. Nil
+	// ends are transparent.
+	GradientTop, GradientBottom color.Color
+
+	Logo       image.Image
+	LogoHeight int // default 64
+
+	Title     string
+	TitleFace font.Face // default Go Bold at 64 pixels
+	MaxLines  int       // title lines before truncating with an ellipsis, default 3
+
+	Subtitle     string
+	SubtitleFace font.Face // default Go Regular at 32 pixels
+
+	TextColor color.Color // default white
+	Padding   int         // default 72
+
+	Options Options // resampling of the background and logo
+}
+
+// Render draws the card.
+func (s *SocialCard) Render() (*Canvas, error) {
+	size := s.Size
+	if size.X <= 0 || size.Y <= 0 {
+		size = SizeOpenGraph
+	}
+	bg := s.Background
+	if bg == nil {
+		bg = color.RGBA{0x1e, 0x29, 0x3b, 0xff}
+	}
+	pad := s.Padding
+	if pad <= 0 {
+		pad = 72
+	}
+	text := s.TextColor
+	if text == nil {
+		text = color.White
+	}
+	title, subtitle := s.TitleFace, s.SubtitleFace
+	var err error
+	if title == nil {
+		if title, err = BoldFace(64); err != nil {
+			return nil, err
+		}
+	}
+	if subtitle == nil {
+		if subtitle, err = DefaultFace(32); err != nil {
+			return nil, err
+		}
+	}
+
+	c := NewCanvas(size.X, size.Y, bg)
+	if s.BackgroundImage != nil {
+		c.DrawImage(s.BackgroundImage, coverRect(s.BackgroundImage.Bounds(), c.Rect), s.Options)
+	}
+	if s.GradientTop != nil || s.GradientBottom != nil {
+		top, bottom := s.GradientTop, s.GradientBottom
+		if top == nil {
+			top = color.Transparent
+		}
+		if bottom == nil {
+			bottom = color.Transparent
+		}
+		c.FillGradient(c.Rect, top, bottom)
+	}
+	if s.Logo != nil {
+		h := s.LogoHeight
+		if h <= 0 {
+			h = 64
+		}
+		lb := s.Logo.Bounds()
+		w := max1(lb.Dx() * h / max1(lb.Dy()))
+		c.DrawImage(s.Logo, image.Rect(pad, pad, pad+w, pad+h), s.Options)
+	}
+
+	// Lay the text out upwards from the bottom padding.
+	width := size.X - 2*pad
+	y := size.Y - pad
+	if s.Subtitle != "" {
+		lines := truncateLines(WrapText(s.Subtitle, subtitle, width), 2, subtitle, width)
+		y = drawLines(c, lines, subtitle, pad, y, text)
+		y -= subtitle.Metrics().Height.Ceil() / 2
+	}
+	if s.Title != "" {
+		maxLines := s.MaxLines
+		if maxLines <= 0 {
+			maxLines = 3
+		}
+		drawLines(c, truncateLines(WrapText(s.Title, title, width), maxLines, title, width), title, pad, y, text)
+	}
+	return c, nil
+}
+
+// coverRect returns the rectangle, centred on dst, that src scales to
+// so that it covers dst while keeping its aspect ratio.
+func coverRect(src, dst image.Rectangle) image.Rectangle {
+	s := math.Max(float64(dst.Dx())/float64(src.Dx()), float64(dst.Dy())/float64(src.Dy()))
+	w := int(math.Ceil(float64(src.Dx()) * s))
+	h := int(math.Ceil(float64(src.Dy()) * s))
+	min := dst.Min.Add(image.Pt((dst.Dx()-w)/2, (dst.Dy()-h)/2))
+	return image.Rectangle{min, min.Add(image.Pt(w, h))}
+}
+
+// truncateLines keeps at most n lines, ending the last kept one with
+// an ellipsis if any were dropped.
+func truncateLines(lines []string, n int, face font.Face, width int) []string {
+	if len(lines) <= n {
+		return lines
+	}
+	lines = lines[:n]
+	last := lines[n-1]
+	for last != "" && MeasureText(last+"…", face) > width {
+		if i := strings.LastIndexByte(last, ' '); i > 0 {
+			last = last[:i]
+		} else {
+			_, size := utf8.DecodeLastRuneInString(last)
+			last = last[:len(last)-size]
+		}
+	}
+	lines[n-1] = strings.TrimRight(last, " ") + "…"
+	return lines
+}
+
+// drawLines draws lines left-aligned at x so that the last one's
+// descent ends at bottom, and returns the top of the first line.
+func drawLines(c *Canvas, lines []string, face font.Face, x, bottom int, col color.Color) int {
+	m := face.Metrics()
+	lh := m.Height.Ceil()
+	baseline := bottom - m.Descent.Ceil() - (len(lines)-1)*lh
+	for i, l := range lines {
+		c.DrawText(l, image.Pt(x, baseline+i*lh), face, col)
+	}
+	return baseline - m.Ascent.Ceil()
+}
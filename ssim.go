@@ -0,0 +1,159 @@
+package convert
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"math"
+)
+
+// SSIM returns the mean structural similarity of the luma of a and b,
+// from 1 for identical images down towards 0. It uses the usual 11x11
+// Gaussian window with σ = 1.5. Images of different sizes score 0.
+func SSIM(a, b image.Image) float64 {
+	ab, bb := a.Bounds(), b.Bounds()
+	if ab.Dx() != bb.Dx() || ab.Dy() != bb.Dy() || ab.Empty() {
+		return 0
+	}
+	w, h := ab.Dx(), ab.Dy()
+	x, y := luma(a), luma(b)
+	xx := make([]float64, len(x))
+	yy := make([]float64, len(x))
+	xy := make([]float64, len(x))
+	for i := range x {
+		xx[i], yy[i], xy[i] = x[i]*x[i], y[i]*y[i], x[i]*y[i]
+	}
+	k := gaussianWindow(1.5, 5)
+	mx, my := blur(x, w, h, k), blur(y, w, h, k)
+	sxx, syy, sxy := blur(xx, w, h, k), blur(yy, w, h, k), blur(xy, w, h, k)
+
+	const c1, c2 = (0.01 * 255) * (0.01 * 255), (0.03 * 255) * (0.03 * 255)
+	var sum float64
+	for i := range x {
+		vx := sxx[i] - mx[i]*mx[i]
+		vy := syy[i] - my[i]*my[i]
+		cov := sxy[i] - mx[i]*my[i]
+		sum += (2*mx[i]*my[i] + c1) * (2*cov + c2) /
+			((mx[i]*mx[i] + my[i]*my[i] + c1) * (vx + vy + c2))
+	}
+	return sum / float64(len(x))
+}
+
+// PSNR returns the peak signal-to-noise ratio of b against a in
+// decibels, over the red, green and blue channels on a 0-255 scale.
+// Identical images score +Inf and images of different sizes 0.
+func PSNR(a, b image.Image) float64 {
+	ab, bb := a.Bounds(), b.Bounds()
+	if ab.Dx() != bb.Dx() || ab.Dy() != bb.Dy() || ab.Empty() {
+		return 0
+	}
+	var sum float64
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			r1, g1, b1, _ := a.At(ab.Min.X+x, ab.Min.Y+y).RGBA()
+			r2, g2, b2, _ := b.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+			for _, d := range [3]float64{float64(r1) - float64(r2), float64(g1) - float64(g2), float64(b1) - float64(b2)} {
+				d /= 257
+				sum += d * d
+			}
+		}
+	}
+	if sum == 0 {
+		return math.Inf(1)
+	}
+	mse := sum / float64(3*ab.Dx()*ab.Dy())
+	return 10 * math.Log10(255*255/mse)
+}
+
+// luma returns the Rec. 601 luma of img on a 0-255 scale, composited
+// onto black as the JPEG encoder does.
+func luma(img image.Image) []float64 {
+	b := img.Bounds()
+	out := make([]float64, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			out = append(out, (0.299*float64(r)+0.587*float64(g)+0.114*float64(bl))/257)
+		}
+	}
+	return out
+}
+
+// gaussianWindow returns normalized Gaussian weights for offsets
+// -radius..radius.
+func gaussianWindow(sigma float64, radius int) []float64 {
+	k := make([]float64, 2*radius+1)
+	var sum float64
+	for i := range k {
+		d := float64(i - radius)
+		k[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += k[i]
+	}
+	for i := range k {
+		k[i] /= sum
+	}
+	return k
+}
+
+// blur applies the separable window k to a w×h plane, clamping at the
+// edges.
+func blur(src []float64, w, h int, k []float64) []float64 {
+	r := len(k) / 2
+	tmp := make([]float64, len(src))
+	for y := 0; y < h; y++ {
+		row := src[y*w : (y+1)*w]
+		for x := 0; x < w; x++ {
+			var v float64
+			for i, kv := range k {
+				v += kv * row[clampInt(x+i-r, 0, w-1)]
+			}
+			tmp[y*w+x] = v
+		}
+	}
+	dst := make([]float64, len(src))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var v float64
+			for i, kv := range k {
+				v += kv * tmp[clampInt(y+i-r, 0, h-1)*w+x]
+			}
+			dst[y*w+x] = v
+		}
+	}
+	return dst
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// targetQuality returns the lowest JPEG quality whose output scores at
+// least opts.TargetSSIM against img, or 100 if none does. Scores rise
+// with quality, so the search is a bisection over 1-100.
+func targetQuality(img image.Image, opts Options) (int, error) {
+	lo, hi := 1, 100
+	var buf bytes.Buffer
+	for lo < hi {
+		opts.Quality = (lo + hi) / 2
+		buf.Reset()
+		if err := encodeJPEG(&buf, img, opts); err != nil {
+			return 0, err
+		}
+		out, err := jpeg.Decode(&buf)
+		if err != nil {
+			return 0, err
+		}
+		if SSIM(img, out) >= opts.TargetSSIM {
+			hi = opts.Quality
+		} else {
+			lo = opts.Quality + 1
+		}
+	}
+	return lo, nil
+}
@@ -0,0 +1,14 @@
+package convert
+
+import "io"
+
+// teed returns w copying to Options.TeeWriters, and opts without them
+// so that nested encoders do not copy twice.
+func (opts Options) teed(w io.Writer) (io.Writer, Options) {
+	if len(opts.TeeWriters) == 0 {
+		return w, opts
+	}
+	w = io.MultiWriter(append([]io.Writer{w}, opts.TeeWriters...)...)
+	opts.TeeWriters = nil
+	return w, opts
+}
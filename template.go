@@ -0,0 +1,139 @@
+package convert
+
+import (
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NameData holds the values substituted into a NameTemplate.
+type NameData struct {
+	Path   string // input path; {name} is its base name without extension
+	Format Format // output format; {ext} and {format} derive from it
+	Width  int
+	Height int
+	Hash   string    // hex digest of the input or output, used by {hash}
+	Time   time.Time // used by {date}, such as from FileDateTaken
+}
+
+// NameTemplate generates output paths from placeholders such as
+// "{name}_{width}x{height}.{ext}". Supported placeholders:
+//
+//	{name}    input base name without extension
+//	{ext}     file extension of the output format
+//	{format}  output format name
+//	{width}   output width
+//	{height}  output height
+//	{hash}    Hash; {hash:8} keeps the first 8 characters
+//	{date}    Time as 2006-01-02; {date:20060102} uses a custom layout
+//
+// Literal braces are written as "{{" and "}}". Watch names its output
+// files with one through WatchOptions.Name.
+type NameTemplate struct {
+	parts []namePart
+}
+
+type namePart struct {
+	literal string
+	field   string
+	arg     string
+}
+
+// ParseNameTemplate parses an output naming template.
+func ParseNameTemplate(s string) (*NameTemplate, error) {
+	t := &NameTemplate{}
+	var lit strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '{' && i+1 < len(s) && s[i+1] == '{':
+			lit.WriteByte('{')
+			i++
+		case c == '}' && i+1 < len(s) && s[i+1] == '}':
+			lit.WriteByte('}')
+			i++
+		case c == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return nil, errors.New("template: unclosed placeholder")
+			}
+			p, err := parsePlaceholder(s[i+1 : i+end])
+			if err != nil {
+				return nil, err
+			}
+			if lit.Len() > 0 {
+				t.parts = append(t.parts, namePart{literal: lit.String()})
+				lit.Reset()
+			}
+			t.parts = append(t.parts, p)
+			i += end
+		case c == '}':
+			return nil, errors.New("template: unexpected '}'")
+		default:
+			lit.WriteByte(c)
+		}
+	}
+	if lit.Len() > 0 {
+		t.parts = append(t.parts, namePart{literal: lit.String()})
+	}
+	return t, nil
+}
+
+func parsePlaceholder(s string) (namePart, error) {
+	field, arg := s, ""
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		field, arg = s[:i], s[i+1:]
+	}
+	switch field {
+	case "name", "ext", "format", "width", "height":
+		if arg != "" {
+			return namePart{}, errors.New("template: {" + field + "} takes no argument")
+		}
+	case "hash":
+		if arg != "" {
+			if n, err := strconv.Atoi(arg); err != nil || n <= 0 {
+				return namePart{}, errors.New("template: invalid hash length " + strconv.Quote(arg))
+			}
+		}
+	case "date":
+		if arg == "" {
+			arg = "2006-01-02"
+		}
+	default:
+		return namePart{}, errors.New("template: unknown placeholder {" + field + "}")
+	}
+	return namePart{field: field, arg: arg}, nil
+}
+
+// Execute expands the template with the given data.
+func (t *NameTemplate) Execute(d NameData) string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		switch p.field {
+		case "":
+			b.WriteString(p.literal)
+		case "name":
+			base := filepath.Base(d.Path)
+			b.WriteString(strings.TrimSuffix(base, filepath.Ext(base)))
+		case "ext":
+			b.WriteString(d.Format.Extension())
+		case "format":
+			b.WriteString(string(d.Format))
+		case "width":
+			b.WriteString(strconv.Itoa(d.Width))
+		case "height":
+			b.WriteString(strconv.Itoa(d.Height))
+		case "hash":
+			h := d.Hash
+			if n, _ := strconv.Atoi(p.arg); n > 0 && n < len(h) {
+				h = h[:n]
+			}
+			b.WriteString(h)
+		case "date":
+			b.WriteString(d.Time.Format(p.arg))
+		}
+	}
+	return b.String()
+}
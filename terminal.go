@@ -0,0 +1,134 @@
+package convert
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// asciiRamp orders characters from empty to dense, for light text on a
+// dark terminal.
+const asciiRamp = " .:-=+*#%@"
+
+// textSize returns the pixel size to resample img to for output
+// opts.Columns characters wide, with rowsPerCell pixel rows per line of
+// text. Terminal cells are about twice as tall as they are wide.
+func textSize(img image.Image, opts Options, rowsPerCell int) (int, int) {
+	b := img.Bounds()
+	cols := opts.Columns
+	if cols <= 0 {
+		cols = 80
+		if b.Dx() < cols {
+			cols = b.Dx()
+		}
+	}
+	if b.Dx() <= 0 || b.Dy() <= 0 {
+		return 0, 0
+	}
+	rows := max1((b.Dy()*cols*rowsPerCell + b.Dx()) / (2 * b.Dx()))
+	return max1(cols), rows
+}
+
+// encodeANSI renders img with the upper half block character, each
+// cell showing two pixels as its foreground and background colors.
+// Mostly transparent pixels show the terminal's own background.
+func encodeANSI(w io.Writer, img image.Image, opts Options) error {
+	cols, rows := textSize(img, opts, 2)
+	img = opts.resize(img, cols, rows*2)
+	b := img.Bounds()
+	bw := bufio.NewWriter(w)
+	for y := b.Min.Y; y < b.Max.Y; y += 2 {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			top, topOK := visible(img.At(x, y))
+			var bottom color.NRGBA
+			bottomOK := false
+			if y+1 < b.Max.Y {
+				bottom, bottomOK = visible(img.At(x, y+1))
+			}
+			switch {
+			case topOK && bottomOK:
+				fmt.Fprintf(bw, "\x1b[%s;%sm▀", ansiColor(top, 38, opts.TrueColor), ansiColor(bottom, 48, opts.TrueColor))
+			case topOK:
+				fmt.Fprintf(bw, "\x1b[0;%sm▀", ansiColor(top, 38, opts.TrueColor))
+			case bottomOK:
+				fmt.Fprintf(bw, "\x1b[0;%sm▄", ansiColor(bottom, 38, opts.TrueColor))
+			default:
+				bw.WriteString("\x1b[0m ")
+			}
+		}
+		bw.WriteString("\x1b[0m\n")
+	}
+	return bw.Flush()
+}
+
+// encodeASCII renders img as characters of increasing density for
+// increasing luma. Transparent pixels are blank.
+func encodeASCII(w io.Writer, img image.Image, opts Options) error {
+	cols, rows := textSize(img, opts, 1)
+	img = opts.resize(img, cols, rows)
+	b := img.Bounds()
+	bw := bufio.NewWriter(w)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			// Premultiplied samples composite onto a black terminal.
+			r, g, bl, _ := img.At(x, y).RGBA()
+			l := (299*r + 587*g + 114*bl) / 1000
+			bw.WriteByte(asciiRamp[int(l)*len(asciiRamp)/0x10000])
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// visible returns the non-premultiplied color of c and whether it is
+// at least half opaque.
+func visible(c color.Color) (color.NRGBA, bool) {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return n, n.A >= 0x80
+}
+
+// ansiColor returns the SGR parameters selecting c as the foreground
+// (base 38) or background (base 48) color.
+func ansiColor(c color.NRGBA, base int, trueColor bool) string {
+	if trueColor {
+		return fmt.Sprintf("%d;2;%d;%d;%d", base, c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("%d;5;%d", base, xterm256(c))
+}
+
+// cubeLevels are the channel values of the xterm 6x6x6 color cube.
+var cubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+// xterm256 returns the closest xterm 256-color palette index to c from
+// the color cube and the gray ramp.
+func xterm256(c color.NRGBA) int {
+	nearest := func(v uint8) int {
+		best := 0
+		for i, l := range cubeLevels {
+			if abs(int(v)-l) < abs(int(v)-cubeLevels[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+	ri, gi, bi := nearest(c.R), nearest(c.G), nearest(c.B)
+	index := 16 + 36*ri + 6*gi + bi
+	dist := rgbDist(c, cubeLevels[ri], cubeLevels[gi], cubeLevels[bi])
+
+	// The gray ramp runs from 8 to 238 in steps of 10.
+	mean := (int(c.R) + int(c.G) + int(c.B)) / 3
+	g := clampInt((mean-3)/10, 0, 23)
+	v := 8 + 10*g
+	if rgbDist(c, v, v, v) < dist {
+		index = 232 + g
+	}
+	return index
+}
+
+// rgbDist returns the squared distance between c and (r, g, b).
+func rgbDist(c color.NRGBA, r, g, b int) int {
+	dr, dg, db := int(c.R)-r, int(c.G)-g, int(c.B)-b
+	return dr*dr + dg*dg + db*db
+}
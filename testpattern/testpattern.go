@@ -0,0 +1,127 @@
+// Package testpattern generates synthetic images, such as gradients,
+// colour bars and noise, for tests and benchmarks. Unlike converttest
+// it does not depend on package testing, so binaries can link it.
+package testpattern
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"strconv"
+
+	convert "github.com/imgutils-org/imgutils-convert"
+)
+
+// newImage returns a w×h image with 16-bit samples if depth is 16 and
+// 8-bit samples otherwise, and a function that sets its pixels from
+// channels in the 0-1 range.
+func newImage(w, h, depth int) (draw.Image, func(x, y int, r, g, b float64)) {
+	r := image.Rect(0, 0, w, h)
+	if depth == 16 {
+		m := image.NewNRGBA64(r)
+		return m, func(x, y int, r, g, b float64) {
+			m.SetNRGBA64(x, y, color.NRGBA64{uint16(r*0xffff + 0.5), uint16(g*0xffff + 0.5), uint16(b*0xffff + 0.5), 0xffff})
+		}
+	}
+	m := image.NewNRGBA(r)
+	return m, func(x, y int, r, g, b float64) {
+		m.SetNRGBA(x, y, color.NRGBA{uint8(r*0xff + 0.5), uint8(g*0xff + 0.5), uint8(b*0xff + 0.5), 0xff})
+	}
+}
+
+// unit returns the channels of c in the 0-1 range, ignoring alpha.
+func unit(c color.Color) (r, g, b float64) {
+	n := color.NRGBA64Model.Convert(c).(color.NRGBA64)
+	return float64(n.R) / 0xffff, float64(n.G) / 0xffff, float64(n.B) / 0xffff
+}
+
+// Gradient returns a w×h image fading horizontally from one colour to
+// another, with 16-bit samples if depth is 16 and 8-bit otherwise.
+// 16-bit gradients show up banding introduced by depth reduction.
+func Gradient(w, h, depth int, from, to color.Color) image.Image {
+	m, set := newImage(w, h, depth)
+	r0, g0, b0 := unit(from)
+	r1, g1, b1 := unit(to)
+	for x := 0; x < w; x++ {
+		t := 0.0
+		if w > 1 {
+			t = float64(x) / float64(w-1)
+		}
+		r, g, b := r0+(r1-r0)*t, g0+(g1-g0)*t, b0+(b1-b0)*t
+		for y := 0; y < h; y++ {
+			set(x, y, r, g, b)
+		}
+	}
+	return m
+}
+
+// ColorBars returns w×h colour bars at 75% intensity: white, yellow,
+// cyan, green, magenta, red and blue, from left to right.
+func ColorBars(w, h, depth int) image.Image {
+	m, set := newImage(w, h, depth)
+	bars := [][3]float64{{1, 1, 1}, {1, 1, 0}, {0, 1, 1}, {0, 1, 0}, {1, 0, 1}, {1, 0, 0}, {0, 0, 1}}
+	for x := 0; x < w; x++ {
+		c := bars[x*len(bars)/w]
+		for y := 0; y < h; y++ {
+			set(x, y, 0.75*c[0], 0.75*c[1], 0.75*c[2])
+		}
+	}
+	return m
+}
+
+// Checkerboard returns a w×h checkerboard of size-pixel squares
+// alternating between a and b, starting with a at the top left.
+func Checkerboard(w, h, depth, size int, a, b color.Color) image.Image {
+	if size < 1 {
+		size = 1
+	}
+	m, set := newImage(w, h, depth)
+	ra, ga, ba := unit(a)
+	rb, gb, bb := unit(b)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if (x/size+y/size)%2 == 0 {
+				set(x, y, ra, ga, ba)
+			} else {
+				set(x, y, rb, gb, bb)
+			}
+		}
+	}
+	return m
+}
+
+// Noise returns a w×h image of uniformly random colours. The same seed
+// gives the same image. Noise defeats compression, so it measures an
+// encoder's worst case.
+func Noise(w, h, depth int, seed int64) image.Image {
+	m, set := newImage(w, h, depth)
+	rng := rand.New(rand.NewSource(seed))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			set(x, y, rng.Float64(), rng.Float64(), rng.Float64())
+		}
+	}
+	return m
+}
+
+// TextChart returns a w×h chart of black text on white in decreasing
+// sizes, from an eighth of the height down to 6 pixels, for checking
+// how resampling and compression treat fine detail.
+func TextChart(w, h int) (image.Image, error) {
+	c := convert.NewCanvas(w, h, color.White)
+	y := 0
+	for size := float64(h) / 8; size >= 6; size *= 0.8 {
+		face, err := convert.DefaultFace(size)
+		if err != nil {
+			return nil, err
+		}
+		y += int(size * 1.2)
+		if y > h {
+			break
+		}
+		label := strconv.Itoa(int(size)) + "px The quick brown fox jumps over the lazy dog 0123456789"
+		c.DrawText(label, image.Pt(int(size/2), y), face, color.Black)
+	}
+	return c.RGBA, nil
+}
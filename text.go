@@ -0,0 +1,84 @@
+package convert
+
+import (
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+)
+
+var (
+	goFontsOnce sync.Once
+	goRegular   *opentype.Font
+	goBold      *opentype.Font
+	goFontsErr  error
+)
+
+// NewFace returns a face of the TrueType or OpenType font data, sized
+// so that an em is size pixels.
+func NewFace(data []byte, size float64) (font.Face, error) {
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return newFace(f, size)
+}
+
+// DefaultFace returns the Go Regular font at size pixels.
+func DefaultFace(size float64) (font.Face, error) {
+	if err := parseGoFonts(); err != nil {
+		return nil, err
+	}
+	return newFace(goRegular, size)
+}
+
+// BoldFace returns the Go Bold font at size pixels.
+func BoldFace(size float64) (font.Face, error) {
+	if err := parseGoFonts(); err != nil {
+		return nil, err
+	}
+	return newFace(goBold, size)
+}
+
+func parseGoFonts() error {
+	goFontsOnce.Do(func() {
+		if goRegular, goFontsErr = opentype.Parse(goregular.TTF); goFontsErr != nil {
+			return
+		}
+		goBold, goFontsErr = opentype.Parse(gobold.TTF)
+	})
+	return goFontsErr
+}
+
+func newFace(f *opentype.Font, size float64) (font.Face, error) {
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// MeasureText returns the advance width of s in pixels.
+func MeasureText(s string, face font.Face) int {
+	return font.MeasureString(face, s).Ceil()
+}
+
+// WrapText breaks s into lines no wider than width pixels, breaking at
+// spaces. Words wider than a line are kept whole on their own line.
+func WrapText(s string, face font.Face, width int) []string {
+	var lines []string
+	for _, para := range strings.Split(s, "\n") {
+		line := ""
+		for _, word := range strings.Fields(para) {
+			if line != "" && MeasureText(line+" "+word, face) > width {
+				lines = append(lines, line)
+				line = ""
+			}
+			if line != "" {
+				line += " "
+			}
+			line += word
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,169 @@
+package convert
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+
+	"github.com/imgutils-org/imgutils-convert/internal/tiffio"
+	"golang.org/x/image/tiff"
+)
+
+// classicTIFFLimit is the largest uncompressed pixel size written as
+// classic TIFF when BigTIFF is not requested. It leaves headroom below
+// the 4 GiB offset limit for directories and incompressible data.
+const classicTIFFLimit = 3 << 30
+
+const defaultTileSize = 256
+
+func init() {
+	for _, magic := range []string{"II+\x00", "MM\x00+"} {
+		image.RegisterFormat("tiff", magic, decodeBigTIFF, decodeBigTIFFConfig)
+	}
+}
+
+// openTIFF buffers r unless it supports random access.
+func openTIFF(r io.Reader) (*tiffio.File, error) {
+	ra, ok := r.(io.ReaderAt)
+	if !ok {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		ra = bytes.NewReader(data)
+	}
+	return tiffio.Open(ra)
+}
+
+func decodeBigTIFF(r io.Reader) (image.Image, error) {
+	f, err := openTIFF(r)
+	if err != nil {
+		return nil, err
+	}
+	return f.Decode(f.IFDs[0])
+}
+
+func decodeBigTIFFConfig(r io.Reader) (image.Config, error) {
+	f, err := openTIFF(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	img, err := f.DecodeRegion(f.IFDs[0], image.Rect(0, 0, 1, 1))
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: img.ColorModel(), Width: f.IFDs[0].Width(), Height: f.IFDs[0].Height()}, nil
+}
+
+// decodeClassicTIFF decodes a classic TIFF image with x/image/tiff,
+// falling back to tiffio for features only it supports, such as CMYK.
+func decodeClassicTIFF(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodeClassicTIFFAt(bytes.NewReader(data), int64(len(data)))
+}
+
+// decodeClassicTIFFAt is decodeClassicTIFF reading at random from ra.
+func decodeClassicTIFFAt(ra io.ReaderAt, size int64) (image.Image, error) {
+	img, err := tiff.Decode(io.NewSectionReader(ra, 0, size))
+	if _, ok := err.(tiff.UnsupportedError); ok {
+		if f, ferr := tiffio.Open(ra); ferr == nil {
+			if m, ferr := f.Decode(f.IFDs[0]); ferr == nil {
+				return m, nil
+			}
+		}
+	}
+	return img, err
+}
+
+// needsBigTIFF reports whether img may not fit in a classic TIFF.
+func needsBigTIFF(img image.Image) bool {
+	b := img.Bounds()
+	return int64(b.Dx())*int64(b.Dy())*8 > classicTIFFLimit
+}
+
+// encodeTIFF writes img with tiffio, as BigTIFF if requested or needed,
+// followed by any pyramid levels. Strips and tiles are written as they
+// are encoded when w is an io.WriteSeeker that can seek, such as a
+// regular *os.File; otherwise, as for a pipe, the output is buffered
+// in memory.
+func encodeTIFF(w io.Writer, img image.Image, opts Options) error {
+	ws, ok := w.(io.WriteSeeker)
+	if ok {
+		_, err := ws.Seek(0, io.SeekCurrent)
+		ok = err == nil
+	}
+	var buf *seekBuffer
+	if !ok {
+		buf = &seekBuffer{}
+		ws = buf
+	}
+	tw, err := tiffio.NewWriter(ws, opts.BigTIFF || needsBigTIFF(img))
+	if err != nil {
+		return err
+	}
+	iopts := &tiffio.ImageOptions{Predictor: true, TileSize: opts.TileSize}
+	if iopts.TileSize == 0 && len(opts.Pyramid) > 0 {
+		iopts.TileSize = defaultTileSize
+	}
+	if opts.GeoTIFF != nil {
+		iopts.Extra = opts.GeoTIFF.entries()
+	}
+	if err := tw.WriteImage(img, iopts); err != nil {
+		return err
+	}
+
+	// Each level is resampled from the previous one.
+	b := img.Bounds()
+	level, factor := img, 1
+	iopts.Reduced, iopts.Extra = true, nil
+	for _, f := range opts.Pyramid {
+		if f <= factor {
+			return errors.New("tiff: pyramid factors must increase")
+		}
+		lw, lh := b.Dx()/f, b.Dy()/f
+		if lw < 1 || lh < 1 {
+			break
+		}
+		level, factor = opts.resize(level, lw, lh), f
+		if err := tw.WriteImage(level, iopts); err != nil {
+			return err
+		}
+	}
+	if buf != nil {
+		_, err = w.Write(buf.data)
+	}
+	return err
+}
+
+// seekBuffer is an in-memory io.WriteSeeker.
+type seekBuffer struct {
+	data []byte
+	pos  int
+}
+
+func (b *seekBuffer) Write(p []byte) (int, error) {
+	if n := b.pos + len(p); n > len(b.data) {
+		b.data = append(b.data, make([]byte, n-len(b.data))...)
+	}
+	copy(b.data[b.pos:], p)
+	b.pos += len(p)
+	return len(p), nil
+}
+
+func (b *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += int64(b.pos)
+	case io.SeekEnd:
+		offset += int64(len(b.data))
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before start")
+	}
+	b.pos = int(offset)
+	return offset, nil
+}
@@ -0,0 +1,97 @@
+package convert
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Rotate rotates img clockwise by a multiple of 90 degrees.
+func Rotate(img image.Image, degrees int) (image.Image, error) {
+	degrees %= 360
+	if degrees < 0 {
+		degrees += 360
+	}
+	if degrees%90 != 0 {
+		return nil, errors.New("rotation must be a multiple of 90 degrees")
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if degrees == 0 {
+		return img, nil
+	}
+	if degrees != 180 {
+		w, h = h, w
+	}
+	dst := newImageLike(img, w, h)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			var dx, dy int
+			switch degrees {
+			case 90:
+				dx, dy = b.Dy()-1-y, x
+			case 180:
+				dx, dy = b.Dx()-1-x, b.Dy()-1-y
+			case 270:
+				dx, dy = y, b.Dx()-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst, nil
+}
+
+// Flip mirrors img left to right.
+func Flip(img image.Image) image.Image {
+	b := img.Bounds()
+	dst := newImageLike(img, b.Dx(), b.Dy())
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			dst.Set(b.Dx()-1-x, y, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// newImageLike returns an empty w×h image with the pixel type of img,
+// or a non-premultiplied type of the same depth.
+func newImageLike(img image.Image, w, h int) draw.Image {
+	r := image.Rect(0, 0, w, h)
+	switch m := img.(type) {
+	case *image.Gray:
+		return image.NewGray(r)
+	case *image.Gray16:
+		return image.NewGray16(r)
+	case *image.RGBA:
+		return image.NewRGBA(r)
+	case *image.RGBA64:
+		return image.NewRGBA64(r)
+	case *image.NRGBA64:
+		return image.NewNRGBA64(r)
+	case *image.Paletted:
+		return image.NewPaletted(r, m.Palette)
+	}
+	switch img.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model, color.Alpha16Model:
+		return image.NewNRGBA64(r)
+	}
+	return image.NewNRGBA(r)
+}
+
+// Grayscale converts img to 8-bit grayscale.
+func Grayscale(img image.Image) *image.Gray {
+	b := img.Bounds()
+	dst := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+// newRGBAFor returns an empty premultiplied image of r, with 16-bit
+// samples if img needs them.
+func newRGBAFor(img image.Image, r image.Rectangle) draw.Image {
+	if isDeep(img) {
+		return image.NewRGBA64(r)
+	}
+	return image.NewRGBA(r)
+}
@@ -0,0 +1,159 @@
+package convert
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+
+	"github.com/imgutils-org/imgutils-convert/internal/jpegdec"
+)
+
+// truncatedDecoder names the decoder of recovered images.
+const truncatedDecoder = "truncated"
+
+// decodeTruncated decodes r like Decode, except that a truncated or
+// corrupt JPEG or PNG comes back with the regions that could not be
+// decoded filled with Options.TruncatedFill, reported as
+// WarnTruncated.
+func (opts *Options) decodeTruncated(r io.Reader, format Format) (image.Image, Format, string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", "", err
+	}
+	img, srcFormat, decoder, err := decodeChain(bytes.NewReader(data))
+	if err == nil {
+		return img, srcFormat, decoder, nil
+	}
+	var missing []image.Rectangle
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8")):
+		img, missing = recoverJPEG(data)
+		srcFormat = JPEG
+	case bytes.HasPrefix(data, []byte(pngHeader)):
+		img, missing = recoverPNG(data)
+		srcFormat = PNG
+	}
+	if img == nil {
+		return nil, "", "", err
+	}
+	opts.warn(WarnTruncated, format)
+	fill := colorOr(opts.TruncatedFill, color.Gray{0x80})
+	return fillMissing(img, missing, fill), srcFormat, truncatedDecoder, nil
+}
+
+// recoverJPEG decodes what it can of a baseline JPEG image, returning
+// it with the regions that could not be decoded, or nil. Decoding
+// resumes after corrupt data at the next restart marker.
+func recoverJPEG(data []byte) (image.Image, []image.Rectangle) {
+	img, err := jpegdec.Decode(bytes.NewReader(data), &jpegdec.Options{Partial: true})
+	var perr *jpegdec.PartialError
+	switch {
+	case errors.As(err, &perr):
+		return img, perr.Missing
+	case err != nil:
+		return nil, nil
+	}
+	return img, nil
+}
+
+// recoverPNG decodes what it can of a non-interlaced PNG image,
+// returning it with the region that could not be decoded, or nil. The
+// rows that inflate are re-encoded, padded to the full height, for
+// image/png to decode.
+func recoverPNG(data []byte) (image.Image, []image.Rectangle) {
+	var ihdr []byte
+	var header []pngChunk
+	var idat bytes.Buffer
+	for i := len(pngHeader); i+8 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 8 + n
+		if n < 0 || end > len(data) {
+			end = len(data)
+		}
+		c := pngChunk{string(data[i+4 : i+8]), data[i+8 : end]}
+		switch c.typ {
+		case "IHDR":
+			ihdr = c.data
+		case "IDAT":
+			idat.Write(c.data)
+		case "IEND":
+		default:
+			if idat.Len() == 0 {
+				header = append(header, c)
+			}
+		}
+		if c.typ == "IEND" || end == len(data) {
+			break
+		}
+		i = end + 4
+	}
+	if len(ihdr) != 13 || ihdr[12] != 0 {
+		return nil, nil
+	}
+	width, height := int(binary.BigEndian.Uint32(ihdr)), int(binary.BigEndian.Uint32(ihdr[4:]))
+	channels := map[byte]int{pngGray: 1, pngRGB: 3, pngPaletted: 1, 4: 2, pngRGBA: 4}[ihdr[9]]
+	stride := 1 + (width*channels*int(ihdr[8])+7)/8
+	if channels == 0 || width <= 0 || height <= 0 || stride > 1<<28/height {
+		return nil, nil
+	}
+
+	var raw []byte
+	if zr, err := zlib.NewReader(&idat); err == nil {
+		raw, _ = io.ReadAll(io.LimitReader(zr, int64(stride*height)))
+	}
+	rows := len(raw) / stride
+	for y := 0; y < rows; y++ {
+		if raw[y*stride] > 4 { // bad filter type
+			rows = y
+		}
+	}
+	raw = append(raw[:rows*stride], make([]byte, (height-rows)*stride)...)
+
+	var buf bytes.Buffer
+	buf.WriteString(pngHeader)
+	pw := &pngWriter{w: &buf}
+	pw.chunk("IHDR", ihdr)
+	for _, c := range header {
+		pw.chunk(c.typ, c.data)
+	}
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(raw)
+	zw.Close()
+	pw.chunk("IDAT", z.Bytes())
+	pw.chunk("IEND", nil)
+	img, err := png.Decode(&buf)
+	if err != nil {
+		return nil, nil
+	}
+	b := img.Bounds()
+	if rows == height {
+		return img, nil
+	}
+	return img, []image.Rectangle{image.Rect(b.Min.X, b.Min.Y+rows, b.Max.X, b.Max.Y)}
+}
+
+// fillMissing returns img with the missing regions painted in fill.
+func fillMissing(img image.Image, missing []image.Rectangle, fill color.Color) image.Image {
+	if len(missing) == 0 {
+		return img
+	}
+	b := img.Bounds()
+	var dst draw.Image
+	if isDeep(img) {
+		dst = image.NewRGBA64(b)
+	} else {
+		dst = image.NewRGBA(b)
+	}
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+	for _, r := range missing {
+		draw.Draw(dst, r, image.NewUniform(fill), image.Point{}, draw.Src)
+	}
+	return dst
+}
@@ -0,0 +1,136 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"io"
+	"net/http"
+)
+
+// UploadOptions configures the Upload middleware.
+type UploadOptions struct {
+	Field     string   // multipart form field, default "image"
+	MaxBytes  int64    // maximum request body size, default 10 MiB
+	MaxPixels int      // maximum width*height of the decoded image, default 50 megapixels
+	MaxMemory int64    // maximum EstimateDecodedSize of the image in bytes, 0 for no limit
+	Accept    []Format // accepted input formats, nil accepts any decodable format
+
+	Format    Format   // canonical output format, default PNG
+	MaxWidth  int      // fit the image within this width, 0 for no limit
+	MaxHeight int      // fit the image within this height, 0 for no limit
+	Geometry  Geometry // then resize as this ImageMagick geometry says, see ParseGeometry
+	Options   Options
+}
+
+// UploadedImage is the normalized image handed to the next handler.
+type UploadedImage struct {
+	Filename    string
+	InputFormat Format
+	Format      Format
+	Image       image.Image
+	Data        []byte // Image encoded in Format
+}
+
+type uploadKey struct{}
+
+// UploadFromContext returns the image stored by the Upload middleware.
+func UploadFromContext(ctx context.Context) (*UploadedImage, bool) {
+	u, ok := ctx.Value(uploadKey{}).(*UploadedImage)
+	return u, ok
+}
+
+// Upload returns middleware that reads an image from a multipart form,
+// validates it, converts it to the canonical format and size, and makes
+// the result available to next via UploadFromContext.
+func Upload(next http.Handler, opts UploadOptions) http.Handler {
+	if opts.Field == "" {
+		opts.Field = "image"
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = 10 << 20
+	}
+	if opts.MaxPixels <= 0 {
+		opts.MaxPixels = 50 << 20
+	}
+	if opts.Format == "" {
+		opts.Format = PNG
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, opts.MaxBytes)
+		u, status, err := readUpload(r, opts)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), uploadKey{}, u)))
+	})
+}
+
+func readUpload(r *http.Request, opts UploadOptions) (*UploadedImage, int, error) {
+	if err := r.ParseMultipartForm(opts.MaxBytes); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	f, hdr, err := r.FormFile(opts.Field)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	cfg, formatStr, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, http.StatusUnsupportedMediaType, err
+	}
+	if !acceptsFormat(opts.Accept, Format(formatStr)) {
+		return nil, http.StatusUnsupportedMediaType, errors.New("unsupported format")
+	}
+	if cfg.Width*cfg.Height > opts.MaxPixels {
+		return nil, http.StatusRequestEntityTooLarge, errors.New("image dimensions too large")
+	}
+	if opts.MaxMemory > 0 && EstimateDecodedSize(cfg) > opts.MaxMemory {
+		return nil, http.StatusRequestEntityTooLarge, errors.New("image too large to decode")
+	}
+
+	img, format, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	img = opts.Options.fit(img, opts.MaxWidth, opts.MaxHeight)
+	w, h := opts.Geometry.Size(img.Bounds().Dx(), img.Bounds().Dy())
+	if w*h > opts.MaxPixels {
+		return nil, http.StatusRequestEntityTooLarge, errors.New("resized image too large")
+	}
+	img = ConvertPixels(opts.Options.resize(img, w, h), opts.Options.PixelFormat)
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, opts.Format, opts.Options); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &UploadedImage{
+		Filename:    hdr.Filename,
+		InputFormat: format,
+		Format:      opts.Format,
+		Image:       img,
+		Data:        buf.Bytes(),
+	}, 0, nil
+}
+
+func acceptsFormat(accept []Format, f Format) bool {
+	if accept == nil {
+		return true
+	}
+	for _, a := range accept {
+		if a == f {
+			return true
+		}
+	}
+	return false
+}
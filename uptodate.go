@@ -0,0 +1,19 @@
+package convert
+
+import "os"
+
+// UpToDate reports whether output exists and was modified no earlier
+// than input, in the manner of make, so that callers converting many
+// files can skip those already converted. It returns false if either
+// file cannot be stat'ed.
+func UpToDate(input, output string) bool {
+	in, err := os.Stat(longPath(input))
+	if err != nil {
+		return false
+	}
+	out, err := os.Stat(longPath(output))
+	if err != nil {
+		return false
+	}
+	return !out.ModTime().Before(in.ModTime())
+}
@@ -0,0 +1,235 @@
+package convert
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OptionsError is returned by Validate and ValidateFor with every
+// problem found in the options.
+type OptionsError struct {
+	Problems []string
+}
+
+func (e *OptionsError) Error() string {
+	return "invalid options: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate reports options that are out of range or conflict with each
+// other, rather than being clamped or ignored during conversion. It
+// returns nil or an *OptionsError listing all problems.
+func (opts Options) Validate() error {
+	var p problems
+	opts.validate(&p)
+	return p.err()
+}
+
+// ValidateFor is like Validate, and also reports options that format
+// cannot honour, such as Lossless for JPEG or TIFF options for PNG.
+func (opts Options) ValidateFor(format Format) error {
+	var p problems
+	opts.validate(&p)
+	opts.validateFormat(&p, format)
+	return p.err()
+}
+
+// maxThumbnail bounds the size of EXIF thumbnails, beyond which they
+// cannot fit in the 64 KiB segment that holds them.
+const maxThumbnail = 1024
+
+// maxTileSize bounds the size of TIFF tiles, each of which is held in
+// memory as it is encoded.
+const maxTileSize = 4096
+
+// maxShadow bounds the blur and offset of a drop shadow, beyond which
+// the canvas it grows would overflow or exhaust memory.
+const maxShadow = 1 << 14
+
+type problems []string
+
+func (p *problems) add(msg string) {
+	*p = append(*p, msg)
+}
+
+func (p *problems) addf(format string, args ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+func (p problems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return &OptionsError{Problems: p}
+}
+
+func (opts Options) validate(p *problems) {
+	if opts.Quality < 0 || opts.Quality > 100 {
+		p.addf("Quality %d is outside 1-100, or 0 for the default", opts.Quality)
+	}
+	if opts.Colors != 0 && (opts.Colors < 2 || opts.Colors > 256) {
+		p.addf("Colors %d is outside 2-256, or 0 for the default", opts.Colors)
+	}
+	if opts.Thumbnail < 0 || opts.Thumbnail > maxThumbnail {
+		p.addf("Thumbnail %d is outside 1-%d, or 0 for none", opts.Thumbnail, maxThumbnail)
+	}
+	if opts.TileSize < 0 || opts.TileSize > maxTileSize {
+		p.addf("TileSize %d is outside 16-%d, or 0 for none", opts.TileSize, maxTileSize)
+	} else if opts.TileSize%16 != 0 {
+		p.addf("TileSize %d is not a multiple of 16", opts.TileSize)
+	}
+	for i, f := range opts.Pyramid {
+		if f < 2 || i > 0 && f <= opts.Pyramid[i-1] {
+			p.addf("Pyramid factors %v are not increasing from 2", opts.Pyramid)
+			break
+		}
+	}
+	if opts.Quantizer < QuantizePlan9 || opts.Quantizer > QuantizeNeuQuant {
+		p.addf("Quantizer %d is not a known method", opts.Quantizer)
+	}
+	if opts.Dither < DitherDefault || opts.Dither > DitherBlueNoise {
+		p.addf("Dither %d is not a known method", opts.Dither)
+	}
+	if _, ok := LookupKernel(opts.Kernel); opts.Kernel != "" && !ok {
+		p.addf("Kernel %q is not registered", opts.Kernel)
+	}
+	if opts.PixelFormat < PixelAuto || opts.PixelFormat > PixelCMYK {
+		p.addf("PixelFormat %d is not a known format", opts.PixelFormat)
+	}
+	if opts.TargetSSIM < 0 || opts.TargetSSIM > 1 {
+		p.addf("TargetSSIM %g is outside 0-1", opts.TargetSSIM)
+	}
+	if opts.Lossless && opts.TargetSSIM > 0 {
+		p.add("Lossless conflicts with TargetSSIM")
+	}
+	if opts.ValidatePassthrough && !opts.AllowPassthrough {
+		p.add("ValidatePassthrough needs AllowPassthrough")
+	}
+	if n := len(opts.EncryptionKey); n != 0 && n != 16 && n != 24 && n != 32 {
+		p.addf("EncryptionKey is %d bytes, not 16, 24 or 32", n)
+	}
+	if opts.Columns < 0 {
+		p.addf("Columns %d is negative", opts.Columns)
+	}
+	if opts.FrameRate < 0 || opts.Speed < 0 || opts.MaxDuration < 0 {
+		p.add("FrameRate, Speed and MaxDuration must not be negative")
+	}
+	if opts.TrimTolerance < 0 {
+		p.addf("TrimTolerance %d is negative", opts.TrimTolerance)
+	}
+	if opts.Grade != nil {
+		if err := opts.Grade.check(); err != nil {
+			p.addf("Grade: %v", err)
+		}
+	}
+	for _, r := range opts.Redact {
+		if r.Empty() || r != r.Canon() {
+			p.addf("Redact region %v is empty", r)
+			break
+		}
+	}
+	if opts.RedactStyle < RedactBlur || opts.RedactStyle > RedactFill {
+		p.addf("RedactStyle %d is not a known style", opts.RedactStyle)
+	}
+	if opts.Posterize != 0 && (opts.Posterize < 2 || opts.Posterize > 256) {
+		p.addf("Posterize %d is outside 2-256, or 0 for none", opts.Posterize)
+	}
+	if opts.Edges < EdgesNone || opts.Edges > EdgesCanny {
+		p.addf("Edges %d is not a known detector", opts.Edges)
+	}
+	if opts.Threshold < 0 || opts.Threshold > 255 {
+		p.addf("Threshold %d is outside 1-255, or 0 for none", opts.Threshold)
+	}
+	if opts.AutoThreshold && opts.Threshold > 0 {
+		p.add("AutoThreshold conflicts with Threshold")
+	}
+	if (opts.PadWidth > 0) != (opts.PadHeight > 0) || opts.PadWidth < 0 || opts.PadHeight < 0 {
+		p.addf("PadWidth %d and PadHeight %d must both be set", opts.PadWidth, opts.PadHeight)
+	}
+	if opts.PadAnchor < AnchorCenter || opts.PadAnchor > AnchorBottomRight {
+		p.addf("PadAnchor %d is not a known anchor", opts.PadAnchor)
+	}
+	if opts.Letterbox && opts.PadWidth <= 0 {
+		p.add("Letterbox needs PadWidth and PadHeight")
+	}
+	if opts.Border < 0 {
+		p.addf("Border %d is negative", opts.Border)
+	}
+	if opts.CornerRadius < 0 {
+		p.addf("CornerRadius %g is negative", opts.CornerRadius)
+	}
+	if opts.Circle && opts.CornerRadius > 0 {
+		p.add("Circle conflicts with CornerRadius")
+	}
+	if opts.TintStrength < 0 || opts.TintStrength > 1 {
+		p.addf("TintStrength %g is outside 0-1", opts.TintStrength)
+	}
+	if opts.TintStrength > 0 && opts.Tint == nil {
+		p.add("TintStrength needs Tint")
+	}
+	if opts.Vignette < 0 || opts.Vignette > 1 {
+		p.addf("Vignette %g is outside 0-1", opts.Vignette)
+	}
+	if s := opts.Shadow; s != nil {
+		if s.Blur < 0 {
+			p.addf("Shadow.Blur %d is negative", s.Blur)
+		} else if s.Blur > maxShadow {
+			p.addf("Shadow.Blur %d is over %d", s.Blur, maxShadow)
+		}
+		if far := func(n int) bool { return n < -maxShadow || n > maxShadow }; far(s.Offset.X) || far(s.Offset.Y) {
+			p.addf("Shadow.Offset %v is over %d pixels", s.Offset, maxShadow)
+		}
+	}
+}
+
+func (opts Options) validateFormat(p *problems, format Format) {
+	caps := format.Capabilities()
+	if caps == (Capabilities{}) {
+		p.addf("format %q is not supported", format)
+		return
+	}
+	if opts.Lossless && !caps.Lossless {
+		p.addf("Lossless conflicts with %s, a lossy format", format)
+	}
+	if format != JPEG {
+		p.only(format, "JPEG", []flag{
+			{"TargetSSIM", opts.TargetSSIM > 0},
+			{"OptimizeCoding", opts.OptimizeCoding},
+			{"Thumbnail", opts.Thumbnail > 0},
+		})
+	}
+	if opts.Indexed && format != PNG {
+		p.addf("Indexed applies to PNG output only, not %s", format)
+	}
+	if opts.Interlace && format != PNG && format != GIF {
+		p.addf("Interlace applies to PNG and GIF output only, not %s", format)
+	}
+	if format != TIFF {
+		p.only(format, "TIFF", []flag{
+			{"BigTIFF", opts.BigTIFF},
+			{"TileSize", opts.TileSize > 0},
+			{"Pyramid", len(opts.Pyramid) > 0},
+			{"GeoTIFF", opts.GeoTIFF != nil},
+		})
+	}
+	if opts.PixelFormat == PixelCMYK && !caps.CMYK {
+		p.addf("PixelCMYK cannot be stored in %s", format)
+	}
+	if !caps.Alpha && (opts.Mask != nil || opts.Circle || opts.CornerRadius > 0 || opts.RemoveBackground != nil) {
+		p.addf("transparency from masking or background removal is lost in %s", format)
+	}
+}
+
+// flag names an option and whether it is set.
+type flag struct {
+	name string
+	set  bool
+}
+
+// only reports each set flag as applying to output in want only.
+func (p *problems) only(format Format, want string, flags []flag) {
+	for _, f := range flags {
+		if f.set {
+			p.addf("%s applies to %s output only, not %s", f.name, want, format)
+		}
+	}
+}
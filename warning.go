@@ -0,0 +1,234 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+
+	"github.com/imgutils-org/imgutils-convert/internal/tiffio"
+)
+
+// WarningKind classifies information lost during a conversion.
+type WarningKind int
+
+const (
+	WarnAlphaDropped       WarningKind = iota + 1 // transparency removed
+	WarnAlphaReduced                              // partial transparency reduced to on/off
+	WarnAnimationFlattened                        // only the first frame was kept
+	WarnDepthReduced                              // samples truncated to 8 bits
+	WarnColorsReduced                             // image quantized to a palette
+	WarnMetadataRemoved                           // EXIF, XMP, ICC or text metadata not carried over
+	WarnLossy                                     // pixels approximated by lossy compression; reported by CanConvert only
+	WarnTruncated                                 // damaged input decoded in part, the rest filled
+	WarnFramesUntrimmed                           // Trim, TrimBorders and Deskew skipped for animation frames
+)
+
+var warningMessages = map[WarningKind]string{
+	WarnAlphaDropped:       "transparency dropped",
+	WarnAlphaReduced:       "partial transparency reduced to binary transparency",
+	WarnAnimationFlattened: "animation flattened to first frame",
+	WarnDepthReduced:       "samples truncated to 8 bits",
+	WarnColorsReduced:      "colors quantized to a palette",
+	WarnMetadataRemoved:    "metadata removed",
+	WarnLossy:              "lossy compression",
+	WarnTruncated:          "damaged input partly filled",
+	WarnFramesUntrimmed:    "animation frames not trimmed or deskewed",
+}
+
+func (k WarningKind) String() string {
+	if m, ok := warningMessages[k]; ok {
+		return m
+	}
+	return "unknown warning"
+}
+
+// Warning describes information lost during a conversion that did not
+// cause it to fail.
+type Warning struct {
+	Kind   WarningKind
+	Format Format // target format
+}
+
+func (w Warning) String() string {
+	return string(w.Format) + ": " + w.Kind.String()
+}
+
+func (opts Options) warn(kind WarningKind, format Format) {
+	if opts.WarnFunc != nil {
+		opts.WarnFunc(Warning{Kind: kind, Format: format})
+	}
+}
+
+// encodeWarnings reports what encoding img in format will lose.
+func encodeWarnings(img image.Image, format Format, opts Options) {
+	if opts.WarnFunc == nil {
+		return
+	}
+	caps := format.Capabilities()
+	palette := caps.MaxColors > 0 || (format == PNG && opts.Indexed)
+
+	if !isOpaque(img) {
+		if !caps.Alpha {
+			opts.warn(WarnAlphaDropped, format)
+		} else if (!caps.PartialAlpha || palette) && hasPartialAlpha(img) {
+			opts.warn(WarnAlphaReduced, format)
+		}
+	}
+	if (!caps.HighBitDepth || palette) && isDeep(img) {
+		opts.warn(WarnDepthReduced, format)
+	}
+	if palette {
+		n := opts.Colors
+		if n <= 0 || n > 256 {
+			n = 256
+		}
+		if p, ok := img.(*image.Paletted); !ok || len(p.Palette) > n {
+			opts.warn(WarnColorsReduced, format)
+		}
+	}
+}
+
+func hasPartialAlpha(img image.Image) bool {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0 && a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodeSource decodes an image for conversion, returning the name of
+// the decoder that read it. When a WarnFunc is set, the source bytes
+// are kept so that animation and metadata that the conversion drops
+// can be reported. For TIFF to TIFF conversions they are kept to copy
+// georeferencing tags into opts.
+func decodeSource(r io.Reader, format Format, opts *Options) (image.Image, Format, string, error) {
+	r, err := opts.salvageReader(r)
+	if err != nil {
+		return nil, "", "", err
+	}
+	decode := decodeChain
+	if opts.AllowTruncated {
+		decode = func(r io.Reader) (image.Image, Format, string, error) {
+			return opts.decodeTruncated(r, format)
+		}
+	}
+	geo := format == TIFF && opts.GeoTIFF == nil
+	if geo {
+		// Only TIFF sources have georeferencing to copy, so only they
+		// need buffering for it.
+		br := bufio.NewReader(r)
+		magic, _ := br.Peek(4)
+		geo, r = tiffMagicAt(bytes.NewReader(magic)) != "", br
+	}
+	if opts.WarnFunc == nil && !geo {
+		return decode(r)
+	}
+	var src bytes.Buffer
+	img, srcFormat, decoder, err := decode(io.TeeReader(r, &src))
+	if err != nil {
+		return nil, "", "", err
+	}
+	if geo && srcFormat == TIFF {
+		// The decoder may stop before tag values it does not use.
+		io.Copy(&src, r)
+		if f, err := tiffio.Open(bytes.NewReader(src.Bytes())); err == nil {
+			opts.GeoTIFF = geoTIFF(f.IFDs[0])
+		}
+	}
+	if opts.WarnFunc == nil {
+		return img, srcFormat, decoder, nil
+	}
+
+	if sources[srcFormat].Animation {
+		// Decoders stop after the first frame.
+		io.Copy(&src, r)
+		if animated(srcFormat, src.Bytes()) {
+			opts.warn(WarnAnimationFlattened, format)
+		}
+	}
+	switch srcFormat {
+	case JPEG:
+		if jpegHasMetadata(src.Bytes()) {
+			opts.warn(WarnMetadataRemoved, format)
+		}
+	case PNG:
+		if pngHasMetadata(src.Bytes()) {
+			opts.warn(WarnMetadataRemoved, format)
+		}
+	}
+	return img, srcFormat, decoder, nil
+}
+
+// animated reports whether data, in a format that may be animated,
+// holds more than one frame.
+func animated(format Format, data []byte) bool {
+	switch format {
+	case GIF:
+		return gifFrameCount(data) > 1
+	case PNG:
+		return isAPNG(data)
+	}
+	return false
+}
+
+// gifFrameCount counts the image descriptors in an encoded GIF.
+func gifFrameCount(data []byte) int {
+	i, err := gifDescriptorOffset(data)
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for i < len(data) {
+		switch data[i] {
+		case 0x2c:
+			n++
+			if i+10 > len(data) {
+				return n
+			}
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&7 + 1)
+			}
+			i++ // LZW minimum code size
+		case 0x21:
+			i += 2
+		default:
+			return n
+		}
+		for i < len(data) && data[i] != 0 {
+			i += int(data[i]) + 1
+		}
+		i++
+	}
+	return n
+}
+
+func jpegHasMetadata(data []byte) bool {
+	segs, _ := readJPEGSegments(bytes.NewReader(data))
+	for _, s := range segs {
+		// APP1 holds EXIF and XMP, APP2 ICC profiles, APP13 IPTC.
+		if s.marker == jpegMarkerAPP1 || s.marker == 0xe2 || s.marker == 0xed {
+			return true
+		}
+	}
+	return false
+}
+
+func pngHasMetadata(data []byte) bool {
+	for i := len(pngHeader); i+8 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		switch string(data[i+4 : i+8]) {
+		case "tEXt", "zTXt", "iTXt", "eXIf", "iCCP":
+			return true
+		}
+		i += 12 + n
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package convert
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// Affine is a 2×3 matrix mapping a point (x, y) to
+// (A[0]x + A[1]y + A[2], A[3]x + A[4]y + A[5]).
+type Affine [6]float64
+
+// Rotation returns the affine transform that rotates clockwise by
+// degrees around (cx, cy), such as to deskew a scanned page.
+func Rotation(degrees, cx, cy float64) Affine {
+	s, c := math.Sincos(degrees * math.Pi / 180)
+	return Affine{c, -s, cx - c*cx + s*cy, s, c, cy - s*cx - c*cy}
+}
+
+// Transform returns a width×height image of img mapped by m from
+// source to output coordinates and resampled with k. Output pixels
+// that no source pixel maps to are transparent.
+func Transform(img image.Image, m Affine, width, height int, k Kernel) image.Image {
+	dst := newRGBAFor(img, image.Rect(0, 0, width, height))
+	dk := &draw.Kernel{Support: k.Support(), At: k.At}
+	dk.Transform(dst, f64.Aff3(m), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// Perspective returns a width×height image of the quadrilateral of img
+// with corners quad, in the order top-left, top-right, bottom-right,
+// bottom-left, resampled with k. It flattens photographed pages and
+// other planes seen at an angle. Output pixels that map outside img are
+// transparent.
+func Perspective(img image.Image, quad [4]image.Point, width, height int, k Kernel) (image.Image, error) {
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("invalid output size")
+	}
+	w, h := float64(width), float64(height)
+	hm, err := homography([4][2]float64{{0, 0}, {w, 0}, {w, h}, {0, h}}, quad)
+	if err != nil {
+		return nil, err
+	}
+
+	b := img.Bounds()
+	src := image.NewRGBA64(b)
+	draw.Draw(src, b, img, b.Min, draw.Src)
+	dst := newRGBAFor(img, image.Rect(0, 0, width, height))
+	support := k.Support()
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			px, py := float64(x)+0.5, float64(y)+0.5
+			u, v := hm.apply(px, py)
+			if u < float64(b.Min.X) || v < float64(b.Min.Y) || u >= float64(b.Max.X) || v >= float64(b.Max.Y) {
+				continue
+			}
+			// Widen the kernel where the output shrinks the source, as
+			// the local scale of the mapping shows.
+			ux, vx := hm.apply(px+1, py)
+			uy, vy := hm.apply(px, py+1)
+			sx := math.Max(1, math.Hypot(ux-u, vx-v))
+			sy := math.Max(1, math.Hypot(uy-u, vy-v))
+			dst.Set(x, y, sample(src, u, v, sx, sy, support, k))
+		}
+	}
+	return dst, nil
+}
+
+// sample returns the colour of src at (u, v), filtering with k scaled
+// by sx and sy and clamping at the edges.
+func sample(src *image.RGBA64, u, v, sx, sy, support float64, k Kernel) color.RGBA64 {
+	b := src.Rect
+	var sum [4]float64
+	var wsum float64
+	y0, y1 := int(math.Floor(v-support*sy)), int(math.Ceil(v+support*sy))
+	x0, x1 := int(math.Floor(u-support*sx)), int(math.Ceil(u+support*sx))
+	for y := y0; y <= y1; y++ {
+		wy := k.At((float64(y) + 0.5 - v) / sy)
+		if wy == 0 {
+			continue
+		}
+		row := clampInt(y, b.Min.Y, b.Max.Y-1)
+		for x := x0; x <= x1; x++ {
+			wt := wy * k.At((float64(x)+0.5-u)/sx)
+			if wt == 0 {
+				continue
+			}
+			p := src.Pix[src.PixOffset(clampInt(x, b.Min.X, b.Max.X-1), row):]
+			for c := range sum {
+				sum[c] += wt * float64(uint16(p[2*c])<<8|uint16(p[2*c+1]))
+			}
+			wsum += wt
+		}
+	}
+	if wsum == 0 {
+		return color.RGBA64{}
+	}
+	var out [4]uint16
+	for c := range sum {
+		out[c] = uint16(math.Max(0, math.Min(0xffff, sum[c]/wsum+0.5)))
+	}
+	// Overshooting kernels can leave colour above alpha.
+	for c := 0; c < 3; c++ {
+		if out[c] > out[3] {
+			out[c] = out[3]
+		}
+	}
+	return color.RGBA64{out[0], out[1], out[2], out[3]}
+}
+
+// projective is a 3×3 matrix with its last entry fixed at 1.
+type projective [8]float64
+
+func (m projective) apply(x, y float64) (float64, float64) {
+	d := m[6]*x + m[7]*y + 1
+	return (m[0]*x + m[1]*y + m[2]) / d, (m[3]*x + m[4]*y + m[5]) / d
+}
+
+// homography returns the projective mapping that takes each of from to
+// the matching point of to, solving the eight linear equations by
+// Gaussian elimination.
+func homography(from [4][2]float64, to [4]image.Point) (projective, error) {
+	var a [8][9]float64
+	for i, p := range from {
+		x, y := p[0], p[1]
+		u, v := float64(to[i].X), float64(to[i].Y)
+		a[2*i] = [9]float64{x, y, 1, 0, 0, 0, -u * x, -u * y, u}
+		a[2*i+1] = [9]float64{0, 0, 0, x, y, 1, -v * x, -v * y, v}
+	}
+	for col := 0; col < 8; col++ {
+		pivot := col
+		for r := col + 1; r < 8; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-9 {
+			return projective{}, errors.New("degenerate quadrilateral")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for r := 0; r < 8; r++ {
+			if r == col {
+				continue
+			}
+			f := a[r][col] / a[col][col]
+			for c := col; c < 9; c++ {
+				a[r][c] -= f * a[col][c]
+			}
+		}
+	}
+	var m projective
+	for i := range m {
+		m[i] = a[i][8] / a[i][i]
+	}
+	return m, nil
+}
@@ -0,0 +1,212 @@
+package convert
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// WatchOptions configures Watch.
+type WatchOptions struct {
+	OutputDir string        // directory for converted files, default the watched directory
+	Name      *NameTemplate // path of each converted file within OutputDir, default "{name}.{ext}"
+	Format    Format        // output format, default PNG
+	Options   Options
+
+	Interval time.Duration // polling interval, default 1s
+	Debounce time.Duration // how long a file must be unchanged before it is converted, default 2s
+	Existing bool          // also convert files present when Watch starts
+
+	OnConvert func(input, output string)   // called after each successful conversion
+	OnError   func(path string, err error) // called for each failed conversion
+	Report    *Report                      // optional, records each conversion
+}
+
+type watchState struct {
+	modTime time.Time
+	size    int64
+	changed time.Time // when modTime or size last changed
+	done    time.Time // modTime at the last conversion attempt
+}
+
+// Watch polls dir and converts image files that appear or change in it,
+// until the context is cancelled. A file is converted once it has not
+// been modified for the debounce period, so partially written files are
+// not picked up. Per-file failures are reported through OnError and do
+// not stop the watcher; Watch only returns early if dir cannot be read.
+func Watch(ctx context.Context, dir string, opts WatchOptions) error {
+	if opts.OutputDir == "" {
+		opts.OutputDir = dir
+	}
+	if opts.Format == "" {
+		opts.Format = PNG
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.Debounce <= 0 {
+		opts.Debounce = 2 * time.Second
+	}
+
+	files := make(map[string]*watchState)
+	first := true
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := pollDir(dir, files, first, &opts); err != nil {
+			return err
+		}
+		first = false
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func pollDir(dir string, files map[string]*watchState, first bool, opts *WatchOptions) error {
+	entries, err := os.ReadDir(longPath(dir))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !isImageExtension(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		name := e.Name()
+		seen[name] = true
+
+		st, ok := files[name]
+		if !ok {
+			st = &watchState{modTime: info.ModTime(), size: info.Size(), changed: now}
+			if first && !opts.Existing {
+				st.done = st.modTime
+			}
+			files[name] = st
+		} else if !info.ModTime().Equal(st.modTime) || info.Size() != st.size {
+			st.modTime, st.size, st.changed = info.ModTime(), info.Size(), now
+		}
+
+		if st.done.Equal(st.modTime) {
+			continue
+		}
+		if now.Sub(st.changed) < opts.Debounce {
+			continue
+		}
+		st.done = st.modTime
+
+		input := filepath.Join(dir, name)
+		var rec Record
+		if opts.Name != nil {
+			rec, err = convertNamed(input, opts)
+		} else {
+			output := filepath.Join(opts.OutputDir, strings.TrimSuffix(name, filepath.Ext(name))+"."+opts.Format.Extension())
+			if sameFile(input, output) {
+				continue
+			}
+			rec, err = convertFileRecord(input, output, opts.Format, opts.Options)
+		}
+		if rec.Input == "" {
+			continue
+		}
+		if err == nil && filepath.Dir(rec.Output) == filepath.Clean(dir) {
+			// Keep the converted file from being picked up as input.
+			if info, err := os.Stat(longPath(rec.Output)); err == nil {
+				out := filepath.Base(rec.Output)
+				files[out] = &watchState{modTime: info.ModTime(), size: info.Size(), changed: now, done: info.ModTime()}
+				seen[out] = true
+			}
+		}
+		if opts.Report != nil {
+			opts.Report.Write(rec)
+		}
+		if err != nil {
+			if opts.OnError != nil {
+				opts.OnError(input, err)
+			}
+			continue
+		}
+		if opts.OnConvert != nil {
+			opts.OnConvert(input, rec.Output)
+		}
+	}
+
+	for name := range files {
+		if !seen[name] {
+			delete(files, name)
+		}
+	}
+	return nil
+}
+
+// convertNamed converts input to a temporary file in the output
+// directory and then moves it to the path opts.Name gives it, so that
+// the template can use the size and digest of the converted file. The
+// zero Record is returned if that path is input itself.
+func convertNamed(input string, opts *WatchOptions) (Record, error) {
+	tmp, err := os.CreateTemp(longPath(opts.OutputDir), ".convert-*")
+	if err != nil {
+		return Record{Input: input, Error: err.Error()}, err
+	}
+	tmp.Close()
+	o := opts.Options
+	o.ChecksumSidecar = false // written once the file has its name
+	rec, err := convertFileRecord(input, tmp.Name(), opts.Format, o)
+	if err != nil {
+		os.Remove(tmp.Name())
+		rec.Output = ""
+		return rec, err
+	}
+
+	d := NameData{Path: input, Format: opts.Format, Hash: rec.OutputSHA256}
+	if f, err := os.Open(tmp.Name()); err == nil {
+		d.Width, d.Height, _ = Dimensions(f)
+		f.Close()
+	}
+	d.Time, _ = FileDateTaken(input)
+	output := filepath.Join(opts.OutputDir, opts.Name.Execute(d))
+	if sameFile(input, output) {
+		os.Remove(tmp.Name())
+		return Record{}, nil
+	}
+	rec.Output = output
+	if err = os.MkdirAll(longPath(filepath.Dir(output)), 0777); err == nil {
+		err = os.Rename(tmp.Name(), longPath(output))
+	}
+	if err == nil && opts.Options.ChecksumSidecar {
+		err = writeChecksum(output)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		rec.Error = err.Error()
+	}
+	return rec, err
+}
+
+// isImageExtension reports whether path has an extension that
+// FormatFromExtension recognizes.
+func isImageExtension(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tiff", ".tif":
+		return true
+	}
+	return false
+}
+
+func sameFile(a, b string) bool {
+	a, errA := filepath.Abs(a)
+	b, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && a == b
+}
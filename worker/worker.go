@@ -0,0 +1,268 @@
+// Package worker runs image conversions consumed from a job queue.
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+
+	convert "github.com/imgutils-org/imgutils-convert"
+)
+
+// Job describes a single conversion request.
+type Job struct {
+	ID      string          `json:"id"`
+	Source  string          `json:"source"` // key of the input blob
+	Dest    string          `json:"dest"`   // key of the output blob
+	Format  convert.Format  `json:"format"`
+	Options convert.Options `json:"options"`
+}
+
+// Delivery is a job received from a Queue.
+type Delivery interface {
+	Job() Job
+	Ack() error  // marks the job as done
+	Nack() error // returns the job to the queue
+}
+
+// Queue yields conversion jobs. Receive blocks until a job is
+// available or the context is cancelled.
+type Queue interface {
+	Receive(ctx context.Context) (Delivery, error)
+}
+
+// BlobSource opens input images by key.
+type BlobSource interface {
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// BlobSink creates output objects by key. The object is committed
+// when the returned writer is closed. Writers should also implement
+// Aborter, so that the output of a failed job is discarded rather
+// than committed.
+type BlobSink interface {
+	Create(ctx context.Context, key string) (io.WriteCloser, error)
+}
+
+// Aborter is implemented by BlobSink writers that can discard the
+// object instead of committing it.
+type Aborter interface {
+	Abort() error
+}
+
+// Event reports the outcome of a job.
+type Event struct {
+	JobID string `json:"job_id"`
+	Dest  string `json:"dest"`
+	Error string `json:"error,omitempty"`
+}
+
+// Publisher sends completion events.
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// Worker consumes jobs from Queue, converts them and writes the
+// results to Sink.
+type Worker struct {
+	Queue       Queue
+	Source      BlobSource
+	Sink        BlobSink
+	Events      Publisher // optional
+	Concurrency int       // number of parallel conversions, default 1
+
+	// OnError is called when acking or nacking a job or publishing its
+	// event fails, as when a nack is lost and the job with it. The
+	// default logs the error with the log package.
+	OnError func(jobID string, err error)
+
+	mu       sync.Mutex
+	closed   bool
+	stop     context.CancelFunc // stops receiving jobs
+	abandon  context.CancelFunc // cancels jobs in flight
+	done     chan struct{}      // closed when Run returns
+	inflight map[int]string     // IDs of jobs in flight
+	next     int
+}
+
+// ErrShutdown is returned by Run once Shutdown has been called.
+var ErrShutdown = errors.New("worker: shut down")
+
+// Run processes jobs until the context is cancelled, the queue returns
+// an error or Shutdown is called. Jobs in flight are finished before
+// Run returns.
+func (w *Worker) Run(ctx context.Context) error {
+	if w.Queue == nil || w.Source == nil || w.Sink == nil {
+		return errors.New("worker: Queue, Source and Sink are required")
+	}
+	n := w.Concurrency
+	if n <= 0 {
+		n = 1
+	}
+
+	jobCtx, abandon := context.WithCancel(ctx)
+	ctx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		stop()
+		abandon()
+		return ErrShutdown
+	}
+	w.stop, w.abandon, w.done = stop, abandon, done
+	if w.inflight == nil {
+		w.inflight = make(map[int]string)
+	}
+	w.mu.Unlock()
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, n)
+	defer func() {
+		wg.Wait()
+		stop()
+		abandon()
+		close(done)
+	}()
+
+	for {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return w.runErr(ctx)
+		}
+
+		d, err := w.Queue.Receive(ctx)
+		if err != nil {
+			<-sem
+			if ctx.Err() != nil {
+				return w.runErr(ctx)
+			}
+			return err
+		}
+
+		w.mu.Lock()
+		id := w.next
+		w.next++
+		w.inflight[id] = d.Job().ID
+		w.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			w.handle(jobCtx, d)
+			w.mu.Lock()
+			delete(w.inflight, id)
+			w.mu.Unlock()
+		}()
+	}
+}
+
+func (w *Worker) runErr(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrShutdown
+	}
+	return ctx.Err()
+}
+
+// InFlight returns the number of jobs being processed, for use as
+// convert.HealthOptions.QueueDepth.
+func (w *Worker) InFlight() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.inflight)
+}
+
+// Shutdown stops Run from receiving new jobs and waits for the jobs in
+// flight to finish. If ctx is done first, it cancels the contexts of
+// the remaining jobs, which are nacked if they fail as a result, and
+// returns their IDs with the context's error without waiting further.
+func (w *Worker) Shutdown(ctx context.Context) ([]string, error) {
+	w.mu.Lock()
+	w.closed = true
+	done := w.done
+	if w.stop != nil {
+		w.stop()
+	}
+	w.mu.Unlock()
+	if done == nil {
+		return nil, nil
+	}
+
+	select {
+	case <-done:
+		return nil, nil
+	case <-ctx.Done():
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var abandoned []string
+	for _, id := range w.inflight {
+		abandoned = append(abandoned, id)
+	}
+	sort.Strings(abandoned)
+	w.abandon()
+	return abandoned, ctx.Err()
+}
+
+func (w *Worker) handle(ctx context.Context, d Delivery) {
+	job := d.Job()
+	err := w.Process(ctx, job)
+	if err != nil {
+		if nerr := d.Nack(); nerr != nil {
+			w.onError(job.ID, fmt.Errorf("worker: nack job %s: %w", job.ID, nerr))
+		}
+	} else if aerr := d.Ack(); aerr != nil {
+		w.onError(job.ID, fmt.Errorf("worker: ack job %s: %w", job.ID, aerr))
+	}
+
+	if w.Events != nil {
+		e := Event{JobID: job.ID, Dest: job.Dest}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		if perr := w.Events.Publish(ctx, e); perr != nil {
+			w.onError(job.ID, fmt.Errorf("worker: publish event for job %s: %w", job.ID, perr))
+		}
+	}
+}
+
+func (w *Worker) onError(jobID string, err error) {
+	if w.OnError != nil {
+		w.OnError(jobID, err)
+		return
+	}
+	log.Print(err)
+}
+
+// Process runs a single job synchronously.
+func (w *Worker) Process(ctx context.Context, job Job) error {
+	in, err := w.Source.Open(ctx, job.Source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := w.Sink.Create(ctx, job.Dest)
+	if err != nil {
+		return err
+	}
+
+	if err := convert.Convert(in, out, job.Format, job.Options); err != nil {
+		if a, ok := out.(Aborter); ok {
+			a.Abort()
+		} else {
+			out.Close()
+		}
+		return err
+	}
+	return out.Close()
+}